@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -23,24 +24,133 @@ type OldAlert struct {
 	CreatedAt     time.Time `json:"created_at"`
 }
 
+const usage = `Использование: migrate [-db ПУТЬ] КОМАНДА [ФЛАГИ]
+
+  status  версия схемы базы и список миграций (примененные и ожидающие)
+  up      применить ожидающие миграции схемы
+  export  выгрузить данные в JSON или CSV:
+            -format json|csv  формат (json)
+            -out ПУТЬ         файл JSON или каталог CSV (data/export-ДАТА.json или data/export-ДАТА/)
+            -only НАБОРЫ      наборы через запятую (все)
+  import  загрузить данные из выгрузки; формат определяется по пути (каталог — CSV):
+            -in ПУТЬ          файл JSON или каталог CSV
+            -mode merge|replace  merge — добавить новые записи, replace — заменить наборы (merge)
+            -only НАБОРЫ      наборы через запятую (все, что есть в выгрузке)
+  legacy  перенести алерты из data/alerts.json старых версий:
+            -in ПУТЬ          файл алертов (data/alerts.json)
+
+Наборы: alerts, calls, orders, reminders, deposits, triggers.
+Путь к базе берется из -db, затем из DATABASE_PATH, по умолчанию data/alerts.db.
+`
+
 func main() {
 	logrus.SetLevel(logrus.InfoLevel)
 
-	jsonPath := "data/alerts.json"
-	dbPath := "data/alerts.db"
+	defaultDB := "data/alerts.db"
+	if v := os.Getenv("DATABASE_PATH"); v != "" {
+		defaultDB = v
+	}
+	dbPath := flag.String("db", defaultDB, "путь к файлу базы SQLite")
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usage) }
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "status":
+		printStatus(*dbPath)
+	case "up":
+		applyPending(*dbPath)
+	case "export":
+		runExport(*dbPath, args[1:])
+	case "import":
+		runImport(*dbPath, args[1:])
+	case "legacy":
+		fs := flag.NewFlagSet("legacy", flag.ExitOnError)
+		jsonPath := fs.String("in", "data/alerts.json", "файл алертов старых версий")
+		fs.Parse(args[1:])
+		migrateJSON(*jsonPath, *dbPath)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// openExisting открывает существующую базу без применения миграций
+func openExisting(dbPath string) *alerts.DatabaseStorage {
+	if _, err := os.Stat(dbPath); err != nil {
+		logrus.Fatalf("database %s not found: %v", dbPath, err)
+	}
+	storage, err := alerts.OpenDatabaseStorage(dbPath)
+	if err != nil {
+		logrus.Fatalf("failed to open database: %v", err)
+	}
+	return storage
+}
+
+// printStatus печатает версию схемы и состояние каждой миграции
+func printStatus(dbPath string) {
+	storage := openExisting(dbPath)
+	defer storage.Close()
+
+	statuses, err := storage.MigrationStatus()
+	if err != nil {
+		logrus.Fatalf("failed to get migration status: %v", err)
+	}
+	version, err := storage.SchemaVersion()
+	if err != nil {
+		logrus.Fatalf("failed to get schema version: %v", err)
+	}
 
+	latest := alerts.LatestSchemaVersion()
+	fmt.Printf("База: %s\n", dbPath)
+	fmt.Printf("Версия схемы: %d, последняя в сборке: %d\n", version, latest)
+	if version > latest {
+		fmt.Println("⚠️ База новее сборки: бот с этой сборкой не запустится, обновите бота")
+	}
+
+	pending := 0
+	for _, st := range statuses {
+		state := "ожидает"
+		if st.AppliedAt != nil {
+			state = "применена " + st.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		} else {
+			pending++
+		}
+		fmt.Printf("  %04d  %-24s %s\n", st.Version, st.Name, state)
+	}
+	fmt.Printf("Ожидают применения: %d\n", pending)
+}
+
+// applyPending применяет ожидающие миграции схемы
+func applyPending(dbPath string) {
+	storage := openExisting(dbPath)
+	defer storage.Close()
+
+	applied, err := storage.ApplyMigrations()
+	for _, m := range applied {
+		fmt.Printf("Применена миграция %04d_%s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		logrus.Fatalf("migration failed: %v", err)
+	}
+	if len(applied) == 0 {
+		fmt.Println("Схема актуальна, миграций для применения нет")
+	}
+}
+
+// migrateJSON переносит алерты из JSON файла старых версий в базу SQLite; алерты, которые уже есть, пропускаются
+func migrateJSON(jsonPath, dbPath string) {
 	// Проверяем, существует ли JSON файл
 	if _, err := os.Stat(jsonPath); os.IsNotExist(err) {
 		logrus.Info("alerts.json not found, no migration needed")
 		return
 	}
 
-	// Проверяем, не существует ли уже БД
-	if _, err := os.Stat(dbPath); err == nil {
-		logrus.Info("database already exists, migration skipped")
-		return
-	}
-
 	logrus.Info("starting migration from JSON to SQLite")
 
 	// Создаем директорию для БД если не существует
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"example.com/alert-bot/internal/alerts"
+)
+
+// csvNull значение NULL в CSV: пустая строка — это пустой текст, а не отсутствие значения
+const csvNull = `\N`
+
+// manifestFile описание выгрузки в каталоге CSV
+const manifestFile = "manifest.json"
+
+// exportManifest заголовок выгрузки: версия схемы базы и состав наборов
+type exportManifest struct {
+	SchemaVersion int       `json:"schema_version"`
+	ExportedAt    time.Time `json:"exported_at"`
+	Datasets      []string  `json:"datasets"`
+}
+
+// exportJSON выгрузка в одном файле JSON
+type exportJSON struct {
+	exportManifest
+	Tables map[string][]alerts.TransferRow `json:"tables"`
+}
+
+// runExport выгружает наборы данных в файл JSON или каталог CSV
+func runExport(dbPath string, args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "json", "формат: json или csv")
+	out := fs.String("out", "", "файл JSON или каталог CSV")
+	only := fs.String("only", "", "наборы через запятую")
+	fs.Parse(args)
+
+	datasets, err := alerts.SelectDatasets(*only)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if *format != "json" && *format != "csv" {
+		logrus.Fatalf("unknown export format %q", *format)
+	}
+	if *out == "" {
+		*out = "data/export-" + time.Now().Format("20060102-150405")
+		if *format == "json" {
+			*out += ".json"
+		}
+	}
+
+	storage := openExisting(dbPath)
+	defer storage.Close()
+
+	version, err := storage.SchemaVersion()
+	if err != nil {
+		logrus.Fatalf("failed to get schema version: %v", err)
+	}
+	manifest := exportManifest{SchemaVersion: version, ExportedAt: time.Now().UTC()}
+
+	tables := make(map[string][]alerts.TransferRow)
+	columns := make(map[string][]string)
+	for _, ds := range datasets {
+		manifest.Datasets = append(manifest.Datasets, ds.Name)
+		for _, table := range ds.Tables {
+			cols, rows, err := storage.ExportTable(table.Name)
+			if err != nil {
+				logrus.Fatalf("failed to export %s: %v", table.Name, err)
+			}
+			if rows == nil {
+				rows = []alerts.TransferRow{}
+			}
+			tables[table.Name], columns[table.Name] = rows, cols
+			fmt.Printf("  %-20s %d\n", table.Name, len(rows))
+		}
+	}
+
+	if *format == "json" {
+		err = writeJSONExport(*out, exportJSON{exportManifest: manifest, Tables: tables})
+	} else {
+		err = writeCSVExport(*out, manifest, tables, columns)
+	}
+	if err != nil {
+		logrus.Fatalf("failed to write export: %v", err)
+	}
+	fmt.Printf("Выгрузка сохранена: %s (схема версии %d)\n", *out, version)
+}
+
+// writeJSONExport записывает выгрузку одним файлом JSON
+func writeJSONExport(path string, export exportJSON) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	return enc.Encode(export)
+}
+
+// writeCSVExport записывает manifest.json и по файлу ТАБЛИЦА.csv на таблицу с заголовком из колонок
+func writeCSVExport(dir string, manifest exportManifest, tables map[string][]alerts.TransferRow, columns map[string][]string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, manifestFile), data, 0644); err != nil {
+		return err
+	}
+
+	for table, rows := range tables {
+		if err := writeCSVTable(filepath.Join(dir, table+".csv"), columns[table], rows); err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// writeCSVTable записывает одну таблицу в CSV
+func writeCSVTable(path string, columns []string, rows []alerts.TransferRow) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	if err := w.Write(columns); err != nil {
+		return err
+	}
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i, col := range columns {
+			record[i] = csvValue(row[col])
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// csvValue значение колонки в CSV
+func csvValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return csvNull
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case bool:
+		if val {
+			return "1"
+		}
+		return "0"
+	}
+	return fmt.Sprint(v)
+}
+
+// runImport загружает выгрузку JSON или CSV в базу в режиме merge или replace
+func runImport(dbPath string, args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	in := fs.String("in", "", "файл JSON или каталог CSV")
+	mode := fs.String("mode", alerts.ImportMerge, "merge или replace")
+	only := fs.String("only", "", "наборы через запятую")
+	fs.Parse(args)
+
+	if *in == "" {
+		logrus.Fatal("import requires -in")
+	}
+	datasets, err := alerts.SelectDatasets(*only)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	info, err := os.Stat(*in)
+	if err != nil {
+		logrus.Fatalf("import source not found: %v", err)
+	}
+	var manifest exportManifest
+	var tables map[string][]alerts.TransferRow
+	if info.IsDir() {
+		manifest, tables, err = readCSVExport(*in, datasets)
+	} else {
+		manifest, tables, err = readJSONExport(*in)
+	}
+	if err != nil {
+		logrus.Fatalf("failed to read export: %v", err)
+	}
+
+	// База создается при необходимости и доводится до последней схемы сборки
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		logrus.Fatalf("failed to create data directory: %v", err)
+	}
+	storage, err := alerts.NewDatabaseStorage(dbPath)
+	if err != nil {
+		logrus.Fatalf("failed to open database: %v", err)
+	}
+	defer storage.Close()
+
+	version, err := storage.SchemaVersion()
+	if err != nil {
+		logrus.Fatalf("failed to get schema version: %v", err)
+	}
+	if manifest.SchemaVersion > version {
+		logrus.Fatalf("export schema version %d is newer than database schema %d: update the bot first", manifest.SchemaVersion, version)
+	}
+
+	result, err := storage.ImportTables(datasets, tables, *mode)
+	if err != nil {
+		logrus.Fatalf("import failed, database unchanged: %v", err)
+	}
+
+	fmt.Printf("Импорт (%s) из %s:\n", *mode, *in)
+	conflicts := 0
+	for _, ds := range datasets {
+		for _, table := range ds.Tables {
+			if _, ok := tables[table.Name]; !ok {
+				continue
+			}
+			fmt.Printf("  %-20s добавлено %d, пропущено %d, конфликтов %d\n", table.Name,
+				result.Inserted[table.Name], result.Skipped[table.Name], result.Conflicts[table.Name])
+			conflicts += result.Conflicts[table.Name]
+		}
+	}
+	if conflicts > 0 {
+		fmt.Printf("Конфликтов: %d — ключ занят другой записью, в базе оставлены существующие версии\n", conflicts)
+	}
+}
+
+// readJSONExport читает выгрузку JSON. Числа без дробной части становятся int64, чтобы ID
+// пользователей и чатов не теряли точность.
+func readJSONExport(path string) (exportManifest, map[string][]alerts.TransferRow, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return exportManifest{}, nil, err
+	}
+	defer file.Close()
+
+	var export exportJSON
+	dec := json.NewDecoder(file)
+	dec.UseNumber()
+	if err := dec.Decode(&export); err != nil {
+		return exportManifest{}, nil, err
+	}
+
+	for _, rows := range export.Tables {
+		for _, row := range rows {
+			for col, v := range row {
+				num, ok := v.(json.Number)
+				if !ok {
+					continue
+				}
+				if n, err := num.Int64(); err == nil {
+					row[col] = n
+				} else if f, err := num.Float64(); err == nil {
+					row[col] = f
+				} else {
+					return exportManifest{}, nil, fmt.Errorf("неверное число %q в колонке %s", num, col)
+				}
+			}
+		}
+	}
+	return export.exportManifest, export.Tables, nil
+}
+
+// readCSVExport читает manifest.json и файлы таблиц выбранных наборов из каталога CSV.
+// Таблицы без файла в выгрузку не входят. Значения остаются строками: SQLite приводит их
+// к типу колонки.
+func readCSVExport(dir string, datasets []alerts.Dataset) (exportManifest, map[string][]alerts.TransferRow, error) {
+	var manifest exportManifest
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return manifest, nil, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, nil, fmt.Errorf("%s: %w", manifestFile, err)
+	}
+
+	tables := make(map[string][]alerts.TransferRow)
+	for _, ds := range datasets {
+		for _, table := range ds.Tables {
+			path := filepath.Join(dir, table.Name+".csv")
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				continue
+			}
+			rows, err := readCSVTable(path)
+			if err != nil {
+				return manifest, nil, fmt.Errorf("%s: %w", table.Name, err)
+			}
+			tables[table.Name] = rows
+		}
+	}
+	return manifest, tables, nil
+}
+
+// readCSVTable читает таблицу CSV с заголовком из колонок
+func readCSVTable(path string) ([]alerts.TransferRow, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("нет заголовка с колонками")
+	}
+
+	header := records[0]
+	rows := make([]alerts.TransferRow, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(alerts.TransferRow, len(header))
+		for i, col := range header {
+			col = strings.TrimSpace(col)
+			if record[i] == csvNull {
+				row[col] = nil
+			} else {
+				row[col] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
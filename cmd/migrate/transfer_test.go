@@ -0,0 +1,216 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"example.com/alert-bot/internal/alerts"
+)
+
+func init() {
+	logrus.SetOutput(io.Discard)
+}
+
+// bigID ID пользователя, который не помещается в float64 без потери точности
+const bigID int64 = 1<<53 + 1
+
+// newStorage создает базу со всеми миграциями во временном каталоге теста.
+func newStorage(t *testing.T) (*alerts.DatabaseStorage, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "alerts.db")
+	s, err := alerts.NewDatabaseStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, path
+}
+
+// seed заполняет базу записями всех наборов: открытый колл с тейк-профитом (closed_at NULL),
+// закрытый колл, составной алерт, ордер без срока и депозит пользователя с большим ID.
+func seed(t *testing.T, s *alerts.DatabaseStorage) {
+	t.Helper()
+	if _, _, err := s.GetUserDeposit(bigID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add(alerts.Alert{ID: "alert001", ChatID: -bigID, UserID: bigID, Username: "trader", Symbol: "BTCUSDT",
+		TargetPrice: 70000, Symbols: []string{"BTCUSDT", "ETHUSDT"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	call := alerts.Call{UserID: bigID, Username: "trader", ChatID: -bigID, Symbol: "BTCUSDT", Market: "futures",
+		Exchange: "Bitget", Direction: "long", EntryPrice: 100, DepositPercent: 10, Leverage: 3}
+	call.ID = "call0001"
+	if _, err := s.OpenCall(call, alerts.FillSourceManual); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddTakeProfits("call0001", []alerts.TakeProfit{{Price: 120, Percent: 50}}); err != nil {
+		t.Fatal(err)
+	}
+	call.ID = "call0002"
+	if _, err := s.OpenCall(call, alerts.FillSourceManual); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CloseCall("call0002", bigID, 110, alerts.CloseRemaining, alerts.FillSourceManual); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.CreateLimitOrder(alerts.LimitOrder{ID: "order001", UserID: bigID, Username: "trader", ChatID: -bigID,
+		Symbol: "BTCUSDT", Direction: "long", LimitPrice: 95, DepositPercent: 5, StopLossPrice: 90, Leverage: 2}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// snapshot строки всех таблиц наборов.
+func snapshot(t *testing.T, s *alerts.DatabaseStorage) map[string][]alerts.TransferRow {
+	t.Helper()
+	tables := make(map[string][]alerts.TransferRow)
+	for _, ds := range alerts.Datasets {
+		for _, table := range ds.Tables {
+			_, rows, err := s.ExportTable(table.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tables[table.Name] = rows
+		}
+	}
+	return tables
+}
+
+// exportAndRead выгружает базу командой export и читает выгрузку так же, как команда import.
+func exportAndRead(t *testing.T, dbPath, format string) map[string][]alerts.TransferRow {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "export")
+	if format == "json" {
+		out += ".json"
+	}
+	runExport(dbPath, []string{"-format", format, "-out", out})
+
+	var tables map[string][]alerts.TransferRow
+	var err error
+	if format == "json" {
+		_, tables, err = readJSONExport(out)
+	} else {
+		_, tables, err = readCSVExport(out, alerts.Datasets)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tables
+}
+
+// findRow строка таблицы по значению колонки.
+func findRow(rows []alerts.TransferRow, col string, value interface{}) alerts.TransferRow {
+	for _, row := range rows {
+		if reflect.DeepEqual(row[col], value) {
+			return row
+		}
+	}
+	return nil
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, format := range []string{"json", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			src, srcPath := newStorage(t)
+			seed(t, src)
+			want := snapshot(t, src)
+			tables := exportAndRead(t, srcPath, format)
+
+			// replace переносит все строки с исходными ID и значениями
+			dst, _ := newStorage(t)
+			if _, err := dst.ImportTables(alerts.Datasets, tables, alerts.ImportReplace); err != nil {
+				t.Fatal(err)
+			}
+			got := snapshot(t, dst)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("после replace таблицы отличаются:\n%v\nожидалось\n%v", got, want)
+			}
+
+			// NULL остается NULL (в CSV — \N), а не пустой строкой
+			open := findRow(got["calls"], "id", "call0001")
+			if open == nil || open["closed_at"] != nil {
+				t.Errorf("closed_at открытого колла %v, ожидался NULL", open["closed_at"])
+			}
+			// ID больше 2^53 переносится без потери точности
+			if deposit := findRow(got["user_deposits"], "user_id", bigID); deposit == nil {
+				t.Errorf("депозит пользователя %d не найден: %v", bigID, got["user_deposits"])
+			}
+
+			// Повторный merge той же выгрузки ничего не добавляет и не считает повторы конфликтами;
+			// дочерние строки существующих записей пропускаются
+			result, err := dst.ImportTables(alerts.Datasets, tables, alerts.ImportMerge)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for table, rows := range want {
+				if result.Inserted[table] != 0 || result.Conflicts[table] != 0 || result.Skipped[table] != len(rows) {
+					t.Errorf("%s: добавлено %d, конфликтов %d, пропущено %d из %d", table,
+						result.Inserted[table], result.Conflicts[table], result.Skipped[table], len(rows))
+				}
+			}
+			if got := snapshot(t, dst); !reflect.DeepEqual(got, want) {
+				t.Errorf("повторный merge изменил базу")
+			}
+		})
+	}
+}
+
+func TestMergeImportReportsConflicts(t *testing.T) {
+	src, srcPath := newStorage(t)
+	seed(t, src)
+	tables := exportAndRead(t, srcPath, "json")
+
+	// В базе уже есть другие записи с теми же ID
+	dst, _ := newStorage(t)
+	if _, err := dst.Add(alerts.Alert{ID: "alert001", ChatID: 1, UserID: 1, Symbol: "SOLUSDT", TargetPrice: 200}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.OpenCall(alerts.Call{ID: "call0001", UserID: 1, ChatID: 1, Symbol: "SOLUSDT", Direction: "short",
+		EntryPrice: 150, DepositPercent: 20}, alerts.FillSourceManual); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.CreateLimitOrder(alerts.LimitOrder{ID: "order001", UserID: 1, ChatID: 1, Symbol: "SOLUSDT",
+		Direction: "short", LimitPrice: 160, DepositPercent: 10}); err != nil {
+		t.Fatal(err)
+	}
+	before := snapshot(t, dst)
+
+	result, err := dst.ImportTables(alerts.Datasets, tables, alerts.ImportMerge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"alerts", "calls", "limit_orders"} {
+		if result.Conflicts[table] != 1 {
+			t.Errorf("%s: конфликтов %d, ожидался 1", table, result.Conflicts[table])
+		}
+	}
+	// Колл без конфликта добавляется вместе с исполнениями, у конфликтующего они пропускаются
+	if result.Inserted["calls"] != 1 || result.Inserted["call_fills"] != 2 || result.Skipped["call_fills"] != 1 {
+		t.Errorf("коллов добавлено %d, исполнений добавлено %d и пропущено %d; ожидалось 1, 2 и 1",
+			result.Inserted["calls"], result.Inserted["call_fills"], result.Skipped["call_fills"])
+	}
+	if result.Inserted["call_take_profits"] != 0 || result.Skipped["call_take_profits"] != 1 {
+		t.Errorf("тейк-профитов добавлено %d и пропущено %d, ожидалось 0 и 1",
+			result.Inserted["call_take_profits"], result.Skipped["call_take_profits"])
+	}
+	if result.Inserted["alert_symbols"] != 0 || result.Skipped["alert_symbols"] != 2 {
+		t.Errorf("символов алерта добавлено %d и пропущено %d, ожидалось 0 и 2",
+			result.Inserted["alert_symbols"], result.Skipped["alert_symbols"])
+	}
+
+	// Существующие записи остаются как были
+	after := snapshot(t, dst)
+	for _, table := range []string{"alerts", "limit_orders"} {
+		if !reflect.DeepEqual(after[table], before[table]) {
+			t.Errorf("%s: существующая запись изменена импортом", table)
+		}
+	}
+	if call := findRow(after["calls"], "id", "call0001"); call == nil || call["symbol"] != "SOLUSDT" {
+		t.Errorf("конфликтующий колл заменен: %v", call)
+	}
+}
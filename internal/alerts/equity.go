@@ -0,0 +1,204 @@
+package alerts
+
+import (
+	"math"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DepositSnapshot точка кривой капитала пользователя.
+type DepositSnapshot struct {
+	ID      int64     `json:"id"`
+	UserID  int64     `json:"user_id"`
+	Deposit float64   `json:"deposit"` // Депозит с учетом только закрытых сделок
+	Equity  float64   `json:"equity"`  // Депозит плюс нереализованный PnL открытых коллов
+	Kind    string    `json:"kind"`    // "close", "daily" или "reset"
+	CallID  string    `json:"call_id,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// Типы снимков депозита
+const (
+	SnapshotKindClose = "close" // Закрытие (части) колла; нереализованный PnL не учитывается
+	SnapshotKindDaily = "daily" // Ежедневная переоценка открытых коллов по рынку
+	SnapshotKindReset = "reset" // Сброс депозита: кривая начинается заново
+)
+
+// MarkedToMarket сообщает, сопоставим ли Equity снимка с дневной переоценкой. Снимок закрытия хранит
+// только реализованный депозит без PnL остальных открытых коллов: рядом с переоценками он дал бы
+// ложные скачки и просадки. Сброс начинает кривую заново и годится как ее первая точка.
+func (snap DepositSnapshot) MarkedToMarket() bool {
+	return snap.Kind != SnapshotKindClose
+}
+
+// tradingDaysPerYear дней в году для годовых Sharpe и Sortino (крипторынок торгуется ежедневно)
+const tradingDaysPerYear = 365
+
+// RecordDepositSnapshot добавляет точку кривой капитала. Время хранится в UTC, чтобы снимки
+// сравнивались по времени как строки.
+func (s *DatabaseStorage) RecordDepositSnapshot(snap DepositSnapshot) error {
+	return recordDepositSnapshot(s.db, snap)
+}
+
+// recordDepositSnapshot добавляет точку кривой капитала отдельно или в транзакции изменения депозита
+func recordDepositSnapshot(q querier, snap DepositSnapshot) error {
+	if snap.At.IsZero() {
+		snap.At = time.Now()
+	}
+	_, err := q.Exec(`
+		INSERT INTO deposit_snapshots (user_id, deposit, equity, kind, call_id, taken_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		snap.UserID, snap.Deposit, snap.Equity, snap.Kind, snap.CallID, snap.At.UTC())
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"user_id": snap.UserID,
+		"equity":  snap.Equity,
+		"kind":    snap.Kind,
+	}).Debug("deposit snapshot recorded")
+	return nil
+}
+
+// GetDepositSnapshots возвращает кривую капитала пользователя с последнего сброса депозита в порядке времени.
+func (s *DatabaseStorage) GetDepositSnapshots(userID int64) []DepositSnapshot {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, deposit, equity, kind, COALESCE(call_id, ''), taken_at
+		FROM deposit_snapshots
+		WHERE user_id = ? AND taken_at >= COALESCE(
+			(SELECT MAX(taken_at) FROM deposit_snapshots WHERE user_id = ? AND kind = 'reset'), 0)
+		ORDER BY taken_at, id`, userID, userID)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to get deposit snapshots")
+		return nil
+	}
+	defer rows.Close()
+
+	var snaps []DepositSnapshot
+	for rows.Next() {
+		var snap DepositSnapshot
+		if err := rows.Scan(&snap.ID, &snap.UserID, &snap.Deposit, &snap.Equity, &snap.Kind, &snap.CallID, &snap.At); err != nil {
+			logrus.WithError(err).Warn("failed to scan deposit snapshot row")
+			continue
+		}
+		snaps = append(snaps, snap)
+	}
+	return snaps
+}
+
+// HasDepositSnapshotSince сообщает, есть ли у пользователя снимок вида kind не раньше since.
+func (s *DatabaseStorage) HasDepositSnapshotSince(userID int64, kind string, since time.Time) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM deposit_snapshots WHERE user_id = ? AND kind = ? AND taken_at >= ?)`,
+		userID, kind, since.UTC()).Scan(&exists)
+	return exists, err
+}
+
+// GetDepositUserIDs возвращает пользователей, у которых есть депозит.
+func (s *DatabaseStorage) GetDepositUserIDs() []int64 {
+	rows, err := s.db.Query(`SELECT user_id FROM user_deposits`)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to get deposit users")
+		return nil
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// EquityStats показатели кривой капитала.
+type EquityStats struct {
+	Start              float64
+	End                float64
+	ReturnPercent      float64
+	MaxDrawdownPercent float64 // Максимальная просадка от пика, % (положительное число)
+	Sharpe             float64 // Годовой коэффициент Шарпа по дневным доходностям (0 — мало данных)
+	Sortino            float64 // Годовой коэффициент Сортино по дневным доходностям (0 — мало данных)
+	Days               int     // Число дневных доходностей в расчете
+}
+
+// ComputeEquityStats считает доходность, максимальную просадку и годовые Sharpe и Sortino по кривой
+// капитала. Берутся только точки с переоценкой по рынку (см. MarkedToMarket), для коэффициентов —
+// последнее значение каждого дня; безрисковая ставка — 0.
+func ComputeEquityStats(all []DepositSnapshot) EquityStats {
+	var stats EquityStats
+	var snaps []DepositSnapshot
+	for _, snap := range all {
+		if snap.MarkedToMarket() {
+			snaps = append(snaps, snap)
+		}
+	}
+	if len(snaps) == 0 {
+		return stats
+	}
+	stats.Start, stats.End = snaps[0].Equity, snaps[len(snaps)-1].Equity
+	if stats.Start > 0 {
+		stats.ReturnPercent = (stats.End - stats.Start) / stats.Start * 100
+	}
+
+	peak := 0.0
+	for _, snap := range snaps {
+		peak = math.Max(peak, snap.Equity)
+		if peak > 0 {
+			stats.MaxDrawdownPercent = math.Max(stats.MaxDrawdownPercent, (peak-snap.Equity)/peak*100)
+		}
+	}
+
+	// Последнее значение каждого дня
+	var daily []float64
+	var lastDay string
+	for _, snap := range snaps {
+		day := snap.At.UTC().Format("2006-01-02")
+		if day == lastDay {
+			daily[len(daily)-1] = snap.Equity
+			continue
+		}
+		daily = append(daily, snap.Equity)
+		lastDay = day
+	}
+
+	var returns []float64
+	for i := 1; i < len(daily); i++ {
+		if daily[i-1] > 0 {
+			returns = append(returns, daily[i]/daily[i-1]-1)
+		}
+	}
+	stats.Days = len(returns)
+	if len(returns) < 2 {
+		return stats
+	}
+
+	var mean float64
+	for _, r := range returns {
+		mean += r
+	}
+	mean /= float64(len(returns))
+
+	var variance, downside float64
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+		if r < 0 {
+			downside += r * r
+		}
+	}
+	std := math.Sqrt(variance / float64(len(returns)-1))
+	downDev := math.Sqrt(downside / float64(len(returns)))
+
+	annual := math.Sqrt(tradingDaysPerYear)
+	if std > 0 {
+		stats.Sharpe = mean / std * annual
+	}
+	if downDev > 0 {
+		stats.Sortino = mean / downDev * annual
+	}
+	return stats
+}
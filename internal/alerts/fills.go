@@ -0,0 +1,231 @@
+package alerts
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CallFill одно исполнение по коллу: открытие, добор или (частичное) закрытие.
+// Реализованный PnL, средняя цена выхода и статистика считаются по этим записям.
+type CallFill struct {
+	ID             int64     `json:"id"`
+	CallID         string    `json:"call_id"`
+	Kind           string    `json:"kind"`            // "open", "add" или "close"
+	Price          float64   `json:"price"`           // Цена исполнения
+	Size           float64   `json:"size"`            // Объем в единицах открытия: открытие — 100, доборы — пропорционально доле депозита
+	DepositPercent float64   `json:"deposit_percent"` // Доля депозита, задействованная исполнением
+	PnlPercent     float64   `json:"pnl_percent"`     // Результат в % от маржи с учетом плеча, комиссий и финансирования (для закрытий)
+	FeePercent     float64   `json:"fee_percent"`     // Комиссии входа и выхода в % от маржи (для закрытий)
+	FundingPercent float64   `json:"funding_percent"` // Финансирование в % от маржи, положительное — уплачено (для закрытий)
+	Source         string    `json:"source"`          // "manual", "sl", "limit", "rush", "tp", "time", "liq", "legacy"
+	FilledAt       time.Time `json:"filled_at"`
+}
+
+// Типы исполнений
+const (
+	FillKindOpen  = "open"
+	FillKindAdd   = "add"
+	FillKindClose = "close"
+)
+
+// Источники исполнений
+const (
+	FillSourceManual     = "manual" // Команды /ocall и /ccall
+	FillSourceStopLoss   = "sl"     // Сработавший стоп-лосс
+	FillSourceLimit      = "limit"  // Исполненный лимитный ордер
+	FillSourceRush       = "rush"   // Закрытие всех коллов командой /rush
+	FillSourceTakeProfit = "tp"     // Исполненная нога тейк-профита
+	FillSourceTimeStop   = "time"   // Закрытие по правилу времени (/rule)
+	FillSourceLiquidate  = "liq"    // Принудительное закрытие по цене ликвидации
+	FillSourceLegacy     = "legacy" // Восстановлено из коллов, созданных до появления журнала
+)
+
+// recordFill добавляет исполнение в журнал в транзакции операции над коллом.
+func recordFill(q querier, fill CallFill) error {
+	if fill.FilledAt.IsZero() {
+		fill.FilledAt = time.Now()
+	}
+	if fill.Source == "" {
+		fill.Source = FillSourceManual
+	}
+
+	_, err := q.Exec(`
+		INSERT INTO call_fills (call_id, kind, price, size, deposit_percent, pnl_percent, fee_percent, funding_percent, source, filled_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		fill.CallID, fill.Kind, fill.Price, fill.Size, fill.DepositPercent, fill.PnlPercent, fill.FeePercent, fill.FundingPercent,
+		fill.Source, fill.FilledAt)
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"call_id": fill.CallID,
+		"kind":    fill.Kind,
+		"price":   fill.Price,
+		"size":    fill.Size,
+		"source":  fill.Source,
+	}).Debug("call fill recorded")
+	return nil
+}
+
+// GetCallFills возвращает журнал исполнений колла в порядке времени.
+func (s *DatabaseStorage) GetCallFills(callID string) []CallFill {
+	rows, err := s.db.Query(`
+		SELECT id, call_id, kind, price, size, deposit_percent, pnl_percent,
+		       COALESCE(fee_percent, 0), COALESCE(funding_percent, 0), source, filled_at
+		FROM call_fills
+		WHERE call_id = ?
+		ORDER BY filled_at, id`, callID)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to get call fills")
+		return nil
+	}
+	defer rows.Close()
+
+	var fills []CallFill
+	for rows.Next() {
+		var fill CallFill
+		if err := rows.Scan(&fill.ID, &fill.CallID, &fill.Kind, &fill.Price, &fill.Size,
+			&fill.DepositPercent, &fill.PnlPercent, &fill.FeePercent, &fill.FundingPercent, &fill.Source, &fill.FilledAt); err != nil {
+			logrus.WithError(err).Warn("failed to scan call fill row")
+			continue
+		}
+		fills = append(fills, fill)
+	}
+	return fills
+}
+
+// callResult итоги закрытий колла по журналу.
+func callResult(q querier, callID string) (avgExit, pnlPercent float64, err error) {
+	var exit, pnl sql.NullFloat64
+	err = q.QueryRow(`SELECT avg_exit_price, pnl_percent FROM call_results WHERE call_id = ?`, callID).Scan(&exit, &pnl)
+	if err != nil {
+		return 0, 0, err
+	}
+	return exit.Float64, pnl.Float64, nil
+}
+
+// openedSize возвращает объем, открытый по коллу (открытие и доборы); 100 для коллов без журнала.
+func openedSize(q querier, callID string) float64 {
+	var size sql.NullFloat64
+	err := q.QueryRow(`SELECT SUM(size) FROM call_fills WHERE call_id = ? AND kind IN ('open', 'add')`, callID).Scan(&size)
+	if err != nil || !size.Valid || size.Float64 <= 0 {
+		return 100
+	}
+	return size.Float64
+}
+
+// AddToCall добирает позицию колла по цене price на depositPercent депозита и записывает исполнение в журнал.
+// Цена входа пересчитывается как средняя, взвешенная по объему, доля депозита колла увеличивается,
+// а размер (процент оставшейся позиции) пересчитывается от нового открытого объема.
+// Накопленное финансирование пересчитывается на новый объем.
+// Если depositPercent = 0, добирается столько же, сколько было открыто. У колла без доли депозита
+// добор равен первоначальному открытию. Позиция после добора проверяется по риск-правилам в той же
+// транзакции; нарушение возвращается как *RiskViolation.
+func (s *DatabaseStorage) AddToCall(callID string, userID int64, price float64, depositPercent float64, source string) (*Call, error) {
+	if price <= 0 {
+		return nil, errors.New("price must be positive")
+	}
+	if depositPercent < 0 {
+		return nil, errors.New("deposit percent must not be negative")
+	}
+
+	var call Call
+	var added float64
+	err := s.withTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow(`
+			SELECT id, user_id, chat_id, symbol, direction, entry_price, size, status, COALESCE(deposit_percent, 0),
+			       COALESCE(stop_loss_price, 0), COALESCE(leverage, 1), COALESCE(taker_fee, 0),
+			       COALESCE(funding_percent, 0), COALESCE(version, 0)
+			FROM calls WHERE id = ? AND user_id = ?`,
+			callID, userID).Scan(&call.ID, &call.UserID, &call.ChatID, &call.Symbol, &call.Direction, &call.EntryPrice, &call.Size, &call.Status,
+			&call.DepositPercent, &call.StopLossPrice, &call.Leverage, &call.TakerFee, &call.FundingPercent, &call.Version)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return errors.New("call not found")
+			}
+			return err
+		}
+		if call.Status != "open" {
+			return errors.New("call is already closed")
+		}
+
+		deposit := call.DepositPercent
+		if deposit == 0 && depositPercent > 0 {
+			return errors.New("колл открыт без доли депозита, добор возможен только без процента")
+		}
+
+		opened := openedSize(tx, callID)
+		remaining := call.Size * opened / 100
+
+		added = depositPercent
+		addUnits := 100.0
+		if deposit > 0 {
+			if added == 0 {
+				added = deposit
+			}
+			addUnits = added * opened / deposit
+		}
+
+		newEntry := (remaining*call.EntryPrice + addUnits*price) / (remaining + addUnits)
+		newOpened := opened + addUnits
+		newSize := (remaining + addUnits) / newOpened * 100
+		newDeposit := deposit + added
+		// Добранный объем финансирование еще не платил: накопленный процент размывается
+		newFunding := call.FundingPercent * remaining / (remaining + addUnits)
+
+		// Риск-правила проверяются для позиции после добора как для нового колла на полный размер
+		candidate := call
+		candidate.EntryPrice = newEntry
+		candidate.DepositPercent = deposit*call.Size/100 + added
+		candidate.Size = 100
+		candidate.FundingPercent = newFunding
+		if err := checkRiskRules(tx, candidate); err != nil {
+			return err
+		}
+
+		if err := recordFill(tx, CallFill{
+			CallID:         callID,
+			Kind:           FillKindAdd,
+			Price:          price,
+			Size:           addUnits,
+			DepositPercent: added,
+			Source:         source,
+		}); err != nil {
+			return fmt.Errorf("failed to record add fill: %w", err)
+		}
+
+		result, err := tx.Exec(`
+			UPDATE calls SET entry_price = ?, size = ?, deposit_percent = ?, funding_percent = ?, version = COALESCE(version, 0) + 1
+			WHERE id = ? AND COALESCE(version, 0) = ?`,
+			newEntry, newSize, newDeposit, newFunding, callID, call.Version)
+		if err != nil {
+			return err
+		}
+		if err := checkVersion(result); err != nil {
+			return err
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"call_id":     callID,
+			"user_id":     userID,
+			"price":       price,
+			"added_pct":   added,
+			"old_entry":   call.EntryPrice,
+			"new_entry":   newEntry,
+			"new_size":    newSize,
+			"deposit_pct": newDeposit,
+		}).Info("call scaled in")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Возвращается колл целиком, как его видят остальные команды
+	return s.GetCallByID(callID, userID)
+}
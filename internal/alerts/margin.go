@@ -0,0 +1,109 @@
+package alerts
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MaintenanceMarginPercent поддерживающая маржа в процентах от объема позиции: колл с плечом
+// ликвидируется, когда убыток съедает маржу до этого уровня.
+const MaintenanceMarginPercent = 0.5
+
+// MaxLeverage максимальное плечо колла
+const MaxLeverage = 125
+
+// EffectiveLeverage возвращает плечо колла; у коллов без плеча — 1.
+func (c Call) EffectiveLeverage() float64 {
+	if c.Leverage < 1 {
+		return 1
+	}
+	return c.Leverage
+}
+
+// LiquidationPrice возвращает цену ликвидации изолированной позиции колла; 0 — без плеча.
+func (c Call) LiquidationPrice() float64 {
+	lev := c.EffectiveLeverage()
+	if lev <= 1 || c.EntryPrice <= 0 {
+		return 0
+	}
+	move := 1/lev - MaintenanceMarginPercent/100
+	if c.Direction == "short" {
+		return c.EntryPrice * (1 + move)
+	}
+	return c.EntryPrice * (1 - move)
+}
+
+// Liquidated сообщает, дошла ли цена до цены ликвидации колла.
+func (c Call) Liquidated(price float64) bool {
+	liq := c.LiquidationPrice()
+	if liq <= 0 {
+		return false
+	}
+	if c.Direction == "short" {
+		return price >= liq
+	}
+	return price <= liq
+}
+
+// PriceChangePercent изменение цены от входа в процентах с учетом направления колла.
+func (c Call) PriceChangePercent(price float64) float64 {
+	if c.EntryPrice <= 0 {
+		return 0
+	}
+	if c.Direction == "short" {
+		return (c.EntryPrice - price) / c.EntryPrice * 100
+	}
+	return (price - c.EntryPrice) / c.EntryPrice * 100
+}
+
+// Returns считает результат колла при выходе по цене price в процентах от маржи (доли депозита):
+// изменение цены, умноженное на плечо, за вычетом комиссий тейкера за вход и выход и накопленного
+// финансирования. Убыток ограничен маржей (-100%).
+func (c Call) Returns(price float64) (pnl, fees, funding float64) {
+	lev := c.EffectiveLeverage()
+	if c.EntryPrice > 0 {
+		fees = lev * c.TakerFee * (1 + price/c.EntryPrice)
+	}
+	funding = lev * c.FundingPercent
+	pnl = lev*c.PriceChangePercent(price) - fees - funding
+	return math.Max(pnl, -100), fees, funding
+}
+
+// NetPnlPercent результат колла при выходе по цене price в процентах от маржи (см. Returns).
+func (c Call) NetPnlPercent(price float64) float64 {
+	pnl, _, _ := c.Returns(price)
+	return pnl
+}
+
+// ValidateLeverage проверяет плечо колла: от 1 до MaxLeverage, на споте — только 1.
+func ValidateLeverage(leverage float64, market string) error {
+	if leverage < 1 || leverage > MaxLeverage {
+		return errors.New("плечо должно быть от 1 до 125")
+	}
+	if leverage > 1 && market == "spot" {
+		return errors.New("плечо доступно только на фьючерсах")
+	}
+	return nil
+}
+
+// AccrueFunding добавляет к накопленному финансированию колла percent процентов от объема
+// (положительное — позиция платит, отрицательное — получает) и запоминает момент начисления.
+func (s *DatabaseStorage) AccrueFunding(callID string, percent float64, at time.Time) error {
+	_, err := s.db.Exec(`
+		UPDATE calls
+		SET funding_percent = COALESCE(funding_percent, 0) + ?, funding_at = ?, version = COALESCE(version, 0) + 1
+		WHERE id = ? AND status = 'open'`,
+		percent, at, callID)
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"call_id": callID,
+		"percent": percent,
+	}).Debug("funding accrued")
+	return nil
+}
@@ -0,0 +1,243 @@
+package alerts
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// migrationFiles пронумерованные миграции схемы: migrations/NNNN_описание.sql. Номера не меняются
+// после выпуска, новая миграция получает следующий номер.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// baselineVersion базовая схема: до версионных миграций таблицы создавались и дополнялись колонками
+// при каждом запуске, поэтому в ней уже существующие колонки пропускаются. Остальные миграции
+// применяются строго: ошибка откатывает миграцию целиком.
+const baselineVersion = 1
+
+// Migration одна миграция схемы.
+type Migration struct {
+	Version    int
+	Name       string
+	statements []string
+}
+
+// MigrationStatus миграция и момент ее применения; AppliedAt nil — миграция еще не применена.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// loadMigrations читает встроенные миграции в порядке номеров.
+func loadMigrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		number, name, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(number)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("неверное имя миграции %q, ожидается NNNN_описание.sql", entry.Name())
+		}
+		data, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, statements: splitStatements(string(data))})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("две миграции с номером %d", migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+// splitStatements делит SQL миграции на запросы по ";" в конце строки и отбрасывает пустые
+// и состоящие только из комментариев.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		current.WriteString(line)
+		current.WriteString("\n")
+		if !strings.HasSuffix(strings.TrimSpace(line), ";") {
+			continue
+		}
+		if stmt := strings.TrimSpace(current.String()); hasSQL(stmt) {
+			statements = append(statements, stmt)
+		}
+		current.Reset()
+	}
+	if stmt := strings.TrimSpace(current.String()); hasSQL(stmt) {
+		statements = append(statements, stmt)
+	}
+	return statements
+}
+
+// hasSQL сообщает, есть ли в тексте что-то кроме комментариев "--".
+func hasSQL(stmt string) bool {
+	for _, line := range strings.Split(stmt, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return true
+		}
+	}
+	return false
+}
+
+// LatestSchemaVersion номер последней миграции, известной этой сборке.
+func LatestSchemaVersion() int {
+	migrations, err := loadMigrations()
+	if err != nil || len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// ensureMigrationsTable создает таблицу примененных миграций.
+func (s *DatabaseStorage) ensureMigrationsTable() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at DATETIME NOT NULL
+		)`)
+	return err
+}
+
+// appliedMigrations возвращает моменты применения миграций по номерам.
+func (s *DatabaseStorage) appliedMigrations() (map[int]time.Time, error) {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// SchemaVersion номер последней примененной миграции; 0 — база еще не мигрирована.
+func (s *DatabaseStorage) SchemaVersion() (int, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// checkSchemaVersion отказывает в работе с базой, схема которой новее этой сборки:
+// старый код не знает о новых колонках и таблицах и может испортить данные.
+func (s *DatabaseStorage) checkSchemaVersion() error {
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if latest := LatestSchemaVersion(); version > latest {
+		return fmt.Errorf("схема базы версии %d новее поддерживаемой этой сборкой %d: обновите бота", version, latest)
+	}
+	return nil
+}
+
+// MigrationStatus возвращает все миграции сборки с отметкой о применении.
+func (s *DatabaseStorage) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// ApplyMigrations применяет непримененные миграции по порядку, каждую в своей транзакции,
+// и возвращает примененные. Если схема базы новее сборки, ничего не меняет.
+func (s *DatabaseStorage) ApplyMigrations() ([]Migration, error) {
+	if err := s.checkSchemaVersion(); err != nil {
+		return nil, err
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := s.withTx(func(tx *sql.Tx) error { return applyMigration(tx, m) }); err != nil {
+			return done, fmt.Errorf("миграция %04d_%s: %w", m.Version, m.Name, err)
+		}
+		logrus.WithFields(logrus.Fields{
+			"version": m.Version,
+			"name":    m.Name,
+		}).Info("schema migration applied")
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// applyMigration выполняет запросы миграции и отмечает ее примененной.
+func applyMigration(tx *sql.Tx, m Migration) error {
+	for _, stmt := range m.statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			// Колонки базовой схемы в старых базах уже могут быть добавлены
+			if m.Version == baselineVersion && strings.Contains(err.Error(), "duplicate column name") {
+				continue
+			}
+			return fmt.Errorf("%w\n%s", err, stmt)
+		}
+	}
+	_, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.Version, m.Name, time.Now().UTC())
+	return err
+}
@@ -0,0 +1,266 @@
+-- Базовая схема: таблицы и колонки, которые до версионных миграций создавались и добавлялись
+-- при каждом запуске. Базы того времени находятся в любом промежуточном состоянии, поэтому
+-- только в этой миграции уже существующие колонки пропускаются.
+
+CREATE TABLE IF NOT EXISTS limit_orders (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    username TEXT NOT NULL,
+    chat_id INTEGER NOT NULL,
+    symbol TEXT NOT NULL,
+    direction TEXT NOT NULL,
+    limit_price REAL NOT NULL,
+    deposit_percent REAL DEFAULT 0,
+    related_call_id TEXT DEFAULT '',
+    size_to_close REAL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'active',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    triggered_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_limit_orders_user_id ON limit_orders(user_id);
+CREATE INDEX IF NOT EXISTS idx_limit_orders_status ON limit_orders(status);
+CREATE INDEX IF NOT EXISTS idx_limit_orders_symbol ON limit_orders(symbol);
+CREATE INDEX IF NOT EXISTS idx_limit_orders_related_call_id ON limit_orders(related_call_id);
+
+CREATE TABLE IF NOT EXISTS reminders (
+    id TEXT PRIMARY KEY,
+    chat_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    username TEXT DEFAULT '',
+    symbol TEXT NOT NULL,
+    text TEXT DEFAULT '',
+    trigger_at DATETIME NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_reminders_trigger_at ON reminders(trigger_at);
+CREATE INDEX IF NOT EXISTS idx_reminders_chat_id   ON reminders(chat_id);
+
+CREATE TABLE IF NOT EXISTS alerts (
+    id TEXT PRIMARY KEY,
+    chat_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    username TEXT DEFAULT '',
+    symbol TEXT NOT NULL,
+    target_price REAL DEFAULT 0,
+    target_percent REAL DEFAULT 0,
+    base_price REAL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    market TEXT DEFAULT '',
+    exchange TEXT DEFAULT '',
+    side TEXT DEFAULT '',
+    mode TEXT DEFAULT 'once',
+    rearm_pct REAL DEFAULT 0,
+    cooldown_sec INTEGER DEFAULT 0,
+    armed INTEGER DEFAULT 1,
+    trigger_count INTEGER DEFAULT 0,
+    last_triggered_at DATETIME,
+    kind TEXT DEFAULT '',
+    expression TEXT DEFAULT '',
+    last_candle_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS alert_symbols (
+    alert_id TEXT NOT NULL,
+    symbol TEXT NOT NULL,
+    PRIMARY KEY (alert_id, symbol)
+);
+CREATE INDEX IF NOT EXISTS idx_alert_symbols_symbol ON alert_symbols(symbol);
+
+CREATE TABLE IF NOT EXISTS user_deposits (
+    user_id INTEGER PRIMARY KEY,
+    initial_deposit REAL DEFAULT 100,
+    current_deposit REAL DEFAULT 100,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_user_deposits_user_id ON user_deposits(user_id);
+CREATE INDEX IF NOT EXISTS idx_alerts_chat_id ON alerts(chat_id);
+CREATE INDEX IF NOT EXISTS idx_alerts_user_id ON alerts(user_id);
+CREATE INDEX IF NOT EXISTS idx_alerts_symbol ON alerts(symbol);
+CREATE INDEX IF NOT EXISTS idx_alerts_created_at ON alerts(created_at);
+
+CREATE TABLE IF NOT EXISTS calls (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    username TEXT NOT NULL,
+    chat_id INTEGER NOT NULL,
+    symbol TEXT NOT NULL,
+    direction TEXT NOT NULL DEFAULT 'long',
+    entry_price REAL NOT NULL,
+    exit_price REAL DEFAULT 0,
+    pnl_percent REAL DEFAULT 0,
+    size REAL DEFAULT 100,
+    status TEXT NOT NULL DEFAULT 'open',
+    opened_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    closed_at DATETIME,
+    market TEXT DEFAULT '',
+    deposit_percent REAL DEFAULT 0,
+    stop_loss_price REAL DEFAULT 0,
+    exchange TEXT DEFAULT '',
+    trail_percent REAL DEFAULT 0,
+    trail_distance REAL DEFAULT 0,
+    trail_activation REAL DEFAULT 0,
+    trail_best_price REAL DEFAULT 0,
+    breakeven_percent REAL DEFAULT 0,
+    breakeven_done INTEGER DEFAULT 0,
+    time_stop_at DATETIME,
+    swing_timeframe TEXT DEFAULT '',
+    swing_checked_at DATETIME,
+    leverage REAL DEFAULT 1,
+    taker_fee REAL DEFAULT 0,
+    funding_percent REAL DEFAULT 0,
+    funding_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_calls_user_id ON calls(user_id);
+CREATE INDEX IF NOT EXISTS idx_calls_status ON calls(status);
+CREATE INDEX IF NOT EXISTS idx_calls_symbol ON calls(symbol);
+CREATE INDEX IF NOT EXISTS idx_calls_opened_at ON calls(opened_at);
+
+CREATE TABLE IF NOT EXISTS call_fills (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    call_id TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    price REAL NOT NULL,
+    size REAL NOT NULL DEFAULT 0,
+    deposit_percent REAL DEFAULT 0,
+    pnl_percent REAL DEFAULT 0,
+    fee_percent REAL DEFAULT 0,
+    funding_percent REAL DEFAULT 0,
+    source TEXT NOT NULL DEFAULT 'manual',
+    filled_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_call_fills_call_id ON call_fills(call_id);
+
+CREATE TABLE IF NOT EXISTS call_take_profits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    call_id TEXT NOT NULL,
+    price REAL NOT NULL,
+    percent REAL NOT NULL,
+    status TEXT NOT NULL DEFAULT 'active',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    filled_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_call_take_profits_call_id ON call_take_profits(call_id);
+
+CREATE TABLE IF NOT EXISTS deposit_snapshots (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    deposit REAL NOT NULL,
+    equity REAL NOT NULL,
+    kind TEXT NOT NULL,
+    call_id TEXT DEFAULT '',
+    taken_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_deposit_snapshots_user_id ON deposit_snapshots(user_id, taken_at);
+
+CREATE TABLE IF NOT EXISTS limit_order_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_id TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    field TEXT NOT NULL,
+    old_value TEXT NOT NULL,
+    new_value TEXT NOT NULL,
+    changed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_limit_order_changes_order_id ON limit_order_changes(order_id);
+
+CREATE TABLE IF NOT EXISTS risk_rules (
+    scope TEXT NOT NULL,
+    owner_id INTEGER NOT NULL,
+    max_exposure REAL DEFAULT 0,
+    max_symbol_exposure REAL DEFAULT 0,
+    max_open_calls INTEGER DEFAULT 0,
+    require_stop_loss INTEGER DEFAULT 0,
+    max_loss_at_stop REAL DEFAULT 0,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (scope, owner_id)
+);
+
+CREATE TABLE IF NOT EXISTS alert_triggers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    alert_id TEXT,
+    symbol TEXT NOT NULL,
+    trigger_price REAL NOT NULL,
+    chat_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    username TEXT DEFAULT '',
+    trigger_type TEXT NOT NULL,
+    triggered_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_triggers_symbol ON alert_triggers(symbol);
+CREATE INDEX IF NOT EXISTS idx_triggers_chat_id ON alert_triggers(chat_id);
+CREATE INDEX IF NOT EXISTS idx_triggers_user_id ON alert_triggers(user_id);
+CREATE INDEX IF NOT EXISTS idx_triggers_triggered_at ON alert_triggers(triggered_at);
+
+CREATE TABLE IF NOT EXISTS price_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    symbol TEXT NOT NULL,
+    price REAL NOT NULL,
+    timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_price_history_symbol ON price_history(symbol);
+CREATE INDEX IF NOT EXISTS idx_price_history_timestamp ON price_history(timestamp);
+
+-- Миграция существующих данных - добавляем колонки если их нет
+ALTER TABLE alerts ADD COLUMN user_id INTEGER DEFAULT 0;
+ALTER TABLE alerts ADD COLUMN username TEXT DEFAULT '';
+ALTER TABLE alerts ADD COLUMN market TEXT DEFAULT '';
+ALTER TABLE alerts ADD COLUMN exchange TEXT DEFAULT '';
+ALTER TABLE alerts ADD COLUMN side TEXT DEFAULT '';
+ALTER TABLE alerts ADD COLUMN mode TEXT DEFAULT 'once';
+ALTER TABLE alerts ADD COLUMN rearm_pct REAL DEFAULT 0;
+ALTER TABLE alerts ADD COLUMN cooldown_sec INTEGER DEFAULT 0;
+ALTER TABLE alerts ADD COLUMN armed INTEGER DEFAULT 1;
+ALTER TABLE alerts ADD COLUMN trigger_count INTEGER DEFAULT 0;
+ALTER TABLE alerts ADD COLUMN last_triggered_at DATETIME;
+ALTER TABLE alerts ADD COLUMN kind TEXT DEFAULT '';
+ALTER TABLE alerts ADD COLUMN expression TEXT DEFAULT '';
+ALTER TABLE alerts ADD COLUMN last_candle_at DATETIME;
+ALTER TABLE alert_triggers ADD COLUMN user_id INTEGER DEFAULT 0;
+ALTER TABLE alert_triggers ADD COLUMN username TEXT DEFAULT '';
+ALTER TABLE calls ADD COLUMN market TEXT DEFAULT '';
+ALTER TABLE calls ADD COLUMN exchange TEXT DEFAULT '';
+ALTER TABLE calls ADD COLUMN size REAL DEFAULT 100;
+ALTER TABLE calls ADD COLUMN deposit_percent REAL DEFAULT 0;
+ALTER TABLE calls ADD COLUMN stop_loss_price REAL DEFAULT 0;
+ALTER TABLE calls ADD COLUMN trail_percent REAL DEFAULT 0;
+ALTER TABLE calls ADD COLUMN trail_distance REAL DEFAULT 0;
+ALTER TABLE calls ADD COLUMN trail_activation REAL DEFAULT 0;
+ALTER TABLE calls ADD COLUMN trail_best_price REAL DEFAULT 0;
+ALTER TABLE calls ADD COLUMN breakeven_percent REAL DEFAULT 0;
+ALTER TABLE calls ADD COLUMN breakeven_done INTEGER DEFAULT 0;
+ALTER TABLE calls ADD COLUMN time_stop_at DATETIME;
+ALTER TABLE calls ADD COLUMN swing_timeframe TEXT DEFAULT '';
+ALTER TABLE calls ADD COLUMN swing_checked_at DATETIME;
+ALTER TABLE calls ADD COLUMN leverage REAL DEFAULT 1;
+ALTER TABLE calls ADD COLUMN taker_fee REAL DEFAULT 0;
+ALTER TABLE calls ADD COLUMN funding_percent REAL DEFAULT 0;
+ALTER TABLE calls ADD COLUMN funding_at DATETIME;
+ALTER TABLE calls ADD COLUMN initial_risk_percent REAL DEFAULT 0;
+ALTER TABLE limit_orders ADD COLUMN order_type TEXT DEFAULT 'limit';
+ALTER TABLE limit_orders ADD COLUMN stop_price REAL DEFAULT 0;
+ALTER TABLE limit_orders ADD COLUMN stop_triggered INTEGER DEFAULT 0;
+ALTER TABLE limit_orders ADD COLUMN oco_group TEXT DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_limit_orders_oco_group ON limit_orders(oco_group);
+ALTER TABLE limit_orders ADD COLUMN expires_at DATETIME;
+ALTER TABLE call_fills ADD COLUMN fee_percent REAL DEFAULT 0;
+ALTER TABLE call_fills ADD COLUMN funding_percent REAL DEFAULT 0;
+ALTER TABLE calls ADD COLUMN version INTEGER DEFAULT 0;
+ALTER TABLE user_deposits ADD COLUMN version INTEGER DEFAULT 0;
+
+-- Представление пересоздается после добавления колонок журнала. call_results — итоги закрытий
+-- по коллам: средняя цена выхода и PnL, взвешенные по закрытому объему, изменение депозита,
+-- комиссии и финансирование в процентах депозита и число ликвидаций
+DROP VIEW IF EXISTS call_results;
+CREATE VIEW IF NOT EXISTS call_results AS
+    SELECT call_id,
+        SUM(size) AS closed_size,
+        SUM(size * price) / SUM(size) AS avg_exit_price,
+        SUM(size * pnl_percent) / SUM(size) AS pnl_percent,
+        SUM(deposit_percent * pnl_percent / 100) AS deposit_pnl_percent,
+        SUM(deposit_percent * COALESCE(fee_percent, 0) / 100) AS deposit_fee_percent,
+        SUM(deposit_percent * COALESCE(funding_percent, 0) / 100) AS deposit_funding_percent,
+        SUM(CASE WHEN source = 'liq' THEN 1 ELSE 0 END) AS liquidations
+    FROM call_fills
+    WHERE kind = 'close' AND size > 0
+    GROUP BY call_id;
@@ -0,0 +1,16 @@
+-- Данные, которые до версионных миграций исправлялись при каждом запуске.
+
+-- Коллы, созданные до появления колонки size
+UPDATE calls SET size = 100 WHERE size IS NULL OR size = 0;
+
+-- Журнал исполнений для коллов, открытых до его появления: открытие на полный размер и одно
+-- закрытие на уже закрытую часть с сохраненными ценой и PnL
+INSERT INTO call_fills (call_id, kind, price, size, deposit_percent, pnl_percent, source, filled_at)
+SELECT id, 'close', exit_price, 100 - size, COALESCE(deposit_percent, 0) * (100 - size) / 100, pnl_percent, 'legacy', COALESCE(closed_at, opened_at)
+FROM calls
+WHERE size < 100 AND exit_price > 0 AND id NOT IN (SELECT call_id FROM call_fills);
+
+INSERT INTO call_fills (call_id, kind, price, size, deposit_percent, pnl_percent, source, filled_at)
+SELECT id, 'open', entry_price, 100, COALESCE(deposit_percent, 0), 0, 'legacy', opened_at
+FROM calls
+WHERE id NOT IN (SELECT call_id FROM call_fills WHERE kind = 'open');
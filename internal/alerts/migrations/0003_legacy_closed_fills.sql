@@ -0,0 +1,7 @@
+-- Закрытым коллам, созданным до журнала исполнений, 0002 не добавила закрытие: size полностью
+-- закрытого колла к тому времени уже был сброшен обратно в 100, и колл считался незакрытым.
+-- Закрытый колл закрыт целиком, поэтому закрытие добавляется на весь размер с сохраненными ценой и PnL
+INSERT INTO call_fills (call_id, kind, price, size, deposit_percent, pnl_percent, source, filled_at)
+SELECT id, 'close', exit_price, 100, COALESCE(deposit_percent, 0), pnl_percent, 'legacy', COALESCE(closed_at, opened_at)
+FROM calls
+WHERE status = 'closed' AND exit_price > 0 AND id NOT IN (SELECT call_id FROM call_fills WHERE kind = 'close');
@@ -0,0 +1,4 @@
+-- Стоп-лосс и плечо ордера на открытие: переносятся в колл при исполнении
+-- и учитываются риск-правилами при выставлении и исполнении ордера
+ALTER TABLE limit_orders ADD COLUMN stop_loss_price REAL DEFAULT 0;
+ALTER TABLE limit_orders ADD COLUMN leverage REAL DEFAULT 1;
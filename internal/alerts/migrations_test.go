@@ -0,0 +1,97 @@
+package alerts
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// legacyCallsTable таблица calls в том виде, в каком ее создавали версии до журнала исполнений
+const legacyCallsTable = `CREATE TABLE calls (
+	id TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	username TEXT NOT NULL,
+	chat_id INTEGER NOT NULL,
+	symbol TEXT NOT NULL,
+	direction TEXT NOT NULL DEFAULT 'long',
+	entry_price REAL NOT NULL,
+	exit_price REAL DEFAULT 0,
+	pnl_percent REAL DEFAULT 0,
+	size REAL DEFAULT 100,
+	status TEXT NOT NULL DEFAULT 'open',
+	opened_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	closed_at DATETIME,
+	market TEXT DEFAULT '',
+	deposit_percent REAL DEFAULT 0,
+	stop_loss_price REAL DEFAULT 0,
+	exchange TEXT DEFAULT ''
+)`
+
+func TestMigrateLegacyCallsKeepsResults(t *testing.T) {
+	s, err := OpenDatabaseStorage(filepath.Join(t.TempDir(), "legacy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Старые версии при полном закрытии обнуляли size, а при следующем запуске возвращали его в 100;
+	// частичное закрытие оставляло у открытого колла остаток и цену с PnL последнего закрытия
+	if _, err := s.db.Exec(legacyCallsTable); err != nil {
+		t.Fatal(err)
+	}
+	legacy := []struct {
+		id, status           string
+		size, exitPrice, pnl float64
+	}{
+		{"closed-restarted", "closed", 100, 120, 20},
+		{"closed-fresh", "closed", 0, 95, -5},
+		{"partial", "open", 50, 90, -10},
+		{"untouched", "open", 100, 0, 0},
+	}
+	for _, c := range legacy {
+		if _, err := s.db.Exec(`
+			INSERT INTO calls (id, user_id, username, chat_id, symbol, entry_price, exit_price, pnl_percent, size, status, deposit_percent, closed_at)
+			VALUES (?, 1, 'user', 1, 'BTCUSDT', 100, ?, ?, ?, ?, 10, CASE WHEN ? = 'closed' THEN CURRENT_TIMESTAMP END)`,
+			c.id, c.exitPrice, c.pnl, c.size, c.status, c.status); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := s.ApplyMigrations(); err != nil {
+		t.Fatal(err)
+	}
+
+	wantClosed := map[string]float64{"closed-restarted": 100, "closed-fresh": 100, "partial": 50}
+	for _, c := range legacy {
+		units, count := fillTotals(t, s, c.id)
+		if count[FillKindOpen] != 1 || units[FillKindOpen] != 100 {
+			t.Errorf("%s: открытий %d на %.2f, ожидалось одно на 100", c.id, count[FillKindOpen], units[FillKindOpen])
+		}
+		if units[FillKindClose] != wantClosed[c.id] {
+			t.Errorf("%s: закрыто %.2f, ожидалось %.2f", c.id, units[FillKindClose], wantClosed[c.id])
+		}
+		if wantClosed[c.id] == 0 {
+			continue
+		}
+		exit, pnl, err := callResult(s.db, c.id)
+		if err != nil {
+			t.Fatalf("%s: %v", c.id, err)
+		}
+		if exit != c.exitPrice || pnl != c.pnl {
+			t.Errorf("%s: выход %.2f и PnL %.2f, ожидалось %.2f и %.2f", c.id, exit, pnl, c.exitPrice, c.pnl)
+		}
+	}
+
+	stats, err := s.GetUserStats(1, StatsFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ClosedCalls != 2 || stats.WinningCalls != 1 || math.Abs(stats.TotalPnl-15) > 1e-9 {
+		t.Errorf("закрытых %d, прибыльных %d, PnL %.2f; ожидалось 2, 1 и 15", stats.ClosedCalls, stats.WinningCalls, stats.TotalPnl)
+	}
+
+	// Повторный запуск миграций ничего не добавляет
+	if done, err := s.ApplyMigrations(); err != nil || len(done) != 0 {
+		t.Errorf("повторное применение: %d миграций, %v", len(done), err)
+	}
+}
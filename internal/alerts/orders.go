@@ -0,0 +1,362 @@
+package alerts
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Типы ордеров
+const (
+	OrderTypeLimit     = "limit"      // Long — цена опустилась до лимита или ниже, short — поднялась до лимита или выше
+	OrderTypeStop      = "stop"       // Long — цена поднялась до стоп-цены или выше, short — опустилась; исполнение по рынку
+	OrderTypeStopLimit = "stop_limit" // После стоп-цены становится лимитным ордером по LimitPrice
+)
+
+// limitOrderColumns колонки limit_orders в порядке scanLimitOrders
+const limitOrderColumns = `id, user_id, username, chat_id, symbol, direction, limit_price,
+		       deposit_percent, COALESCE(related_call_id, ''), COALESCE(size_to_close, 0),
+		       status, created_at, triggered_at,
+		       COALESCE(order_type, 'limit'), COALESCE(stop_price, 0), COALESCE(stop_triggered, 0), COALESCE(oco_group, ''),
+		       expires_at, COALESCE(stop_loss_price, 0), COALESCE(leverage, 1)`
+
+// scanLimitOrders читает ордера, выбранные с колонками limitOrderColumns.
+func scanLimitOrders(rows *sql.Rows) []LimitOrder {
+	var orders []LimitOrder
+	for rows.Next() {
+		var order LimitOrder
+		var triggeredAt, expiresAt sql.NullTime
+		err := rows.Scan(&order.ID, &order.UserID, &order.Username, &order.ChatID,
+			&order.Symbol, &order.Direction, &order.LimitPrice, &order.DepositPercent,
+			&order.RelatedCallID, &order.SizeToClose, &order.Status, &order.CreatedAt, &triggeredAt,
+			&order.OrderType, &order.StopPrice, &order.StopTriggered, &order.OcoGroup,
+			&expiresAt, &order.StopLossPrice, &order.Leverage)
+		if err != nil {
+			logrus.WithError(err).Warn("failed to scan limit order row")
+			continue
+		}
+		if triggeredAt.Valid {
+			order.TriggeredAt = &triggeredAt.Time
+		}
+		if expiresAt.Valid {
+			order.ExpiresAt = &expiresAt.Time
+		}
+		orders = append(orders, order)
+	}
+	return orders
+}
+
+// WaitingForStop сообщает, что ордер еще ждет стоп-цены.
+func (o LimitOrder) WaitingForStop() bool {
+	return o.OrderType == OrderTypeStop || (o.OrderType == OrderTypeStopLimit && !o.StopTriggered)
+}
+
+// TriggerPrice цена, которой ордер ждет сейчас: стоп-цена до срабатывания стопа, затем лимитная.
+func (o LimitOrder) TriggerPrice() float64 {
+	if o.WaitingForStop() {
+		return o.StopPrice
+	}
+	return o.LimitPrice
+}
+
+// StopReached сообщает, что цена дошла до стоп-цены: long — до нее или выше, short — до нее или ниже.
+func (o LimitOrder) StopReached(price float64) bool {
+	if o.Direction == "short" {
+		return price <= o.StopPrice
+	}
+	return price >= o.StopPrice
+}
+
+// LimitReached сообщает, что цена дошла до лимитной: long — до нее или ниже, short — до нее или выше.
+func (o LimitOrder) LimitReached(price float64) bool {
+	if o.Direction == "short" {
+		return price >= o.LimitPrice
+	}
+	return price <= o.LimitPrice
+}
+
+// Expired сообщает, что срок действия ордера истек к моменту now.
+func (o LimitOrder) Expired(now time.Time) bool {
+	return o.ExpiresAt != nil && !now.Before(*o.ExpiresAt)
+}
+
+// GetLimitOrderByID возвращает ордер пользователя по ID.
+func (s *DatabaseStorage) GetLimitOrderByID(orderID string, userID int64) (*LimitOrder, error) {
+	rows, err := s.db.Query(`
+		SELECT `+limitOrderColumns+`
+		FROM limit_orders
+		WHERE id = ? AND user_id = ?`, orderID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := scanLimitOrders(rows)
+	if len(orders) == 0 {
+		return nil, errors.New("ордер не найден")
+	}
+	return &orders[0], nil
+}
+
+// SetLimitOrderOcoGroup включает активный ордер в OCO-группу.
+func (s *DatabaseStorage) SetLimitOrderOcoGroup(orderID string, group string) error {
+	_, err := s.db.Exec(`UPDATE limit_orders SET oco_group = ? WHERE id = ? AND status = 'active'`, group, orderID)
+	return err
+}
+
+// ErrOrderNotActive ордер успели исполнить, отменить или снять по сроку, пока он проверялся.
+var ErrOrderNotActive = errors.New("ордер уже не активен")
+
+// ActivateStopLimit отмечает, что стоп-цена stop_limit ордера достигнута, и в той же транзакции
+// отменяет остальные ордера его OCO-группы. Возвращает ID отмененных ордеров.
+func (s *DatabaseStorage) ActivateStopLimit(order LimitOrder) ([]string, error) {
+	var cancelled []string
+	err := s.withTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`UPDATE limit_orders SET stop_triggered = 1 WHERE id = ? AND status = 'active'`, order.ID)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrOrderNotActive
+		}
+		cancelled, err = cancelOcoSiblings(tx, order.OcoGroup, order.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.WithField("order_id", order.ID).Info("stop-limit order activated")
+	logOcoCancelled(order, cancelled)
+	return cancelled, nil
+}
+
+// CloseCallByOrder исполняет ордер на закрытие колла по цене exitPrice: ордер помечается исполненным,
+// колл закрывается и остальные ордера OCO-группы отменяются одной транзакцией, поэтому обе ноги OCO
+// не могут остаться активными. Возвращает ID отмененных ордеров группы.
+func (s *DatabaseStorage) CloseCallByOrder(order LimitOrder, exitPrice float64) ([]string, error) {
+	var cancelled []string
+	err := s.closeCall(order.RelatedCallID, order.UserID, exitPrice, order.SizeToClose, FillSourceLimit, func(tx *sql.Tx) error {
+		var err error
+		cancelled, err = fillLimitOrder(tx, order)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logOcoCancelled(order, cancelled)
+	return cancelled, nil
+}
+
+// OpenCallByOrder исполняет ордер на открытие: колл candidate открывается, ордер помечается исполненным
+// и остальные ордера OCO-группы отменяются одной транзакцией. Возвращает колл и ID отмененных ордеров.
+// Риск-правила проверяются в той же транзакции; при нарушении (*RiskViolation) ордер остается активным.
+func (s *DatabaseStorage) OpenCallByOrder(order LimitOrder, candidate Call) (Call, []string, error) {
+	var cancelled []string
+	call, err := s.openCall(candidate, FillSourceLimit, func(tx *sql.Tx) error {
+		var err error
+		if cancelled, err = fillLimitOrder(tx, order); err != nil {
+			return err
+		}
+		return checkRiskRules(tx, candidate)
+	})
+	if err != nil {
+		return call, nil, err
+	}
+
+	logOcoCancelled(order, cancelled)
+	return call, cancelled, nil
+}
+
+// fillLimitOrder помечает активный ордер исполненным и отменяет остальные ордера его OCO-группы.
+// Ордер, который уже не активен, дает ErrOrderNotActive и откатывает всю транзакцию исполнения.
+func fillLimitOrder(tx *sql.Tx, order LimitOrder) ([]string, error) {
+	result, err := tx.Exec(`
+		UPDATE limit_orders
+		SET status = 'triggered', triggered_at = ?
+		WHERE id = ? AND status = 'active'`,
+		time.Now(), order.ID)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, ErrOrderNotActive
+	}
+	return cancelOcoSiblings(tx, order.OcoGroup, order.ID)
+}
+
+// cancelOcoSiblings отменяет активные ордера OCO-группы, кроме exceptID, и возвращает их ID.
+func cancelOcoSiblings(tx *sql.Tx, group, exceptID string) ([]string, error) {
+	if group == "" {
+		return nil, nil
+	}
+
+	rows, err := tx.Query(`SELECT id FROM limit_orders WHERE oco_group = ? AND id != ? AND status = 'active'`, group, exceptID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	if _, err := tx.Exec(`UPDATE limit_orders SET status = 'cancelled' WHERE oco_group = ? AND id != ? AND status = 'active'`,
+		group, exceptID); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// logOcoCancelled пишет в журнал отмену ордеров OCO-группы после фиксации транзакции
+func logOcoCancelled(order LimitOrder, cancelled []string) {
+	if len(cancelled) == 0 {
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"oco_group": order.OcoGroup,
+		"filled_id": order.ID,
+		"cancelled": cancelled,
+	}).Info("oco siblings cancelled")
+}
+
+// GetExpiredLimitOrders возвращает активные ордера, срок действия которых истек к моменту now.
+func (s *DatabaseStorage) GetExpiredLimitOrders(now time.Time) []LimitOrder {
+	rows, err := s.db.Query(`
+		SELECT ` + limitOrderColumns + `
+		FROM limit_orders
+		WHERE status = 'active' AND expires_at IS NOT NULL
+		ORDER BY created_at ASC`)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to get limit orders with expiry")
+		return nil
+	}
+	defer rows.Close()
+
+	// Время сравнивается в Go: в базе оно хранится строкой с часовым поясом
+	var expired []LimitOrder
+	for _, order := range scanLimitOrders(rows) {
+		if order.Expired(now) {
+			expired = append(expired, order)
+		}
+	}
+	return expired
+}
+
+// ExpireLimitOrder помечает активный ордер как истекший; false — ордер уже не активен.
+func (s *DatabaseStorage) ExpireLimitOrder(orderID string) (bool, error) {
+	result, err := s.db.Exec(`UPDATE limit_orders SET status = 'expired' WHERE id = ? AND status = 'active'`, orderID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected > 0 {
+		logrus.WithField("order_id", orderID).Info("limit order expired")
+	}
+	return affected > 0, nil
+}
+
+// LimitOrderChange запись журнала изменений ордера.
+type LimitOrderChange struct {
+	ID        int64     `json:"id"`
+	OrderID   string    `json:"order_id"`
+	UserID    int64     `json:"user_id"`
+	Field     string    `json:"field"` // "price", "stop", "size" или "expiry"
+	OldValue  string    `json:"old_value"`
+	NewValue  string    `json:"new_value"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// ModifyLimitOrder сохраняет измененные цены, размер и срок действия активного ордера order
+// и записывает changes в журнал изменений одной транзакцией.
+func (s *DatabaseStorage) ModifyLimitOrder(order LimitOrder, changes []LimitOrderChange) error {
+	err := s.withTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`
+			UPDATE limit_orders
+			SET limit_price = ?, stop_price = ?, deposit_percent = ?, size_to_close = ?, expires_at = ?
+			WHERE id = ? AND user_id = ? AND status = 'active'`,
+			order.LimitPrice, order.StopPrice, order.DepositPercent, order.SizeToClose, order.ExpiresAt,
+			order.ID, order.UserID)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return errors.New("ордер не найден или уже не активен")
+		}
+
+		now := time.Now()
+		for _, change := range changes {
+			if _, err := tx.Exec(`
+				INSERT INTO limit_order_changes (order_id, user_id, field, old_value, new_value, changed_at)
+				VALUES (?, ?, ?, ?, ?, ?)`,
+				order.ID, order.UserID, change.Field, change.OldValue, change.NewValue, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"order_id": order.ID,
+		"user_id":  order.UserID,
+		"changes":  changes,
+	}).Info("limit order modified")
+	return nil
+}
+
+// GetLimitOrderChanges возвращает журнал изменений ордера в порядке времени.
+func (s *DatabaseStorage) GetLimitOrderChanges(orderID string) []LimitOrderChange {
+	rows, err := s.db.Query(`
+		SELECT id, order_id, user_id, field, old_value, new_value, changed_at
+		FROM limit_order_changes
+		WHERE order_id = ?
+		ORDER BY changed_at, id`, orderID)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to get limit order changes")
+		return nil
+	}
+	defer rows.Close()
+
+	var changes []LimitOrderChange
+	for rows.Next() {
+		var c LimitOrderChange
+		if err := rows.Scan(&c.ID, &c.OrderID, &c.UserID, &c.Field, &c.OldValue, &c.NewValue, &c.ChangedAt); err != nil {
+			logrus.WithError(err).Warn("failed to scan limit order change row")
+			continue
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
@@ -0,0 +1,218 @@
+package alerts
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Области риск-правил
+const (
+	RiskScopeUser = "user" // Правила пользователя (действуют во всех чатах)
+	RiskScopeChat = "chat" // Правила чата (действуют для всех участников)
+)
+
+// RiskRules ограничения на открытие коллов. Нулевое значение поля — ограничения нет.
+// Экспозиция считается как объем позиции в процентах депозита: доля депозита × плечо × оставшийся размер.
+type RiskRules struct {
+	MaxExposure       float64 `json:"max_exposure,omitempty"`        // Суммарная экспозиция открытых коллов, % депозита
+	MaxSymbolExposure float64 `json:"max_symbol_exposure,omitempty"` // Экспозиция по одному символу, % депозита
+	MaxOpenCalls      int     `json:"max_open_calls,omitempty"`      // Число одновременно открытых коллов
+	RequireStopLoss   bool    `json:"require_stop_loss,omitempty"`   // Колл можно открыть только со стоп-лоссом
+	MaxLossAtStop     float64 `json:"max_loss_at_stop,omitempty"`    // Убыток при срабатывании стопа, % депозита
+}
+
+// Empty сообщает, что ни одно ограничение не задано.
+func (r RiskRules) Empty() bool {
+	return r == RiskRules{}
+}
+
+// Merge объединяет правила, выбирая по каждому полю более строгое ограничение.
+func (r RiskRules) Merge(other RiskRules) RiskRules {
+	return RiskRules{
+		MaxExposure:       stricterLimit(r.MaxExposure, other.MaxExposure),
+		MaxSymbolExposure: stricterLimit(r.MaxSymbolExposure, other.MaxSymbolExposure),
+		MaxOpenCalls:      int(stricterLimit(float64(r.MaxOpenCalls), float64(other.MaxOpenCalls))),
+		RequireStopLoss:   r.RequireStopLoss || other.RequireStopLoss,
+		MaxLossAtStop:     stricterLimit(r.MaxLossAtStop, other.MaxLossAtStop),
+	}
+}
+
+// stricterLimit меньший из двух лимитов; 0 означает отсутствие лимита.
+func stricterLimit(a, b float64) float64 {
+	if a <= 0 {
+		return b
+	}
+	if b <= 0 {
+		return a
+	}
+	return math.Min(a, b)
+}
+
+// RiskViolation колл нарушает риск-правило.
+type RiskViolation struct {
+	Rule    string // "exposure", "symbol", "calls", "sl" или "maxloss"
+	Message string
+}
+
+func (v *RiskViolation) Error() string { return v.Message }
+
+// Exposure объем оставшейся позиции колла в процентах депозита.
+func (c Call) Exposure() float64 {
+	return c.DepositPercent * c.EffectiveLeverage() * c.Size / 100
+}
+
+// LossAtStop убыток колла в процентах депозита при закрытии остатка по стоп-лоссу с учетом плеча
+// и комиссий. Без стоп-лосса рискуется вся маржа остатка позиции.
+func (c Call) LossAtStop() float64 {
+	margin := c.DepositPercent * c.Size / 100
+	if c.StopLossPrice <= 0 {
+		return margin
+	}
+	return math.Max(0, -c.NetPnlPercent(c.StopLossPrice)*margin/100)
+}
+
+// Check проверяет, можно ли открыть колл call при уже открытых коллах пользователя open.
+// Если call уже есть среди open (добор), он заменяет собой старое состояние. Размер 0 считается
+// новым коллом на полный размер.
+func (r RiskRules) Check(open []Call, call Call) error {
+	if call.Size <= 0 {
+		call.Size = 100
+	}
+
+	total, symbol := call.Exposure(), call.Exposure()
+	count := 1
+	for _, c := range open {
+		if c.ID == call.ID || c.Status != "open" {
+			continue
+		}
+		count++
+		total += c.Exposure()
+		if c.Symbol == call.Symbol {
+			symbol += c.Exposure()
+		}
+	}
+
+	if r.MaxOpenCalls > 0 && count > r.MaxOpenCalls {
+		return &RiskViolation{Rule: "calls", Message: fmt.Sprintf(
+			"превышен лимит открытых коллов: будет %d при лимите %d", count, r.MaxOpenCalls)}
+	}
+	if r.RequireStopLoss && call.StopLossPrice <= 0 {
+		return &RiskViolation{Rule: "sl", Message: "колл можно открыть только со стоп-лоссом (sl PRICE)"}
+	}
+	if r.MaxExposure > 0 && total > r.MaxExposure+1e-9 {
+		return &RiskViolation{Rule: "exposure", Message: fmt.Sprintf(
+			"суммарная экспозиция станет %.0f%% депозита при лимите %.0f%%", total, r.MaxExposure)}
+	}
+	if r.MaxSymbolExposure > 0 && symbol > r.MaxSymbolExposure+1e-9 {
+		return &RiskViolation{Rule: "symbol", Message: fmt.Sprintf(
+			"экспозиция по %s станет %.0f%% депозита при лимите %.0f%%", call.Symbol, symbol, r.MaxSymbolExposure)}
+	}
+	if r.MaxLossAtStop > 0 {
+		if loss := call.LossAtStop(); loss > r.MaxLossAtStop+1e-9 {
+			return &RiskViolation{Rule: "maxloss", Message: fmt.Sprintf(
+				"убыток по стоп-лоссу составит %.2f%% депозита при лимите %.2f%%", loss, r.MaxLossAtStop)}
+		}
+	}
+	return nil
+}
+
+// GetRiskRules возвращает правила области scope для пользователя или чата ownerID;
+// если правила не заданы — пустые.
+func (s *DatabaseStorage) GetRiskRules(scope string, ownerID int64) (RiskRules, error) {
+	return getRiskRules(s.db, scope, ownerID)
+}
+
+// getRiskRules читает правила отдельно или в транзакции открытия колла
+func getRiskRules(q querier, scope string, ownerID int64) (RiskRules, error) {
+	var rules RiskRules
+	err := q.QueryRow(`
+		SELECT max_exposure, max_symbol_exposure, max_open_calls, require_stop_loss, max_loss_at_stop
+		FROM risk_rules WHERE scope = ? AND owner_id = ?`,
+		scope, ownerID).Scan(&rules.MaxExposure, &rules.MaxSymbolExposure, &rules.MaxOpenCalls,
+		&rules.RequireStopLoss, &rules.MaxLossAtStop)
+	if err == sql.ErrNoRows {
+		return RiskRules{}, nil
+	}
+	return rules, err
+}
+
+// SetRiskRules сохраняет правила области scope; пустые правила удаляются.
+func (s *DatabaseStorage) SetRiskRules(scope string, ownerID int64, rules RiskRules) error {
+	var err error
+	if rules.Empty() {
+		_, err = s.db.Exec(`DELETE FROM risk_rules WHERE scope = ? AND owner_id = ?`, scope, ownerID)
+	} else {
+		_, err = s.db.Exec(`
+			INSERT INTO risk_rules (scope, owner_id, max_exposure, max_symbol_exposure, max_open_calls, require_stop_loss, max_loss_at_stop, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(scope, owner_id) DO UPDATE SET
+				max_exposure = excluded.max_exposure,
+				max_symbol_exposure = excluded.max_symbol_exposure,
+				max_open_calls = excluded.max_open_calls,
+				require_stop_loss = excluded.require_stop_loss,
+				max_loss_at_stop = excluded.max_loss_at_stop,
+				updated_at = excluded.updated_at`,
+			scope, ownerID, rules.MaxExposure, rules.MaxSymbolExposure, rules.MaxOpenCalls,
+			rules.RequireStopLoss, rules.MaxLossAtStop, time.Now())
+	}
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"scope":    scope,
+		"owner_id": ownerID,
+		"rules":    rules,
+	}).Info("risk rules updated")
+	return nil
+}
+
+// EffectiveRiskRules правила, которые действуют для пользователя в чате: по каждому ограничению
+// берется более строгое из правил пользователя и чата.
+func (s *DatabaseStorage) EffectiveRiskRules(userID, chatID int64) RiskRules {
+	user, err := s.GetRiskRules(RiskScopeUser, userID)
+	if err != nil {
+		logrus.WithError(err).WithField("user_id", userID).Warn("failed to get user risk rules")
+	}
+	chat, err := s.GetRiskRules(RiskScopeChat, chatID)
+	if err != nil {
+		logrus.WithError(err).WithField("chat_id", chatID).Warn("failed to get chat risk rules")
+	}
+	return user.Merge(chat)
+}
+
+// checkRiskRules проверяет колл call по действующим правилам его пользователя и чата в транзакции
+// открытия или добора. Правила и открытые коллы читаются через tx, поэтому параллельные открытия
+// не могут вместе превысить лимиты, проверенные каждое по отдельности.
+func checkRiskRules(tx *sql.Tx, call Call) error {
+	user, err := getRiskRules(tx, RiskScopeUser, call.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get user risk rules: %w", err)
+	}
+	chat, err := getRiskRules(tx, RiskScopeChat, call.ChatID)
+	if err != nil {
+		return fmt.Errorf("failed to get chat risk rules: %w", err)
+	}
+	rules := user.Merge(chat)
+	if rules.Empty() {
+		return nil
+	}
+
+	open, err := userCalls(tx, call.UserID, true)
+	if err != nil {
+		return fmt.Errorf("failed to get open calls: %w", err)
+	}
+	return rules.Check(open, call)
+}
+
+// OpenCallChecked открывает колл, если он не нарушает риск-правила пользователя и чата.
+// Проверка выполняется в транзакции открытия; нарушение возвращается как *RiskViolation.
+func (s *DatabaseStorage) OpenCallChecked(call Call, source string) (Call, error) {
+	return s.openCall(call, source, func(tx *sql.Tx) error {
+		return checkRiskRules(tx, call)
+	})
+}
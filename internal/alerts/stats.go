@@ -0,0 +1,172 @@
+package alerts
+
+import (
+	"database/sql"
+	"math"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StatsFilter окно и фильтр статистики коллов. Коллы попадают в окно по времени открытия;
+// нулевые From и To — окно без границы. Коллы без доли депозита в статистику не входят.
+type StatsFilter struct {
+	From   time.Time
+	To     time.Time // Не включительно
+	Symbol string    // Пустой — все символы
+	Label  string    // Описание окна для заголовков, например "за 30 дней"
+}
+
+// DefaultStatsDays окно статистики по умолчанию
+const DefaultStatsDays = 90
+
+// DefaultStatsFilter окно последних DefaultStatsDays дней по всем символам.
+func DefaultStatsFilter(now time.Time) StatsFilter {
+	return StatsFilter{From: now.AddDate(0, 0, -DefaultStatsDays), Label: "за последние 90 дней"}
+}
+
+// sqlTimeFormat формат времени SQLite datetime(), в котором сравниваются границы окна
+const sqlTimeFormat = "2006-01-02 15:04:05"
+
+// where возвращает условия фильтра для таблицы calls (начиная с " AND ") и их аргументы.
+func (f StatsFilter) where() (string, []interface{}) {
+	cond := " AND deposit_percent > 0"
+	var args []interface{}
+	if !f.From.IsZero() {
+		cond += " AND opened_at >= ?"
+		args = append(args, f.From.UTC().Format(sqlTimeFormat))
+	}
+	if !f.To.IsZero() {
+		cond += " AND opened_at < ?"
+		args = append(args, f.To.UTC().Format(sqlTimeFormat))
+	}
+	if f.Symbol != "" {
+		cond += " AND symbol = ?"
+		args = append(args, f.Symbol)
+	}
+	return cond, args
+}
+
+// TradeMetrics показатели закрытых коллов, которые считаются по каждой сделке.
+type TradeMetrics struct {
+	GrossProfit   float64       `json:"gross_profit"`   // Сумма PnL прибыльных коллов, %
+	GrossLoss     float64       `json:"gross_loss"`     // Сумма PnL убыточных коллов по модулю, %
+	ProfitFactor  float64       `json:"profit_factor"`  // GrossProfit / GrossLoss; 0, если убыточных нет
+	Expectancy    float64       `json:"expectancy"`     // Средний результат сделки в % депозита
+	AvgHold       time.Duration `json:"avg_hold"`       // Среднее время удержания
+	AvgR          float64       `json:"avg_r"`          // Средний результат в R (риск до первого стоп-лосса)
+	RCalls        int           `json:"r_calls"`        // Коллов со стоп-лоссом, по которым считается AvgR
+	MaxWinStreak  int           `json:"max_win_streak"` // Самая длинная серия прибыльных коллов
+	MaxLossStreak int           `json:"max_loss_streak"`
+}
+
+// closedTrade закрытый колл для расчета TradeMetrics
+type closedTrade struct {
+	pnl         float64
+	depositPnl  float64
+	openedAt    time.Time
+	closedAt    sql.NullTime
+	riskPercent float64
+	leverage    float64
+}
+
+// computeTradeMetrics считает показатели по закрытым коллам в порядке закрытия.
+func computeTradeMetrics(trades []closedTrade) TradeMetrics {
+	var m TradeMetrics
+	if len(trades) == 0 {
+		return m
+	}
+
+	var depositPnl, rSum float64
+	var hold time.Duration
+	var holdCount, winStreak, lossStreak int
+	for _, t := range trades {
+		depositPnl += t.depositPnl
+		switch {
+		case t.pnl > 0:
+			m.GrossProfit += t.pnl
+			winStreak, lossStreak = winStreak+1, 0
+		case t.pnl < 0:
+			m.GrossLoss -= t.pnl
+			winStreak, lossStreak = 0, lossStreak+1
+		default:
+			winStreak, lossStreak = 0, 0
+		}
+		if winStreak > m.MaxWinStreak {
+			m.MaxWinStreak = winStreak
+		}
+		if lossStreak > m.MaxLossStreak {
+			m.MaxLossStreak = lossStreak
+		}
+
+		if t.closedAt.Valid && t.closedAt.Time.After(t.openedAt) {
+			hold += t.closedAt.Time.Sub(t.openedAt)
+			holdCount++
+		}
+		// PnL считается от маржи, поэтому риск до стопа умножается на плечо
+		if t.riskPercent > 0 {
+			rSum += t.pnl / (t.riskPercent * math.Max(t.leverage, 1))
+			m.RCalls++
+		}
+	}
+
+	if m.GrossLoss > 0 {
+		m.ProfitFactor = m.GrossProfit / m.GrossLoss
+	}
+	m.Expectancy = depositPnl / float64(len(trades))
+	if holdCount > 0 {
+		m.AvgHold = hold / time.Duration(holdCount)
+	}
+	if m.RCalls > 0 {
+		m.AvgR = rSum / float64(m.RCalls)
+	}
+	return m
+}
+
+// getTradeMetrics считает TradeMetrics закрытых коллов в окне f по пользователям;
+// userID 0 — по всем пользователям.
+func (s *DatabaseStorage) getTradeMetrics(userID int64, f StatsFilter) map[int64]TradeMetrics {
+	cond, args := f.where()
+	query := `
+		SELECT user_id, r.pnl_percent, COALESCE(r.deposit_pnl_percent, 0), opened_at, closed_at,
+		       COALESCE(initial_risk_percent, 0), COALESCE(leverage, 1)
+		FROM calls JOIN call_results r ON r.call_id = calls.id
+		WHERE status = 'closed'` + cond
+	if userID != 0 {
+		query += " AND user_id = ?"
+		args = append(args, userID)
+	}
+	query += " ORDER BY COALESCE(closed_at, opened_at)"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to get closed calls for trade metrics")
+		return nil
+	}
+	defer rows.Close()
+
+	trades := make(map[int64][]closedTrade)
+	for rows.Next() {
+		var id int64
+		var t closedTrade
+		if err := rows.Scan(&id, &t.pnl, &t.depositPnl, &t.openedAt, &t.closedAt, &t.riskPercent, &t.leverage); err != nil {
+			logrus.WithError(err).Warn("failed to scan closed call for trade metrics")
+			continue
+		}
+		trades[id] = append(trades[id], t)
+	}
+
+	metrics := make(map[int64]TradeMetrics, len(trades))
+	for id, list := range trades {
+		metrics[id] = computeTradeMetrics(list)
+	}
+	return metrics
+}
+
+// initialRiskPercent расстояние от входа до стоп-лосса в % цены входа; 0 без стоп-лосса.
+func initialRiskPercent(entry, stopLoss float64) float64 {
+	if entry <= 0 || stopLoss <= 0 {
+		return 0
+	}
+	return math.Abs(entry-stopLoss) / entry * 100
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"strings"
 	"time"
 
 	"example.com/alert-bot/internal/reminder"
@@ -16,17 +15,78 @@ import (
 )
 
 type Alert struct {
-	ID            string    `json:"id"`
-	ChatID        int64     `json:"chat_id"`
-	UserID        int64     `json:"user_id"`  // ID пользователя Telegram
-	Username      string    `json:"username"` // Username пользователя Telegram
-	Symbol        string    `json:"symbol"`
-	Market        string    `json:"market"`   // "spot" или "futures"
-	Exchange      string    `json:"exchange"` // "Bitget" или "Bybit"
-	TargetPrice   float64   `json:"target_price,omitempty"`
-	TargetPercent float64   `json:"target_percent,omitempty"`
-	BasePrice     float64   `json:"base_price,omitempty"`
-	CreatedAt     time.Time `json:"created_at"`
+	ID            string  `json:"id"`
+	ChatID        int64   `json:"chat_id"`
+	UserID        int64   `json:"user_id"`  // ID пользователя Telegram
+	Username      string  `json:"username"` // Username пользователя Telegram
+	Symbol        string  `json:"symbol"`
+	Market        string  `json:"market"`   // "spot" или "futures"
+	Exchange      string  `json:"exchange"` // "Bitget" или "Bybit"
+	TargetPrice   float64 `json:"target_price,omitempty"`
+	TargetPercent float64 `json:"target_percent,omitempty"`
+	BasePrice     float64 `json:"base_price,omitempty"`
+	// Side сторона рынка при создании ценового алерта: "above" — цель выше цены (ждем рост),
+	// "below" — цель ниже цены (ждем падение). Пусто у старых алертов.
+	Side      string    `json:"side,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// Повторяющиеся алерты не удаляются после срабатывания, а ждут повторного взвода
+	Mode            string     `json:"mode,omitempty"`      // "once" (по умолчанию) или "recurring"
+	RearmPercent    float64    `json:"rearm_pct,omitempty"` // Взвести снова, когда цена отойдет от цели на N%
+	CooldownSec     int        `json:"cooldown_sec,omitempty"`
+	Armed           bool       `json:"armed"`
+	TriggerCount    int        `json:"trigger_count,omitempty"`
+	LastTriggeredAt *time.Time `json:"last_triggered_at,omitempty"`
+	// Составной алерт: условие над несколькими символами (Symbol — первый символ условия)
+	Kind       string   `json:"kind,omitempty"`       // "" — ценовой/процентный, "expression" — составной
+	Expression string   `json:"expression,omitempty"` // Текст условия, например "BTCUSDT < 60000 AND ETHUSDT/BTCUSDT > 0.05"
+	Symbols    []string `json:"symbols,omitempty"`    // Все символы условия (заполняется при создании)
+	// LastCandleAt закрытие последней свечи, на которой проверялся индикаторный алерт
+	LastCandleAt *time.Time `json:"last_candle_at,omitempty"`
+}
+
+// Стороны ценового алерта
+const (
+	SideAbove = "above"
+	SideBelow = "below"
+)
+
+// Типы алерта
+const (
+	AlertKindExpression = "expression"
+	AlertKindIndicator  = "indicator" // Expression хранит условие индикатора, например "rsi:14 1h < 30"
+)
+
+// Режимы алерта
+const (
+	AlertModeOnce      = "once"
+	AlertModeRecurring = "recurring"
+)
+
+// Recurring сообщает, что алерт остается активным после срабатывания.
+func (a Alert) Recurring() bool {
+	return a.Mode == AlertModeRecurring
+}
+
+// alertColumns список колонок для SELECT алертов, согласованный со scanAlert
+const alertColumns = `id, chat_id, COALESCE(user_id, 0), COALESCE(username, ''), symbol, market, target_price, target_percent, base_price, created_at, exchange, COALESCE(side, ''),
+	COALESCE(mode, 'once'), COALESCE(rearm_pct, 0), COALESCE(cooldown_sec, 0), COALESCE(armed, 1), COALESCE(trigger_count, 0), last_triggered_at,
+	COALESCE(kind, ''), COALESCE(expression, ''), last_candle_at`
+
+// scanAlert читает строку, выбранную с alertColumns
+func scanAlert(rows *sql.Rows) (Alert, error) {
+	var alert Alert
+	var lastTriggeredAt, lastCandleAt sql.NullTime
+	err := rows.Scan(&alert.ID, &alert.ChatID, &alert.UserID, &alert.Username, &alert.Symbol, &alert.Market,
+		&alert.TargetPrice, &alert.TargetPercent, &alert.BasePrice, &alert.CreatedAt, &alert.Exchange, &alert.Side,
+		&alert.Mode, &alert.RearmPercent, &alert.CooldownSec, &alert.Armed, &alert.TriggerCount, &lastTriggeredAt,
+		&alert.Kind, &alert.Expression, &lastCandleAt)
+	if lastTriggeredAt.Valid {
+		alert.LastTriggeredAt = &lastTriggeredAt.Time
+	}
+	if lastCandleAt.Valid {
+		alert.LastCandleAt = &lastCandleAt.Time
+	}
+	return alert, err
 }
 
 type Call struct {
@@ -47,6 +107,37 @@ type Call struct {
 	OpenedAt       time.Time  `json:"opened_at"`
 	ClosedAt       *time.Time `json:"closed_at,omitempty"`
 	StopLossPrice  float64    `json:"stop_loss_price,omitempty"` // Цена стоп-лосса
+
+	// Трейлинг-стоп: стоп-лосс подтягивается за лучшей ценой на TrailPercent процентов или TrailDistance в цене
+	TrailPercent    float64 `json:"trail_percent,omitempty"`
+	TrailDistance   float64 `json:"trail_distance,omitempty"`
+	TrailActivation float64 `json:"trail_activation,omitempty"` // Прибыль в %, после которой трейлинг включается (0 — сразу)
+	TrailBestPrice  float64 `json:"trail_best_price,omitempty"` // Лучшая цена с момента активации (0 — еще не активирован)
+
+	// Правила автоматизации колла (/rule)
+	BreakEvenPercent float64    `json:"breakeven_percent,omitempty"` // Перенести стоп в цену входа при PnL >= N% (0 — правило выключено)
+	BreakEvenDone    bool       `json:"breakeven_done,omitempty"`    // Стоп уже перенесен в безубыток
+	TimeStopAt       *time.Time `json:"time_stop_at,omitempty"`      // Закрыть колл, если он открыт после этого момента
+	SwingTimeframe   string     `json:"swing_timeframe,omitempty"`   // Подтягивать стоп к последнему swing low/high таймфрейма
+	SwingCheckedAt   *time.Time `json:"swing_checked_at,omitempty"`  // Закрытие свечи, по которой swing проверялся последним
+
+	// Плечо, комиссии и финансирование (фьючерсы)
+	Leverage       float64    `json:"leverage,omitempty"`        // Плечо: объем позиции = доля депозита × плечо (0 или 1 — без плеча)
+	TakerFee       float64    `json:"taker_fee,omitempty"`       // Комиссия тейкера биржи в % от объема за одну сторону
+	FundingPercent float64    `json:"funding_percent,omitempty"` // Накопленное финансирование в % от объема (положительное — уплачено)
+	FundingAt      *time.Time `json:"funding_at,omitempty"`      // Момент последнего начисления финансирования
+
+	Version int64 `json:"version"` // Растет при каждом изменении колла; запись проверяет прочитанную версию
+}
+
+// HasRules сообщает, настроено ли для колла хотя бы одно правило автоматизации.
+func (c Call) HasRules() bool {
+	return c.BreakEvenPercent > 0 || c.TimeStopAt != nil || c.SwingTimeframe != ""
+}
+
+// Trailing сообщает, настроен ли для колла трейлинг-стоп.
+func (c Call) Trailing() bool {
+	return c.TrailPercent > 0 || c.TrailDistance > 0
 }
 
 type AlertTrigger struct {
@@ -84,6 +175,10 @@ type UserStats struct {
 	InitialDeposit            float64 `json:"initial_deposit"`
 	CurrentDeposit            float64 `json:"current_deposit"`
 	TotalReturnPercent        float64 `json:"total_return_percent"`
+	TotalFees                 float64 `json:"total_fees"`    // Уплаченные комиссии в % депозита
+	TotalFunding              float64 `json:"total_funding"` // Финансирование в % депозита (положительное — уплачено)
+	Liquidations              int     `json:"liquidations"`
+	TradeMetrics
 }
 
 type DatabaseStorage struct {
@@ -98,18 +193,14 @@ func generateShortID() string {
 	return hex.EncodeToString(bytes)
 }
 
+// NewDatabaseStorage открывает базу и применяет непримененные миграции схемы.
 func NewDatabaseStorage(dbPath string) (*DatabaseStorage, error) {
-	if dbPath == "" {
-		dbPath = "data/alerts.db"
-	}
-
-	db, err := sql.Open("sqlite", dbPath+"?_foreign_keys=on") // Возвращаем драйвер "sqlite"
+	storage, err := OpenDatabaseStorage(dbPath)
 	if err != nil {
 		return nil, err
 	}
-
-	storage := &DatabaseStorage{db: db}
 	if err := storage.migrate(); err != nil {
+		storage.Close()
 		return nil, err
 	}
 
@@ -117,6 +208,22 @@ func NewDatabaseStorage(dbPath string) (*DatabaseStorage, error) {
 	return storage, nil
 }
 
+// OpenDatabaseStorage открывает базу без миграций: для просмотра и применения миграций вручную (cmd/migrate).
+func OpenDatabaseStorage(dbPath string) (*DatabaseStorage, error) {
+	if dbPath == "" {
+		dbPath = "data/alerts.db"
+	}
+
+	// Транзакции сразу берут блокировку записи, а занятая база ожидается до 5 секунд:
+	// бот и мониторинг меняют одни и те же коллы и депозиты параллельно
+	db, err := sql.Open("sqlite", dbPath+"?_foreign_keys=on&_pragma=busy_timeout(5000)&_txlock=immediate") // Возвращаем драйвер "sqlite"
+	if err != nil {
+		return nil, err
+	}
+
+	return &DatabaseStorage{db: db}, nil
+}
+
 func (s *DatabaseStorage) Close() error {
 	logrus.Info("closing database connection")
 	return s.db.Close()
@@ -134,141 +241,10 @@ func (s *DatabaseStorage) GetPendingReminders() ([]reminder.Task, error) {
 	return reminder.GetPendingReminders(s.db)
 }
 
+// migrate применяет непримененные миграции схемы (alerts/migrations). База новее сборки не открывается.
 func (s *DatabaseStorage) migrate() error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS limit_orders (
-			id TEXT PRIMARY KEY,
-			user_id INTEGER NOT NULL,
-			username TEXT NOT NULL,
-			chat_id INTEGER NOT NULL,
-			symbol TEXT NOT NULL,
-			direction TEXT NOT NULL,
-			limit_price REAL NOT NULL,
-			deposit_percent REAL DEFAULT 0,
-			related_call_id TEXT DEFAULT '',
-			size_to_close REAL DEFAULT 0,
-			status TEXT NOT NULL DEFAULT 'active',
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			triggered_at DATETIME
-		)`,
-		`CREATE INDEX IF NOT EXISTS idx_limit_orders_user_id ON limit_orders(user_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_limit_orders_status ON limit_orders(status)`,
-		`CREATE INDEX IF NOT EXISTS idx_limit_orders_symbol ON limit_orders(symbol)`,
-		`CREATE INDEX IF NOT EXISTS idx_limit_orders_related_call_id ON limit_orders(related_call_id)`,
-
-		`CREATE TABLE IF NOT EXISTS reminders (
-			id TEXT PRIMARY KEY,
-			chat_id INTEGER NOT NULL,
-			user_id INTEGER NOT NULL,
-			username TEXT DEFAULT '',
-			symbol TEXT NOT NULL,
-			text TEXT DEFAULT '',
-			trigger_at DATETIME NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE INDEX IF NOT EXISTS idx_reminders_trigger_at ON reminders(trigger_at)`,
-		`CREATE INDEX IF NOT EXISTS idx_reminders_chat_id   ON reminders(chat_id)`,
-		`CREATE TABLE IF NOT EXISTS alerts (
-			id TEXT PRIMARY KEY,
-			chat_id INTEGER NOT NULL,
-			user_id INTEGER NOT NULL DEFAULT 0,
-			username TEXT DEFAULT '',
-			symbol TEXT NOT NULL,
-			target_price REAL DEFAULT 0,
-			target_percent REAL DEFAULT 0,
-			base_price REAL DEFAULT 0,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			market TEXT DEFAULT '',
-			exchange TEXT DEFAULT ''
-		)`,
-		`CREATE TABLE IF NOT EXISTS user_deposits (
-    		user_id INTEGER PRIMARY KEY,
-    		initial_deposit REAL DEFAULT 100,
-    		current_deposit REAL DEFAULT 100,
-    		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE INDEX IF NOT EXISTS idx_user_deposits_user_id ON user_deposits(user_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_alerts_chat_id ON alerts(chat_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_alerts_user_id ON alerts(user_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_alerts_symbol ON alerts(symbol)`,
-		`CREATE INDEX IF NOT EXISTS idx_alerts_created_at ON alerts(created_at)`,
-
-		`CREATE TABLE IF NOT EXISTS calls (
-			id TEXT PRIMARY KEY,
-			user_id INTEGER NOT NULL,
-			username TEXT NOT NULL,
-			chat_id INTEGER NOT NULL,
-			symbol TEXT NOT NULL,
-			direction TEXT NOT NULL DEFAULT 'long',
-			entry_price REAL NOT NULL,
-			exit_price REAL DEFAULT 0,
-			pnl_percent REAL DEFAULT 0,
-			size REAL DEFAULT 100,
-			status TEXT NOT NULL DEFAULT 'open',
-			opened_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			closed_at DATETIME,
-			market TEXT DEFAULT '',
-			deposit_percent REAL DEFAULT 0,
-			stop_loss_price REAL DEFAULT 0,
-			exchange TEXT DEFAULT ''
-		)`,
-		`CREATE INDEX IF NOT EXISTS idx_calls_user_id ON calls(user_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_calls_status ON calls(status)`,
-		`CREATE INDEX IF NOT EXISTS idx_calls_symbol ON calls(symbol)`,
-		`CREATE INDEX IF NOT EXISTS idx_calls_opened_at ON calls(opened_at)`,
-
-		`CREATE TABLE IF NOT EXISTS alert_triggers (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			alert_id TEXT,
-			symbol TEXT NOT NULL,
-			trigger_price REAL NOT NULL,
-			chat_id INTEGER NOT NULL,
-			user_id INTEGER NOT NULL DEFAULT 0,
-			username TEXT DEFAULT '',
-			trigger_type TEXT NOT NULL,
-			triggered_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE INDEX IF NOT EXISTS idx_triggers_symbol ON alert_triggers(symbol)`,
-		`CREATE INDEX IF NOT EXISTS idx_triggers_chat_id ON alert_triggers(chat_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_triggers_user_id ON alert_triggers(user_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_triggers_triggered_at ON alert_triggers(triggered_at)`,
-
-		`CREATE TABLE IF NOT EXISTS price_history (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			symbol TEXT NOT NULL,
-			price REAL NOT NULL,
-			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE INDEX IF NOT EXISTS idx_price_history_symbol ON price_history(symbol)`,
-		`CREATE INDEX IF NOT EXISTS idx_price_history_timestamp ON price_history(timestamp)`,
-
-		// Миграция существующих данных - добавляем колонки если их нет
-		`ALTER TABLE alerts ADD COLUMN user_id INTEGER DEFAULT 0`,
-		`ALTER TABLE alerts ADD COLUMN username TEXT DEFAULT ''`,
-		`ALTER TABLE alerts ADD COLUMN market TEXT DEFAULT ''`,
-		`ALTER TABLE alerts ADD COLUMN exchange TEXT DEFAULT ''`,
-		`ALTER TABLE alert_triggers ADD COLUMN user_id INTEGER DEFAULT 0`,
-		`ALTER TABLE alert_triggers ADD COLUMN username TEXT DEFAULT ''`,
-		`ALTER TABLE calls ADD COLUMN market TEXT DEFAULT ''`,
-		`ALTER TABLE calls ADD COLUMN exchange TEXT DEFAULT ''`,
-		`ALTER TABLE calls ADD COLUMN size REAL DEFAULT 100`,
-		`ALTER TABLE calls ADD COLUMN deposit_percent REAL DEFAULT 0`,
-		`ALTER TABLE calls ADD COLUMN stop_loss_price REAL DEFAULT 0`,
-	}
-	// Обновляем старые коллы без size
-	_, err := s.db.Exec(`UPDATE calls SET size = 100 WHERE size IS NULL OR size = 0`)
-	if err != nil {
-		logrus.WithError(err).Warn("failed to update old calls with default size")
-	}
-
-	for _, query := range queries {
-		if _, err := s.db.Exec(query); err != nil {
-			// Игнорируем ошибки добавления колонок если они уже существуют
-			if !strings.Contains(err.Error(), "duplicate column name") {
-				logrus.WithError(err).WithField("query", query).Warn("migration query failed")
-			}
-		}
+	if _, err := s.ApplyMigrations(); err != nil {
+		return err
 	}
 
 	logrus.Info("database migration completed")
@@ -286,9 +262,16 @@ type LimitOrder struct {
 	DepositPercent float64    `json:"deposit_percent"`
 	RelatedCallID  string     `json:"related_call_id,omitempty"` // ID колла для закрытия (если указан)
 	SizeToClose    float64    `json:"size_to_close,omitempty"`   // Размер для закрытия (если это ордер на закрытие)
-	Status         string     `json:"status"`                    // "active", "triggered", "cancelled"
+	OrderType      string     `json:"order_type"`                // "limit", "stop" или "stop_limit"
+	StopPrice      float64    `json:"stop_price,omitempty"`      // Цена срабатывания stop и stop_limit
+	StopTriggered  bool       `json:"stop_triggered,omitempty"`  // stop_limit сработал и ждет лимитной цены
+	OcoGroup       string     `json:"oco_group,omitempty"`       // Исполнение ордера отменяет остальные ордера группы
+	StopLossPrice  float64    `json:"stop_loss_price,omitempty"` // Стоп-лосс колла, открытого ордером
+	Leverage       float64    `json:"leverage,omitempty"`        // Плечо колла, открытого ордером
+	Status         string     `json:"status"`                    // "active", "triggered", "cancelled", "expired"
 	CreatedAt      time.Time  `json:"created_at"`
 	TriggeredAt    *time.Time `json:"triggered_at,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"` // Good-till-date; nil — до отмены (GTC)
 }
 
 func (s *DatabaseStorage) Add(alert Alert) (Alert, error) {
@@ -310,17 +293,32 @@ func (s *DatabaseStorage) Add(alert Alert) (Alert, error) {
 	if alert.CreatedAt.IsZero() {
 		alert.CreatedAt = time.Now()
 	}
+	if alert.Mode == "" {
+		alert.Mode = AlertModeOnce
+	}
+	// Новый алерт всегда взведен
+	alert.Armed = true
 
 	_, err := s.db.Exec(`
-		INSERT INTO alerts (id, chat_id, user_id, username, symbol, market, target_price, target_percent, base_price, created_at, exchange)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		INSERT INTO alerts (id, chat_id, user_id, username, symbol, market, target_price, target_percent, base_price, created_at, exchange, side,
+			mode, rearm_pct, cooldown_sec, armed, trigger_count, last_triggered_at, kind, expression, last_candle_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		alert.ID, alert.ChatID, alert.UserID, alert.Username, alert.Symbol, alert.Market,
-		alert.TargetPrice, alert.TargetPercent, alert.BasePrice, alert.CreatedAt, alert.Exchange)
+		alert.TargetPrice, alert.TargetPercent, alert.BasePrice, alert.CreatedAt, alert.Exchange, alert.Side,
+		alert.Mode, alert.RearmPercent, alert.CooldownSec, alert.Armed, alert.TriggerCount, alert.LastTriggeredAt,
+		alert.Kind, alert.Expression, alert.LastCandleAt)
 
 	if err != nil {
 		return alert, err
 	}
 
+	// Символы составного алерта: по ним монитор опрашивает цены и находит алерт на каждом тике
+	for _, symbol := range alert.Symbols {
+		if _, err := s.db.Exec(`INSERT OR IGNORE INTO alert_symbols (alert_id, symbol) VALUES (?, ?)`, alert.ID, symbol); err != nil {
+			return alert, err
+		}
+	}
+
 	logrus.WithFields(logrus.Fields{
 		"alert_id": alert.ID,
 		"chat_id":  alert.ChatID,
@@ -339,58 +337,67 @@ func (s *DatabaseStorage) Update(alert Alert) error {
 
 	_, err := s.db.Exec(`
 		UPDATE alerts 
-		SET chat_id = ?, user_id = ?, username = ?, symbol = ?, market = ?, target_price = ?, target_percent = ?, base_price = ?, exchange = ?
+		SET chat_id = ?, user_id = ?, username = ?, symbol = ?, market = ?, target_price = ?, target_percent = ?, base_price = ?, exchange = ?, side = ?,
+			mode = ?, rearm_pct = ?, cooldown_sec = ?, armed = ?, trigger_count = ?, last_triggered_at = ?, kind = ?, expression = ?, last_candle_at = ?
 		WHERE id = ?`,
 		alert.ChatID, alert.UserID, alert.Username, alert.Symbol, alert.Market,
-		alert.TargetPrice, alert.TargetPercent, alert.BasePrice, alert.Exchange, alert.ID)
+		alert.TargetPrice, alert.TargetPercent, alert.BasePrice, alert.Exchange, alert.Side,
+		alert.Mode, alert.RearmPercent, alert.CooldownSec, alert.Armed, alert.TriggerCount, alert.LastTriggeredAt,
+		alert.Kind, alert.Expression, alert.LastCandleAt, alert.ID)
 
 	return err
 }
 
 // GetUserDeposit получает информацию о депозите пользователя
 func (s *DatabaseStorage) GetUserDeposit(userID int64) (initialDeposit, currentDeposit float64, err error) {
-	err = s.db.QueryRow(`
-		SELECT initial_deposit, current_deposit 
+	initialDeposit, currentDeposit, _, err = getUserDeposit(s.db, userID)
+	return initialDeposit, currentDeposit, err
+}
+
+// getUserDeposit возвращает депозит пользователя и версию строки; если депозита нет, создает его
+// с начальным значением 100.
+func getUserDeposit(q querier, userID int64) (initialDeposit, currentDeposit float64, version int64, err error) {
+	err = q.QueryRow(`
+		SELECT initial_deposit, current_deposit, COALESCE(version, 0)
 		FROM user_deposits 
-		WHERE user_id = ?`, userID).Scan(&initialDeposit, &currentDeposit)
+		WHERE user_id = ?`, userID).Scan(&initialDeposit, &currentDeposit, &version)
 
 	if err == sql.ErrNoRows {
 		// Если депозит не найден, создаем новый с начальным значением 100
-		_, err = s.db.Exec(`
-			INSERT INTO user_deposits (user_id, initial_deposit, current_deposit) 
+		_, err = q.Exec(`
+			INSERT OR IGNORE INTO user_deposits (user_id, initial_deposit, current_deposit) 
 			VALUES (?, 100, 100)`, userID)
 		if err != nil {
-			return 0, 0, err
+			return 0, 0, 0, err
 		}
-		return 100, 100, nil
+		return 100, 100, 0, nil
 	}
 
-	return initialDeposit, currentDeposit, err
+	return initialDeposit, currentDeposit, version, err
 }
 
 // UpdateUserDeposit обновляет текущий депозит пользователя
 func (s *DatabaseStorage) UpdateUserDeposit(userID int64, newDeposit float64) error {
-	// Сначала проверяем, существует ли запись
-	var exists bool
-	err := s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM user_deposits WHERE user_id = ?)`, userID).Scan(&exists)
+	return s.withTx(func(tx *sql.Tx) error {
+		// Создаем запись, если её нет, и читаем версию
+		_, _, version, err := getUserDeposit(tx, userID)
+		if err != nil {
+			return err
+		}
+		return setUserDeposit(tx, userID, newDeposit, version)
+	})
+}
+
+// setUserDeposit записывает текущий депозит, если строка еще имеет прочитанную версию version.
+func setUserDeposit(q querier, userID int64, newDeposit float64, version int64) error {
+	result, err := q.Exec(`
+		UPDATE user_deposits 
+		SET current_deposit = ?, version = COALESCE(version, 0) + 1, updated_at = CURRENT_TIMESTAMP 
+		WHERE user_id = ? AND COALESCE(version, 0) = ?`, newDeposit, userID, version)
 	if err != nil {
 		return err
 	}
-
-	if !exists {
-		// Создаем запись, если её нет
-		_, err = s.db.Exec(`
-			INSERT INTO user_deposits (user_id, initial_deposit, current_deposit) 
-			VALUES (?, 100, ?)`, userID, newDeposit)
-	} else {
-		// Обновляем существующую
-		_, err = s.db.Exec(`
-			UPDATE user_deposits 
-			SET current_deposit = ?, updated_at = CURRENT_TIMESTAMP 
-			WHERE user_id = ?`, newDeposit, userID)
-	}
-
-	if err != nil {
+	if err := checkVersion(result); err != nil {
 		return err
 	}
 
@@ -402,18 +409,27 @@ func (s *DatabaseStorage) UpdateUserDeposit(userID int64, newDeposit float64) er
 	return nil
 }
 
-// ResetUserDeposit сбрасывает депозит пользователя до начального значения
+// ResetUserDeposit сбрасывает депозит пользователя до начального значения; кривая капитала начинается заново
 func (s *DatabaseStorage) ResetUserDeposit(userID int64) error {
-	_, err := s.db.Exec(`
-		UPDATE user_deposits 
-		SET current_deposit = initial_deposit, updated_at = CURRENT_TIMESTAMP 
-		WHERE user_id = ?`, userID)
+	return s.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE user_deposits 
+			SET current_deposit = initial_deposit, version = COALESCE(version, 0) + 1, updated_at = CURRENT_TIMESTAMP 
+			WHERE user_id = ?`, userID)
+		if err != nil {
+			return err
+		}
 
-	return err
+		initialDeposit, _, _, err := getUserDeposit(tx, userID)
+		if err != nil {
+			return err
+		}
+		return recordDepositSnapshot(tx, DepositSnapshot{UserID: userID, Deposit: initialDeposit, Equity: initialDeposit, Kind: SnapshotKindReset})
+	})
 }
 func (s *DatabaseStorage) ListByChat(chatID int64) []Alert {
 	rows, err := s.db.Query(`
-		SELECT id, chat_id, COALESCE(user_id, 0), COALESCE(username, ''), symbol, market, target_price, target_percent, base_price, created_at, exchange
+		SELECT `+alertColumns+`
 		FROM alerts 
 		WHERE chat_id = ?
 		ORDER BY created_at ASC`, chatID)
@@ -426,9 +442,7 @@ func (s *DatabaseStorage) ListByChat(chatID int64) []Alert {
 
 	var alerts []Alert
 	for rows.Next() {
-		var alert Alert
-		err := rows.Scan(&alert.ID, &alert.ChatID, &alert.UserID, &alert.Username, &alert.Symbol, &alert.Market,
-			&alert.TargetPrice, &alert.TargetPercent, &alert.BasePrice, &alert.CreatedAt, &alert.Exchange)
+		alert, err := scanAlert(rows)
 		if err != nil {
 			logrus.WithError(err).Warn("failed to scan alert row")
 			continue
@@ -452,6 +466,9 @@ func (s *DatabaseStorage) DeleteByID(chatID int64, id string) (bool, error) {
 
 	deleted := affected > 0
 	if deleted {
+		if _, err := s.db.Exec("DELETE FROM alert_symbols WHERE alert_id = ?", id); err != nil {
+			logrus.WithError(err).WithField("alert_id", id).Warn("failed to delete alert symbols")
+		}
 		logrus.WithFields(logrus.Fields{
 			"alert_id": id,
 			"chat_id":  chatID,
@@ -474,6 +491,9 @@ func (s *DatabaseStorage) DeleteAllByChat(chatID int64) (int, error) {
 
 	count := int(affected)
 	if count > 0 {
+		if _, err := s.db.Exec("DELETE FROM alert_symbols WHERE alert_id NOT IN (SELECT id FROM alerts)"); err != nil {
+			logrus.WithError(err).Warn("failed to delete orphaned alert symbols")
+		}
 		logrus.WithFields(logrus.Fields{
 			"chat_id": chatID,
 			"count":   count,
@@ -485,9 +505,9 @@ func (s *DatabaseStorage) DeleteAllByChat(chatID int64) (int, error) {
 
 func (s *DatabaseStorage) GetBySymbol(symbol string) []Alert {
 	rows, err := s.db.Query(`
-		SELECT id, chat_id, COALESCE(user_id, 0), COALESCE(username, ''), symbol, market, target_price, target_percent, base_price, created_at, exchange
+		SELECT `+alertColumns+`
 		FROM alerts 
-		WHERE symbol = ?`, symbol)
+		WHERE symbol = ? OR id IN (SELECT alert_id FROM alert_symbols WHERE symbol = ?)`, symbol, symbol)
 
 	if err != nil {
 		logrus.WithError(err).Warn("failed to get alerts by symbol")
@@ -497,9 +517,33 @@ func (s *DatabaseStorage) GetBySymbol(symbol string) []Alert {
 
 	var alerts []Alert
 	for rows.Next() {
-		var alert Alert
-		err := rows.Scan(&alert.ID, &alert.ChatID, &alert.UserID, &alert.Username, &alert.Symbol, &alert.Market,
-			&alert.TargetPrice, &alert.TargetPercent, &alert.BasePrice, &alert.CreatedAt, &alert.Exchange)
+		alert, err := scanAlert(rows)
+		if err != nil {
+			logrus.WithError(err).Warn("failed to scan alert row")
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+
+	return alerts
+}
+
+// GetByKind возвращает все алерты заданного типа
+func (s *DatabaseStorage) GetByKind(kind string) []Alert {
+	rows, err := s.db.Query(`
+		SELECT `+alertColumns+`
+		FROM alerts 
+		WHERE kind = ?`, kind)
+
+	if err != nil {
+		logrus.WithError(err).Warn("failed to get alerts by kind")
+		return nil
+	}
+	defer rows.Close()
+
+	var alerts []Alert
+	for rows.Next() {
+		alert, err := scanAlert(rows)
 		if err != nil {
 			logrus.WithError(err).Warn("failed to scan alert row")
 			continue
@@ -515,6 +559,8 @@ func (s *DatabaseStorage) GetAllSymbols() []string {
 		SELECT DISTINCT symbol FROM (
 			SELECT symbol FROM alerts WHERE symbol != ''
 			UNION
+			SELECT symbol FROM alert_symbols
+			UNION
 			SELECT symbol FROM calls WHERE symbol != '' AND status = 'open'
 			UNION
 			SELECT symbol FROM limit_orders WHERE symbol != '' AND status = 'active'
@@ -570,22 +616,14 @@ func (s *DatabaseStorage) GetSymbolsFromUserAlertsAndCalls(chatID int64) []strin
 
 // Методы для работы с коллами
 
-func (s *DatabaseStorage) OpenCall(call Call) (Call, error) {
-	if call.ID == "" {
-		// Генерируем уникальный короткий ID
-		for {
-			call.ID = generateShortID()
-			var exists bool
-			err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM calls WHERE id = ?)", call.ID).Scan(&exists)
-			if err != nil {
-				return call, err
-			}
-			if !exists {
-				break
-			}
-		}
-	}
+// OpenCall создает колл и записывает исполнение открытия в журнал; source — источник исполнения (FillSource*).
+func (s *DatabaseStorage) OpenCall(call Call, source string) (Call, error) {
+	return s.openCall(call, source, nil)
+}
 
+// openCall открывает колл; before выполняется первым шагом той же транзакции (может быть nil),
+// и его ошибка отменяет открытие.
+func (s *DatabaseStorage) openCall(call Call, source string, before func(tx *sql.Tx) error) (Call, error) {
 	if call.OpenedAt.IsZero() {
 		call.OpenedAt = time.Now()
 	}
@@ -596,13 +634,58 @@ func (s *DatabaseStorage) OpenCall(call Call) (Call, error) {
 
 	call.Status = "open"
 	call.Size = 100.0 // Инициализируем размер позиции по умолчанию
+	call.Leverage = call.EffectiveLeverage()
+	call.FundingPercent = 0
+	call.FundingAt = &call.OpenedAt
+	call.Version = 0
+
+	// Колл и исполнение открытия записываются вместе: колл без журнала не появится
+	generateID := call.ID == ""
+	err := s.withTx(func(tx *sql.Tx) error {
+		if before != nil {
+			if err := before(tx); err != nil {
+				return err
+			}
+		}
+		if generateID {
+			// Генерируем уникальный короткий ID
+			for {
+				call.ID = generateShortID()
+				var exists bool
+				err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM calls WHERE id = ?)", call.ID).Scan(&exists)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					break
+				}
+			}
+		}
 
-	_, err := s.db.Exec(`
-		INSERT INTO calls (id, user_id, username, chat_id, symbol, market, direction, entry_price, size, status, opened_at, deposit_percent, stop_loss_price, exchange)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		call.ID, call.UserID, call.Username, call.ChatID, call.Symbol, call.Market,
-		call.Direction, call.EntryPrice, call.Size, call.Status, call.OpenedAt, call.DepositPercent, call.StopLossPrice, call.Exchange)
+		_, err := tx.Exec(`
+			INSERT INTO calls (id, user_id, username, chat_id, symbol, market, direction, entry_price, size, status, opened_at, deposit_percent, stop_loss_price, exchange,
+			                   leverage, taker_fee, funding_percent, funding_at, initial_risk_percent, version)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			call.ID, call.UserID, call.Username, call.ChatID, call.Symbol, call.Market,
+			call.Direction, call.EntryPrice, call.Size, call.Status, call.OpenedAt, call.DepositPercent, call.StopLossPrice, call.Exchange,
+			call.Leverage, call.TakerFee, call.FundingPercent, call.FundingAt, initialRiskPercent(call.EntryPrice, call.StopLossPrice), call.Version)
+		if err != nil {
+			return err
+		}
 
+		if err := recordFill(tx, CallFill{
+			CallID:         call.ID,
+			Kind:           FillKindOpen,
+			Price:          call.EntryPrice,
+			Size:           call.Size,
+			DepositPercent: call.DepositPercent,
+			Source:         source,
+			FilledAt:       call.OpenedAt,
+		}); err != nil {
+			return fmt.Errorf("failed to record open fill: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
 		return call, err
 	}
@@ -615,110 +698,146 @@ func (s *DatabaseStorage) OpenCall(call Call) (Call, error) {
 		"direction":     call.Direction,
 		"entry_price":   call.EntryPrice,
 		"position_size": call.DepositPercent,
+		"leverage":      call.Leverage,
 	}).Info("call opened")
 
 	return call, nil
 }
 
-func (s *DatabaseStorage) CloseCall(callID string, userID int64, exitPrice float64, sizeToClose float64) error {
-	// Получаем информацию о колле
-	var call Call
-	err := s.db.QueryRow(`
-		SELECT id, user_id, username, chat_id, symbol, market, direction, entry_price, size, status, deposit_percent
-		FROM calls WHERE id = ? AND user_id = ? AND status = 'open'`,
-		callID, userID).Scan(
-		&call.ID, &call.UserID, &call.Username, &call.ChatID,
-		&call.Symbol, &call.Market, &call.Direction, &call.EntryPrice, &call.Size, &call.Status, &call.DepositPercent)
+// CloseRemaining размер закрытия «весь остаток»: закрывается размер колла, прочитанный в транзакции,
+// а не прочитанный до нее. Полное закрытие (стоп-лосс, тайм-стоп, ликвидация) не падает с ошибкой
+// и не оставляет остаток, если колл успели частично закрыть между чтением и закрытием.
+const CloseRemaining = -1.0
+
+// CloseCall закрывает sizeToClose единиц колла (или весь остаток, если sizeToClose == CloseRemaining)
+// по цене exitPrice и записывает исполнение в журнал.
+// PnL закрытия считается от маржи с учетом плеча, комиссий тейкера и накопленного финансирования.
+// Цена выхода и PnL колла пересчитываются по всем закрытиям из журнала (средние, взвешенные по объему).
+//
+// Исполнение, изменение депозита, снимок капитала, колл и отмена ордеров записываются одной транзакцией;
+// колл и депозит обновляются только в прочитанной версии. Параллельное закрытие того же колла
+// (стоп-лосс и /ccall) ждет первое и затем видит уже уменьшенный или закрытый колл.
+func (s *DatabaseStorage) CloseCall(callID string, userID int64, exitPrice float64, sizeToClose float64, source string) error {
+	return s.closeCall(callID, userID, exitPrice, sizeToClose, source, nil)
+}
 
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return errors.New("call not found or already closed")
+// closeCall закрывает часть колла; before выполняется первым шагом той же транзакции (может быть nil),
+// и его ошибка отменяет закрытие.
+func (s *DatabaseStorage) closeCall(callID string, userID int64, exitPrice float64, sizeToClose float64, source string, before func(tx *sql.Tx) error) error {
+	var call Call
+	var fill CallFill
+	var avgExitPrice, realizedPnl, newSize float64
+	var status string
+	err := s.withTx(func(tx *sql.Tx) error {
+		if before != nil {
+			if err := before(tx); err != nil {
+				return err
+			}
 		}
-		return err
-	}
 
-	// Проверяем, что запрошенный размер не превышает текущий
-	if sizeToClose <= 0 || sizeToClose > call.Size {
-		return fmt.Errorf("неверный размер для закрытия. Должен быть от 0 до текущего размера %.2f", call.Size)
-	}
-
-	// Вычисляем базовый PnL (изменение цены в процентах)
-	var basePnlPercent float64
-	if call.Direction == "long" {
-		basePnlPercent = ((exitPrice - call.EntryPrice) / call.EntryPrice) * 100
-	} else { // short
-		basePnlPercent = ((call.EntryPrice - exitPrice) / call.EntryPrice) * 100
-	}
+		// Получаем информацию о колле
+		err := tx.QueryRow(`
+			SELECT id, user_id, username, chat_id, symbol, market, direction, entry_price, size, status, deposit_percent,
+			       COALESCE(leverage, 1), COALESCE(taker_fee, 0), COALESCE(funding_percent, 0), COALESCE(version, 0)
+			FROM calls WHERE id = ? AND user_id = ? AND status = 'open'`,
+			callID, userID).Scan(
+			&call.ID, &call.UserID, &call.Username, &call.ChatID,
+			&call.Symbol, &call.Market, &call.Direction, &call.EntryPrice, &call.Size, &call.Status, &call.DepositPercent,
+			&call.Leverage, &call.TakerFee, &call.FundingPercent, &call.Version)
 
-	// PnL для закрываемой части позиции
-	// Размер позиции учитывается в изменении депозита
-	pnlPercentForClosedPart := basePnlPercent
-
-	// Рассчитываем изменение депозита
-	if call.DepositPercent > 0 {
-		_, currentDeposit, err := s.GetUserDeposit(userID)
 		if err != nil {
-			logrus.WithError(err).Warn("failed to get user deposit for PnL calculation")
-		} else {
-			// Вычисляем, какая часть позиции закрывается
-			closedPositionPercent := call.DepositPercent * (sizeToClose / call.Size)
+			if err == sql.ErrNoRows {
+				return errors.New("call not found or already closed")
+			}
+			return err
+		}
 
-			// Изменение депозита = размер_позиции × изменение_цены
-			// Например: позиция 200%, цена +10% → депозит +20%
-			depositChangePercent := closedPositionPercent * (basePnlPercent / 100)
-			depositChange := (depositChangePercent / 100) * currentDeposit
+		if sizeToClose == CloseRemaining {
+			sizeToClose = call.Size
+		}
+		// Проверяем, что запрошенный размер не превышает текущий
+		if sizeToClose <= 0 || sizeToClose > call.Size {
+			return fmt.Errorf("неверный размер для закрытия. Должен быть от 0 до текущего размера %.2f", call.Size)
+		}
 
-			newDeposit := currentDeposit + depositChange
+		// PnL закрываемой части в процентах от маржи: изменение цены × плечо за вычетом комиссий и финансирования.
+		// Размер позиции учитывается в изменении депозита
+		pnlPercentForClosedPart, feePercent, fundingPercent := call.Returns(exitPrice)
+
+		// Размер колла — процент открытой позиции, в журнале объем хранится в единицах открытия
+		closedPositionPercent := call.DepositPercent * (sizeToClose / 100)
+
+		fill = CallFill{
+			CallID:         callID,
+			Kind:           FillKindClose,
+			Price:          exitPrice,
+			Size:           sizeToClose * openedSize(tx, callID) / 100,
+			DepositPercent: closedPositionPercent,
+			PnlPercent:     pnlPercentForClosedPart,
+			FeePercent:     feePercent,
+			FundingPercent: fundingPercent,
+			Source:         source,
+		}
+		if err := recordFill(tx, fill); err != nil {
+			return fmt.Errorf("failed to record close fill: %w", err)
+		}
 
-			// Обновляем депозит пользователя
-			err = s.UpdateUserDeposit(userID, newDeposit)
-			if err != nil {
-				logrus.WithError(err).Warn("failed to update user deposit after closing call")
-			} else {
-				logrus.WithFields(logrus.Fields{
-					"user_id":               userID,
-					"call_id":               callID,
-					"closed_position_pct":   closedPositionPercent,
-					"base_pnl_pct":          basePnlPercent,
-					"deposit_change_pct":    depositChangePercent,
-					"deposit_change_amount": depositChange,
-					"old_deposit":           currentDeposit,
-					"new_deposit":           newDeposit,
-				}).Info("user deposit updated after call close")
+		// Итог по всем закрытиям: средняя цена выхода и PnL, взвешенные по объему
+		avgExitPrice, realizedPnl = exitPrice, pnlPercentForClosedPart
+		if avg, pnl, err := callResult(tx, callID); err == nil {
+			avgExitPrice, realizedPnl = avg, pnl
+		} else {
+			logrus.WithError(err).WithField("call_id", callID).Warn("failed to derive call result from fills")
+		}
+
+		// Рассчитываем изменение депозита
+		if call.DepositPercent > 0 {
+			if err := applyDepositChange(tx, call, closedPositionPercent, fill); err != nil {
+				return fmt.Errorf("failed to update user deposit: %w", err)
 			}
 		}
-	}
 
-	newSize := call.Size - sizeToClose
-	status := "open"
-	var closedAt sql.NullTime
+		newSize = call.Size - sizeToClose
+		status = "open"
+		var closedAt sql.NullTime
 
-	// Если оставшийся размер очень мал, считаем колл полностью закрытым
-	if newSize < 0.001 {
-		status = "closed"
-		now := time.Now()
-		closedAt = sql.NullTime{Time: now, Valid: true}
-		newSize = 0.0
-		//newDepositPercent = 0.0
-	}
+		// Если оставшийся размер очень мал, считаем колл полностью закрытым
+		if newSize < 0.001 {
+			status = "closed"
+			now := time.Now()
+			closedAt = sql.NullTime{Time: now, Valid: true}
+			newSize = 0.0
+			//newDepositPercent = 0.0
+		}
 
-	// Обновляем колл в базе данных
-	_, err = s.db.Exec(`
-		UPDATE calls
-		SET exit_price = ?, pnl_percent = ?, size = ?, status = ?, closed_at = ?
-		WHERE id = ?`,
-		exitPrice, pnlPercentForClosedPart, newSize, status, closedAt, callID)
+		// Обновляем колл в базе данных
+		result, err := tx.Exec(`
+			UPDATE calls
+			SET exit_price = ?, pnl_percent = ?, size = ?, status = ?, closed_at = ?, version = COALESCE(version, 0) + 1
+			WHERE id = ? AND COALESCE(version, 0) = ?`,
+			avgExitPrice, realizedPnl, newSize, status, closedAt, callID, call.Version)
+		if err != nil {
+			return err
+		}
+		if err := checkVersion(result); err != nil {
+			return err
+		}
 
+		// Если колл полностью закрыт, отменяем все связанные лимитные ордера и тейк-профиты
+		if status == "closed" {
+			if err := cancelLimitOrdersByCallID(tx, callID); err != nil {
+				return fmt.Errorf("failed to cancel limit orders for closed call: %w", err)
+			}
+			if err := cancelTakeProfitsByCallID(tx, callID); err != nil {
+				return fmt.Errorf("failed to cancel take-profits for closed call: %w", err)
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	// Если колл полностью закрыт, отменяем все связанные лимитные ордера
-	if status == "closed" {
-		err = s.CancelLimitOrdersByCallID(callID)
-		if err != nil {
-			logrus.WithError(err).Warn("failed to cancel limit orders for closed call")
-		}
-	}
+
 	logrus.WithFields(logrus.Fields{
 		"call_id":     callID,
 		"user_id":     userID,
@@ -727,8 +846,11 @@ func (s *DatabaseStorage) CloseCall(callID string, userID int64, exitPrice float
 		"direction":   call.Direction,
 		"entry_price": call.EntryPrice,
 		"exit_price":  exitPrice,
-		"pnl_percent": pnlPercentForClosedPart,
+		"pnl_percent": fill.PnlPercent,
+		"avg_exit":    avgExitPrice,
+		"realized":    realizedPnl,
 		"closed_size": sizeToClose,
+		"source":      source,
 		"new_size":    newSize,
 		"status":      status,
 	}).Info("call closed (partially or fully)")
@@ -736,12 +858,59 @@ func (s *DatabaseStorage) CloseCall(callID string, userID int64, exitPrice float
 	return nil
 }
 
+// applyDepositChange применяет к депозиту результат закрытия части колла и записывает точку кривой капитала.
+func applyDepositChange(tx *sql.Tx, call Call, closedPositionPercent float64, fill CallFill) error {
+	_, currentDeposit, version, err := getUserDeposit(tx, call.UserID)
+	if err != nil {
+		return err
+	}
+
+	// Изменение депозита = размер_позиции × PnL от маржи
+	// Например: позиция 200%, цена +10% без плеча → депозит +20% (за вычетом комиссий)
+	depositChangePercent := closedPositionPercent * (fill.PnlPercent / 100)
+	depositChange := (depositChangePercent / 100) * currentDeposit
+	newDeposit := currentDeposit + depositChange
+
+	if err := setUserDeposit(tx, call.UserID, newDeposit, version); err != nil {
+		return err
+	}
+
+	// Точка кривой капитала: нереализованный PnL других коллов здесь не известен
+	if err := recordDepositSnapshot(tx, DepositSnapshot{
+		UserID:  call.UserID,
+		Deposit: newDeposit,
+		Equity:  newDeposit,
+		Kind:    SnapshotKindClose,
+		CallID:  call.ID,
+	}); err != nil {
+		return fmt.Errorf("failed to record deposit snapshot: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"user_id":               call.UserID,
+		"call_id":               call.ID,
+		"closed_position_pct":   closedPositionPercent,
+		"pnl_pct":               fill.PnlPercent,
+		"fee_pct":               fill.FeePercent,
+		"funding_pct":           fill.FundingPercent,
+		"deposit_change_pct":    depositChangePercent,
+		"deposit_change_amount": depositChange,
+		"old_deposit":           currentDeposit,
+		"new_deposit":           newDeposit,
+	}).Info("user deposit updated after call close")
+	return nil
+}
+
 func (s *DatabaseStorage) UpdateStopLoss(callID string, userID int64, stopLossPrice float64) error {
+	// Первый стоп-лосс колла задает его риск (1R) для статистики
 	result, err := s.db.Exec(`
 		UPDATE calls
-		SET stop_loss_price = ?
+		SET stop_loss_price = ?, trail_percent = 0, trail_distance = 0, trail_activation = 0, trail_best_price = 0, version = COALESCE(version, 0) + 1,
+		    initial_risk_percent = CASE
+		        WHEN COALESCE(initial_risk_percent, 0) = 0 AND ? > 0 AND entry_price > 0 THEN ABS(entry_price - ?) / entry_price * 100
+		        ELSE initial_risk_percent END
 		WHERE id = ? AND user_id = ? AND status = 'open'`,
-		stopLossPrice, callID, userID)
+		stopLossPrice, stopLossPrice, stopLossPrice, callID, userID)
 	if err != nil {
 		return err
 	}
@@ -763,10 +932,164 @@ func (s *DatabaseStorage) UpdateStopLoss(callID string, userID int64, stopLossPr
 	return nil
 }
 
+// SetTrailingStop включает трейлинг-стоп колла: percent — отступ в процентах от лучшей цены,
+// distance — отступ в цене (задается одно из двух), activation — прибыль в %, после которой стоп
+// начинает подтягиваться. Лучшая цена сбрасывается, текущий стоп-лосс сохраняется до первого подтягивания.
+func (s *DatabaseStorage) SetTrailingStop(callID string, userID int64, percent, distance, activation float64) error {
+	if (percent > 0) == (distance > 0) {
+		return errors.New("укажите отступ трейлинг-стопа в процентах или в цене")
+	}
+	if percent >= 100 {
+		return errors.New("отступ трейлинг-стопа должен быть меньше 100%")
+	}
+	if activation < 0 {
+		return errors.New("порог активации не может быть отрицательным")
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE calls
+		SET trail_percent = ?, trail_distance = ?, trail_activation = ?, trail_best_price = 0, version = COALESCE(version, 0) + 1
+		WHERE id = ? AND user_id = ? AND status = 'open'`,
+		percent, distance, activation, callID, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errors.New("call not found or already closed")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"call_id":    callID,
+		"user_id":    userID,
+		"percent":    percent,
+		"distance":   distance,
+		"activation": activation,
+	}).Info("trailing stop set")
+
+	return nil
+}
+
+// UpdateTrailingStop сохраняет лучшую цену трейлинг-стопа и подтягивает стоп-лосс. Стоп, как
+// и в TightenStopLoss, только сужается: движок считает его по коллу, прочитанному раньше, и не должен
+// ослабить стоп, который пользователь успел подтянуть командой /sl. Возвращает, сдвинулся ли стоп.
+func (s *DatabaseStorage) UpdateTrailingStop(callID string, bestPrice, stopLossPrice float64) (bool, error) {
+	var moved bool
+	err := s.withTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`
+			UPDATE calls
+			SET stop_loss_price = ?
+			WHERE id = ? AND status = 'open' AND (
+				(direction = 'long' AND COALESCE(stop_loss_price, 0) < ?) OR
+				(direction = 'short' AND (COALESCE(stop_loss_price, 0) = 0 OR stop_loss_price > ?)))`,
+			stopLossPrice, callID, stopLossPrice, stopLossPrice)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		moved = affected > 0
+
+		_, err = tx.Exec(`
+			UPDATE calls
+			SET trail_best_price = ?, version = COALESCE(version, 0) + 1
+			WHERE id = ? AND status = 'open'`,
+			bestPrice, callID)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"call_id":         callID,
+		"best_price":      bestPrice,
+		"stop_loss_price": stopLossPrice,
+		"stop_moved":      moved,
+	}).Debug("trailing stop updated")
+	return moved, nil
+}
+
+// UpdateCallRules сохраняет правила автоматизации колла и их состояние. Стоп-лосс не меняется:
+// его двигает TightenStopLoss.
+func (s *DatabaseStorage) UpdateCallRules(call Call) error {
+	result, err := s.db.Exec(`
+		UPDATE calls
+		SET breakeven_percent = ?, breakeven_done = ?, time_stop_at = ?, swing_timeframe = ?, swing_checked_at = ?, version = COALESCE(version, 0) + 1
+		WHERE id = ? AND user_id = ? AND status = 'open'`,
+		call.BreakEvenPercent, call.BreakEvenDone, call.TimeStopAt, call.SwingTimeframe, call.SwingCheckedAt,
+		call.ID, call.UserID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errors.New("call not found or already closed")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"call_id":         call.ID,
+		"breakeven_pct":   call.BreakEvenPercent,
+		"breakeven_done":  call.BreakEvenDone,
+		"time_stop_at":    call.TimeStopAt,
+		"swing_timeframe": call.SwingTimeframe,
+	}).Debug("call rules updated")
+	return nil
+}
+
+// TightenStopLoss переносит стоп-лосс открытого колла на stopLossPrice, только если это улучшает стоп:
+// для лонга — выше текущего, для шорта — ниже текущего (или стопа еще нет). Возвращает, был ли стоп перенесен.
+func (s *DatabaseStorage) TightenStopLoss(callID string, stopLossPrice float64) (bool, error) {
+	result, err := s.db.Exec(`
+		UPDATE calls
+		SET stop_loss_price = ?, version = COALESCE(version, 0) + 1
+		WHERE id = ? AND status = 'open' AND (
+			(direction = 'long' AND COALESCE(stop_loss_price, 0) < ?) OR
+			(direction = 'short' AND (COALESCE(stop_loss_price, 0) = 0 OR stop_loss_price > ?)))`,
+		stopLossPrice, callID, stopLossPrice, stopLossPrice)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected > 0 {
+		logrus.WithFields(logrus.Fields{
+			"call_id":         callID,
+			"stop_loss_price": stopLossPrice,
+		}).Info("stop-loss tightened")
+	}
+	return affected > 0, nil
+}
+
 func (s *DatabaseStorage) GetUserCalls(userID int64, onlyOpen bool) []Call {
+	calls, err := userCalls(s.db, userID, onlyOpen)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to get user calls")
+	}
+	return calls
+}
+
+// userCalls читает коллы пользователя отдельно или в транзакции (проверка риск-правил при открытии).
+func userCalls(q querier, userID int64, onlyOpen bool) ([]Call, error) {
 	query := `
 		SELECT id, user_id, username, chat_id, symbol, market, direction, entry_price, size, 
-		       COALESCE(exit_price, 0), COALESCE(pnl_percent, 0), status, opened_at, closed_at, COALESCE(deposit_percent, 0), COALESCE(stop_loss_price, 0), exchange
+		       COALESCE(exit_price, 0), COALESCE(pnl_percent, 0), status, opened_at, closed_at, COALESCE(deposit_percent, 0), COALESCE(stop_loss_price, 0), exchange,
+		       COALESCE(trail_percent, 0), COALESCE(trail_distance, 0), COALESCE(trail_activation, 0), COALESCE(trail_best_price, 0),
+		       COALESCE(breakeven_percent, 0), COALESCE(breakeven_done, 0), time_stop_at, COALESCE(swing_timeframe, ''), swing_checked_at,
+		       COALESCE(leverage, 1), COALESCE(taker_fee, 0), COALESCE(funding_percent, 0), funding_at
 		FROM calls 
 		WHERE user_id = ?`
 
@@ -776,10 +1099,9 @@ func (s *DatabaseStorage) GetUserCalls(userID int64, onlyOpen bool) []Call {
 
 	query += " ORDER BY opened_at DESC"
 
-	rows, err := s.db.Query(query, userID)
+	rows, err := q.Query(query, userID)
 	if err != nil {
-		logrus.WithError(err).Warn("failed to get user calls")
-		return nil
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -787,9 +1109,13 @@ func (s *DatabaseStorage) GetUserCalls(userID int64, onlyOpen bool) []Call {
 	for rows.Next() {
 		var call Call
 		var closedAt sql.NullTime
+		var timeStopAt, swingCheckedAt, fundingAt sql.NullTime
 		err := rows.Scan(&call.ID, &call.UserID, &call.Username, &call.ChatID,
 			&call.Symbol, &call.Market, &call.Direction, &call.EntryPrice, &call.Size, &call.ExitPrice,
-			&call.PnlPercent, &call.Status, &call.OpenedAt, &closedAt, &call.DepositPercent, &call.StopLossPrice, &call.Exchange)
+			&call.PnlPercent, &call.Status, &call.OpenedAt, &closedAt, &call.DepositPercent, &call.StopLossPrice, &call.Exchange,
+			&call.TrailPercent, &call.TrailDistance, &call.TrailActivation, &call.TrailBestPrice,
+			&call.BreakEvenPercent, &call.BreakEvenDone, &timeStopAt, &call.SwingTimeframe, &swingCheckedAt,
+			&call.Leverage, &call.TakerFee, &call.FundingPercent, &fundingAt)
 		if err != nil {
 			logrus.WithError(err).Warn("failed to scan call row")
 			continue
@@ -797,16 +1123,28 @@ func (s *DatabaseStorage) GetUserCalls(userID int64, onlyOpen bool) []Call {
 		if closedAt.Valid {
 			call.ClosedAt = &closedAt.Time
 		}
+		if timeStopAt.Valid {
+			call.TimeStopAt = &timeStopAt.Time
+		}
+		if swingCheckedAt.Valid {
+			call.SwingCheckedAt = &swingCheckedAt.Time
+		}
+		if fundingAt.Valid {
+			call.FundingAt = &fundingAt.Time
+		}
 		calls = append(calls, call)
 	}
 
-	return calls
+	return calls, rows.Err()
 }
 
 func (s *DatabaseStorage) GetAllOpenCalls() []Call {
 	rows, err := s.db.Query(`
 		SELECT id, user_id, username, chat_id, symbol, market, direction, entry_price, size, 
-		       COALESCE(exit_price, 0), COALESCE(pnl_percent, 0), status, opened_at, closed_at, COALESCE(deposit_percent, 0), COALESCE(stop_loss_price, 0), exchange
+		       COALESCE(exit_price, 0), COALESCE(pnl_percent, 0), status, opened_at, closed_at, COALESCE(deposit_percent, 0), COALESCE(stop_loss_price, 0), exchange,
+		       COALESCE(trail_percent, 0), COALESCE(trail_distance, 0), COALESCE(trail_activation, 0), COALESCE(trail_best_price, 0),
+		       COALESCE(breakeven_percent, 0), COALESCE(breakeven_done, 0), time_stop_at, COALESCE(swing_timeframe, ''), swing_checked_at,
+		       COALESCE(leverage, 1), COALESCE(taker_fee, 0), COALESCE(funding_percent, 0), funding_at
 		FROM calls 
 		WHERE status = 'open'
 		ORDER BY opened_at DESC`)
@@ -820,10 +1158,14 @@ func (s *DatabaseStorage) GetAllOpenCalls() []Call {
 	var calls []Call
 	for rows.Next() {
 		var call Call
+		var timeStopAt, swingCheckedAt, fundingAt sql.NullTime
 		var closedAt sql.NullTime
 		err := rows.Scan(&call.ID, &call.UserID, &call.Username, &call.ChatID,
 			&call.Symbol, &call.Market, &call.Direction, &call.EntryPrice, &call.Size, &call.ExitPrice,
-			&call.PnlPercent, &call.Status, &call.OpenedAt, &closedAt, &call.DepositPercent, &call.StopLossPrice, &call.Exchange)
+			&call.PnlPercent, &call.Status, &call.OpenedAt, &closedAt, &call.DepositPercent, &call.StopLossPrice, &call.Exchange,
+			&call.TrailPercent, &call.TrailDistance, &call.TrailActivation, &call.TrailBestPrice,
+			&call.BreakEvenPercent, &call.BreakEvenDone, &timeStopAt, &call.SwingTimeframe, &swingCheckedAt,
+			&call.Leverage, &call.TakerFee, &call.FundingPercent, &fundingAt)
 		if err != nil {
 			logrus.WithError(err).Warn("failed to scan call row")
 			continue
@@ -831,34 +1173,47 @@ func (s *DatabaseStorage) GetAllOpenCalls() []Call {
 		if closedAt.Valid {
 			call.ClosedAt = &closedAt.Time
 		}
+		if timeStopAt.Valid {
+			call.TimeStopAt = &timeStopAt.Time
+		}
+		if swingCheckedAt.Valid {
+			call.SwingCheckedAt = &swingCheckedAt.Time
+		}
+		if fundingAt.Valid {
+			call.FundingAt = &fundingAt.Time
+		}
 		calls = append(calls, call)
 	}
 
 	return calls
 }
 
-func (s *DatabaseStorage) GetUserStats(userID int64) (*UserStats, error) {
+// GetUserStats возвращает статистику коллов пользователя в окне f
+func (s *DatabaseStorage) GetUserStats(userID int64, f StatsFilter) (*UserStats, error) {
 	var stats UserStats
 
-	// Базовая статистика за последние 90 дней
+	cond, args := f.where()
 	err := s.db.QueryRow(`
 		SELECT 
 			user_id,
 			username,
 			COUNT(*) as total_calls,
 			SUM(CASE WHEN status = 'closed' THEN 1 ELSE 0 END) as closed_calls,
-			SUM(CASE WHEN status = 'closed' AND pnl_percent > 0 THEN 1 ELSE 0 END) as winning_calls,
-			COALESCE(SUM(CASE WHEN status = 'closed' THEN pnl_percent ELSE 0 END), 0) as total_pnl,
-			COALESCE(AVG(CASE WHEN status = 'closed' THEN pnl_percent ELSE NULL END), 0) as avg_pnl,
-			COALESCE(MAX(CASE WHEN status = 'closed' THEN pnl_percent ELSE NULL END), 0) as best_call,
-			COALESCE(MIN(CASE WHEN status = 'closed' THEN pnl_percent ELSE NULL END), 0) as worst_call
-		FROM calls 
-		WHERE user_id = ? AND opened_at >= datetime('now', '-90 days') and deposit_percent>0
+			SUM(CASE WHEN status = 'closed' AND r.pnl_percent > 0 THEN 1 ELSE 0 END) as winning_calls,
+			COALESCE(SUM(CASE WHEN status = 'closed' THEN r.pnl_percent ELSE 0 END), 0) as total_pnl,
+			COALESCE(AVG(CASE WHEN status = 'closed' THEN r.pnl_percent ELSE NULL END), 0) as avg_pnl,
+			COALESCE(MAX(CASE WHEN status = 'closed' THEN r.pnl_percent ELSE NULL END), 0) as best_call,
+			COALESCE(MIN(CASE WHEN status = 'closed' THEN r.pnl_percent ELSE NULL END), 0) as worst_call,
+			COALESCE(SUM(r.deposit_fee_percent), 0) as total_fees,
+			COALESCE(SUM(r.deposit_funding_percent), 0) as total_funding,
+			COALESCE(SUM(r.liquidations), 0) as liquidations
+		FROM calls LEFT JOIN call_results r ON r.call_id = calls.id 
+		WHERE user_id = ?`+cond+`
 		GROUP BY user_id, username`,
-		userID).Scan(
+		append([]interface{}{userID}, args...)...).Scan(
 		&stats.UserID, &stats.Username, &stats.TotalCalls, &stats.ClosedCalls,
 		&stats.WinningCalls, &stats.TotalPnl, &stats.AveragePnl,
-		&stats.BestCall, &stats.WorstCall)
+		&stats.BestCall, &stats.WorstCall, &stats.TotalFees, &stats.TotalFunding, &stats.Liquidations)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -871,26 +1226,32 @@ func (s *DatabaseStorage) GetUserStats(userID int64) (*UserStats, error) {
 	if stats.ClosedCalls > 0 {
 		stats.WinRate = (float64(stats.WinningCalls) / float64(stats.ClosedCalls)) * 100
 	}
+	stats.TradeMetrics = s.getTradeMetrics(userID, f)[userID]
 
 	return &stats, nil
 }
 
-func (s *DatabaseStorage) GetAllUserStats() []UserStats {
+// GetAllUserStats возвращает статистику коллов всех пользователей в окне f
+func (s *DatabaseStorage) GetAllUserStats(f StatsFilter) []UserStats {
+	cond, args := f.where()
 	rows, err := s.db.Query(`
 		SELECT 
 			user_id,
 			username,
 			COUNT(*) as total_calls,
 			SUM(CASE WHEN status = 'closed' THEN 1 ELSE 0 END) as closed_calls,
-			SUM(CASE WHEN status = 'closed' AND pnl_percent > 0 THEN 1 ELSE 0 END) as winning_calls,
-			COALESCE(SUM(CASE WHEN status = 'closed' THEN pnl_percent ELSE 0 END), 0) as total_pnl,
-			COALESCE(AVG(CASE WHEN status = 'closed' THEN pnl_percent ELSE NULL END), 0) as avg_pnl,
-			COALESCE(MAX(CASE WHEN status = 'closed' THEN pnl_percent ELSE NULL END), 0) as best_call,
-			COALESCE(MIN(CASE WHEN status = 'closed' THEN pnl_percent ELSE NULL END), 0) as worst_call
-		FROM calls 
-		WHERE opened_at >= datetime('now', '-90 days') and deposit_percent>0
+			SUM(CASE WHEN status = 'closed' AND r.pnl_percent > 0 THEN 1 ELSE 0 END) as winning_calls,
+			COALESCE(SUM(CASE WHEN status = 'closed' THEN r.pnl_percent ELSE 0 END), 0) as total_pnl,
+			COALESCE(AVG(CASE WHEN status = 'closed' THEN r.pnl_percent ELSE NULL END), 0) as avg_pnl,
+			COALESCE(MAX(CASE WHEN status = 'closed' THEN r.pnl_percent ELSE NULL END), 0) as best_call,
+			COALESCE(MIN(CASE WHEN status = 'closed' THEN r.pnl_percent ELSE NULL END), 0) as worst_call,
+			COALESCE(SUM(r.deposit_fee_percent), 0) as total_fees,
+			COALESCE(SUM(r.deposit_funding_percent), 0) as total_funding,
+			COALESCE(SUM(r.liquidations), 0) as liquidations
+		FROM calls LEFT JOIN call_results r ON r.call_id = calls.id 
+		WHERE 1 = 1`+cond+`
 		GROUP BY user_id, username
-		ORDER BY total_pnl DESC`)
+		ORDER BY total_pnl DESC`, args...)
 
 	if err != nil {
 		logrus.WithError(err).Warn("failed to get all user stats")
@@ -898,12 +1259,13 @@ func (s *DatabaseStorage) GetAllUserStats() []UserStats {
 	}
 	defer rows.Close()
 
+	metrics := s.getTradeMetrics(0, f)
 	var stats []UserStats
 	for rows.Next() {
 		var stat UserStats
 		err := rows.Scan(&stat.UserID, &stat.Username, &stat.TotalCalls, &stat.ClosedCalls,
 			&stat.WinningCalls, &stat.TotalPnl, &stat.AveragePnl,
-			&stat.BestCall, &stat.WorstCall)
+			&stat.BestCall, &stat.WorstCall, &stat.TotalFees, &stat.TotalFunding, &stat.Liquidations)
 		if err != nil {
 			logrus.WithError(err).Warn("failed to scan user stats row")
 			continue
@@ -913,6 +1275,7 @@ func (s *DatabaseStorage) GetAllUserStats() []UserStats {
 		if stat.ClosedCalls > 0 {
 			stat.WinRate = (float64(stat.WinningCalls) / float64(stat.ClosedCalls)) * 100
 		}
+		stat.TradeMetrics = metrics[stat.UserID]
 
 		// Получаем информацию о депозите
 		initialDeposit, currentDeposit, err := s.GetUserDeposit(stat.UserID)
@@ -928,25 +1291,27 @@ func (s *DatabaseStorage) GetAllUserStats() []UserStats {
 	return stats
 }
 
-func (s *DatabaseStorage) GetUserTradesBySymbol(userID int64) map[string]struct {
+// GetUserTradesBySymbol возвращает статистику коллов пользователя по символам в окне f
+func (s *DatabaseStorage) GetUserTradesBySymbol(userID int64, f StatsFilter) map[string]struct {
 	TotalCalls   int
 	ClosedCalls  int
 	WinningCalls int
 	TotalPnl     float64
 	WinRate      float64
 } {
+	cond, args := f.where()
 	rows, err := s.db.Query(`
 		SELECT 
 			symbol,
 			COUNT(*) as total_calls,
 			SUM(CASE WHEN status = 'closed' THEN 1 ELSE 0 END) as closed_calls,
-			SUM(CASE WHEN status = 'closed' AND pnl_percent > 0 THEN 1 ELSE 0 END) as winning_calls,
-			COALESCE(SUM(CASE WHEN status = 'closed' THEN pnl_percent ELSE 0 END), 0) as total_pnl
-		FROM calls 
-		WHERE user_id = ? AND opened_at >= datetime('now', '-90 days') and deposit_percent>0
+			SUM(CASE WHEN status = 'closed' AND r.pnl_percent > 0 THEN 1 ELSE 0 END) as winning_calls,
+			COALESCE(SUM(CASE WHEN status = 'closed' THEN r.pnl_percent ELSE 0 END), 0) as total_pnl
+		FROM calls LEFT JOIN call_results r ON r.call_id = calls.id
+		WHERE user_id = ?`+cond+`
 		GROUP BY symbol
 		ORDER BY symbol`,
-		userID)
+		append([]interface{}{userID}, args...)...)
 
 	if err != nil {
 		logrus.WithError(err).Warn("failed to get user trades by symbol")
@@ -1050,15 +1415,19 @@ func (s *DatabaseStorage) GetSymbolStats(userID int64) map[string]struct {
 	return result
 }
 
-func (s *DatabaseStorage) GetBestWorstCallsForUser(userID int64) (bestCall, worstCall *Call) {
+// GetBestWorstCallsForUser возвращает лучший и худший закрытые коллы пользователя в окне f
+func (s *DatabaseStorage) GetBestWorstCallsForUser(userID int64, f StatsFilter) (bestCall, worstCall *Call) {
+	cond, args := f.where()
+	args = append([]interface{}{userID}, args...)
+
 	// Лучший колл
 	var best Call
 	err := s.db.QueryRow(`
-		SELECT id, symbol, direction, entry_price, exit_price, pnl_percent
-		FROM calls 
-		WHERE user_id = ? AND status = 'closed' AND opened_at >= datetime('now', '-90 days')
-		ORDER BY pnl_percent DESC LIMIT 1`,
-		userID).Scan(&best.ID, &best.Symbol, &best.Direction, &best.EntryPrice, &best.ExitPrice, &best.PnlPercent)
+		SELECT id, symbol, direction, entry_price, r.avg_exit_price, r.pnl_percent
+		FROM calls JOIN call_results r ON r.call_id = calls.id
+		WHERE user_id = ? AND status = 'closed'`+cond+`
+		ORDER BY r.pnl_percent DESC LIMIT 1`,
+		args...).Scan(&best.ID, &best.Symbol, &best.Direction, &best.EntryPrice, &best.ExitPrice, &best.PnlPercent)
 
 	if err == nil {
 		bestCall = &best
@@ -1067,11 +1436,11 @@ func (s *DatabaseStorage) GetBestWorstCallsForUser(userID int64) (bestCall, wors
 	// Худший колл
 	var worst Call
 	err = s.db.QueryRow(`
-		SELECT id, symbol, direction, entry_price, exit_price, pnl_percent
-		FROM calls 
-		WHERE user_id = ? AND status = 'closed' AND opened_at >= datetime('now', '-90 days')
-		ORDER BY pnl_percent ASC LIMIT 1`,
-		userID).Scan(&worst.ID, &worst.Symbol, &worst.Direction, &worst.EntryPrice, &worst.ExitPrice, &worst.PnlPercent)
+		SELECT id, symbol, direction, entry_price, r.avg_exit_price, r.pnl_percent
+		FROM calls JOIN call_results r ON r.call_id = calls.id
+		WHERE user_id = ? AND status = 'closed'`+cond+`
+		ORDER BY r.pnl_percent ASC LIMIT 1`,
+		args...).Scan(&worst.ID, &worst.Symbol, &worst.Direction, &worst.EntryPrice, &worst.ExitPrice, &worst.PnlPercent)
 
 	if err == nil {
 		worstCall = &worst
@@ -1081,18 +1450,27 @@ func (s *DatabaseStorage) GetBestWorstCallsForUser(userID int64) (bestCall, wors
 }
 
 func (s *DatabaseStorage) GetCallByID(callID string, userID int64) (*Call, error) {
+	var timeStopAt, swingCheckedAt, fundingAt sql.NullTime
 	var call Call
 	var closedAt sql.NullTime
 
 	err := s.db.QueryRow(`
 		SELECT id, user_id, username, chat_id, symbol, market, direction, entry_price, size, 
-		       COALESCE(exit_price, 0), COALESCE(pnl_percent, 0), status, opened_at, closed_at, COALESCE(stop_loss_price, 0), exchange
+		       COALESCE(exit_price, 0), COALESCE(pnl_percent, 0), status, opened_at, closed_at, COALESCE(stop_loss_price, 0), exchange,
+		       COALESCE(deposit_percent, 0),
+		       COALESCE(trail_percent, 0), COALESCE(trail_distance, 0), COALESCE(trail_activation, 0), COALESCE(trail_best_price, 0),
+		       COALESCE(breakeven_percent, 0), COALESCE(breakeven_done, 0), time_stop_at, COALESCE(swing_timeframe, ''), swing_checked_at,
+		       COALESCE(leverage, 1), COALESCE(taker_fee, 0), COALESCE(funding_percent, 0), funding_at, COALESCE(version, 0)
 		FROM calls 
 		WHERE id = ? AND user_id = ?`,
 		callID, userID).Scan(
 		&call.ID, &call.UserID, &call.Username, &call.ChatID,
 		&call.Symbol, &call.Market, &call.Direction, &call.EntryPrice, &call.Size, &call.ExitPrice,
-		&call.PnlPercent, &call.Status, &call.OpenedAt, &closedAt, &call.StopLossPrice, &call.Exchange)
+		&call.PnlPercent, &call.Status, &call.OpenedAt, &closedAt, &call.StopLossPrice, &call.Exchange,
+		&call.DepositPercent,
+		&call.TrailPercent, &call.TrailDistance, &call.TrailActivation, &call.TrailBestPrice,
+		&call.BreakEvenPercent, &call.BreakEvenDone, &timeStopAt, &call.SwingTimeframe, &swingCheckedAt,
+		&call.Leverage, &call.TakerFee, &call.FundingPercent, &fundingAt, &call.Version)
 
 	if err != nil {
 		return nil, err
@@ -1101,16 +1479,27 @@ func (s *DatabaseStorage) GetCallByID(callID string, userID int64) (*Call, error
 	if closedAt.Valid {
 		call.ClosedAt = &closedAt.Time
 	}
+	if timeStopAt.Valid {
+		call.TimeStopAt = &timeStopAt.Time
+	}
+	if swingCheckedAt.Valid {
+		call.SwingCheckedAt = &swingCheckedAt.Time
+	}
+	if fundingAt.Valid {
+		call.FundingAt = &fundingAt.Time
+	}
 
 	return &call, nil
 }
 
-func (s *DatabaseStorage) GetUserCallsHistory(userID int64, days int, onlyOpen bool) []Call {
+// GetUserCallsHistory возвращает коллы пользователя в окне f, новые первыми
+func (s *DatabaseStorage) GetUserCallsHistory(userID int64, f StatsFilter, onlyOpen bool) []Call {
+	cond, args := f.where()
 	query := `
 		SELECT id, user_id, username, chat_id, symbol, direction, entry_price, 
 		       COALESCE(exit_price, 0), COALESCE(pnl_percent, 0), status, opened_at, closed_at
 		FROM calls 
-		WHERE user_id = ? AND opened_at >= datetime('now', '-' || ? || ' days')`
+		WHERE user_id = ?` + cond
 
 	if onlyOpen {
 		query += " AND status = 'open'"
@@ -1118,7 +1507,7 @@ func (s *DatabaseStorage) GetUserCallsHistory(userID int64, days int, onlyOpen b
 
 	query += " ORDER BY opened_at DESC"
 
-	rows, err := s.db.Query(query, userID, days)
+	rows, err := s.db.Query(query, append([]interface{}{userID}, args...)...)
 	if err != nil {
 		logrus.WithError(err).Warn("failed to get user calls history")
 		return nil
@@ -1265,14 +1654,19 @@ func (s *DatabaseStorage) CreateLimitOrder(order LimitOrder) (LimitOrder, error)
 	}
 
 	order.Status = "active"
+	if order.OrderType == "" {
+		order.OrderType = OrderTypeLimit
+	}
 
 	_, err := s.db.Exec(`
 		INSERT INTO limit_orders (id, user_id, username, chat_id, symbol, direction, limit_price, 
-		                          deposit_percent, related_call_id, size_to_close, status, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		                          deposit_percent, related_call_id, size_to_close, status, created_at,
+		                          order_type, stop_price, oco_group, expires_at, stop_loss_price, leverage)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		order.ID, order.UserID, order.Username, order.ChatID, order.Symbol, order.Direction,
 		order.LimitPrice, order.DepositPercent, order.RelatedCallID, order.SizeToClose,
-		order.Status, order.CreatedAt)
+		order.Status, order.CreatedAt, order.OrderType, order.StopPrice, order.OcoGroup, order.ExpiresAt,
+		order.StopLossPrice, order.Leverage)
 
 	if err != nil {
 		return order, err
@@ -1283,7 +1677,10 @@ func (s *DatabaseStorage) CreateLimitOrder(order LimitOrder) (LimitOrder, error)
 		"user_id":         order.UserID,
 		"symbol":          order.Symbol,
 		"direction":       order.Direction,
+		"order_type":      order.OrderType,
 		"limit_price":     order.LimitPrice,
+		"stop_price":      order.StopPrice,
+		"oco_group":       order.OcoGroup,
 		"related_call_id": order.RelatedCallID,
 	}).Info("limit order created")
 
@@ -1293,9 +1690,7 @@ func (s *DatabaseStorage) CreateLimitOrder(order LimitOrder) (LimitOrder, error)
 // GetActiveLimitOrders возвращает все активные лимитные ордера
 func (s *DatabaseStorage) GetActiveLimitOrders() []LimitOrder {
 	rows, err := s.db.Query(`
-		SELECT id, user_id, username, chat_id, symbol, direction, limit_price, 
-		       deposit_percent, COALESCE(related_call_id, ''), COALESCE(size_to_close, 0), 
-		       status, created_at, triggered_at
+		SELECT ` + limitOrderColumns + `
 		FROM limit_orders
 		WHERE status = 'active'
 		ORDER BY created_at ASC`)
@@ -1306,32 +1701,13 @@ func (s *DatabaseStorage) GetActiveLimitOrders() []LimitOrder {
 	}
 	defer rows.Close()
 
-	var orders []LimitOrder
-	for rows.Next() {
-		var order LimitOrder
-		var triggeredAt sql.NullTime
-		err := rows.Scan(&order.ID, &order.UserID, &order.Username, &order.ChatID,
-			&order.Symbol, &order.Direction, &order.LimitPrice, &order.DepositPercent,
-			&order.RelatedCallID, &order.SizeToClose, &order.Status, &order.CreatedAt, &triggeredAt)
-		if err != nil {
-			logrus.WithError(err).Warn("failed to scan limit order row")
-			continue
-		}
-		if triggeredAt.Valid {
-			order.TriggeredAt = &triggeredAt.Time
-		}
-		orders = append(orders, order)
-	}
-
-	return orders
+	return scanLimitOrders(rows)
 }
 
 // GetUserLimitOrders возвращает активные лимитные ордера пользователя
 func (s *DatabaseStorage) GetUserLimitOrders(userID int64) []LimitOrder {
 	rows, err := s.db.Query(`
-		SELECT id, user_id, username, chat_id, symbol, direction, limit_price, 
-		       deposit_percent, COALESCE(related_call_id, ''), COALESCE(size_to_close, 0), 
-		       status, created_at, triggered_at
+		SELECT `+limitOrderColumns+`
 		FROM limit_orders
 		WHERE user_id = ? AND status = 'active'
 		ORDER BY created_at ASC`, userID)
@@ -1342,24 +1718,7 @@ func (s *DatabaseStorage) GetUserLimitOrders(userID int64) []LimitOrder {
 	}
 	defer rows.Close()
 
-	var orders []LimitOrder
-	for rows.Next() {
-		var order LimitOrder
-		var triggeredAt sql.NullTime
-		err := rows.Scan(&order.ID, &order.UserID, &order.Username, &order.ChatID,
-			&order.Symbol, &order.Direction, &order.LimitPrice, &order.DepositPercent,
-			&order.RelatedCallID, &order.SizeToClose, &order.Status, &order.CreatedAt, &triggeredAt)
-		if err != nil {
-			logrus.WithError(err).Warn("failed to scan limit order row")
-			continue
-		}
-		if triggeredAt.Valid {
-			order.TriggeredAt = &triggeredAt.Time
-		}
-		orders = append(orders, order)
-	}
-
-	return orders
+	return scanLimitOrders(rows)
 }
 
 // CancelLimitOrder отменяет лимитный ордер
@@ -1391,29 +1750,14 @@ func (s *DatabaseStorage) CancelLimitOrder(orderID string, userID int64) error {
 	return nil
 }
 
-// TriggerLimitOrder помечает ордер как исполненный
-func (s *DatabaseStorage) TriggerLimitOrder(orderID string) error {
-	now := time.Now()
-	_, err := s.db.Exec(`
-		UPDATE limit_orders
-		SET status = 'triggered', triggered_at = ?
-		WHERE id = ? AND status = 'active'`,
-		now, orderID)
-
-	if err != nil {
-		return err
-	}
-
-	logrus.WithFields(logrus.Fields{
-		"order_id": orderID,
-	}).Info("limit order triggered")
-
-	return nil
-}
-
 // CancelLimitOrdersByCallID отменяет все лимитные ордера, связанные с коллом
 func (s *DatabaseStorage) CancelLimitOrdersByCallID(callID string) error {
-	result, err := s.db.Exec(`
+	return cancelLimitOrdersByCallID(s.db, callID)
+}
+
+// cancelLimitOrdersByCallID отменяет лимитные ордера колла отдельно или в транзакции закрытия
+func cancelLimitOrdersByCallID(q querier, callID string) error {
+	result, err := q.Exec(`
 		UPDATE limit_orders
 		SET status = 'cancelled'
 		WHERE related_call_id = ? AND status = 'active'`,
@@ -1441,9 +1785,7 @@ func (s *DatabaseStorage) CancelLimitOrdersByCallID(callID string) error {
 // GetLimitOrdersBySymbol возвращает активные ордера по символу
 func (s *DatabaseStorage) GetLimitOrdersBySymbol(symbol string) []LimitOrder {
 	rows, err := s.db.Query(`
-		SELECT id, user_id, username, chat_id, symbol, direction, limit_price, 
-		       deposit_percent, COALESCE(related_call_id, ''), COALESCE(size_to_close, 0), 
-		       status, created_at, triggered_at
+		SELECT `+limitOrderColumns+`
 		FROM limit_orders
 		WHERE symbol = ? AND status = 'active'
 		ORDER BY created_at ASC`, symbol)
@@ -1454,22 +1796,5 @@ func (s *DatabaseStorage) GetLimitOrdersBySymbol(symbol string) []LimitOrder {
 	}
 	defer rows.Close()
 
-	var orders []LimitOrder
-	for rows.Next() {
-		var order LimitOrder
-		var triggeredAt sql.NullTime
-		err := rows.Scan(&order.ID, &order.UserID, &order.Username, &order.ChatID,
-			&order.Symbol, &order.Direction, &order.LimitPrice, &order.DepositPercent,
-			&order.RelatedCallID, &order.SizeToClose, &order.Status, &order.CreatedAt, &triggeredAt)
-		if err != nil {
-			logrus.WithError(err).Warn("failed to scan limit order row")
-			continue
-		}
-		if triggeredAt.Valid {
-			order.TriggeredAt = &triggeredAt.Time
-		}
-		orders = append(orders, order)
-	}
-
-	return orders
+	return scanLimitOrders(rows)
 }
@@ -0,0 +1,180 @@
+package alerts
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TakeProfit одна нога лестницы тейк-профитов колла: при достижении цены закрывается Percent позиции.
+type TakeProfit struct {
+	ID       int64      `json:"id"`
+	CallID   string     `json:"call_id"`
+	Price    float64    `json:"price"`
+	Percent  float64    `json:"percent"` // Доля позиции в процентах (в единицах размера колла)
+	Status   string     `json:"status"`  // "active", "filled", "cancelled"
+	FilledAt *time.Time `json:"filled_at,omitempty"`
+}
+
+// Статусы ног тейк-профита
+const (
+	TakeProfitActive    = "active"
+	TakeProfitFilled    = "filled"
+	TakeProfitCancelled = "cancelled"
+)
+
+// ValidateTakeProfits проверяет лестницу тейк-профитов для колла с направлением direction
+// и ценой входа entry: цены должны быть в сторону прибыли, а сумма долей не больше 100%.
+func ValidateTakeProfits(direction string, entry float64, legs []TakeProfit) error {
+	var total float64
+	for _, leg := range legs {
+		if leg.Price <= 0 {
+			return errors.New("цена тейк-профита должна быть больше 0")
+		}
+		if leg.Percent <= 0 {
+			return errors.New("доля тейк-профита должна быть больше 0")
+		}
+		if direction == "short" && leg.Price >= entry {
+			return fmt.Errorf("тейк-профит %g для шорта должен быть ниже цены входа", leg.Price)
+		}
+		if direction != "short" && leg.Price <= entry {
+			return fmt.Errorf("тейк-профит %g для лонга должен быть выше цены входа", leg.Price)
+		}
+		total += leg.Percent
+	}
+	if total > 100.0001 {
+		return fmt.Errorf("сумма долей тейк-профитов %.0f%% больше 100%%", total)
+	}
+	return nil
+}
+
+// AddTakeProfits сохраняет ноги тейк-профита колла.
+func (s *DatabaseStorage) AddTakeProfits(callID string, legs []TakeProfit) ([]TakeProfit, error) {
+	saved := make([]TakeProfit, 0, len(legs))
+	for _, leg := range legs {
+		result, err := s.db.Exec(`
+			INSERT INTO call_take_profits (call_id, price, percent, status)
+			VALUES (?, ?, ?, ?)`,
+			callID, leg.Price, leg.Percent, TakeProfitActive)
+		if err != nil {
+			return saved, err
+		}
+		leg.ID, _ = result.LastInsertId()
+		leg.CallID = callID
+		leg.Status = TakeProfitActive
+		saved = append(saved, leg)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"call_id": callID,
+		"count":   len(saved),
+	}).Info("take-profits added")
+	return saved, nil
+}
+
+// GetTakeProfits возвращает все ноги тейк-профита колла в порядке добавления.
+func (s *DatabaseStorage) GetTakeProfits(callID string) []TakeProfit {
+	return s.queryTakeProfits(`
+		SELECT id, call_id, price, percent, status, filled_at
+		FROM call_take_profits
+		WHERE call_id = ?
+		ORDER BY id`, callID)
+}
+
+// GetActiveTakeProfits возвращает неисполненные ноги тейк-профита колла.
+func (s *DatabaseStorage) GetActiveTakeProfits(callID string) []TakeProfit {
+	return s.queryTakeProfits(`
+		SELECT id, call_id, price, percent, status, filled_at
+		FROM call_take_profits
+		WHERE call_id = ? AND status = 'active'
+		ORDER BY id`, callID)
+}
+
+func (s *DatabaseStorage) queryTakeProfits(query string, args ...any) []TakeProfit {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to get take-profits")
+		return nil
+	}
+	defer rows.Close()
+
+	var legs []TakeProfit
+	for rows.Next() {
+		var leg TakeProfit
+		var filledAt sql.NullTime
+		if err := rows.Scan(&leg.ID, &leg.CallID, &leg.Price, &leg.Percent, &leg.Status, &filledAt); err != nil {
+			logrus.WithError(err).Warn("failed to scan take-profit row")
+			continue
+		}
+		if filledAt.Valid {
+			leg.FilledAt = &filledAt.Time
+		}
+		legs = append(legs, leg)
+	}
+	return legs
+}
+
+// ErrTakeProfitNotActive нога тейк-профита уже исполнена или отменена (например, закрытием колла).
+var ErrTakeProfitNotActive = errors.New("тейк-профит уже не активен")
+
+// CloseCallByTakeProfit исполняет ногу тейк-профита: нога отмечается исполненной и колл закрывается
+// на sizeToClose (CloseRemaining — на весь остаток) по цене exitPrice одной транзакцией, поэтому нога не может остаться исполненной
+// без закрытия и наоборот.
+func (s *DatabaseStorage) CloseCallByTakeProfit(leg TakeProfit, userID int64, exitPrice, sizeToClose float64) error {
+	err := s.closeCall(leg.CallID, userID, exitPrice, sizeToClose, FillSourceTakeProfit, func(tx *sql.Tx) error {
+		return fillTakeProfit(tx, leg.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"take_profit_id": leg.ID,
+		"call_id":        leg.CallID,
+	}).Info("take-profit filled")
+	return nil
+}
+
+// fillTakeProfit отмечает активную ногу исполненной в транзакции закрытия колла.
+func fillTakeProfit(tx *sql.Tx, id int64) error {
+	result, err := tx.Exec(`
+		UPDATE call_take_profits
+		SET status = 'filled', filled_at = ?
+		WHERE id = ? AND status = 'active'`,
+		time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrTakeProfitNotActive
+	}
+	return nil
+}
+
+// CancelTakeProfitsByCallID отменяет все неисполненные ноги тейк-профита колла.
+func (s *DatabaseStorage) CancelTakeProfitsByCallID(callID string) error {
+	return cancelTakeProfitsByCallID(s.db, callID)
+}
+
+// cancelTakeProfitsByCallID отменяет ноги тейк-профита отдельно или в транзакции закрытия колла
+func cancelTakeProfitsByCallID(q querier, callID string) error {
+	result, err := q.Exec(`
+		UPDATE call_take_profits
+		SET status = 'cancelled'
+		WHERE call_id = ? AND status = 'active'`,
+		callID)
+	if err != nil {
+		return err
+	}
+
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		logrus.WithFields(logrus.Fields{
+			"call_id": callID,
+			"count":   affected,
+		}).Info("take-profits cancelled by call id")
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package alerts
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Режимы импорта
+const (
+	ImportMerge   = "merge"   // Существующие записи сохраняются, добавляются только новые
+	ImportReplace = "replace" // Таблицы набора очищаются и заполняются из файла с исходными ID
+)
+
+// TransferTable таблица, которая переносится при экспорте и импорте.
+type TransferTable struct {
+	Name string
+	Key  string // Ключ записи, на который ссылаются дочерние таблицы набора
+	// AutoID — ключ id назначает база. При слиянии id не переносится, чтобы не совпасть с чужими записями
+	AutoID bool
+	// Parent и ParentTable — колонка со ссылкой на запись родительской таблицы того же набора.
+	// При слиянии строка переносится, только если родитель был добавлен этим импортом:
+	// к существующему коллу не добавляются чужие исполнения
+	Parent      string
+	ParentTable string
+}
+
+// Dataset набор связанных таблиц, который экспортируется и импортируется целиком.
+type Dataset struct {
+	Name        string
+	Description string
+	Tables      []TransferTable // Родительские таблицы идут раньше дочерних
+}
+
+// Datasets наборы данных для переноса состояния бота между хостами и резервных копий
+var Datasets = []Dataset{
+	{Name: "alerts", Description: "алерты и их символы", Tables: []TransferTable{
+		{Name: "alerts", Key: "id"},
+		{Name: "alert_symbols", Parent: "alert_id", ParentTable: "alerts"},
+	}},
+	{Name: "calls", Description: "коллы, исполнения и тейк-профиты", Tables: []TransferTable{
+		{Name: "calls", Key: "id"},
+		{Name: "call_fills", AutoID: true, Parent: "call_id", ParentTable: "calls"},
+		{Name: "call_take_profits", AutoID: true, Parent: "call_id", ParentTable: "calls"},
+	}},
+	{Name: "orders", Description: "лимитные ордера и журнал их изменений", Tables: []TransferTable{
+		{Name: "limit_orders", Key: "id"},
+		{Name: "limit_order_changes", AutoID: true, Parent: "order_id", ParentTable: "limit_orders"},
+	}},
+	{Name: "reminders", Description: "напоминания", Tables: []TransferTable{
+		{Name: "reminders"},
+	}},
+	{Name: "deposits", Description: "депозиты и кривая капитала", Tables: []TransferTable{
+		{Name: "user_deposits", Key: "user_id"},
+		{Name: "deposit_snapshots", AutoID: true, Parent: "user_id", ParentTable: "user_deposits"},
+	}},
+	{Name: "triggers", Description: "история срабатываний алертов", Tables: []TransferTable{
+		{Name: "alert_triggers", AutoID: true},
+	}},
+}
+
+// SelectDatasets возвращает наборы по именам через запятую; пустая строка — все наборы.
+func SelectDatasets(names string) ([]Dataset, error) {
+	if strings.TrimSpace(names) == "" {
+		return Datasets, nil
+	}
+	var selected []Dataset
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, ds := range Datasets {
+			if ds.Name == name {
+				selected = append(selected, ds)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("неизвестный набор данных %q", name)
+		}
+	}
+	return selected, nil
+}
+
+// TransferRow строка таблицы: колонка → значение как оно хранится в базе
+// (int64, float64, string, []byte или nil).
+type TransferRow map[string]interface{}
+
+// ImportResult число добавленных, пропущенных и конфликтующих строк по таблицам.
+type ImportResult struct {
+	Inserted map[string]int
+	Skipped  map[string]int // Строки, которые уже есть в базе, и дочерние строки не добавленных записей
+	// Conflicts — строки, ключ которых при слиянии занят другой записью: в базе остается существующая
+	Conflicts map[string]int
+}
+
+// tableColumns колонки таблицы в порядке объявления.
+func tableColumns(q querier, table string) ([]string, error) {
+	rows, err := q.Query(`SELECT name FROM pragma_table_info(?) ORDER BY cid`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("таблица %s не найдена", table)
+	}
+	return columns, rows.Err()
+}
+
+// ExportTable возвращает колонки и все строки таблицы. Значения читаются как хранятся:
+// унарный плюс делает колонку выражением, и драйвер не превращает DATETIME в time.Time,
+// поэтому после импорта время остается в том же текстовом формате.
+func (s *DatabaseStorage) ExportTable(table string) ([]string, []TransferRow, error) {
+	columns, err := tableColumns(s.db, table)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	exprs := make([]string, len(columns))
+	for i, col := range columns {
+		exprs[i] = fmt.Sprintf(`+"%s" AS "%s"`, col, col)
+	}
+	rows, err := s.db.Query(`SELECT ` + strings.Join(exprs, ", ") + ` FROM "` + table + `" ORDER BY rowid`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var result []TransferRow
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		row := make(TransferRow, len(columns))
+		for i, col := range columns {
+			row[col] = values[i]
+		}
+		result = append(result, row)
+	}
+	return columns, result, rows.Err()
+}
+
+// ImportTables переносит строки data (таблица → строки) в таблицы наборов datasets одной транзакцией:
+// при любой ошибке база остается как была. Наборы, таблиц которых нет в data, пропускаются.
+// Колонки, которых нет в таблице, считаются ошибкой, недостающие получают значения по умолчанию.
+func (s *DatabaseStorage) ImportTables(datasets []Dataset, data map[string][]TransferRow, mode string) (ImportResult, error) {
+	if mode != ImportMerge && mode != ImportReplace {
+		return ImportResult{}, fmt.Errorf("неизвестный режим импорта %q: merge или replace", mode)
+	}
+
+	var result ImportResult
+	err := s.withTx(func(tx *sql.Tx) error {
+		result = ImportResult{Inserted: make(map[string]int), Skipped: make(map[string]int), Conflicts: make(map[string]int)}
+		for _, ds := range datasets {
+			// Набор без таблиц в файле не трогается даже при замене
+			if !datasetPresent(ds, data) {
+				continue
+			}
+			// Ключи родительских записей, добавленных этим импортом
+			added := make(map[string]map[string]bool)
+			for _, table := range ds.Tables {
+				if mode == ImportReplace {
+					if _, err := tx.Exec(`DELETE FROM "` + table.Name + `"`); err != nil {
+						return fmt.Errorf("%s: %w", table.Name, err)
+					}
+				}
+				if err := importTable(tx, table, data[table.Name], mode, added, &result); err != nil {
+					return fmt.Errorf("%s: %w", table.Name, err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"mode":      mode,
+		"inserted":  result.Inserted,
+		"skipped":   result.Skipped,
+		"conflicts": result.Conflicts,
+	}).Info("data imported")
+	return result, nil
+}
+
+// datasetPresent сообщает, есть ли в данных импорта хотя бы одна таблица набора.
+func datasetPresent(ds Dataset, data map[string][]TransferRow) bool {
+	for _, table := range ds.Tables {
+		if _, ok := data[table.Name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// importTable добавляет строки одной таблицы и запоминает ключи добавленных записей для дочерних таблиц.
+func importTable(tx *sql.Tx, table TransferTable, rows []TransferRow, mode string, added map[string]map[string]bool, result *ImportResult) error {
+	columns, err := tableColumns(tx, table.Name)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		known[col] = true
+	}
+	added[table.Name] = make(map[string]bool)
+	merge := mode == ImportMerge
+
+	for i, row := range rows {
+		var cols []string
+		for col := range row {
+			if !known[col] {
+				return fmt.Errorf("строка %d: в таблице нет колонки %q", i+1, col)
+			}
+			// При слиянии id назначает база
+			if merge && table.AutoID && col == "id" {
+				continue
+			}
+			cols = append(cols, col)
+		}
+		if len(cols) == 0 {
+			continue
+		}
+		sort.Strings(cols)
+
+		if merge && table.Parent != "" && !added[table.ParentTable][keyString(row[table.Parent])] {
+			result.Skipped[table.Name]++
+			continue
+		}
+
+		args := make([]interface{}, len(cols))
+		quoted := make([]string, len(cols))
+		for j, col := range cols {
+			args[j] = row[col]
+			quoted[j] = `"` + col + `"`
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")
+
+		query := `INSERT INTO "` + table.Name + `" (` + strings.Join(quoted, ", ") + `) VALUES (` + placeholders + `)`
+		deduplicated := merge && table.AutoID && table.Parent == ""
+		switch {
+		case deduplicated:
+			// У истории без родителя нет ключа: пропускается строка, которая уже есть целиком
+			conds := make([]string, len(cols))
+			for j, col := range quoted {
+				conds[j] = col + ` IS ?`
+			}
+			query = `INSERT INTO "` + table.Name + `" (` + strings.Join(quoted, ", ") + `) SELECT ` + placeholders +
+				` WHERE NOT EXISTS (SELECT 1 FROM "` + table.Name + `" WHERE ` + strings.Join(conds, " AND ") + `)`
+			args = append(args, args...)
+		case merge:
+			query = `INSERT OR IGNORE` + strings.TrimPrefix(query, `INSERT`)
+		}
+
+		res, err := tx.Exec(query, args...)
+		if err != nil {
+			return fmt.Errorf("строка %d: %w", i+1, err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			// INSERT OR IGNORE не отличает повтор записи от занятого ключа: повтор пропускается,
+			// а ключ, занятый другой записью, — конфликт, о котором нужно сообщить
+			if !deduplicated {
+				same, err := rowExists(tx, table.Name, quoted, args)
+				if err != nil {
+					return fmt.Errorf("строка %d: %w", i+1, err)
+				}
+				if !same {
+					result.Conflicts[table.Name]++
+					logrus.WithFields(logrus.Fields{
+						"table": table.Name,
+						"row":   i + 1,
+						"key":   row[table.Key],
+					}).Warn("import conflict, existing row kept")
+					continue
+				}
+			}
+			result.Skipped[table.Name]++
+			continue
+		}
+		result.Inserted[table.Name]++
+		if table.Key != "" {
+			added[table.Name][keyString(row[table.Key])] = true
+		}
+	}
+	return nil
+}
+
+// rowExists сообщает, есть ли в таблице строка с такими же значениями колонок quoted.
+func rowExists(tx *sql.Tx, table string, quoted []string, args []interface{}) (bool, error) {
+	conds := make([]string, len(quoted))
+	for j, col := range quoted {
+		conds[j] = col + ` IS ?`
+	}
+	var exists bool
+	err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM "`+table+`" WHERE `+strings.Join(conds, " AND ")+`)`, args...).Scan(&exists)
+	return exists, err
+}
+
+// keyString ключ записи для сравнения: числа из JSON и CSV приходят разными типами.
+func keyString(v interface{}) string {
+	switch k := v.(type) {
+	case float64:
+		if k == float64(int64(k)) {
+			return fmt.Sprint(int64(k))
+		}
+	case []byte:
+		return string(k)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,67 @@
+package alerts
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrConcurrentUpdate строка изменилась между чтением и записью: ее версия уже не та, что была прочитана.
+var ErrConcurrentUpdate = errors.New("данные изменены параллельной операцией")
+
+// txAttempts сколько раз операция повторяется после ErrConcurrentUpdate
+const txAttempts = 3
+
+// querier общие методы *sql.DB и *sql.Tx: шаги многошаговых операций выполняются либо отдельно,
+// либо внутри транзакции вызывающей операции.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// withTx выполняет fn в одной транзакции: любая ошибка откатывает все шаги. Транзакция берет
+// блокировку записи сразу (_txlock=immediate), поэтому параллельные операции над тем же коллом
+// или депозитом выполняются по очереди. Если fn вернула ErrConcurrentUpdate, операция повторяется
+// заново, начиная с чтения.
+//
+// Внутри fn все запросы должны идти через tx: запись через s.db ждала бы блокировку,
+// которую держит сама транзакция.
+func (s *DatabaseStorage) withTx(fn func(tx *sql.Tx) error) error {
+	var err error
+	for attempt := 1; attempt <= txAttempts; attempt++ {
+		if err = s.runTx(fn); !errors.Is(err, ErrConcurrentUpdate) {
+			return err
+		}
+		logrus.WithField("attempt", attempt).Debug("transaction retried after concurrent update")
+	}
+	return err
+}
+
+// runTx одна попытка withTx
+func (s *DatabaseStorage) runTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkVersion проверяет результат UPDATE с условием version = ?: ни одной измененной строки —
+// строку успели изменить после чтения.
+func checkVersion(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrConcurrentUpdate
+	}
+	return nil
+}
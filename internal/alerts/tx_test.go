@@ -0,0 +1,510 @@
+package alerts
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"math"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// workers сколько горутин одновременно работают с одним коллом
+const workers = 20
+
+func init() {
+	logrus.SetOutput(io.Discard)
+}
+
+// newTestStorage создает базу со всеми миграциями во временном каталоге теста.
+func newTestStorage(t *testing.T) *DatabaseStorage {
+	t.Helper()
+	s, err := NewDatabaseStorage(filepath.Join(t.TempDir(), "alerts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// openTestCall открывает long колл по цене 100 на 10% депозита пользователя 1 (депозит создается со значением 100).
+func openTestCall(t *testing.T, s *DatabaseStorage) Call {
+	t.Helper()
+	if _, _, err := s.GetUserDeposit(1); err != nil {
+		t.Fatal(err)
+	}
+	call, err := s.OpenCall(Call{UserID: 1, ChatID: 1, Symbol: "BTCUSDT", Market: "spot", Exchange: "Bitget",
+		Direction: "long", EntryPrice: 100, DepositPercent: 10}, FillSourceManual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return call
+}
+
+// hammer запускает n горутин с op(i) одновременно и возвращает ошибки по номерам.
+func hammer(n int, op func(i int) error) []error {
+	errs := make([]error, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = op(i)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return errs
+}
+
+// fillTotals объем исполнений колла по типам в единицах открытия и число исполнений.
+func fillTotals(t *testing.T, s *DatabaseStorage, callID string) (units map[string]float64, count map[string]int) {
+	t.Helper()
+	rows, err := s.db.Query(`SELECT kind, SUM(size), COUNT(*) FROM call_fills WHERE call_id = ? GROUP BY kind`, callID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	units, count = make(map[string]float64), make(map[string]int)
+	for rows.Next() {
+		var kind string
+		var sum float64
+		var n int
+		if err := rows.Scan(&kind, &sum, &n); err != nil {
+			t.Fatal(err)
+		}
+		units[kind], count[kind] = sum, n
+	}
+	return units, count
+}
+
+// closeSnapshots число снимков закрытия и депозит в последнем из них.
+func closeSnapshots(t *testing.T, s *DatabaseStorage) (n int, lastDeposit float64) {
+	t.Helper()
+	for _, snap := range s.GetDepositSnapshots(1) {
+		if snap.Kind == SnapshotKindClose {
+			n++
+			lastDeposit = snap.Deposit
+		}
+	}
+	return n, lastDeposit
+}
+
+// checkLedger проверяет, что журнал исполнений сходится с коллом: открытый объем равен закрытому
+// плюс оставшемуся, а версия колла выросла ровно на число успешных операций.
+func checkLedger(t *testing.T, s *DatabaseStorage, callID string, ops int) *Call {
+	t.Helper()
+	call, err := s.GetCallByID(callID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	units, _ := fillTotals(t, s, callID)
+	opened := units[FillKindOpen] + units[FillKindAdd]
+	remaining := call.Size * opened / 100
+	if math.Abs(opened-units[FillKindClose]-remaining) > 1e-6 {
+		t.Errorf("открыто %.6f, закрыто %.6f, осталось %.6f: журнал не сходится с коллом", opened, units[FillKindClose], remaining)
+	}
+	if call.Version != int64(ops) {
+		t.Errorf("версия колла %d, успешных операций %d", call.Version, ops)
+	}
+	return call
+}
+
+func succeeded(errs []error) int {
+	n := 0
+	for _, err := range errs {
+		if err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func TestConcurrentFullCloseOnce(t *testing.T) {
+	s := newTestStorage(t)
+	call := openTestCall(t, s)
+
+	errs := hammer(workers, func(int) error {
+		return s.CloseCall(call.ID, 1, 110, 100, FillSourceManual)
+	})
+	if n := succeeded(errs); n != 1 {
+		t.Fatalf("успешных полных закрытий %d, ожидалось 1: %v", n, errs)
+	}
+
+	closed := checkLedger(t, s, call.ID, 1)
+	if closed.Status != "closed" {
+		t.Errorf("статус колла %q, ожидался closed", closed.Status)
+	}
+	if _, count := fillTotals(t, s, call.ID); count[FillKindClose] != 1 {
+		t.Errorf("исполнений закрытия %d, ожидалось 1", count[FillKindClose])
+	}
+
+	// 10% депозита, цена +10%: депозит меняется один раз на 1%
+	_, deposit, err := s.GetUserDeposit(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(deposit-101) > 1e-9 {
+		t.Errorf("депозит %.6f, ожидалось 101", deposit)
+	}
+	if n, last := closeSnapshots(t, s); n != 1 || last != deposit {
+		t.Errorf("снимков закрытия %d с депозитом %.6f, ожидался 1 с %.6f", n, last, deposit)
+	}
+}
+
+func TestConcurrentPartialCloses(t *testing.T) {
+	s := newTestStorage(t)
+	call := openTestCall(t, s)
+
+	// 20 закрытий по 5 единиц закрывают колл целиком, и каждое должно примениться
+	errs := hammer(workers, func(int) error {
+		return s.CloseCall(call.ID, 1, 110, 100.0/workers, FillSourceManual)
+	})
+	if n := succeeded(errs); n != workers {
+		t.Fatalf("успешных частичных закрытий %d из %d: %v", n, workers, errs)
+	}
+
+	closed := checkLedger(t, s, call.ID, workers)
+	if closed.Status != "closed" {
+		t.Errorf("статус колла %q, ожидался closed", closed.Status)
+	}
+
+	// Каждое закрытие меняет депозит на 0.5% доли × 10% цены от уже измененного депозита
+	_, deposit, err := s.GetUserDeposit(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 100 * math.Pow(1+0.5/100*10/100, workers)
+	if math.Abs(deposit-want) > 1e-9 {
+		t.Errorf("депозит %.9f, ожидалось %.9f", deposit, want)
+	}
+	if n, last := closeSnapshots(t, s); n != workers || math.Abs(last-deposit) > 1e-9 {
+		t.Errorf("снимков закрытия %d с депозитом %.9f, ожидалось %d с %.9f", n, last, workers, deposit)
+	}
+}
+
+func TestConcurrentCloseRemainingAfterPartialCloses(t *testing.T) {
+	s := newTestStorage(t)
+	call := openTestCall(t, s)
+
+	// Стоп-лосс прочитал колл до частичных закрытий: остаток берется в транзакции,
+	// поэтому полное закрытие не падает и не оставляет открытую часть
+	errs := hammer(workers, func(i int) error {
+		if i == 0 {
+			return s.CloseCall(call.ID, 1, 90, CloseRemaining, FillSourceStopLoss)
+		}
+		return s.CloseCall(call.ID, 1, 110, 100.0/workers, FillSourceManual)
+	})
+	if errs[0] != nil {
+		t.Fatalf("закрытие остатка: %v", errs[0])
+	}
+
+	closed := checkLedger(t, s, call.ID, succeeded(errs))
+	if closed.Status != "closed" {
+		t.Errorf("статус колла %q, ожидался closed", closed.Status)
+	}
+	if units, _ := fillTotals(t, s, call.ID); math.Abs(units[FillKindClose]-100) > 1e-9 {
+		t.Errorf("закрыто %.6f, ожидалось 100", units[FillKindClose])
+	}
+}
+
+func TestConcurrentAddAndPartialCloses(t *testing.T) {
+	s := newTestStorage(t)
+	call := openTestCall(t, s)
+
+	// Половина горутин добирает позицию, половина закрывает по 10% остатка
+	errs := hammer(workers, func(i int) error {
+		if i%2 == 0 {
+			_, err := s.AddToCall(call.ID, 1, 90, 5, FillSourceManual)
+			return err
+		}
+		return s.CloseCall(call.ID, 1, 120, 10, FillSourceManual)
+	})
+	if n := succeeded(errs); n != workers {
+		t.Fatalf("успешных операций %d из %d: %v", n, workers, errs)
+	}
+
+	open := checkLedger(t, s, call.ID, workers)
+	if open.Status != "open" {
+		t.Errorf("статус колла %q, ожидался open", open.Status)
+	}
+	if math.Abs(open.DepositPercent-(10+5*workers/2)) > 1e-9 {
+		t.Errorf("доля депозита %.6f, ожидалось %d", open.DepositPercent, 10+5*workers/2)
+	}
+
+	_, count := fillTotals(t, s, call.ID)
+	if count[FillKindAdd] != workers/2 || count[FillKindClose] != workers/2 {
+		t.Errorf("доборов %d и закрытий %d, ожидалось по %d", count[FillKindAdd], count[FillKindClose], workers/2)
+	}
+	_, deposit, err := s.GetUserDeposit(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, last := closeSnapshots(t, s); n != workers/2 || math.Abs(last-deposit) > 1e-9 {
+		t.Errorf("снимков закрытия %d с депозитом %.9f, ожидалось %d с %.9f", n, last, workers/2, deposit)
+	}
+}
+
+func TestConcurrentCloseAndAdd(t *testing.T) {
+	s := newTestStorage(t)
+	call := openTestCall(t, s)
+
+	// Добор после полного закрытия отклоняется, а не меняет закрытый колл
+	errs := hammer(workers, func(i int) error {
+		if i%2 == 0 {
+			_, err := s.AddToCall(call.ID, 1, 90, 5, FillSourceManual)
+			return err
+		}
+		return s.CloseCall(call.ID, 1, 110, 100, FillSourceManual)
+	})
+
+	var adds, closes int
+	for i, err := range errs {
+		if err != nil {
+			continue
+		}
+		if i%2 == 0 {
+			adds++
+		} else {
+			closes++
+		}
+	}
+	if closes != 1 {
+		t.Fatalf("успешных полных закрытий %d, ожидалось 1: %v", closes, errs)
+	}
+
+	closed := checkLedger(t, s, call.ID, adds+closes)
+	if closed.Status != "closed" {
+		t.Errorf("статус колла %q, ожидался closed", closed.Status)
+	}
+	if _, count := fillTotals(t, s, call.ID); count[FillKindAdd] != adds {
+		t.Errorf("исполнений добора %d, успешных доборов %d", count[FillKindAdd], adds)
+	}
+}
+
+func TestWithTxRetriesConcurrentUpdate(t *testing.T) {
+	s := newTestStorage(t)
+	call := openTestCall(t, s)
+
+	// Между чтением версии и записью колл меняет "другая операция": первая попытка получает
+	// ErrConcurrentUpdate и откатывается, вторая читает заново и проходит
+	attempts := 0
+	err := s.withTx(func(tx *sql.Tx) error {
+		attempts++
+		var version int64
+		if err := tx.QueryRow(`SELECT COALESCE(version, 0) FROM calls WHERE id = ?`, call.ID).Scan(&version); err != nil {
+			return err
+		}
+		if attempts == 1 {
+			if _, err := tx.Exec(`UPDATE calls SET version = version + 1 WHERE id = ?`, call.ID); err != nil {
+				return err
+			}
+		}
+		result, err := tx.Exec(`UPDATE calls SET stop_loss_price = 95, version = COALESCE(version, 0) + 1
+			WHERE id = ? AND COALESCE(version, 0) = ?`, call.ID, version)
+		if err != nil {
+			return err
+		}
+		return checkVersion(result)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Errorf("попыток %d, ожидалось 2", attempts)
+	}
+
+	// Откат первой попытки не оставил ее изменение версии
+	updated, err := s.GetCallByID(call.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Version != 1 || updated.StopLossPrice != 95 {
+		t.Errorf("версия %d и стоп %.2f, ожидалось 1 и 95", updated.Version, updated.StopLossPrice)
+	}
+}
+
+func TestWithTxGivesUpAfterAttempts(t *testing.T) {
+	s := newTestStorage(t)
+
+	attempts := 0
+	err := s.withTx(func(*sql.Tx) error {
+		attempts++
+		return ErrConcurrentUpdate
+	})
+	if !errors.Is(err, ErrConcurrentUpdate) {
+		t.Errorf("ошибка %v, ожидалась ErrConcurrentUpdate", err)
+	}
+	if attempts != txAttempts {
+		t.Errorf("попыток %d, ожидалось %d", attempts, txAttempts)
+	}
+}
+
+func TestStaleDepositVersionRejected(t *testing.T) {
+	s := newTestStorage(t)
+
+	_, _, version, err := getUserDeposit(s.db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateUserDeposit(1, 150); err != nil {
+		t.Fatal(err)
+	}
+	if err := setUserDeposit(s.db, 1, 50, version); !errors.Is(err, ErrConcurrentUpdate) {
+		t.Fatalf("запись по устаревшей версии: %v, ожидалась ErrConcurrentUpdate", err)
+	}
+	if _, deposit, _ := s.GetUserDeposit(1); deposit != 150 {
+		t.Errorf("депозит %.2f, ожидалось 150", deposit)
+	}
+}
+
+func TestConcurrentOcoFillsOneLeg(t *testing.T) {
+	s := newTestStorage(t)
+	call := openTestCall(t, s)
+
+	// Тейк и стоп на закрытие всего колла в одной OCO-группе
+	var legs []LimitOrder
+	for _, price := range []float64{120, 90} {
+		order, err := s.CreateLimitOrder(LimitOrder{UserID: 1, ChatID: 1, Symbol: call.Symbol, Direction: "short",
+			LimitPrice: price, RelatedCallID: call.ID, SizeToClose: 100, OcoGroup: "g1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		legs = append(legs, order)
+	}
+
+	cancelled := make([][]string, workers)
+	errs := hammer(workers, func(i int) error {
+		leg := legs[i%2]
+		ids, err := s.CloseCallByOrder(leg, leg.LimitPrice)
+		cancelled[i] = ids
+		return err
+	})
+
+	filled := -1
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			if filled >= 0 {
+				t.Fatalf("исполнились обе ноги OCO: %v", errs)
+			}
+			filled = i
+		case !errors.Is(err, ErrOrderNotActive):
+			t.Errorf("горутина %d: %v, ожидалась ErrOrderNotActive", i, err)
+		}
+	}
+	if filled < 0 {
+		t.Fatalf("ни одна нога OCO не исполнилась: %v", errs)
+	}
+
+	winner, loser := legs[filled%2], legs[1-filled%2]
+	if len(cancelled[filled]) != 1 || cancelled[filled][0] != loser.ID {
+		t.Errorf("отменены %v, ожидалась вторая нога %s", cancelled[filled], loser.ID)
+	}
+	for _, leg := range []struct {
+		id     string
+		status string
+	}{{winner.ID, "triggered"}, {loser.ID, "cancelled"}} {
+		order, err := s.GetLimitOrderByID(leg.id, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.Status != leg.status {
+			t.Errorf("ордер %s в статусе %q, ожидался %q", leg.id, order.Status, leg.status)
+		}
+	}
+
+	closed := checkLedger(t, s, call.ID, 1)
+	if closed.Status != "closed" || closed.ExitPrice != winner.LimitPrice {
+		t.Errorf("колл %q по %.2f, ожидалось закрытие по %.2f", closed.Status, closed.ExitPrice, winner.LimitPrice)
+	}
+}
+
+func TestConcurrentTakeProfitFillsOnce(t *testing.T) {
+	s := newTestStorage(t)
+	call := openTestCall(t, s)
+	legs, err := s.AddTakeProfits(call.ID, []TakeProfit{{Price: 110, Percent: 40}, {Price: 120, Percent: 60}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Размер больше остатка отклоняется закрытием, нога должна остаться активной
+	if err := s.CloseCallByTakeProfit(legs[0], 1, 110, 150); err == nil {
+		t.Fatal("закрытие на 150% должно быть отклонено")
+	}
+	if active := s.GetActiveTakeProfits(call.ID); len(active) != 2 {
+		t.Fatalf("после отклоненного закрытия активных ног %d, ожидалось 2", len(active))
+	}
+
+	errs := hammer(workers, func(int) error {
+		return s.CloseCallByTakeProfit(legs[0], 1, 110, legs[0].Percent)
+	})
+	if n := succeeded(errs); n != 1 {
+		t.Fatalf("нога исполнена %d раз: %v", n, errs)
+	}
+	for i, err := range errs {
+		if err != nil && !errors.Is(err, ErrTakeProfitNotActive) {
+			t.Errorf("горутина %d: %v, ожидалась ErrTakeProfitNotActive", i, err)
+		}
+	}
+
+	partial := checkLedger(t, s, call.ID, 1)
+	if partial.Status != "open" || math.Abs(partial.Size-60) > 1e-9 {
+		t.Errorf("колл %q с остатком %.2f, ожидался открытый с 60", partial.Status, partial.Size)
+	}
+	if active := s.GetActiveTakeProfits(call.ID); len(active) != 1 || active[0].ID != legs[1].ID {
+		t.Errorf("активные ноги %+v, ожидалась только вторая", active)
+	}
+}
+
+func TestConcurrentOpensRespectRiskRules(t *testing.T) {
+	s := newTestStorage(t)
+	if err := s.SetRiskRules(RiskScopeUser, 1, RiskRules{MaxOpenCalls: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Каждое открытие по отдельности проходит лимит, вместе они не должны его превысить
+	errs := hammer(workers, func(i int) error {
+		call := Call{UserID: 1, ChatID: 1, Symbol: "BTCUSDT", Direction: "long", EntryPrice: 100, DepositPercent: 1}
+		if i%2 == 0 {
+			_, err := s.OpenCallChecked(call, FillSourceManual)
+			return err
+		}
+		order, err := s.CreateLimitOrder(LimitOrder{UserID: 1, ChatID: 1, Symbol: "BTCUSDT", Direction: "long",
+			LimitPrice: 100, DepositPercent: 1})
+		if err != nil {
+			return err
+		}
+		_, _, err = s.OpenCallByOrder(order, call)
+		return err
+	})
+
+	if n := succeeded(errs); n != 3 {
+		t.Errorf("открыто %d коллов при лимите 3", n)
+	}
+	for i, err := range errs {
+		var violation *RiskViolation
+		if err != nil && !errors.As(err, &violation) {
+			t.Errorf("горутина %d: %v, ожидалось нарушение риск-правил", i, err)
+		}
+	}
+	if open := s.GetUserCalls(1, true); len(open) != 3 {
+		t.Errorf("открытых коллов %d, ожидалось 3", len(open))
+	}
+	// Отклоненный ордер остается активным: его отменяет движок вместе с уведомлением
+	rejected := 0
+	for i := 1; i < workers; i += 2 {
+		if errs[i] != nil {
+			rejected++
+		}
+	}
+	if active := len(s.GetLimitOrdersBySymbol("BTCUSDT")); active != rejected {
+		t.Errorf("активных ордеров %d, отклонено исполнений %d", active, rejected)
+	}
+}
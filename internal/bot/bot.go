@@ -4,11 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"math"
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -16,6 +14,7 @@ import (
 
 	"example.com/alert-bot/internal/alerts"
 	"example.com/alert-bot/internal/config"
+	"example.com/alert-bot/internal/engine"
 	"example.com/alert-bot/internal/levels"
 	"example.com/alert-bot/internal/prices"
 	"example.com/alert-bot/internal/reminder"
@@ -23,19 +22,16 @@ import (
 
 // TelegramBot инкапсулирует работу с Telegram API.
 type TelegramBot struct {
-	api           *tgbotapi.BotAPI
-	cfg           config.Config
-	st            *alerts.DatabaseStorage
-	monitorCtx    context.Context
-	stopMon       context.CancelFunc
-	pricesClients *prices.ExchangeClients // Добавлено поле для клиентов бирж
-	scheduler     *reminder.Scheduler
-	// Для отслеживания резких изменений цен
-	sharpChangeMu        sync.Mutex
-	lastSharpChangeAlert map[string]struct {
-		Time  time.Time
-		Price float64
-	} // Время и цена последнего алерта о резком изменении для каждого символа
+	api        *tgbotapi.BotAPI
+	cfg        config.Config
+	st         *alerts.DatabaseStorage
+	monitorCtx context.Context
+	stopMon    context.CancelFunc
+	monitor    *prices.PriceMonitor // Текущий монитор цен (для метрик опроса)
+	exchanges  *prices.Registry     // Реестр адаптеров бирж
+	quotes     *prices.QuoteCache   // Общий кеш котировок: заполняет монитор, читают команды
+	scheduler  *reminder.Scheduler
+	engine     *engine.Engine // Проверка алертов, стоп-лоссов и лимитных ордеров
 }
 
 // NewTelegramBot создает экземпляр бота.
@@ -52,23 +48,26 @@ func NewTelegramBot(cfg config.Config) (*TelegramBot, error) {
 		return nil, fmt.Errorf("database storage init: %w", err)
 	}
 
-	pricesClients := prices.NewExchangeClients(cfg)
+	exchanges := prices.NewDefaultRegistry(cfg)
 
 	bot := &TelegramBot{
-		api:           api,
-		cfg:           cfg,
-		st:            st,
-		pricesClients: pricesClients,
-		lastSharpChangeAlert: make(map[string]struct {
-			Time  time.Time
-			Price float64
-		}),
+		api:       api,
+		cfg:       cfg,
+		st:        st,
+		exchanges: exchanges,
+		quotes:    prices.NewQuoteCache(time.Duration(cfg.PriceCacheTTLSec) * time.Second),
 		// ⬇️ scheduler создаём ПОСЛЕ объявления bot, но до return
 		scheduler: nil, // временно, сразу ниже заполним
 	}
 
 	// ⬇️ теперь у нас ЕСТЬ переменная bot и доступ к st.DB()
 	bot.scheduler = reminder.NewScheduler(st.DB(), api)
+	bot.engine = engine.New(st, enginePrices{bot}, bot, cfg.SharpChangePercent,
+		time.Duration(cfg.SharpChangeIntervalMin)*time.Minute)
+	market := newEngineMarket(bot)
+	bot.engine.Market = market
+	bot.engine.Candles = market
+	bot.engine.Fees = market
 
 	return bot, nil
 }
@@ -87,6 +86,7 @@ func (b *TelegramBot) Start(ctx context.Context) error {
 	// Запуск мониторинга цен для алертов
 	b.startMonitoring(ctx)
 	go b.scheduler.Start(ctx)
+	go b.runEquitySnapshots(ctx)
 	for {
 		select {
 		case <-ctx.Done():
@@ -106,6 +106,10 @@ func (b *TelegramBot) Start(ctx context.Context) error {
 }
 
 func (b *TelegramBot) handleUpdate(ctx context.Context, upd tgbotapi.Update) {
+	if upd.CallbackQuery != nil {
+		b.handleCallback(ctx, upd.CallbackQuery)
+		return
+	}
 	if upd.Message == nil {
 		return
 	}
@@ -121,6 +125,8 @@ func (b *TelegramBot) handleUpdate(ctx context.Context, upd tgbotapi.Update) {
 	switch {
 	case text == "/chatid":
 		b.reply(chatID, fmt.Sprintf("Chat ID: %d\nUser ID: %d\nUsername: %s", chatID, userID, username))
+	case strings.HasPrefix(text, "/addcall"):
+		b.cmdAddToCall(ctx, chatID, userID, text)
 	case strings.HasPrefix(text, "/add"):
 		b.cmdAddAlert(ctx, chatID, userID, username, text)
 	case text == "/alerts":
@@ -139,16 +145,24 @@ func (b *TelegramBot) handleUpdate(ctx context.Context, upd tgbotapi.Update) {
 		b.cmdCloseCall(ctx, chatID, userID, text)
 	case strings.HasPrefix(text, "/sl"):
 		b.cmdSetStopLoss(ctx, chatID, userID, text)
+	case strings.HasPrefix(text, "/rule"):
+		b.cmdCallRule(ctx, chatID, userID, text)
+	case strings.HasPrefix(text, "/size"):
+		b.cmdPositionSize(ctx, chatID, userID, text)
+	case strings.HasPrefix(text, "/risk"):
+		b.cmdRisk(chatID, userID, text)
 	case text == "/mycalls":
 		b.cmdMyCalls(ctx, chatID, userID)
 	case text == "/allcalls":
 		b.cmdAllCalls(ctx, chatID)
-	case text == "/callstats":
-		b.cmdCallStats(chatID)
-	case text == "/mycallstats":
-		b.cmdMyCallStats(chatID, userID)
-	case text == "/mytrades":
-		b.cmdMyTrades(chatID, userID)
+	case strings.HasPrefix(text, "/callstats"):
+		b.cmdCallStats(chatID, text)
+	case strings.HasPrefix(text, "/mycallstats"):
+		b.cmdMyCallStats(chatID, userID, text)
+	case strings.HasPrefix(text, "/mytrades"):
+		b.cmdMyTrades(chatID, userID, text)
+	case text == "/equity":
+		b.cmdEquity(chatID, userID)
 	case strings.HasPrefix(text, "/history"):
 		b.cmdHistory(chatID, text)
 	case text == "/stats":
@@ -163,31 +177,43 @@ func (b *TelegramBot) handleUpdate(ctx context.Context, upd tgbotapi.Update) {
 		b.cmdCreateLimitOrder(ctx, chatID, userID, username, text)
 	case strings.HasPrefix(text, "/climit"):
 		b.cmdCancelLimitOrder(ctx, chatID, userID, text)
+	case strings.HasPrefix(text, "/modlimit"):
+		b.cmdModifyLimitOrder(chatID, userID, text)
 	case text == "/myorders":
 		b.cmdMyOrders(ctx, chatID, userID)
 	case text == "/start":
 		b.reply(chatID, "*Way2Million, by Saint\\_Dmitriy*\n\n*Команды:*\n"+
 			"/start - список всех команд бота\n"+
 			"/chatid - показать Chat ID, User ID и Username\n"+
-			"/add TICKER price|pct VALUE - создать алерт\n"+
+			"/add TICKER price|pct VALUE [repeat] [rearm=N%] [cooldown=4h] - создать алерт (repeat — повторяющийся)\n"+
+			"/add if УСЛОВИЕ [repeat] [cooldown=4h] - составной алерт, например: /add if BTC < 60000 AND ETH/BTC > 0.05\n"+
+			"/add TICKER rsi|ema|sma|macd|bb|atr TF ... - алерт по индикатору на закрытии свечи, например: /add BTC rsi 1h < 30, /add ETH ema 4h 50x200\n"+
 			"/alerts - показать все активные алерты пользователя\n"+
 			"/del ID - удалить алерт по ID\n"+
 			"/clearallalerts - удалить все алерты\n"+
 			"/p TICKER - показать цену одного символа с изменениями\n"+
 			"/allp - показать цены всех токенов из алертов и коллов\n"+
 			"/chart TICKER [tf] - построить график с уровнями поддержки и сопротивления\n"+
-			"/ocall TICKER [long|short] [size] sl [sl PRICE] - открыть колл\n"+
+			"/ocall TICKER [long|short] [size] [xN] [sl PRICE] [tp PRICE:PCT ...] - открыть колл с плечом, стоп-лоссом и лестницей тейк-профитов\n"+
+			"/addcall CALLID [deposit_percent] - добрать позицию по текущей цене (по умолчанию — как при открытии)\n"+
 			"/ccall CALLID [size] - закрыть колл по ID\n"+
 			"/sl CALLID [price] - установить/обновить стоп-лосс для колла\n"+
+			"/sl CALLID trail 3%|DIST [after 5%] - трейлинг-стоп: подтягивается за лучшей ценой (после порога прибыли)\n"+
+			"/rule CALLID be 2%|time 24h|swing 1h|off [be|time|swing] - правила колла: стоп в безубыток, закрытие по времени, стоп за swing low/high\n"+
+			"/size TICKER long|short RISK% sl PRICE [entry PRICE] [xN] - рассчитать размер позиции по риску и стопу\n"+
+			"/risk [chat] set|off KEY VALUE - риск-правила: экспозиция, лимит на символ, число коллов, обязательный стоп, убыток по стопу\n"+
 			"/limit TICKER [b|s] PRICE % [CALLID](Опционально) - создать лимитный ордер\n"+
+			"/limit TICKER [b|s] stop PRICE [limit PRICE] % [CALLID] [oco ORDERID] - стоп- и стоп-лимит ордер, OCO-связка с другим ордером\n"+
 			"/climit ORDERID - отменить лимитный ордер\n"+
+			"/modlimit ORDERID price|stop|size|gtd VALUE - изменить активный ордер (без значения — журнал изменений)\n"+
 			"/myorders - показать активные лимитные ордера\n"+
 			"/mycalls - показать активные коллы с текущим PnL\n"+
 			"/allcalls - показать все коллы всех пользователей\n"+
 			"/rush - закрыть все открытые коллы пользователя\n"+
-			"/callstats - рейтинг трейдеров за 90 дней\n"+
-			"/mycallstats - персональная статистика коллов за 90 дней\n"+
-			"/mytrades - статистика по символам за 90 дней\n"+
+			"/callstats [7d|30d|ytd|all|FROM..TO] [TICKER] - рейтинг трейдеров (по умолчанию за 90 дней)\n"+
+			"/mycallstats [окно] [TICKER] - персональная статистика коллов: PF, ожидание, удержание, R, серии\n"+
+			"/mytrades [окно] - статистика по символам\n"+
+			"/equity - кривая капитала: просадка, Sharpe и Sortino\n"+
 			"/history - история сработавших алертов\n"+
 			"/stats - статистика по активным алертам")
 	default:
@@ -250,12 +276,75 @@ func parseDuration(s string) (time.Duration, error) {
 }
 
 // cmdAddAlert обрабатывает команду /add TICKER [price|pct] VALUE
+// или /add if УСЛОВИЕ для составного алерта
 func (b *TelegramBot) cmdAddAlert(ctx context.Context, chatID int64, userID int64, username string, text string) {
-	parts := strings.Fields(text)
+	// Опции повторяющегося алерта: repeat, rearm=N%, cooldown=DURATION
+	var parts []string
+	var recurring bool
+	var rearmPercent float64
+	var cooldown time.Duration
+	for _, field := range strings.Fields(text) {
+		lower := strings.ToLower(field)
+		switch {
+		case lower == "repeat" || lower == "recurring":
+			recurring = true
+		case strings.HasPrefix(lower, "rearm="):
+			v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimPrefix(lower, "rearm="), "%"), 64)
+			if err != nil || v <= 0 {
+				b.reply(chatID, "Неверное расстояние взвода: "+field)
+				return
+			}
+			recurring = true
+			rearmPercent = v
+		case strings.HasPrefix(lower, "cooldown="):
+			d, err := time.ParseDuration(strings.TrimPrefix(lower, "cooldown="))
+			if err != nil || d <= 0 {
+				b.reply(chatID, "Неверная пауза (пример: cooldown=30m, cooldown=4h): "+field)
+				return
+			}
+			recurring = true
+			cooldown = d
+		default:
+			parts = append(parts, field)
+		}
+	}
+
+	// Составной алерт: /add if УСЛОВИЕ
+	if len(parts) >= 2 && (strings.EqualFold(parts[1], "if") || strings.EqualFold(parts[1], "when")) {
+		alert := alerts.Alert{
+			ChatID:      chatID,
+			UserID:      userID,
+			Username:    username,
+			Mode:        alerts.AlertModeOnce,
+			CooldownSec: int(cooldown.Seconds()),
+		}
+		if recurring {
+			alert.Mode = alerts.AlertModeRecurring
+		}
+		b.addExpressionAlert(ctx, chatID, alert, strings.Join(parts[2:], " "))
+		return
+	}
+
+	// Индикаторный алерт: /add TICKER rsi 1h < 30
+	if len(parts) >= 3 && engine.IsIndicator(parts[2]) {
+		alert := alerts.Alert{
+			ChatID:      chatID,
+			UserID:      userID,
+			Username:    username,
+			Symbol:      formatSymbol(parts[1]),
+			Mode:        alerts.AlertModeOnce,
+			CooldownSec: int(cooldown.Seconds()),
+		}
+		if recurring {
+			alert.Mode = alerts.AlertModeRecurring
+		}
+		b.addIndicatorAlert(ctx, chatID, alert, parts[2:])
+		return
+	}
 
 	// Теперь допускаем как 3, так и 4 части
 	if len(parts) < 3 || len(parts) > 4 {
-		b.reply(chatID, "Использование: /add TICKER [price|pct] VALUE\nПример: /add BTCUSDT price 50000\nПример: /add BTCUSDT 50000 (по умолчанию price)\nПример: /add BTCUSDT pct 5")
+		b.reply(chatID, "Использование: /add TICKER [price|pct] VALUE [repeat] [rearm=N%] [cooldown=DURATION]\nПример: /add BTCUSDT price 50000\nПример: /add BTCUSDT 50000 (по умолчанию price)\nПример: /add BTCUSDT pct 5\nПример: /add BTCUSDT 50000 repeat rearm=1% cooldown=4h (повторяющийся алерт)")
 		return
 	}
 
@@ -281,10 +370,16 @@ func (b *TelegramBot) cmdAddAlert(ctx context.Context, chatID int64, userID int6
 	}
 
 	alert := alerts.Alert{
-		ChatID:   chatID,
-		UserID:   userID,
-		Username: username,
-		Symbol:   symbol,
+		ChatID:       chatID,
+		UserID:       userID,
+		Username:     username,
+		Symbol:       symbol,
+		Mode:         alerts.AlertModeOnce,
+		RearmPercent: rearmPercent,
+		CooldownSec:  int(cooldown.Seconds()),
+	}
+	if recurring {
+		alert.Mode = alerts.AlertModeRecurring
 	}
 
 	preferredExchange, preferredMarket := b.getPreferredExchangeMarketForSymbol(symbol)
@@ -292,26 +387,28 @@ func (b *TelegramBot) cmdAddAlert(ctx context.Context, chatID int64, userID int6
 	switch alertType {
 	case "price":
 		alert.TargetPrice = value
-		priceInfo, err := prices.FetchPriceInfo(b.pricesClients, symbol, preferredExchange, preferredMarket)
+		priceInfo, err := b.quotes.CurrentPrice(b.exchanges, symbol, preferredExchange, preferredMarket)
 		if err != nil {
 			b.reply(chatID, "Ошибка получения цены для "+symbol+": "+err.Error())
 			return
 		}
 		alert.Exchange = priceInfo.Exchange
 		alert.Market = priceInfo.Market
+		// Запоминаем сторону: алерт сработает, когда цена пересечет цель с этой стороны
+		alert.Side = engine.SideFor(value, priceInfo.CurrentPrice)
 		alert, err = b.st.Add(alert)
 		if err != nil {
 			b.reply(chatID, "Ошибка создания алерта: "+err.Error())
 			return
 		}
-		b.reply(chatID, fmt.Sprintf("Алерт создан (ID: `%s`)\n%s на %s %s достигнет %s (текущая: %s)", alert.ID, symbol, alert.Exchange, alert.Market, prices.FormatPrice(value), prices.FormatPrice(priceInfo.CurrentPrice)))
+		b.reply(chatID, fmt.Sprintf("Алерт создан (ID: `%s`)\n%s на %s %s достигнет %s (текущая: %s)", alert.ID, symbol, alert.Exchange, alert.Market, prices.FormatPrice(value), prices.FormatPrice(priceInfo.CurrentPrice))+formatRecurring(alert)+"\n"+formatAsOf(priceInfo.AsOf))
 
 		// Перезапускаем мониторинг с новым символом
 		b.restartMonitoring(ctx)
 	case "pct":
 		alert.TargetPercent = value
 		// Получаем текущую цену для базовой
-		priceInfo, err := prices.FetchPriceInfo(b.pricesClients, symbol, preferredExchange, preferredMarket)
+		priceInfo, err := b.quotes.CurrentPrice(b.exchanges, symbol, preferredExchange, preferredMarket)
 		if err != nil {
 			b.reply(chatID, "Ошибка получения цены для "+symbol+": "+err.Error())
 			return
@@ -324,7 +421,7 @@ func (b *TelegramBot) cmdAddAlert(ctx context.Context, chatID int64, userID int6
 			b.reply(chatID, "Ошибка создания алерта: "+err.Error())
 			return
 		}
-		b.reply(chatID, fmt.Sprintf("Алерт создан (ID: `%s`)\n%s на %s %s изменится на %.2f%% от %s (текущая: %s)", alert.ID, symbol, alert.Exchange, alert.Market, value, prices.FormatPrice(priceInfo.CurrentPrice), prices.FormatPrice(priceInfo.CurrentPrice)))
+		b.reply(chatID, fmt.Sprintf("Алерт создан (ID: `%s`)\n%s на %s %s изменится на %.2f%% от %s (текущая: %s)", alert.ID, symbol, alert.Exchange, alert.Market, value, prices.FormatPrice(priceInfo.CurrentPrice), prices.FormatPrice(priceInfo.CurrentPrice))+formatRecurring(alert)+"\n"+formatAsOf(priceInfo.AsOf))
 
 		// Перезапускаем мониторинг с новым символом
 		b.restartMonitoring(ctx)
@@ -333,11 +430,150 @@ func (b *TelegramBot) cmdAddAlert(ctx context.Context, chatID int64, userID int6
 	}
 }
 
-// cmdOpenCall обрабатывает команду /ocall TICKER [long|short]
+// addExpressionAlert создает составной алерт из условия вроде "BTC < 60000 AND ETH/BTC > 0.05"
+func (b *TelegramBot) addExpressionAlert(ctx context.Context, chatID int64, alert alerts.Alert, condition string) {
+	if strings.TrimSpace(condition) == "" {
+		b.reply(chatID, "Использование: /add if УСЛОВИЕ [repeat] [cooldown=DURATION]\n"+
+			"Пример: /add if BTC < 60000 AND ETH/BTC > 0.05\n"+
+			"Пример: /add if change(SOL, 1h) > 4% OR volume(SOL) > 500M\n"+
+			"Операнды: TICKER (цена), A/B (отношение цен), change(TICKER, 15m|1h|4h|24h) (изменение в %), volume(TICKER) (оборот за 24ч в USDT)\n"+
+			"Операторы: < <= > >=, AND, OR, скобки")
+		return
+	}
+
+	expr, err := engine.ParseExpr(condition, formatSymbol)
+	if err != nil {
+		b.reply(chatID, "Ошибка в условии: "+err.Error())
+		return
+	}
+
+	// Проверяем, что все величины условия сейчас доступны
+	holds, err := expr.Eval(b.engine.Market)
+	if err != nil {
+		b.reply(chatID, "Не удалось вычислить условие: "+err.Error())
+		return
+	}
+
+	symbols := expr.Symbols()
+	alert.Kind = alerts.AlertKindExpression
+	alert.Expression = expr.String()
+	alert.Symbol = symbols[0]
+	alert.Symbols = symbols
+
+	alert, err = b.st.Add(alert)
+	if err != nil {
+		b.reply(chatID, "Ошибка создания алерта: "+err.Error())
+		return
+	}
+
+	state := "сейчас не выполняется"
+	if holds {
+		state = "уже выполняется — алерт сработает на ближайшем тике"
+	}
+	b.reply(chatID, fmt.Sprintf("Составной алерт создан (ID: `%s`)\nУсловие: %s\nСимволы: %s\nУсловие %s",
+		alert.ID, alert.Expression, strings.Join(symbols, ", "), state)+formatRecurring(alert))
+
+	b.restartMonitoring(ctx)
+}
+
+// addIndicatorAlert создает алерт по индикатору, который проверяется на закрытии каждой свечи
+func (b *TelegramBot) addIndicatorAlert(ctx context.Context, chatID int64, alert alerts.Alert, fields []string) {
+	spec, err := engine.ParseIndicatorSpec(fields)
+	if err != nil {
+		b.reply(chatID, "Ошибка в условии индикатора: "+err.Error()+"\n"+
+			"Примеры:\n"+
+			"/add BTC rsi 1h < 30 (RSI(14); rsi:21 — другой период)\n"+
+			"/add ETH ema 4h 50x200 (пересечение EMA50 и EMA200; sma — простые средние)\n"+
+			"/add ETH ema 1d 200 (цена пересекла EMA200)\n"+
+			"/add SOL macd 4h (MACD пересек сигнальную; macd:12:26:9)\n"+
+			"/add BTC bb 1h (закрытие за полосами Боллинджера; bb:20:2)\n"+
+			"/add BTC atr 1d > 2000 (ATR(14))")
+		return
+	}
+
+	// Считаем индикатор сразу: проверяем символ и показываем текущее значение
+	now := time.Now()
+	candles, err := b.engine.Candles.Candles(alert.Symbol, spec.Timeframe, spec.CandlesNeeded()+1)
+	if err != nil {
+		b.reply(chatID, "Ошибка получения свечей для "+alert.Symbol+": "+err.Error())
+		return
+	}
+	signal, err := spec.Evaluate(engine.ClosedCandles(candles, spec.Timeframe, now))
+	if err != nil {
+		b.reply(chatID, "Не удалось рассчитать индикатор: "+err.Error())
+		return
+	}
+
+	// Первая проверка — на закрытии следующей свечи
+	lastClose, _ := engine.LastCandleClose(spec.Timeframe, now)
+	alert.Kind = alerts.AlertKindIndicator
+	alert.Expression = spec.String()
+	alert.LastCandleAt = &lastClose
+
+	alert, err = b.st.Add(alert)
+	if err != nil {
+		b.reply(chatID, "Ошибка создания алерта: "+err.Error())
+		return
+	}
+
+	b.reply(chatID, fmt.Sprintf("Индикаторный алерт создан (ID: `%s`)\n%s: %s\nПроверяется на закрытии каждой свечи %s\nСейчас: %s",
+		alert.ID, alert.Symbol, alert.Expression, spec.Timeframe, signal.Detail)+formatRecurring(alert))
+
+	b.restartMonitoring(ctx)
+}
+
+// parseTakeProfitLegs разбирает ноги тейк-профита вида PRICE:PCT. Ноги без доли делят
+// поровну то, что осталось от 100% после ног с явной долей.
+func parseTakeProfitLegs(args []string) ([]alerts.TakeProfit, error) {
+	var legs []alerts.TakeProfit
+	var explicit float64
+	var implicit int
+	for _, arg := range args {
+		priceStr, pctStr, hasPct := strings.Cut(arg, ":")
+		price, err := strconv.ParseFloat(priceStr, 64)
+		if err != nil || price <= 0 {
+			return nil, fmt.Errorf("неверная цена тейк-профита %q", arg)
+		}
+		leg := alerts.TakeProfit{Price: price}
+		if hasPct {
+			pct, err := strconv.ParseFloat(strings.TrimSuffix(pctStr, "%"), 64)
+			if err != nil || pct <= 0 {
+				return nil, fmt.Errorf("неверная доля тейк-профита %q", arg)
+			}
+			leg.Percent = pct
+			explicit += pct
+		} else {
+			implicit++
+		}
+		legs = append(legs, leg)
+	}
+	if len(legs) == 0 {
+		return nil, errors.New("укажите цены тейк-профита после 'tp'")
+	}
+	if implicit > 0 {
+		share := (100 - explicit) / float64(implicit)
+		if share <= 0 {
+			return nil, errors.New("не осталось доли позиции для тейк-профитов без процента")
+		}
+		for i := range legs {
+			if legs[i].Percent == 0 {
+				legs[i].Percent = share
+			}
+		}
+	}
+	return legs, nil
+}
+
+// cmdOpenCall обрабатывает команду /ocall TICKER [long|short] [deposit_percent] [xLEVERAGE] [sl PRICE] [tp PRICE:PCT ...]
 func (b *TelegramBot) cmdOpenCall(ctx context.Context, chatID int64, userID int64, username string, text string) {
+	const usage = "Использование: /ocall TICKER [long|short] [deposit_percent] [xLEVERAGE] [sl PRICE] [tp PRICE:PCT ...]\n" +
+		"Пример: /ocall BTC long 40 sl 25000 (открыть лонг по BTC с 40% депозита и стоп-лоссом 25000)\n" +
+		"Пример: /ocall BTC long 10 x5 sl 58000 (10% депозита с плечом x5, только фьючерсы)\n" +
+		"Пример: /ocall BTC long 20 sl 58000 tp 65000:30 68000:30 72000:40 (с лестницей тейк-профитов)\n" +
+		"Пример: /ocall ETH short"
 	parts := strings.Fields(text)
-	if len(parts) < 2 || len(parts) > 6 { // Добавляем возможность для 6 частей (ocall TICKER [long|short] [deposit_percent] [sl PRICE])
-		b.reply(chatID, "Использование: /ocall TICKER [long|short] [deposit_percent] [sl PRICE]\nПример: /ocall BTC long 40 sl 25000 (открыть лонг по BTC с 40% депозита и стоп-лоссом 25000)\nПример: /ocall ETH short")
+	if len(parts) < 2 {
+		b.reply(chatID, usage)
 		return
 	}
 
@@ -345,8 +581,10 @@ func (b *TelegramBot) cmdOpenCall(ctx context.Context, chatID int64, userID int6
 	direction := "long"  // по умолчанию
 	positionSize := 0.0  // по умолчанию 0%
 	stopLossPrice := 0.0 // по умолчанию 0 (без стоп-лосса)
+	leverage := 1.0      // по умолчанию без плеча
+	var takeProfits []alerts.TakeProfit
 
-	// Парсинг направления, процента депозита и стоп-лосса
+	// Парсинг направления, процента депозита, стоп-лосса и тейк-профитов
 	argIndex := 2
 
 	// Парсинг направления
@@ -368,6 +606,17 @@ func (b *TelegramBot) cmdOpenCall(ctx context.Context, chatID int64, userID int6
 		}
 	}
 
+	// Парсинг плеча: x5
+	if len(parts) > argIndex && strings.HasPrefix(strings.ToLower(parts[argIndex]), "x") {
+		levVal, err := strconv.ParseFloat(parts[argIndex][1:], 64)
+		if err != nil {
+			b.reply(chatID, "Неверное значение плеча. Используйте x и число, например x5.")
+			return
+		}
+		leverage = levVal
+		argIndex++
+	}
+
 	// Парсинг стоп-лосса
 	if len(parts) > argIndex && strings.ToLower(parts[argIndex]) == "sl" {
 		argIndex++
@@ -375,6 +624,7 @@ func (b *TelegramBot) cmdOpenCall(ctx context.Context, chatID int64, userID int6
 			slVal, err := strconv.ParseFloat(parts[argIndex], 64)
 			if err == nil && slVal >= 0 {
 				stopLossPrice = slVal
+				argIndex++
 			} else {
 				b.reply(chatID, "Неверное значение стоп-лосса. Используйте число >= 0.")
 				return
@@ -385,14 +635,35 @@ func (b *TelegramBot) cmdOpenCall(ctx context.Context, chatID int64, userID int6
 		}
 	}
 
+	// Парсинг лестницы тейк-профитов: все оставшиеся аргументы
+	if len(parts) > argIndex && strings.ToLower(parts[argIndex]) == "tp" {
+		legs, err := parseTakeProfitLegs(parts[argIndex+1:])
+		if err != nil {
+			b.reply(chatID, "Ошибка тейк-профита: "+err.Error())
+			return
+		}
+		takeProfits = legs
+		argIndex = len(parts)
+	}
+
+	if len(parts) > argIndex {
+		b.reply(chatID, usage)
+		return
+	}
+
 	// Получаем текущую цену
 	preferredExchange, preferredMarket := b.getPreferredExchangeMarketForSymbol(symbol)
-	priceInfo, err := prices.FetchPriceInfo(b.pricesClients, symbol, preferredExchange, preferredMarket)
+	priceInfo, err := b.quotes.CurrentPrice(b.exchanges, symbol, preferredExchange, preferredMarket)
 	if err != nil {
 		b.reply(chatID, "Ошибка получения цены для "+symbol+": "+err.Error())
 		return
 	}
 
+	if err := alerts.ValidateLeverage(leverage, priceInfo.Market); err != nil {
+		b.reply(chatID, "Ошибка плеча: "+err.Error())
+		return
+	}
+
 	// Создаем колл
 	call := alerts.Call{
 		UserID:         userID,
@@ -405,14 +676,37 @@ func (b *TelegramBot) cmdOpenCall(ctx context.Context, chatID int64, userID int6
 		DepositPercent: positionSize,  // Сохраняем процент от депозита
 		StopLossPrice:  stopLossPrice, // Сохраняем цену стоп-лосса
 		Exchange:       priceInfo.Exchange,
+		Leverage:       leverage,
+		TakerFee:       prices.TakerFeePercent(priceInfo.Exchange, priceInfo.Market),
+	}
+
+	if len(takeProfits) > 0 {
+		if err := alerts.ValidateTakeProfits(direction, call.EntryPrice, takeProfits); err != nil {
+			b.reply(chatID, "Ошибка тейк-профита: "+err.Error())
+			return
+		}
 	}
 
-	call, err = b.st.OpenCall(call)
+	// Риск-правила проверяются в транзакции открытия
+	call, err = b.st.OpenCallChecked(call, alerts.FillSourceManual)
+	var violation *alerts.RiskViolation
+	if errors.As(err, &violation) {
+		b.reply(chatID, "⛔ Колл отклонен риск-правилами: "+err.Error())
+		return
+	}
 	if err != nil {
 		b.reply(chatID, "Ошибка создания колла: "+err.Error())
 		return
 	}
 
+	if len(takeProfits) > 0 {
+		takeProfits, err = b.st.AddTakeProfits(call.ID, takeProfits)
+		if err != nil {
+			logrus.WithError(err).WithField("call_id", call.ID).Error("failed to save take-profits")
+			b.reply(chatID, fmt.Sprintf("Колл `%s` открыт, но тейк-профиты не сохранены: %s", call.ID, err.Error()))
+		}
+	}
+
 	directionRus := "Long"
 	if direction == "short" {
 		directionRus = "Short"
@@ -424,20 +718,100 @@ func (b *TelegramBot) cmdOpenCall(ctx context.Context, chatID int64, userID int6
 	if call.DepositPercent > 0 {
 		msg += fmt.Sprintf("\nПроцент от депозита: %.0f%%", call.DepositPercent)
 	}
+	if call.Leverage > 1 {
+		msg += fmt.Sprintf("\nПлечо: x%g, ликвидация: %s", call.Leverage, prices.FormatPrice(call.LiquidationPrice()))
+	}
+	if call.TakerFee > 0 {
+		msg += fmt.Sprintf("\nКомиссия тейкера: %.3g%% за сторону", call.TakerFee)
+	}
 
 	if call.StopLossPrice > 0 {
 		msg += fmt.Sprintf("\nСтоп-лосс: %s", prices.FormatPrice(call.StopLossPrice))
 	}
+	for i, tp := range takeProfits {
+		msg += fmt.Sprintf("\nTP%d: %s (%.0f%%)", i+1, prices.FormatPrice(tp.Price), tp.Percent)
+	}
 	msg += fmt.Sprintf("\nБиржа: %s, Рынок: %s", call.Exchange, call.Market)
+	msg += "\n" + formatAsOf(priceInfo.AsOf)
 
 	b.reply(chatID, msg)
 }
 
-// cmdSetStopLoss обрабатывает команду /sl CALLID [price]
+// cmdAddToCall обрабатывает команду /addcall CALLID [deposit_percent]
+func (b *TelegramBot) cmdAddToCall(ctx context.Context, chatID int64, userID int64, text string) {
+	parts := strings.Fields(text)
+	if len(parts) < 2 || len(parts) > 3 {
+		b.reply(chatID, "Использование: /addcall CALLID [deposit_percent]\nПример: /addcall `abc123de` 20 (добрать 20% депозита)\nПример: /addcall `abc123de` (добрать столько же, сколько открыто)")
+		return
+	}
+
+	callID := parts[1]
+	var depositPercent float64
+	if len(parts) == 3 {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(parts[2], "%"), 64)
+		if err != nil || v <= 0 {
+			b.reply(chatID, "Неверный процент депозита. Используйте число > 0.")
+			return
+		}
+		depositPercent = v
+	}
+
+	call, err := b.st.GetCallByID(callID, userID)
+	if err != nil {
+		b.reply(chatID, "Колл не найден или не принадлежит вам")
+		return
+	}
+	if call.Status != "open" {
+		b.reply(chatID, "Колл уже закрыт")
+		return
+	}
+
+	priceInfo, err := b.quotes.CurrentPrice(b.exchanges, call.Symbol, call.Exchange, call.Market)
+	if err != nil {
+		b.reply(chatID, fmt.Sprintf("Ошибка получения цены для %s: %s", call.Symbol, err.Error()))
+		return
+	}
+
+	// Риск-правила проверяются для позиции после добора в транзакции добора
+	oldEntry := call.EntryPrice
+	updated, err := b.st.AddToCall(callID, userID, priceInfo.CurrentPrice, depositPercent, alerts.FillSourceManual)
+	var violation *alerts.RiskViolation
+	if errors.As(err, &violation) {
+		b.reply(chatID, "⛔ Добор отклонен риск-правилами: "+err.Error())
+		return
+	}
+	if err != nil {
+		b.reply(chatID, "Ошибка добора: "+err.Error())
+		return
+	}
+
+	directionRus := "Long"
+	if updated.Direction == "short" {
+		directionRus = "Short"
+	}
+
+	msg := fmt.Sprintf("Позиция добрана!\nID: `%s`\nСимвол: %s\nНаправление: %s\nЦена добора: %s\nСредний вход: %s → %s",
+		updated.ID, updated.Symbol, directionRus, prices.FormatPrice(priceInfo.CurrentPrice),
+		prices.FormatAvgPrice(oldEntry), prices.FormatAvgPrice(updated.EntryPrice))
+	if updated.DepositPercent > 0 {
+		msg += fmt.Sprintf("\nПроцент от депозита: %.0f%% (в позиции %.0f%%)",
+			updated.DepositPercent, updated.DepositPercent*updated.Size/100)
+	}
+	msg += "\n" + formatAsOf(priceInfo.AsOf)
+
+	b.reply(chatID, msg)
+}
+
+// cmdSetStopLoss обрабатывает команду /sl CALLID [price] и /sl CALLID trail DIST [after PCT]
 func (b *TelegramBot) cmdSetStopLoss(ctx context.Context, chatID int64, userID int64, text string) {
 	parts := strings.Fields(text)
+	if len(parts) >= 3 && strings.ToLower(parts[2]) == "trail" {
+		b.cmdSetTrailingStop(chatID, userID, parts)
+		return
+	}
 	if len(parts) < 2 || len(parts) > 3 {
-		b.reply(chatID, "Использование: /sl CALLID [price]\nПример: /sl `abc123de` 25000 (установить стоп-лосс на 25000)\nПример: /sl `abc123de` (удалить стоп-лосс или установить на 0)")
+		b.reply(chatID, "Использование: /sl CALLID [price]\nПример: /sl `abc123de` 25000 (установить стоп-лосс на 25000)\nПример: /sl `abc123de` (удалить стоп-лосс или установить на 0)\n"+
+			"Трейлинг: /sl CALLID trail 3% [after 5%] или /sl CALLID trail 1500 (отступ в цене)")
 		return
 	}
 
@@ -491,6 +865,230 @@ func (b *TelegramBot) cmdSetStopLoss(ctx context.Context, chatID int64, userID i
 	}
 }
 
+// cmdSetTrailingStop обрабатывает /sl CALLID trail DIST [after PCT]: DIST с % — отступ в процентах
+// от лучшей цены, без % — в цене; after — прибыль в %, после которой стоп начинает подтягиваться.
+func (b *TelegramBot) cmdSetTrailingStop(chatID int64, userID int64, parts []string) {
+	const usage = "Использование: /sl CALLID trail DIST [after PCT]\n" +
+		"Пример: /sl `abc123de` trail 3% (стоп в 3% от лучшей цены)\n" +
+		"Пример: /sl `abc123de` trail 1500 after 5% (стоп в 1500 от лучшей цены после +5% прибыли)"
+	if len(parts) != 4 && len(parts) != 6 {
+		b.reply(chatID, usage)
+		return
+	}
+
+	callID := parts[1]
+	var percent, distance, activation float64
+	if strings.HasSuffix(parts[3], "%") {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(parts[3], "%"), 64)
+		if err != nil || v <= 0 {
+			b.reply(chatID, "Неверный отступ трейлинг-стопа. Используйте число > 0.")
+			return
+		}
+		percent = v
+	} else {
+		v, err := strconv.ParseFloat(parts[3], 64)
+		if err != nil || v <= 0 {
+			b.reply(chatID, "Неверный отступ трейлинг-стопа. Используйте число > 0.")
+			return
+		}
+		distance = v
+	}
+
+	if len(parts) == 6 {
+		if strings.ToLower(parts[4]) != "after" {
+			b.reply(chatID, usage)
+			return
+		}
+		v, err := strconv.ParseFloat(strings.TrimSuffix(parts[5], "%"), 64)
+		if err != nil || v < 0 {
+			b.reply(chatID, "Неверный порог активации. Используйте число >= 0.")
+			return
+		}
+		activation = v
+	}
+
+	call, err := b.st.GetCallByID(callID, userID)
+	if err != nil {
+		b.reply(chatID, "Колл не найден или не принадлежит вам")
+		return
+	}
+	if call.Status != "open" {
+		b.reply(chatID, "Нельзя установить стоп-лосс для закрытого колла")
+		return
+	}
+
+	if err := b.st.SetTrailingStop(callID, userID, percent, distance, activation); err != nil {
+		b.reply(chatID, "Ошибка установки трейлинг-стопа: "+err.Error())
+		return
+	}
+	call.TrailPercent, call.TrailDistance, call.TrailActivation = percent, distance, activation
+
+	msg := fmt.Sprintf("Трейлинг-стоп для колла `%s` установлен: %s", callID, formatTrailing(*call))
+	if call.StopLossPrice > 0 {
+		msg += fmt.Sprintf("\nТекущий стоп-лосс %s сохраняется до первого подтягивания", prices.FormatPrice(call.StopLossPrice))
+	}
+	b.reply(chatID, msg)
+}
+
+// cmdCallRule обрабатывает команду /rule CALLID [be PCT | time DURATION | swing TF | off [be|time|swing]]
+func (b *TelegramBot) cmdCallRule(ctx context.Context, chatID int64, userID int64, text string) {
+	const usage = "Использование: /rule CALLID [be PCT | time DURATION | swing TF | off [be|time|swing]]\n" +
+		"Пример: /rule `abc123de` be 2% (перенести стоп в цену входа при PnL >= 2%)\n" +
+		"Пример: /rule `abc123de` time 24h (закрыть колл, если он открыт дольше 24 часов)\n" +
+		"Пример: /rule `abc123de` swing 1h (подтягивать стоп к последнему swing low/high на 1h)\n" +
+		"Пример: /rule `abc123de` off time (выключить правило времени; без аргумента — все правила)\n" +
+		"Пример: /rule `abc123de` (показать правила колла)"
+	parts := strings.Fields(text)
+	if len(parts) < 2 || len(parts) > 4 {
+		b.reply(chatID, usage)
+		return
+	}
+
+	callID := parts[1]
+	call, err := b.st.GetCallByID(callID, userID)
+	if err != nil {
+		b.reply(chatID, "Колл не найден или не принадлежит вам")
+		return
+	}
+	if call.Status != "open" {
+		b.reply(chatID, "Нельзя задать правила для закрытого колла")
+		return
+	}
+
+	if len(parts) == 2 {
+		if !call.HasRules() {
+			b.reply(chatID, fmt.Sprintf("У колла `%s` нет правил", callID))
+			return
+		}
+		b.reply(chatID, fmt.Sprintf("Правила колла `%s`: %s", callID, formatCallRules(*call)))
+		return
+	}
+
+	rule := strings.ToLower(parts[2])
+	switch rule {
+	case "be":
+		if len(parts) != 4 {
+			b.reply(chatID, usage)
+			return
+		}
+		v, err := strconv.ParseFloat(strings.TrimSuffix(parts[3], "%"), 64)
+		if err != nil || v <= 0 {
+			b.reply(chatID, "Неверный порог безубытка. Используйте число > 0.")
+			return
+		}
+		call.BreakEvenPercent = v
+		call.BreakEvenDone = false
+
+	case "time":
+		if len(parts) != 4 {
+			b.reply(chatID, usage)
+			return
+		}
+		dur, err := parseDuration(strings.ToLower(parts[3]))
+		if err != nil || dur <= 0 {
+			b.reply(chatID, "Неверная длительность (пример: 90m, 24h, 3d)")
+			return
+		}
+		closeAt := call.OpenedAt.Add(dur)
+		call.TimeStopAt = &closeAt
+
+	case "swing":
+		if len(parts) != 4 {
+			b.reply(chatID, usage)
+			return
+		}
+		tf := strings.ToLower(parts[3])
+		if _, err := levels.TimeframeDuration(tf); err != nil {
+			b.reply(chatID, "Неверный таймфрейм (пример: 15m, 1h, 4h, 1d)")
+			return
+		}
+		call.SwingTimeframe = tf
+		call.SwingCheckedAt = nil
+
+	case "off":
+		what := "all"
+		if len(parts) == 4 {
+			what = strings.ToLower(parts[3])
+		}
+		switch what {
+		case "be":
+			call.BreakEvenPercent, call.BreakEvenDone = 0, false
+		case "time":
+			call.TimeStopAt = nil
+		case "swing":
+			call.SwingTimeframe, call.SwingCheckedAt = "", nil
+		case "all":
+			call.BreakEvenPercent, call.BreakEvenDone = 0, false
+			call.TimeStopAt = nil
+			call.SwingTimeframe, call.SwingCheckedAt = "", nil
+		default:
+			b.reply(chatID, usage)
+			return
+		}
+
+	default:
+		b.reply(chatID, usage)
+		return
+	}
+
+	if err := b.st.UpdateCallRules(*call); err != nil {
+		b.reply(chatID, "Ошибка сохранения правила: "+err.Error())
+		return
+	}
+
+	if !call.HasRules() {
+		b.reply(chatID, fmt.Sprintf("Правила колла `%s` выключены", callID))
+		return
+	}
+	msg := fmt.Sprintf("Правила колла `%s`: %s", callID, formatCallRules(*call))
+	if call.TimeStopAt != nil && !time.Now().Before(*call.TimeStopAt) {
+		msg += "\nВремя уже истекло: колл закроется на ближайшей проверке цены"
+	}
+	b.reply(chatID, msg)
+}
+
+// formatCallRules описание правил автоматизации колла
+func formatCallRules(call alerts.Call) string {
+	var rules []string
+	if call.BreakEvenPercent > 0 {
+		rule := fmt.Sprintf("стоп в безубыток при +%g%%", call.BreakEvenPercent)
+		if call.BreakEvenDone {
+			rule += " (выполнено)"
+		}
+		rules = append(rules, rule)
+	}
+	if call.TimeStopAt != nil {
+		rules = append(rules, fmt.Sprintf("закрыть в %s", call.TimeStopAt.Local().Format("15:04 02.01")))
+	}
+	if call.SwingTimeframe != "" {
+		pivot := "swing low"
+		if call.Direction == "short" {
+			pivot = "swing high"
+		}
+		rules = append(rules, fmt.Sprintf("стоп за %s на %s", pivot, call.SwingTimeframe))
+	}
+	return strings.Join(rules, ", ")
+}
+
+// formatTrailing описание трейлинг-стопа колла: отступ и порог активации
+func formatTrailing(call alerts.Call) string {
+	desc := fmt.Sprintf("отступ %s", prices.FormatPrice(call.TrailDistance))
+	if call.TrailPercent > 0 {
+		desc = fmt.Sprintf("отступ %g%%", call.TrailPercent)
+	}
+	if call.TrailActivation > 0 {
+		if call.TrailBestPrice > 0 {
+			desc += fmt.Sprintf(", активирован после +%g%%", call.TrailActivation)
+		} else {
+			desc += fmt.Sprintf(", включится после +%g%% прибыли", call.TrailActivation)
+		}
+	}
+	if call.TrailBestPrice > 0 {
+		desc += fmt.Sprintf(", лучшая цена %s", prices.FormatPrice(call.TrailBestPrice))
+	}
+	return desc
+}
+
 // cmdCloseCall обрабатывает команду /ccall CALLID [size]
 func (b *TelegramBot) cmdCloseCall(ctx context.Context, chatID int64, userID int64, text string) {
 	parts := strings.Fields(text)
@@ -513,7 +1111,8 @@ func (b *TelegramBot) cmdCloseCall(ctx context.Context, chatID int64, userID int
 		return
 	}
 
-	size := call.Size
+	// Без размера закрывается весь остаток на момент закрытия
+	size := alerts.CloseRemaining
 
 	if len(parts) == 3 {
 		sizeVal, err := strconv.ParseFloat(parts[2], 64)
@@ -526,7 +1125,7 @@ func (b *TelegramBot) cmdCloseCall(ctx context.Context, chatID int64, userID int
 
 	// Получаем текущую цену для символа из колла
 	preferredExchange, preferredMarket := b.getPreferredExchangeMarketForSymbol(call.Symbol)
-	priceInfo, err := prices.FetchPriceInfo(b.pricesClients, call.Symbol, preferredExchange, preferredMarket)
+	priceInfo, err := b.quotes.CurrentPrice(b.exchanges, call.Symbol, preferredExchange, preferredMarket)
 	if err != nil {
 		b.reply(chatID, fmt.Sprintf("Ошибка получения цены для %s: %s", call.Symbol, err.Error()))
 		logrus.WithError(err).WithField("symbol", call.Symbol).Warn("failed to fetch price info for closing call")
@@ -534,7 +1133,7 @@ func (b *TelegramBot) cmdCloseCall(ctx context.Context, chatID int64, userID int
 	}
 
 	// Закрываем колл
-	err = b.st.CloseCall(callID, userID, priceInfo.CurrentPrice, size)
+	err = b.st.CloseCall(callID, userID, priceInfo.CurrentPrice, size, alerts.FillSourceManual)
 	if err != nil {
 		b.reply(chatID, "Ошибка закрытия колла: "+err.Error())
 		return
@@ -555,21 +1154,31 @@ func (b *TelegramBot) cmdCloseCall(ctx context.Context, chatID int64, userID int
 
 		statusMsg := ""
 		if updatedCall.Status == "closed" {
-			statusMsg = fmt.Sprintf("Колл полностью закрыт!\nID: `%s`\nСимвол: %s\nНаправление: %s\nЦена входа: %s\nЦена выхода: %s\nPnL: %s%.2f%%",
+			statusMsg = fmt.Sprintf("Колл полностью закрыт!\nID: `%s`\nСимвол: %s\nНаправление: %s\nЦена входа: %s\nЦена выхода: %s\nСредняя цена выхода: %s\nPnL: %s%.2f%%",
 				callID, updatedCall.Symbol, directionRus, prices.FormatPrice(updatedCall.EntryPrice),
-				prices.FormatPrice(priceInfo.CurrentPrice), pnlSign, updatedCall.PnlPercent)
+				prices.FormatPrice(priceInfo.CurrentPrice), prices.FormatPrice(updatedCall.ExitPrice), pnlSign, updatedCall.PnlPercent)
 		} else {
-			statusMsg = fmt.Sprintf("Колл частично закрыт на %.0f%%!\nID: `%s`\nСимвол: %s\nНаправление: %s\nОставшийся размер: %.0f\nЦена входа: %s\nЦена выхода: %s\nPnL на закрытую часть: %s%.2f%%",
+			statusMsg = fmt.Sprintf("Колл частично закрыт на %.0f%%!\nID: `%s`\nСимвол: %s\nНаправление: %s\nОставшийся размер: %.0f\nЦена входа: %s\nЦена выхода: %s\nРеализованный PnL (все закрытия, средняя цена выхода %s): %s%.2f%%",
 				size, callID, updatedCall.Symbol, directionRus, updatedCall.Size, prices.FormatPrice(updatedCall.EntryPrice),
-				prices.FormatPrice(priceInfo.CurrentPrice), pnlSign, updatedCall.PnlPercent)
+				prices.FormatPrice(priceInfo.CurrentPrice), prices.FormatPrice(updatedCall.ExitPrice), pnlSign, updatedCall.PnlPercent)
 		}
-		b.reply(chatID, statusMsg)
+		b.reply(chatID, statusMsg+"\n"+formatAsOf(priceInfo.AsOf))
 	} else {
 		b.reply(chatID, fmt.Sprintf("Колл `%s` закрыт по цене %s", callID, prices.FormatPrice(priceInfo.CurrentPrice)))
 	}
 }
 
-// cmdMyCalls показывает активные коллы пользователя, сгруппированные по тикерам
+// addFills возвращает доборы позиции из журнала исполнений колла
+func addFills(fills []alerts.CallFill) []alerts.CallFill {
+	var adds []alerts.CallFill
+	for _, fill := range fills {
+		if fill.Kind == alerts.FillKindAdd {
+			adds = append(adds, fill)
+		}
+	}
+	return adds
+}
+
 // cmdMyCalls показывает активные коллы пользователя, сгруппированные по тикерам
 func (b *TelegramBot) cmdMyCalls(ctx context.Context, chatID int64, userID int64) {
 	calls := b.st.GetUserCalls(userID, true)
@@ -609,16 +1218,20 @@ func (b *TelegramBot) cmdMyCalls(ctx context.Context, chatID int64, userID int64
 	var totalPnlToDeposit float64
 	symbolIndex := 1
 
+	var pricesAsOf time.Time // Время самой старой из использованных цен
 	for _, key := range keys {
 		symbolCalls := callsBySymbol[key]
 
 		// Получаем текущую цену для символа
-		priceInfo, err := prices.FetchCurrentPrice(b.pricesClients, key.Symbol, symbolCalls[0].Exchange, symbolCalls[0].Market)
+		priceInfo, err := b.quotes.CurrentPrice(b.exchanges, key.Symbol, symbolCalls[0].Exchange, symbolCalls[0].Market)
 		if err != nil {
 			logrus.WithError(err).WithField("symbol", key.Symbol).Warn("failed to get current price for symbol group")
 			continue
 		}
 		currentPrice := priceInfo.CurrentPrice
+		if pricesAsOf.IsZero() || priceInfo.AsOf.Before(pricesAsOf) {
+			pricesAsOf = priceInfo.AsOf
+		}
 
 		directionRus := "Long"
 		if key.Direction == "short" {
@@ -644,19 +1257,19 @@ func (b *TelegramBot) cmdMyCalls(ctx context.Context, chatID int64, userID int64
 			EffectiveSize float64
 			SizeStr       string
 			BasePnl       float64
+			Fees          float64 // Комиссии входа и выхода в % от маржи
+			Funding       float64 // Накопленное финансирование в % от маржи
 			HoldingTime   string
+			Adds          []alerts.CallFill   // Доборы позиции
+			TakeProfits   []alerts.TakeProfit // Лестница тейк-профитов
+			Call          alerts.Call
 		}
 		var callInfos []CallInfo
 
 		// Выводим каждый колл в группе
 		for _, call := range symbolCalls {
-			// Базовое изменение цены
-			var basePnl float64
-			if call.Direction == "long" {
-				basePnl = ((currentPrice - call.EntryPrice) / call.EntryPrice) * 100
-			} else {
-				basePnl = ((call.EntryPrice - currentPrice) / call.EntryPrice) * 100
-			}
+			// PnL от маржи с учетом плеча, комиссий и накопленного финансирования
+			basePnl, fees, funding := call.Returns(currentPrice)
 
 			// Вклад в депозит
 			pnlToDeposit := call.DepositPercent * (basePnl / 100)
@@ -695,7 +1308,12 @@ func (b *TelegramBot) cmdMyCalls(ctx context.Context, chatID int64, userID int64
 				EffectiveSize: effectiveSize,
 				SizeStr:       sizeStr,
 				BasePnl:       basePnl,
+				Fees:          fees,
+				Funding:       funding,
 				HoldingTime:   holdingStr,
+				Adds:          addFills(b.st.GetCallFills(call.ID)),
+				TakeProfits:   b.st.GetTakeProfits(call.ID),
+				Call:          call,
 			})
 
 			// Накапливаем для средних значений
@@ -745,6 +1363,39 @@ func (b *TelegramBot) cmdMyCalls(ctx context.Context, chatID int64, userID int64
 
 			msg.WriteString(fmt.Sprintf("      %d. ID: `%s`, entry: %s, size: %s, PnL: %s%.2f%%, t: %s\n",
 				i+1, info.ID, prices.FormatPrice(info.EntryPrice), info.SizeStr, pnlSign, info.BasePnl, info.HoldingTime))
+			if info.Call.Leverage > 1 {
+				msg.WriteString(fmt.Sprintf("         ⚡ плечо x%g, ликвидация %s\n",
+					info.Call.Leverage, prices.FormatPrice(info.Call.LiquidationPrice())))
+			}
+			if info.Fees != 0 || info.Funding != 0 {
+				msg.WriteString(fmt.Sprintf("         💸 комиссии %.2f%%, финансирование %+.2f%% (от маржи, учтены в PnL)\n",
+					info.Fees, -info.Funding))
+			}
+			for _, add := range info.Adds {
+				msg.WriteString(fmt.Sprintf("         ➕ добор %s по %s, +%.0f%% депозита\n",
+					add.FilledAt.Format("02.01 15:04"), prices.FormatPrice(add.Price), add.DepositPercent))
+			}
+			if info.Call.Trailing() {
+				stop := "—"
+				if info.Call.StopLossPrice > 0 {
+					stop = prices.FormatPrice(info.Call.StopLossPrice)
+				}
+				msg.WriteString(fmt.Sprintf("         🔻 трейлинг-стоп %s: %s\n", stop, formatTrailing(info.Call)))
+			}
+			if info.Call.HasRules() {
+				msg.WriteString(fmt.Sprintf("         ⚙️ правила: %s\n", formatCallRules(info.Call)))
+			}
+			for j, tp := range info.TakeProfits {
+				state := "ожидает"
+				switch tp.Status {
+				case alerts.TakeProfitFilled:
+					state = "✅ исполнен"
+				case alerts.TakeProfitCancelled:
+					state = "отменен"
+				}
+				msg.WriteString(fmt.Sprintf("         🎯 TP%d %s (%.0f%%) — %s\n",
+					j+1, prices.FormatPrice(tp.Price), tp.Percent, state))
+			}
 		}
 
 		msg.WriteString("\n")
@@ -767,12 +1418,21 @@ func (b *TelegramBot) cmdMyCalls(ctx context.Context, chatID int64, userID int64
 		msg.WriteString(fmt.Sprintf("*Совокупный PnL к депозиту: %s%.2f%%*\n", pnlToDepositSign, totalPnlToDeposit))
 	}
 
+	if !pricesAsOf.IsZero() {
+		msg.WriteString("\nСамая старая " + formatAsOf(pricesAsOf))
+	}
+
 	b.reply(chatID, msg.String())
 }
 
-// cmdCallStats показывает статистику коллов всех пользователей за последние 90 дней
-func (b *TelegramBot) cmdCallStats(chatID int64) {
-	stats := b.st.GetAllUserStats()
+// cmdCallStats показывает статистику коллов всех пользователей в окне (по умолчанию за последние 90 дней)
+func (b *TelegramBot) cmdCallStats(chatID int64, text string) {
+	filter, err := parseStatsFilter(strings.Fields(text)[1:], time.Now())
+	if err != nil {
+		b.reply(chatID, "Ошибка: "+err.Error()+"\n"+statsUsage)
+		return
+	}
+	stats := b.st.GetAllUserStats(filter)
 
 	// Получаем все активные коллы для расчета текущего размера позиций и PnL
 	activeCalls := b.st.GetAllOpenCalls()
@@ -782,21 +1442,19 @@ func (b *TelegramBot) cmdCallStats(chatID int64) {
 	})
 
 	for _, call := range activeCalls {
+		if filter.Symbol != "" && call.Symbol != filter.Symbol {
+			continue
+		}
 		if call.DepositPercent > 0 {
-			priceInfo, err := prices.FetchCurrentPrice(b.pricesClients, call.Symbol, call.Exchange, call.Market)
+			priceInfo, err := b.quotes.CurrentPrice(b.exchanges, call.Symbol, call.Exchange, call.Market)
 			if err != nil {
 				logrus.WithError(err).WithField("symbol", call.Symbol).Warn("failed to get current price for active call stats in cmdCallStats")
 				continue
 			}
 			currentPrice := priceInfo.CurrentPrice
 
-			// Базовое изменение цены
-			var basePnl float64
-			if call.Direction == "long" {
-				basePnl = ((currentPrice - call.EntryPrice) / call.EntryPrice) * 100
-			} else {
-				basePnl = ((call.EntryPrice - currentPrice) / call.EntryPrice) * 100
-			}
+			// PnL от маржи с учетом плеча, комиссий и накопленного финансирования
+			basePnl := call.NetPnlPercent(currentPrice)
 
 			// Вклад в депозит = размер_позиции × изменение_цены
 			pnlToDeposit := call.DepositPercent * (basePnl / 100)
@@ -879,7 +1537,7 @@ func (b *TelegramBot) cmdCallStats(chatID int64) {
 	}
 
 	var msg strings.Builder
-	msg.WriteString("📊 *Рейтинг трейдеров за последние 90 дней:*\n\n")
+	msg.WriteString(fmt.Sprintf("📊 *Рейтинг трейдеров %s:*\n\n", filter.Label))
 
 	for i, stat := range filteredStats {
 		username := stat.Username
@@ -907,6 +1565,12 @@ func (b *TelegramBot) cmdCallStats(chatID int64) {
 			}
 			msg.WriteString(fmt.Sprintf("   📊 Закрыто: %d | PnL: %s%.2f%% | WR: %.1f%%\n",
 				stat.ClosedCalls, pnlSign, stat.TotalPnl, stat.WinRate))
+			if stat.GrossLoss > 0 {
+				msg.WriteString(fmt.Sprintf("   ⚖️ PF: %.2f | Ожидание: %+.2f%%\n", stat.ProfitFactor, stat.Expectancy))
+			}
+			if stat.Liquidations > 0 {
+				msg.WriteString(fmt.Sprintf("   💀 Ликвидаций: %d\n", stat.Liquidations))
+			}
 		}
 
 		// Активные позиции
@@ -931,34 +1595,40 @@ func (b *TelegramBot) cmdCallStats(chatID int64) {
 	b.reply(chatID, msg.String())
 }
 
-// cmdMyCallStats показывает персональную статистику коллов пользователя за последние 90 дней
-func (b *TelegramBot) cmdMyCallStats(chatID int64, userID int64) {
-	stats, err := b.st.GetUserStats(userID)
+// cmdMyCallStats показывает персональную статистику коллов пользователя в окне (по умолчанию за последние 90 дней)
+func (b *TelegramBot) cmdMyCallStats(chatID int64, userID int64, text string) {
+	filter, err := parseStatsFilter(strings.Fields(text)[1:], time.Now())
+	if err != nil {
+		b.reply(chatID, "Ошибка: "+err.Error()+"\n"+statsUsage)
+		return
+	}
+	stats, err := b.st.GetUserStats(userID, filter)
 	if err != nil {
 		b.reply(chatID, "Ошибка получения статистики: "+err.Error())
 		return
 	}
 
 	// Получаем активные коллы
-	activeCalls := b.st.GetUserCalls(userID, true)
+	var activeCalls []alerts.Call
+	for _, call := range b.st.GetUserCalls(userID, true) {
+		if filter.Symbol == "" || call.Symbol == filter.Symbol {
+			activeCalls = append(activeCalls, call)
+		}
+	}
 	var totalPositionSize float64
 	var totalPnlToDeposit float64
 
 	for _, call := range activeCalls {
 		if call.DepositPercent > 0 {
-			priceInfo, err := prices.FetchCurrentPrice(b.pricesClients, call.Symbol, call.Exchange, call.Market)
+			priceInfo, err := b.quotes.CurrentPrice(b.exchanges, call.Symbol, call.Exchange, call.Market)
 			if err != nil {
 				logrus.WithError(err).WithField("symbol", call.Symbol).Warn("failed to get current price for active call stats")
 				continue
 			}
 			currentPrice := priceInfo.CurrentPrice
 
-			var basePnl float64
-			if call.Direction == "long" {
-				basePnl = ((currentPrice - call.EntryPrice) / call.EntryPrice) * 100
-			} else {
-				basePnl = ((call.EntryPrice - currentPrice) / call.EntryPrice) * 100
-			}
+			// PnL от маржи с учетом плеча, комиссий и накопленного финансирования
+			basePnl := call.NetPnlPercent(currentPrice)
 
 			pnlToDeposit := call.DepositPercent * (basePnl / 100)
 			totalPositionSize += call.DepositPercent
@@ -973,12 +1643,12 @@ func (b *TelegramBot) cmdMyCallStats(chatID int64, userID int64) {
 	}
 
 	if stats.ClosedCalls == 0 && len(activeCalls) == 0 {
-		b.reply(chatID, "У вас нет закрытых или активных коллов за последние 90 дней")
+		b.reply(chatID, "У вас нет закрытых или активных коллов "+filter.Label)
 		return
 	}
 
 	var msg strings.Builder
-	msg.WriteString("📊 *Ваша статистика коллов за последние 90 дней:*\n\n")
+	msg.WriteString(fmt.Sprintf("📊 *Ваша статистика коллов %s:*\n\n", filter.Label))
 
 	// Доходность депозита
 	if initialDeposit > 0 && currentDeposit > 0 {
@@ -1005,7 +1675,15 @@ func (b *TelegramBot) cmdMyCallStats(chatID int64, userID int64) {
 		if stats.AveragePnl < 0 {
 			avgPnlSign = ""
 		}
-		msg.WriteString(fmt.Sprintf("   Средний PnL: %s%.2f%%\n\n", avgPnlSign, stats.AveragePnl))
+		msg.WriteString(fmt.Sprintf("   Средний PnL: %s%.2f%%\n", avgPnlSign, stats.AveragePnl))
+		if stats.TotalFees != 0 || stats.TotalFunding != 0 {
+			msg.WriteString(fmt.Sprintf("   Комиссии: %.2f%% | Финансирование: %+.2f%% депозита\n", stats.TotalFees, -stats.TotalFunding))
+		}
+		if stats.Liquidations > 0 {
+			msg.WriteString(fmt.Sprintf("   Ликвидаций: %d\n", stats.Liquidations))
+		}
+		msg.WriteString(formatTradeMetrics(stats.TradeMetrics, "   "))
+		msg.WriteString("\n")
 	}
 
 	// Активные позиции
@@ -1031,7 +1709,7 @@ func (b *TelegramBot) cmdMyCallStats(chatID int64, userID int64) {
 	// Лучший и худший коллы
 	if stats.ClosedCalls > 0 {
 		msg.WriteString("\n")
-		bestCall, worstCall := b.st.GetBestWorstCallsForUser(userID)
+		bestCall, worstCall := b.st.GetBestWorstCallsForUser(userID, filter)
 
 		if bestCall != nil {
 			directionRus := "Long"
@@ -1053,16 +1731,21 @@ func (b *TelegramBot) cmdMyCallStats(chatID int64, userID int64) {
 	b.reply(chatID, msg.String())
 }
 
-// cmdMyTrades показывает статистику по символам для пользователя за последние 90 дней
-func (b *TelegramBot) cmdMyTrades(chatID int64, userID int64) {
-	trades := b.st.GetUserTradesBySymbol(userID)
+// cmdMyTrades показывает статистику по символам для пользователя в окне (по умолчанию за последние 90 дней)
+func (b *TelegramBot) cmdMyTrades(chatID int64, userID int64, text string) {
+	filter, err := parseStatsFilter(strings.Fields(text)[1:], time.Now())
+	if err != nil {
+		b.reply(chatID, "Ошибка: "+err.Error()+"\n"+statsUsage)
+		return
+	}
+	trades := b.st.GetUserTradesBySymbol(userID, filter)
 	if len(trades) == 0 {
-		b.reply(chatID, "У вас нет сделок за последние 90 дней")
+		b.reply(chatID, "У вас нет сделок "+filter.Label)
 		return
 	}
 
 	var msg strings.Builder
-	msg.WriteString("📈 *Ваши сделки по символам за последние 90 дней:*\n\n")
+	msg.WriteString(fmt.Sprintf("📈 *Ваши сделки по символам %s:*\n\n", filter.Label))
 
 	// Получаем отсортированные ключи для стабильного порядка
 	symbols := make([]string, 0, len(trades))
@@ -1105,7 +1788,7 @@ func (b *TelegramBot) cmdRush(ctx context.Context, chatID int64, userID int64) {
 
 	for _, call := range openCalls {
 		// Получаем текущую цену для символа
-		priceInfo, err := prices.FetchPriceInfo(b.pricesClients, call.Symbol, call.Exchange, call.Market)
+		priceInfo, err := b.quotes.CurrentPrice(b.exchanges, call.Symbol, call.Exchange, call.Market)
 		if err != nil {
 			failCount++
 			failMessages = append(failMessages, fmt.Sprintf("Колл `%s` (%s): Ошибка получения цены - %s", call.ID, call.Symbol, err.Error()))
@@ -1113,8 +1796,8 @@ func (b *TelegramBot) cmdRush(ctx context.Context, chatID int64, userID int64) {
 			continue
 		}
 
-		// Закрываем колл полностью
-		err = b.st.CloseCall(call.ID, call.UserID, priceInfo.CurrentPrice, 100.0)
+		// Закрываем колл полностью (оставшийся размер)
+		err = b.st.CloseCall(call.ID, call.UserID, priceInfo.CurrentPrice, alerts.CloseRemaining, alerts.FillSourceRush)
 		if err != nil {
 			failCount++
 			failMessages = append(failMessages, fmt.Sprintf("Колл `%s` (%s): Ошибка закрытия - %s", call.ID, call.Symbol, err.Error()))
@@ -1161,21 +1844,21 @@ func (b *TelegramBot) cmdAllCalls(ctx context.Context, chatID int64) {
 	}
 
 	var callsWithPnl []CallWithPnL
+	var pricesAsOf time.Time // Время самой старой из использованных цен
 	for _, call := range calls {
-		priceInfo, err := prices.FetchCurrentPrice(b.pricesClients, call.Symbol, call.Exchange, call.Market)
+		priceInfo, err := b.quotes.CurrentPrice(b.exchanges, call.Symbol, call.Exchange, call.Market)
 		if err != nil {
 			logrus.WithError(err).WithField("symbol", call.Symbol).Warn("failed to get current price for call")
 			continue
 		}
 		currentPrice := priceInfo.CurrentPrice
-
-		var basePnl float64
-		if call.Direction == "long" {
-			basePnl = ((currentPrice - call.EntryPrice) / call.EntryPrice) * 100
-		} else {
-			basePnl = ((call.EntryPrice - currentPrice) / call.EntryPrice) * 100
+		if pricesAsOf.IsZero() || priceInfo.AsOf.Before(pricesAsOf) {
+			pricesAsOf = priceInfo.AsOf
 		}
 
+		// PnL от маржи с учетом плеча, комиссий и накопленного финансирования
+		basePnl := call.NetPnlPercent(currentPrice)
+
 		pnlToDeposit := call.DepositPercent * (basePnl / 100)
 
 		callsWithPnl = append(callsWithPnl, CallWithPnL{
@@ -1264,6 +1947,10 @@ func (b *TelegramBot) cmdAllCalls(ctx context.Context, chatID int64) {
 		msg.WriteString("\n")
 	}
 
+	if !pricesAsOf.IsZero() {
+		msg.WriteString("\nСамая старая " + formatAsOf(pricesAsOf))
+	}
+
 	b.reply(chatID, msg.String())
 }
 
@@ -1301,14 +1988,32 @@ func (b *TelegramBot) cmdListAlerts(chatID int64) {
 		})
 
 		for i, alert := range symbolAlerts {
-			if alert.TargetPrice > 0 {
-				msg.WriteString(fmt.Sprintf("%d. Цель %s, ID: `%s`\n",
-					i+1, prices.FormatPrice(alert.TargetPrice), alert.ID))
+			if alert.Kind == alerts.AlertKindExpression {
+				msg.WriteString(fmt.Sprintf("%d. Условие: %s, ID: `%s`\n", i+1, alert.Expression, alert.ID))
+			} else if alert.Kind == alerts.AlertKindIndicator {
+				msg.WriteString(fmt.Sprintf("%d. Индикатор: %s, ID: `%s`\n", i+1, alert.Expression, alert.ID))
+			} else if alert.TargetPrice > 0 {
+				arrow := ""
+				switch alert.Side {
+				case alerts.SideAbove:
+					arrow = " ↑"
+				case alerts.SideBelow:
+					arrow = " ↓"
+				}
+				msg.WriteString(fmt.Sprintf("%d. Цель%s %s, ID: `%s`\n",
+					i+1, arrow, prices.FormatPrice(alert.TargetPrice), alert.ID))
 			} else if alert.TargetPercent != 0 {
 				msg.WriteString(fmt.Sprintf("%d. Изменение на %.2f%% от %s, ID: `%s`\n",
 					i+1, alert.TargetPercent, prices.FormatPrice(alert.BasePrice), alert.ID))
 			}
 			msg.WriteString(fmt.Sprintf("   Биржа: %s, Рынок: %s\n", alert.Exchange, alert.Market))
+			if alert.Recurring() {
+				state := "взведен"
+				if !alert.Armed {
+					state = "ждет взвода"
+				}
+				msg.WriteString(fmt.Sprintf("   🔁 Повторяющийся, %s, срабатываний: %d\n", state, alert.TriggerCount))
+			}
 		}
 		msg.WriteString("\n")
 	}
@@ -1366,7 +2071,7 @@ func (b *TelegramBot) cmdPriceAll(ctx context.Context, chatID int64) {
 
 	for _, symbol := range symbols {
 		preferredExchange, preferredMarket := b.getPreferredExchangeMarketForSymbol(symbol)
-		priceInfo, err := prices.FetchPriceInfo(b.pricesClients, symbol, preferredExchange, preferredMarket)
+		priceInfo, err := b.quotes.PriceInfo(b.exchanges, symbol, preferredExchange, preferredMarket)
 		if err != nil {
 			msg += fmt.Sprintf("%s: ошибка получения цены\n", symbol)
 			logrus.WithError(err).WithField("symbol", symbol).Warn("failed to fetch price info")
@@ -1382,7 +2087,7 @@ func (b *TelegramBot) cmdPriceAll(ctx context.Context, chatID int64) {
 		msg += fmt.Sprintf("%s: %s\n", symbol, prices.FormatPrice(priceInfo.CurrentPrice))
 		msg += fmt.Sprintf("15м: %s | 1ч: %s | 4ч: %s | 24ч: %s\n",
 			change15m, change1h, change4h, change24h)
-		msg += fmt.Sprintf("Биржа: %s, Рынок: %s\n\n", priceInfo.Exchange, priceInfo.Market)
+		msg += fmt.Sprintf("Биржа: %s, Рынок: %s, %s\n\n", priceInfo.Exchange, priceInfo.Market, formatAsOf(priceInfo.AsOf))
 	}
 
 	b.reply(chatID, msg)
@@ -1398,7 +2103,7 @@ func (b *TelegramBot) cmdPrice(ctx context.Context, chatID int64, text string) {
 
 	symbol := formatSymbol(parts[1])
 	preferredExchange, preferredMarket := b.getPreferredExchangeMarketForSymbol(symbol)
-	priceInfo, err := prices.FetchPriceInfo(b.pricesClients, symbol, preferredExchange, preferredMarket)
+	priceInfo, err := b.quotes.PriceInfo(b.exchanges, symbol, preferredExchange, preferredMarket)
 	if err != nil {
 		b.reply(chatID, fmt.Sprintf("%s: ошибка получения цены - %s", symbol, err.Error()))
 		logrus.WithError(err).WithField("symbol", symbol).Warn("failed to fetch price info")
@@ -1415,6 +2120,7 @@ func (b *TelegramBot) cmdPrice(ctx context.Context, chatID int64, text string) {
 	msg += fmt.Sprintf("15м: %s | 1ч: %s | 4ч: %s | 24ч: %s",
 		change15m, change1h, change4h, change24h)
 	msg += fmt.Sprintf("\nБиржа: %s, Рынок: %s", priceInfo.Exchange, priceInfo.Market)
+	msg += "\n" + formatAsOf(priceInfo.AsOf)
 
 	b.reply(chatID, msg)
 }
@@ -1430,219 +2136,48 @@ func formatChange(change float64) string {
 	}
 }
 
-// checkAlerts проверяет алерты для символа и отправляет уведомления
-func (b *TelegramBot) checkAlerts(symbol string, currentPrice float64) {
-	alerts := b.st.GetBySymbol(symbol)
-	logrus.WithFields(logrus.Fields{
-		"symbol": symbol,
-		"price":  currentPrice,
-		"count":  len(alerts),
-	}).Debug("checking alerts for symbol")
-
-	for _, alert := range alerts {
-		triggered := false
-		var msg string
-
-		logrus.WithFields(logrus.Fields{
-			"alert_id":       alert.ID,
-			"target_price":   alert.TargetPrice,
-			"target_percent": alert.TargetPercent,
-			"base_price":     alert.BasePrice,
-			"current_price":  currentPrice,
-		}).Debug("checking individual alert")
-
-		// Проверка алерта по целевой цене с погрешностью 0.5%
-		if alert.TargetPrice > 0 {
-			tolerance := alert.TargetPrice * 0.005 // 0.5%
-
-			// Проверяем попадание в диапазон с погрешностью
-			if math.Abs(currentPrice-alert.TargetPrice) <= tolerance {
-				triggered = true
-				msg = fmt.Sprintf("🚨АЛЕРТ! %s достиг %s (текущая: %s)", symbol, prices.FormatPrice(alert.TargetPrice), prices.FormatPrice(currentPrice))
-				logrus.WithField("alert_id", alert.ID).Info("price alert triggered")
-			}
-		}
-
-		// Проверка алерта по проценту
-		if !triggered && alert.TargetPercent != 0 && alert.BasePrice > 0 {
-			changePct := ((currentPrice - alert.BasePrice) / alert.BasePrice) * 100
-
-			// Проверяем достижение целевого процента (с учетом направления)
-			targetReached := false
-			if alert.TargetPercent > 0 && changePct >= alert.TargetPercent {
-				targetReached = true
-			} else if alert.TargetPercent < 0 && changePct <= alert.TargetPercent {
-				targetReached = true
-			}
-
-			if targetReached {
-				triggered = true
-				direction := "вырос"
-				if alert.TargetPercent < 0 {
-					direction = "упал"
-				}
-				msg = fmt.Sprintf("АЛЕРТ! %s %s на %.2f%% (от %s до %s)",
-					symbol, direction, math.Abs(changePct), prices.FormatPrice(alert.BasePrice), prices.FormatPrice(currentPrice))
-				logrus.WithFields(logrus.Fields{
-					"alert_id":   alert.ID,
-					"change_pct": changePct,
-					"target_pct": alert.TargetPercent,
-				}).Info("percent alert triggered")
-			}
-		}
-
-		if triggered {
-			// Логируем срабатывание алерта
-			triggerType := "price"
-			if alert.TargetPercent != 0 {
-				triggerType = "percent"
-			}
-			b.st.LogAlertTrigger(alert.ID, symbol, currentPrice, alert.ChatID, alert.UserID, alert.Username, triggerType)
-
-			// Отправляем уведомление
-			b.reply(alert.ChatID, msg)
-
-			// Удаляем сработавший алерт
-			_, err := b.st.DeleteByID(alert.ChatID, alert.ID)
-			if err != nil {
-				logrus.WithError(err).WithField("alert_id", alert.ID).Warn("failed to delete triggered alert")
-			} else {
-				logrus.WithFields(logrus.Fields{
-					"alert_id": alert.ID,
-					"symbol":   symbol,
-					"price":    currentPrice,
-				}).Info("alert triggered and deleted")
-			}
-		}
+// formatRecurring описывает условия повторного взвода алерта (пусто для однократных)
+func formatRecurring(alert alerts.Alert) string {
+	if !alert.Recurring() {
+		return ""
 	}
-}
-
-// checkSharpChange проверяет резкие изменения цены для символа
-func (b *TelegramBot) checkSharpChange(symbol string, currentPrice float64) {
-	// Получаем цену на указанный интервал назад
-	intervalAgo := time.Now().Add(-time.Duration(b.cfg.SharpChangeIntervalMin) * time.Minute)
-
-	oldPrice := 0.0
-	err := error(nil)
-
-	// Определяем предпочтительную биржу и рынок из существующих алертов или коллов
-	preferredExchange := ""
-	preferredMarket := ""
-
-	preferredExchange, preferredMarket = b.getPreferredExchangeMarketForSymbol(symbol)
 
-	b.sharpChangeMu.Lock()
-	lastAlert, exists := b.lastSharpChangeAlert[symbol]
-	b.sharpChangeMu.Unlock()
-
-	// Если есть данные о предыдущем алерте о резком изменении, используем его цену как базовую для следующего расчета.
-	// Это обеспечивает, что последующие алерты считаются от цены последнего срабатывания.
-	if exists && time.Since(lastAlert.Time) < time.Duration(b.cfg.SharpChangeIntervalMin)*time.Minute {
-		oldPrice = lastAlert.Price
-	} else {
-		oldPrice, err = b.fetchHistoricalPrice(symbol, intervalAgo, preferredExchange, preferredMarket)
-		if err != nil {
-			logrus.WithError(err).WithFields(logrus.Fields{
-				"symbol":   symbol,
-				"interval": fmt.Sprintf("%dm", b.cfg.SharpChangeIntervalMin),
-			}).Debug("failed to get historical price for sharp change check")
-			return
+	if alert.Kind == alerts.AlertKindExpression || alert.Kind == alerts.AlertKindIndicator {
+		msg := "\n🔁 Повторяющийся: взводится снова, когда условие перестанет выполняться"
+		if alert.CooldownSec > 0 {
+			msg += fmt.Sprintf(" и пройдет %s", time.Duration(alert.CooldownSec)*time.Second)
 		}
+		return msg
 	}
 
-	// Вычисляем процентное изменение
-	changePct := ((currentPrice - oldPrice) / oldPrice) * 100
-	absChangePct := math.Abs(changePct)
-
-	logrus.WithFields(logrus.Fields{
-		"symbol":        symbol,
-		"current_price": currentPrice,
-		"old_price":     oldPrice,
-		"change_pct":    changePct,
-		"threshold":     b.cfg.SharpChangePercent,
-		"interval_min":  b.cfg.SharpChangeIntervalMin,
-	}).Debug("checking sharp change")
-
-	// Проверяем, превышает ли изменение пороговое значение
-	if absChangePct >= b.cfg.SharpChangePercent {
-		// Проверяем, не отправляли ли мы уже алерт недавно для этого символа
-		b.sharpChangeMu.Lock()
-		lastAlertTime, exists := b.lastSharpChangeAlert[symbol]
-		now := time.Now()
-
-		// Отправляем алерт не чаще чем раз в 5 минут для одного символа
-		if !exists || now.Sub(lastAlertTime.Time) >= 5*time.Minute {
-			b.lastSharpChangeAlert[symbol] = struct {
-				Time  time.Time
-				Price float64
-			}{Time: now, Price: currentPrice}
-			b.sharpChangeMu.Unlock()
-
-			// Формируем сообщение
-			direction := "вырос"
-			if changePct < 0 {
-				direction = "упал"
-			}
-
-			// Получаем всех пользователей с алертами или коллами на этот символ
-			symbolAlerts := b.st.GetBySymbol(symbol)
-			symbolCalls := b.st.GetAllOpenCalls()
-
-			// Создаем map уникальных пользователей
-			alertedUsers := make(map[int64]alerts.Alert)
-
-			// Добавляем пользователей с алертами
-			for _, alert := range symbolAlerts {
-				alertedUsers[alert.ChatID] = alert
-			}
-
-			// Добавляем пользователей с коллами на данный символ
-			for _, call := range symbolCalls {
-				if call.Symbol == symbol {
-					// Создаем "псевдо-алерт" для пользователя с коллом
-					pseudoAlert := alerts.Alert{
-						ChatID:   call.ChatID,
-						UserID:   call.UserID,
-						Username: call.Username,
-						Symbol:   call.Symbol,
-					}
-					alertedUsers[call.ChatID] = pseudoAlert
-				}
-			}
-
-			// Отправляем уведомление каждому пользователю с алертами на этот символ
-			if len(alertedUsers) > 0 {
-				msg := fmt.Sprintf("%s %s на %.2f%% за %dм (от %s до %s)",
-					symbol, direction, absChangePct, b.cfg.SharpChangeIntervalMin,
-					prices.FormatPrice(oldPrice), prices.FormatPrice(currentPrice))
-
-				for chatID, alert := range alertedUsers {
-					b.reply(chatID, msg)
-					// Логируем резкое изменение. Сохраняем currentPrice как lastTriggerPrice для следующего алерта.
-					b.st.LogAlertTrigger("", symbol, currentPrice, chatID, alert.UserID, alert.Username, "sharp_change")
-				}
-
-				logrus.WithFields(logrus.Fields{
-					"symbol":         symbol,
-					"change_pct":     changePct,
-					"interval_min":   b.cfg.SharpChangeIntervalMin,
-					"notified_chats": len(alertedUsers),
-				}).Info("sharp change alert sent")
-			}
+	var conditions []string
+	if alert.RearmPercent > 0 {
+		conditions = append(conditions, fmt.Sprintf("цена отойдет от цели на %.2f%%", alert.RearmPercent))
+	}
+	if alert.CooldownSec > 0 {
+		conditions = append(conditions, fmt.Sprintf("пройдет %s", time.Duration(alert.CooldownSec)*time.Second))
+	}
+	if len(conditions) == 0 {
+		if alert.TargetPrice > 0 {
+			conditions = append(conditions, fmt.Sprintf("цена отойдет от цели на %.2f%%", engine.DefaultRearmPercent))
 		} else {
-			b.sharpChangeMu.Unlock()
-			logrus.WithFields(logrus.Fields{
-				"symbol":              symbol,
-				"change_pct":          changePct,
-				"last_alert_time_ago": now.Sub(lastAlertTime.Time).String(),
-			}).Debug("sharp change detected but alert suppressed due to recent notification")
+			conditions = append(conditions, "сразу, от цены срабатывания")
 		}
 	}
+	return "\n🔁 Повторяющийся: взводится снова, когда " + strings.Join(conditions, " или ")
+}
+
+// formatAsOf показывает, на какой момент получена цена
+func formatAsOf(asOf time.Time) string {
+	if asOf.IsZero() {
+		return "время цены неизвестно"
+	}
+	return fmt.Sprintf("цена на %s (%d с назад)", asOf.Format("15:04:05"), int(time.Since(asOf).Seconds()))
 }
 
 // fetchHistoricalPrice получает историческую цену для указанного времени
 func (b *TelegramBot) fetchHistoricalPrice(symbol string, timestamp time.Time, preferredExchange, preferredMarket string) (float64, error) {
-	return prices.FetchHistoricalPrice(b.pricesClients, symbol, timestamp, preferredExchange, preferredMarket)
+	return prices.FetchHistoricalPrice(b.exchanges, symbol, timestamp, preferredExchange, preferredMarket)
 }
 
 // cmdHistory показывает историю сработавших алертов пользователя
@@ -1725,6 +2260,13 @@ func (b *TelegramBot) cmdStats(chatID int64, userID int64) {
 	msg.WriteString(fmt.Sprintf("\nВсего активных алертов: %d\n", totalActiveAlerts))
 	msg.WriteString(fmt.Sprintf("Отслеживается символов: %d", len(stats)))
 
+	if b.monitor != nil {
+		if cycle := b.monitor.LastCycle(); !cycle.StartedAt.IsZero() {
+			msg.WriteString(fmt.Sprintf("\nПоследний опрос цен: %.1f с, получено %d, из потока %d, ошибок %d",
+				cycle.Duration.Seconds(), cycle.Fetched, cycle.Streamed, cycle.Failed))
+		}
+	}
+
 	b.reply(chatID, msg.String())
 }
 
@@ -1741,77 +2283,31 @@ func (b *TelegramBot) startMonitoring(ctx context.Context) {
 
 	if len(symbols) > 0 {
 		// Используем мониторинг с провайдером символов, проверяем каждые 60 секунд
-		mon := prices.NewPriceMonitorWithProvider(b.st, b.pricesClients, 0, 60)
+		mon := prices.NewPriceMonitorWithProvider(b.st, b.exchanges, 0, 60)
+		mon.Quotes = b.quotes
+		if b.cfg.PriceStream {
+			// Цены приходят из WebSocket, REST опрос раз в 60 секунд подхватывает символы без потока
+			mon.Stream = prices.NewStreamFeed(b.st, prices.DefaultStreamVenues()...)
+		}
 		monCtx, cancel := context.WithCancel(ctx)
+		b.monitor = mon
 		b.monitorCtx = monCtx
 		b.stopMon = cancel
+		go b.runIndicatorChecks(monCtx)
 		go func() {
-			_ = mon.Run(monCtx, func(symbol string, oldPrice, newPrice, deltaPct float64) {
+			_ = mon.Run(monCtx, func(upd prices.PriceUpdate) {
 				// Логируем цену в историю (периодически)
-				b.st.LogPriceHistory(symbol, newPrice)
-
-				// Проверяем алерты пользователей
-				alertsForSymbol := b.st.GetBySymbol(symbol)
-				callsForSymbol := b.st.GetAllOpenCalls()
-
-				// Фильтруем коллы по символу
-				var symbolCalls []alerts.Call
-				for _, call := range callsForSymbol {
-					if call.Symbol == symbol {
-						symbolCalls = append(symbolCalls, call)
-					}
-				}
-
-				if len(alertsForSymbol) > 0 || len(symbolCalls) > 0 {
-					b.checkAlerts(symbol, newPrice)
-					// Также проверяем резкие изменения цены
-					b.checkSharpChange(symbol, newPrice)
-					// проверяем лимитные ордера
-					b.checkLimitOrders(symbol, newPrice)
-
-					// Проверяем стоп-лоссы для открытых коллов
-					for _, call := range symbolCalls {
-						if call.StopLossPrice > 0 {
-							triggeredSL := false
-							var slMsg string
-
-							if call.Direction == "long" && newPrice <= call.StopLossPrice {
-								triggeredSL = true
-								slMsg = fmt.Sprintf("🛑 СТОП-ЛОСС! Колл `%s` (%s %s) закрыт по стоп-лоссу: цена %s достигла/пробила %s",
-									call.ID, call.Symbol, "Long", prices.FormatPrice(newPrice), prices.FormatPrice(call.StopLossPrice))
-							} else if call.Direction == "short" && newPrice >= call.StopLossPrice {
-								triggeredSL = true
-								slMsg = fmt.Sprintf("🛑 СТОП-ЛОСС! Колл `%s` (%s %s) закрыт по стоп-лоссу: цена %s достигла/пробила %s",
-									call.ID, call.Symbol, "Short", prices.FormatPrice(newPrice), prices.FormatPrice(call.StopLossPrice))
-							}
-
-							if triggeredSL {
-								logrus.WithFields(logrus.Fields{
-									"call_id":         call.ID,
-									"symbol":          call.Symbol,
-									"current_price":   newPrice,
-									"stop_loss_price": call.StopLossPrice,
-									"direction":       call.Direction,
-								}).Info("stop-loss triggered")
-
-								// Закрываем колл полностью оставшимся размером
-								err := b.st.CloseCall(call.ID, call.UserID, newPrice, call.Size)
-								if err != nil {
-									logrus.WithError(err).WithField("call_id", call.ID).Error("failed to close call by stop-loss")
-								} else {
-									// Отменяем все лимитные ордера, связанные с этим коллом
-									err = b.st.CancelLimitOrdersByCallID(call.ID)
-									if err != nil {
-										logrus.WithError(err).Warn("failed to cancel limit orders after stop-loss")
-									}
-									b.reply(call.ChatID, slMsg)
-								}
-							}
-						}
-					}
-				} else {
-					logrus.WithField("symbol", symbol).Debug("no alerts or calls for symbol, skipping check")
-				}
+				b.st.LogPriceHistory(upd.Symbol, upd.Price)
+
+				b.engine.OnTick(engine.Tick{
+					Symbol:   upd.Symbol,
+					Price:    upd.Price,
+					Prev:     upd.Prev,
+					High:     upd.High,
+					Low:      upd.Low,
+					Exchange: upd.Exchange,
+					Market:   upd.Market,
+				})
 			})
 		}()
 	} else {
@@ -1819,6 +2315,28 @@ func (b *TelegramBot) startMonitoring(ctx context.Context) {
 	}
 }
 
+// indicatorCheckInterval как часто проверяется закрытие свечей индикаторных алертов и swing-правил коллов
+const indicatorCheckInterval = 30 * time.Second
+
+// runIndicatorChecks проверяет индикаторные алерты и swing-правила коллов после закрытия свечей их таймфреймов,
+// начисляет финансирование фьючерсным коллам и отменяет ордера с истекшим сроком действия
+func (b *TelegramBot) runIndicatorChecks(ctx context.Context) {
+	ticker := time.NewTicker(indicatorCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.engine.CheckIndicators()
+			b.engine.CheckCallRules()
+			b.engine.CheckFunding()
+			b.engine.CheckOrderExpiry()
+		}
+	}
+}
+
 // restartMonitoring перезапускает мониторинг (вызывается при добавлении алертов)
 func (b *TelegramBot) restartMonitoring(ctx context.Context) {
 	logrus.Info("restarting monitoring due to alert changes")
@@ -1859,12 +2377,18 @@ func (b *TelegramBot) getPreferredExchangeMarketForSymbol(symbol string) (string
 
 // cmdCreateLimitOrder обрабатывает команду /limit
 func (b *TelegramBot) cmdCreateLimitOrder(ctx context.Context, chatID, userID int64, username, text string) {
+	const usage = "Использование: /limit TICKER [b|s] PRICE|stop PRICE [limit PRICE] DEPOSIT_PERCENT [CALL_ID] [sl PRICE] [xN] [oco ORDER_ID] [gtd 24h|2024-12-31|gtc]\n" +
+		"Примеры:\n" +
+		"/limit BTC b 120000 5 - открыть лонг при достижении 120000\n" +
+		"/limit BTC b 120000 5 sl 118000 x3 - открыть лонг со стоп-лоссом 118000 и плечом x3\n" +
+		"/limit BTC s 122000 50 abc123de - закрыть 50% колла abc123de при достижении 122000\n" +
+		"/limit BTC b stop 70000 5 - открыть лонг по рынку на пробое 70000\n" +
+		"/limit BTC b stop 70000 limit 70300 5 - на пробое 70000 выставить лимитную покупку по 70300\n" +
+		"/limit BTC s stop 65000 100 abc123de oco k9x2m1qa - стоп колла; исполнение одного ордера отменит другой\n" +
+		"/limit ETH b 3000 10 gtd 3d - ордер действует 3 дня (по умолчанию — до отмены)"
 	parts := strings.Fields(text)
 	if len(parts) < 5 {
-		b.reply(chatID, "Использование: /limit TICKER [b|s] PRICE DEPOSIT_PERCENT [CALL_ID]\n"+
-			"Примеры:\n"+
-			"/limit BTC b 120000 5 - открыть лонг при достижении 120000\n"+
-			"/limit BTC s 122000 50 abc123de - закрыть 50% колла abc123de при достижении 122000")
+		b.reply(chatID, usage)
 		return
 	}
 
@@ -1881,24 +2405,91 @@ func (b *TelegramBot) cmdCreateLimitOrder(ctx context.Context, chatID, userID in
 		return
 	}
 
-	limitPrice, err := strconv.ParseFloat(parts[3], 64)
-	if err != nil || limitPrice <= 0 {
-		b.reply(chatID, "Неверная цена лимитного ордера")
-		return
+	orderType := alerts.OrderTypeLimit
+	var limitPrice, stopPrice float64
+	var err error
+	idx := 3
+	if strings.ToLower(parts[idx]) == "stop" {
+		orderType = alerts.OrderTypeStop
+		if len(parts) < 6 {
+			b.reply(chatID, usage)
+			return
+		}
+		stopPrice, err = strconv.ParseFloat(parts[4], 64)
+		if err != nil || stopPrice <= 0 {
+			b.reply(chatID, "Неверная стоп-цена ордера")
+			return
+		}
+		idx = 5
+		if strings.ToLower(parts[idx]) == "limit" {
+			orderType = alerts.OrderTypeStopLimit
+			if len(parts) < 8 {
+				b.reply(chatID, usage)
+				return
+			}
+			limitPrice, err = strconv.ParseFloat(parts[6], 64)
+			if err != nil || limitPrice <= 0 {
+				b.reply(chatID, "Неверная цена лимитного ордера")
+				return
+			}
+			idx = 7
+		}
+	} else {
+		limitPrice, err = strconv.ParseFloat(parts[idx], 64)
+		if err != nil || limitPrice <= 0 {
+			b.reply(chatID, "Неверная цена лимитного ордера")
+			return
+		}
+		idx++
 	}
 
-	depositPercent, err := strconv.ParseFloat(parts[4], 64)
+	depositPercent, err := strconv.ParseFloat(parts[idx], 64)
 	if err != nil || depositPercent <= 0 {
 		b.reply(chatID, "Неверный процент депозита")
 		return
 	}
 
-	var relatedCallID string
-	var sizeToClose float64
+	var relatedCallID, ocoWith string
+	var sizeToClose, stopLossPrice float64
+	leverage := 1.0
+	var expiresAt *time.Time
+	for i := idx + 1; i < len(parts); i++ {
+		switch {
+		case strings.ToLower(parts[i]) == "sl" && i+1 < len(parts):
+			stopLossPrice, err = strconv.ParseFloat(parts[i+1], 64)
+			if err != nil || stopLossPrice < 0 {
+				b.reply(chatID, "Неверное значение стоп-лосса. Используйте число >= 0.")
+				return
+			}
+			i++
+		case isLeverageArg(parts[i]):
+			leverage, _ = strconv.ParseFloat(parts[i][1:], 64)
+		case strings.ToLower(parts[i]) == "oco" && i+1 < len(parts):
+			ocoWith = parts[i+1]
+			i++
+		case strings.ToLower(parts[i]) == "gtc":
+			expiresAt = nil
+		case strings.ToLower(parts[i]) == "gtd" && i+1 < len(parts):
+			expiresAt, err = parseOrderExpiry(parts[i+1], time.Now())
+			if err != nil {
+				b.reply(chatID, "Ошибка срока действия: "+err.Error())
+				return
+			}
+			i++
+		case relatedCallID == "":
+			relatedCallID = parts[i]
+		default:
+			b.reply(chatID, usage)
+			return
+		}
+	}
 
 	// Если указан ID колла - это ордер на закрытие
-	if len(parts) >= 6 {
-		relatedCallID = parts[5]
+	if relatedCallID != "" {
+		if stopLossPrice > 0 || leverage != 1 {
+			b.reply(chatID, "Стоп-лосс и плечо задаются только для ордера на открытие")
+			return
+		}
 
 		// Проверяем существование колла
 		call, err := b.st.GetCallByID(relatedCallID, userID)
@@ -1940,13 +2531,69 @@ func (b *TelegramBot) cmdCreateLimitOrder(ctx context.Context, chatID, userID in
 		}
 	}
 
+	// OCO: новый ордер входит в группу существующего активного ордера того же символа
+	var ocoGroup string
+	if ocoWith != "" {
+		sibling, err := b.st.GetLimitOrderByID(ocoWith, userID)
+		if err != nil || sibling.Status != "active" {
+			b.reply(chatID, fmt.Sprintf("Ордер `%s` для OCO не найден или уже не активен", ocoWith))
+			return
+		}
+		if sibling.Symbol != symbol {
+			b.reply(chatID, fmt.Sprintf("Ордер `%s` выставлен по %s, а не по %s", ocoWith, sibling.Symbol, symbol))
+			return
+		}
+		ocoGroup = sibling.OcoGroup
+		if ocoGroup == "" {
+			ocoGroup = sibling.ID
+		}
+	}
+
 	// Получаем текущую цену для информации
 	preferredExchange, preferredMarket := b.getPreferredExchangeMarketForSymbol(symbol)
-	priceInfo, err := prices.FetchPriceInfo(b.pricesClients, symbol, preferredExchange, preferredMarket)
+	priceInfo, err := b.quotes.CurrentPrice(b.exchanges, symbol, preferredExchange, preferredMarket)
 	if err != nil {
 		b.reply(chatID, "Ошибка получения текущей цены для "+symbol+": "+err.Error())
 		return
 	}
+	if err := alerts.ValidateLeverage(leverage, priceInfo.Market); err != nil {
+		b.reply(chatID, "Ошибка плеча: "+err.Error())
+		return
+	}
+
+	// Стоп-ордер, стоп-цена которого уже пройдена, исполнился бы сразу
+	if orderType != alerts.OrderTypeLimit {
+		if direction == "long" && stopPrice <= priceInfo.CurrentPrice {
+			b.reply(chatID, fmt.Sprintf("Стоп-цена покупки должна быть выше текущей цены %s", prices.FormatPrice(priceInfo.CurrentPrice)))
+			return
+		}
+		if direction == "short" && stopPrice >= priceInfo.CurrentPrice {
+			b.reply(chatID, fmt.Sprintf("Стоп-цена продажи должна быть ниже текущей цены %s", prices.FormatPrice(priceInfo.CurrentPrice)))
+			return
+		}
+	}
+	entryPrice := limitPrice
+	if orderType == alerts.OrderTypeStop {
+		entryPrice = stopPrice
+	}
+
+	// Ордер на открытие заранее проверяется по риск-правилам (повторно — при исполнении)
+	if relatedCallID == "" {
+		candidate := alerts.Call{
+			Symbol:         symbol,
+			Direction:      direction,
+			EntryPrice:     entryPrice,
+			DepositPercent: depositPercent,
+			Market:         priceInfo.Market,
+			Exchange:       priceInfo.Exchange,
+			StopLossPrice:  stopLossPrice,
+			Leverage:       leverage,
+		}
+		if err := b.checkRisk(chatID, userID, candidate); err != nil {
+			b.reply(chatID, "⛔ Лимитный ордер отклонен риск-правилами: "+err.Error())
+			return
+		}
+	}
 
 	// Создаем лимитный ордер
 	order := alerts.LimitOrder{
@@ -1959,6 +2606,12 @@ func (b *TelegramBot) cmdCreateLimitOrder(ctx context.Context, chatID, userID in
 		DepositPercent: depositPercent,
 		RelatedCallID:  relatedCallID,
 		SizeToClose:    sizeToClose,
+		OrderType:      orderType,
+		StopPrice:      stopPrice,
+		OcoGroup:       ocoGroup,
+		ExpiresAt:      expiresAt,
+		StopLossPrice:  stopLossPrice,
+		Leverage:       leverage,
 	}
 
 	order, err = b.st.CreateLimitOrder(order)
@@ -1966,20 +2619,34 @@ func (b *TelegramBot) cmdCreateLimitOrder(ctx context.Context, chatID, userID in
 		b.reply(chatID, "Ошибка создания лимитного ордера: "+err.Error())
 		return
 	}
+	if ocoGroup == ocoWith && ocoWith != "" {
+		// Группа получает ID первого ордера
+		if err := b.st.SetLimitOrderOcoGroup(ocoWith, ocoGroup); err != nil {
+			logrus.WithError(err).WithField("order_id", ocoWith).Warn("failed to set oco group")
+		}
+	}
 
 	// Формируем сообщение
 	var msg string
 	directionRus := map[string]string{"long": "Long", "short": "Short"}[direction]
 
 	if relatedCallID != "" {
-		msg = fmt.Sprintf("✅ Лимитный ордер создан!\nID: `%s`\nСимвол: %s\nТип: Закрытие %.0f%% колла `%s`\nЦена: %s\nТекущая цена: %s",
-			order.ID, symbol, depositPercent, relatedCallID,
-			prices.FormatPrice(limitPrice), prices.FormatPrice(priceInfo.CurrentPrice))
+		msg = fmt.Sprintf("✅ %s создан!\nID: `%s`\nСимвол: %s\nТип: Закрытие %.0f%% колла `%s`\n%s\nТекущая цена: %s",
+			orderTypeName(order), order.ID, symbol, depositPercent, relatedCallID,
+			formatOrderPrices(order), prices.FormatPrice(priceInfo.CurrentPrice))
 	} else {
-		msg = fmt.Sprintf("✅ Лимитный ордер создан!\nID: `%s`\nСимвол: %s\nНаправление: %s\nЦена: %s\nРазмер: %.0f%% депозита\nТекущая цена: %s",
-			order.ID, symbol, directionRus, prices.FormatPrice(limitPrice),
+		msg = fmt.Sprintf("✅ %s создан!\nID: `%s`\nСимвол: %s\nНаправление: %s\n%s\nРазмер: %.0f%% депозита\nТекущая цена: %s",
+			orderTypeName(order), order.ID, symbol, directionRus, formatOrderPrices(order),
 			depositPercent, prices.FormatPrice(priceInfo.CurrentPrice))
+		if risk := formatOrderStopLeverage(order); risk != "" {
+			msg += "\n" + risk
+		}
 	}
+	if ocoGroup != "" {
+		msg += fmt.Sprintf("\nOCO с ордером `%s`: исполнение одного отменит остальные", ocoWith)
+	}
+	msg += "\nДействует: " + formatOrderExpiry(order)
+	msg += "\n" + formatAsOf(priceInfo.AsOf)
 
 	b.reply(chatID, msg)
 
@@ -2035,7 +2702,7 @@ func (b *TelegramBot) cmdMyOrders(ctx context.Context, chatID, userID int64) {
 
 		// Получаем текущую цену
 		preferredExchange, preferredMarket := b.getPreferredExchangeMarketForSymbol(symbol)
-		priceInfo, err := prices.FetchCurrentPrice(b.pricesClients, symbol, preferredExchange, preferredMarket)
+		priceInfo, err := b.quotes.CurrentPrice(b.exchanges, symbol, preferredExchange, preferredMarket)
 		currentPrice := 0.0
 		if err == nil {
 			currentPrice = priceInfo.CurrentPrice
@@ -2047,9 +2714,9 @@ func (b *TelegramBot) cmdMyOrders(ctx context.Context, chatID, userID int64) {
 		}
 		msg.WriteString("\n\n")
 
-		// Сортируем ордера по цене
+		// Сортируем ордера по цене, которой они ждут
 		sort.Slice(symbolOrders, func(i, j int) bool {
-			return symbolOrders[i].LimitPrice < symbolOrders[j].LimitPrice
+			return symbolOrders[i].TriggerPrice() < symbolOrders[j].TriggerPrice()
 		})
 
 		for i, order := range symbolOrders {
@@ -2066,7 +2733,7 @@ func (b *TelegramBot) cmdMyOrders(ctx context.Context, chatID, userID int64) {
 			// Рассчитываем разницу с текущей ценой
 			var priceDiff string
 			if currentPrice > 0 {
-				diff := ((order.LimitPrice - currentPrice) / currentPrice) * 100
+				diff := ((order.TriggerPrice() - currentPrice) / currentPrice) * 100
 				sign := "+"
 				if diff < 0 {
 					sign = ""
@@ -2076,7 +2743,19 @@ func (b *TelegramBot) cmdMyOrders(ctx context.Context, chatID, userID int64) {
 
 			msg.WriteString(fmt.Sprintf("   %d. ID: `%s`\n", i+1, order.ID))
 			msg.WriteString(fmt.Sprintf("      Тип: %s\n", orderType))
-			msg.WriteString(fmt.Sprintf("      Цена: %s%s\n", prices.FormatPrice(order.LimitPrice), priceDiff))
+			if order.OrderType != alerts.OrderTypeLimit && order.OrderType != "" {
+				msg.WriteString(fmt.Sprintf("      %s\n", orderTypeName(order)))
+			}
+			msg.WriteString(fmt.Sprintf("      %s%s\n", formatOrderPrices(order), priceDiff))
+			if risk := formatOrderStopLeverage(order); risk != "" {
+				msg.WriteString(fmt.Sprintf("      %s\n", risk))
+			}
+			if order.ExpiresAt != nil {
+				msg.WriteString(fmt.Sprintf("      ⏳ Действует: %s\n", formatOrderExpiry(order)))
+			}
+			if siblings := ocoSiblings(orders, order); len(siblings) > 0 {
+				msg.WriteString(fmt.Sprintf("      🔗 OCO с `%s`\n", strings.Join(siblings, "`, `")))
+			}
 		}
 		msg.WriteString("\n")
 	}
@@ -2084,135 +2763,66 @@ func (b *TelegramBot) cmdMyOrders(ctx context.Context, chatID, userID int64) {
 	b.reply(chatID, msg.String())
 }
 
-// checkLimitOrders проверяет и исполняет лимитные ордера
-func (b *TelegramBot) checkLimitOrders(symbol string, currentPrice float64) {
-	orders := b.st.GetLimitOrdersBySymbol(symbol)
-	if len(orders) == 0 {
-		return
-	}
-
-	logrus.WithFields(logrus.Fields{
-		"symbol": symbol,
-		"price":  currentPrice,
-		"count":  len(orders),
-	}).Debug("checking limit orders for symbol")
-
-	for _, order := range orders {
-		triggered := false
-
-		// Проверяем условия исполнения
-		// Для long (buy): цена опустилась до/ниже лимитной
-		// Для short (sell): цена поднялась до/выше лимитной
-		if order.Direction == "long" && currentPrice <= order.LimitPrice {
-			triggered = true
-		} else if order.Direction == "short" && currentPrice >= order.LimitPrice {
-			triggered = true
-		}
-
-		if !triggered {
-			continue
+// orderTypeName название ордера по типу
+func orderTypeName(order alerts.LimitOrder) string {
+	switch order.OrderType {
+	case alerts.OrderTypeStop:
+		return "Стоп-ордер"
+	case alerts.OrderTypeStopLimit:
+		if order.StopTriggered {
+			return "Стоп-лимит ордер (стоп сработал)"
 		}
+		return "Стоп-лимит ордер"
+	default:
+		return "Лимитный ордер"
+	}
+}
 
-		logrus.WithFields(logrus.Fields{
-			"order_id":        order.ID,
-			"symbol":          order.Symbol,
-			"direction":       order.Direction,
-			"limit_price":     order.LimitPrice,
-			"current_price":   currentPrice,
-			"related_call_id": order.RelatedCallID,
-		}).Info("limit order triggered")
-
-		var msg string
-
-		// Если это ордер на закрытие колла
-		if order.RelatedCallID != "" {
-			// Получаем информацию о колле
-			call, err := b.st.GetCallByID(order.RelatedCallID, order.UserID)
-			if err != nil {
-				logrus.WithError(err).WithField("order_id", order.ID).Error("failed to get call for limit order")
-				b.st.CancelLimitOrder(order.ID, order.UserID)
-				continue
-			}
-
-			if call.Status != "open" {
-				logrus.WithField("order_id", order.ID).Warn("call already closed, cancelling limit order")
-				b.st.CancelLimitOrder(order.ID, order.UserID)
-				continue
-			}
-
-			// Закрываем позицию
-			err = b.st.CloseCall(order.RelatedCallID, order.UserID, currentPrice, order.SizeToClose)
-			if err != nil {
-				logrus.WithError(err).WithField("order_id", order.ID).Error("failed to close call by limit order")
-				msg = fmt.Sprintf("⚠️ Ошибка исполнения лимитного ордера `%s`: %s", order.ID, err.Error())
-			} else {
-				// Получаем обновленную информацию о колле
-				updatedCall, _ := b.st.GetCallByID(order.RelatedCallID, order.UserID)
-
-				pnlSign := "+"
-				var pnl float64
-				if updatedCall != nil {
-					pnl = updatedCall.PnlPercent
-					if pnl < 0 {
-						pnlSign = ""
-					}
-				}
-
-				if updatedCall != nil && updatedCall.Status == "closed" {
-					msg = fmt.Sprintf("✅ Лимитный ордер `%s` исполнен!\nКолл `%s` (%s) полностью закрыт по цене %s\nPnL: %s%.2f%%",
-						order.ID, order.RelatedCallID, symbol,
-						prices.FormatPrice(currentPrice), pnlSign, pnl)
-				} else {
-					msg = fmt.Sprintf("✅ Лимитный ордер `%s` исполнен!\nЗакрыто %.0f%% колла `%s` (%s) по цене %s\nPnL на закрытую часть: %s%.2f%%",
-						order.ID, (order.SizeToClose/call.Size)*100,
-						order.RelatedCallID, symbol, prices.FormatPrice(currentPrice), pnlSign, pnl)
-				}
-
-				// Помечаем ордер как исполненный
-				b.st.TriggerLimitOrder(order.ID)
-			}
-		} else {
-			// Это ордер на открытие позиции
-			preferredExchange, preferredMarket := b.getPreferredExchangeMarketForSymbol(symbol)
-			priceInfo, err := prices.FetchPriceInfo(b.pricesClients, symbol, preferredExchange, preferredMarket)
-			if err != nil {
-				logrus.WithError(err).WithField("order_id", order.ID).Error("failed to get price info for limit order")
-				continue
-			}
-
-			// Создаем новый колл
-			call := alerts.Call{
-				UserID:         order.UserID,
-				Username:       order.Username,
-				ChatID:         order.ChatID,
-				Symbol:         symbol,
-				Direction:      order.Direction,
-				EntryPrice:     currentPrice,
-				Market:         priceInfo.Market,
-				DepositPercent: order.DepositPercent,
-				Exchange:       priceInfo.Exchange,
-			}
+// formatOrderPrices строка с ценами ордера
+func formatOrderPrices(order alerts.LimitOrder) string {
+	switch order.OrderType {
+	case alerts.OrderTypeStop:
+		return "Стоп-цена: " + prices.FormatPrice(order.StopPrice) + " (по рынку)"
+	case alerts.OrderTypeStopLimit:
+		return fmt.Sprintf("Стоп-цена: %s, лимит: %s", prices.FormatPrice(order.StopPrice), prices.FormatPrice(order.LimitPrice))
+	default:
+		return "Цена: " + prices.FormatPrice(order.LimitPrice)
+	}
+}
 
-			call, err = b.st.OpenCall(call)
-			if err != nil {
-				logrus.WithError(err).WithField("order_id", order.ID).Error("failed to open call by limit order")
-				msg = fmt.Sprintf("⚠️ Ошибка исполнения лимитного ордера `%s`: %s", order.ID, err.Error())
-			} else {
-				directionRus := map[string]string{"long": "Long", "short": "Short"}[order.Direction]
-				msg = fmt.Sprintf("✅ Лимитный ордер `%s` исполнен!\nОткрыт колл `%s`\nСимвол: %s\nНаправление: %s\nЦена входа: %s\nРазмер: %.0f%%",
-					order.ID, call.ID, symbol, directionRus,
-					prices.FormatPrice(currentPrice), order.DepositPercent)
+// formatOrderStopLeverage стоп-лосс и плечо, с которыми ордер откроет колл; пусто, если они не заданы
+func formatOrderStopLeverage(order alerts.LimitOrder) string {
+	var parts []string
+	if order.StopLossPrice > 0 {
+		parts = append(parts, "Стоп-лосс: "+prices.FormatPrice(order.StopLossPrice))
+	}
+	if order.Leverage > 1 {
+		parts = append(parts, fmt.Sprintf("Плечо: x%g", order.Leverage))
+	}
+	return strings.Join(parts, ", ")
+}
 
-				// Помечаем ордер как исполненный
-				b.st.TriggerLimitOrder(order.ID)
-			}
-		}
+// isLeverageArg аргумент плеча вида x5
+func isLeverageArg(arg string) bool {
+	if !strings.HasPrefix(strings.ToLower(arg), "x") {
+		return false
+	}
+	_, err := strconv.ParseFloat(arg[1:], 64)
+	return err == nil
+}
 
-		// Отправляем уведомление пользователю
-		if msg != "" {
-			b.reply(order.ChatID, msg)
+// ocoSiblings ID остальных активных ордеров OCO-группы order
+func ocoSiblings(orders []alerts.LimitOrder, order alerts.LimitOrder) []string {
+	if order.OcoGroup == "" {
+		return nil
+	}
+	var ids []string
+	for _, o := range orders {
+		if o.OcoGroup == order.OcoGroup && o.ID != order.ID {
+			ids = append(ids, o.ID)
 		}
 	}
+	return ids
 }
 
 func (b *TelegramBot) cmdChart(ctx context.Context, chatID int64, text string) {
@@ -0,0 +1,141 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
+
+	"example.com/alert-bot/internal/alerts"
+	"example.com/alert-bot/internal/levels"
+)
+
+// equitySnapshotInterval как часто проверяется, сделан ли дневной снимок депозита
+const equitySnapshotInterval = time.Hour
+
+// computeEquity возвращает депозит пользователя и капитал: депозит плюс нереализованный PnL
+// оставшейся части открытых коллов по текущим ценам.
+func (b *TelegramBot) computeEquity(userID int64) (deposit, equity float64, err error) {
+	_, deposit, err = b.st.GetUserDeposit(userID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	equity = deposit
+	for _, call := range b.st.GetUserCalls(userID, true) {
+		if call.DepositPercent <= 0 {
+			continue
+		}
+		priceInfo, err := b.quotes.CurrentPrice(b.exchanges, call.Symbol, call.Exchange, call.Market)
+		if err != nil {
+			logrus.WithError(err).WithField("symbol", call.Symbol).Warn("failed to get current price for equity")
+			continue
+		}
+		margin := call.DepositPercent * call.Size / 100
+		equity += margin / 100 * call.NetPnlPercent(priceInfo.CurrentPrice) / 100 * deposit
+	}
+	return deposit, equity, nil
+}
+
+// runEquitySnapshots раз в день (по UTC) записывает переоценку капитала каждого пользователя с депозитом
+func (b *TelegramBot) runEquitySnapshots(ctx context.Context) {
+	ticker := time.NewTicker(equitySnapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		b.takeDailyEquitySnapshots()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// takeDailyEquitySnapshots записывает дневной снимок тем, у кого его еще нет за текущие сутки
+func (b *TelegramBot) takeDailyEquitySnapshots() {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for _, userID := range b.st.GetDepositUserIDs() {
+		done, err := b.st.HasDepositSnapshotSince(userID, alerts.SnapshotKindDaily, today)
+		if err != nil {
+			logrus.WithError(err).WithField("user_id", userID).Warn("failed to check deposit snapshot")
+			continue
+		}
+		if done {
+			continue
+		}
+
+		deposit, equity, err := b.computeEquity(userID)
+		if err != nil {
+			logrus.WithError(err).WithField("user_id", userID).Warn("failed to compute equity")
+			continue
+		}
+		if err := b.st.RecordDepositSnapshot(alerts.DepositSnapshot{
+			UserID:  userID,
+			Deposit: deposit,
+			Equity:  equity,
+			Kind:    alerts.SnapshotKindDaily,
+		}); err != nil {
+			logrus.WithError(err).WithField("user_id", userID).Warn("failed to record daily deposit snapshot")
+		}
+	}
+}
+
+// cmdEquity отправляет график кривой капитала с доходностью, максимальной просадкой, Sharpe и Sortino
+func (b *TelegramBot) cmdEquity(chatID int64, userID int64) {
+	deposit, equity, err := b.computeEquity(userID)
+	if err != nil {
+		b.reply(chatID, "Ошибка получения депозита: "+err.Error())
+		return
+	}
+
+	// Текущая точка по рынку дополняет сохраненную кривую
+	snaps := append(b.st.GetDepositSnapshots(userID), alerts.DepositSnapshot{
+		UserID:  userID,
+		Deposit: deposit,
+		Equity:  equity,
+		Kind:    alerts.SnapshotKindDaily,
+		At:      time.Now(),
+	})
+	if len(snaps) < 2 {
+		b.reply(chatID, "Кривая капитала пока пуста: точки появляются при закрытии коллов и раз в день")
+		return
+	}
+	stats := alerts.ComputeEquityStats(snaps)
+
+	var caption strings.Builder
+	caption.WriteString(fmt.Sprintf("📈 Кривая капитала с %s\n\n", snaps[0].At.Local().Format("02.01.2006")))
+	caption.WriteString(fmt.Sprintf("Капитал: %.2f (депозит %.2f)\n", equity, deposit))
+	caption.WriteString(fmt.Sprintf("Доходность: %+.2f%%\n", stats.ReturnPercent))
+	caption.WriteString(fmt.Sprintf("Макс. просадка: %.2f%%\n", stats.MaxDrawdownPercent))
+	if stats.Days >= 2 {
+		caption.WriteString(fmt.Sprintf("Sharpe: %.2f | Sortino: %.2f (годовые, %d дн.)", stats.Sharpe, stats.Sortino, stats.Days))
+	} else {
+		caption.WriteString("Sharpe и Sortino: нужно минимум 3 дня истории")
+	}
+
+	times := make([]time.Time, len(snaps))
+	values := make([]float64, len(snaps))
+	for i, snap := range snaps {
+		times[i], values[i] = snap.At, snap.Equity
+	}
+	chartData, err := levels.NewBasicChartGenerator(800, 500).GenerateEquityChart("Equity", times, values)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to generate equity chart")
+		b.reply(chatID, caption.String())
+		return
+	}
+
+	photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("equity_%d.png", userID),
+		Bytes: chartData,
+	})
+	photo.Caption = caption.String()
+	if _, err := b.api.Send(photo); err != nil {
+		logrus.WithError(err).Error("failed to send equity chart")
+		b.reply(chatID, caption.String())
+	}
+}
@@ -0,0 +1,143 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/alert-bot/internal/engine"
+	"example.com/alert-bot/internal/levels"
+	"example.com/alert-bot/internal/prices"
+)
+
+// volumeTTL как часто пересчитывается суточный объем символа
+const volumeTTL = 5 * time.Minute
+
+// fundingRequestTimeout ограничение на запрос ставки финансирования
+const fundingRequestTimeout = 10 * time.Second
+
+// engineMarket реализует engine.MarketData, engine.CandleSource и engine.FeeSource поверх кеша котировок,
+// свечей Bitget и реестра бирж.
+type engineMarket struct {
+	b      *TelegramBot
+	candle *levels.BitgetClient
+
+	mu      sync.Mutex
+	volumes map[string]cachedVolume
+}
+
+type cachedVolume struct {
+	value float64
+	at    time.Time
+}
+
+func newEngineMarket(b *TelegramBot) *engineMarket {
+	return &engineMarket{
+		b:       b,
+		candle:  levels.NewBitgetClient("https://api.bitget.com"),
+		volumes: make(map[string]cachedVolume),
+	}
+}
+
+func (m *engineMarket) Price(symbol string) (float64, error) {
+	exchange, market := m.b.getPreferredExchangeMarketForSymbol(symbol)
+	info, err := m.b.quotes.CurrentPrice(m.b.exchanges, symbol, exchange, market)
+	if err != nil {
+		return 0, err
+	}
+	return info.CurrentPrice, nil
+}
+
+func (m *engineMarket) Change(symbol, interval string) (float64, error) {
+	exchange, market := m.b.getPreferredExchangeMarketForSymbol(symbol)
+	info, err := m.b.quotes.PriceInfo(m.b.exchanges, symbol, exchange, market)
+	if err != nil {
+		return 0, err
+	}
+	switch interval {
+	case "15m":
+		return info.Change15m, nil
+	case "1h":
+		return info.Change1h, nil
+	case "4h":
+		return info.Change4h, nil
+	case "24h":
+		return info.Change24h, nil
+	}
+	return 0, fmt.Errorf("unsupported change interval %q", interval)
+}
+
+// Volume24h суммирует оборот часовых свечей Bitget spot за последние 24 часа.
+func (m *engineMarket) Volume24h(symbol string) (float64, error) {
+	m.mu.Lock()
+	cached, ok := m.volumes[symbol]
+	m.mu.Unlock()
+	if ok && time.Since(cached.at) < volumeTTL {
+		return cached.value, nil
+	}
+
+	candles, err := m.candle.GetCandles(symbol, "1h", 24)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get volume for %s: %w", symbol, err)
+	}
+	if len(candles) == 0 {
+		return 0, fmt.Errorf("no candles for %s", symbol)
+	}
+
+	var volume float64
+	for _, c := range candles {
+		volume += c.Volume * c.Close
+	}
+
+	m.mu.Lock()
+	m.volumes[symbol] = cachedVolume{value: volume, at: time.Now()}
+	m.mu.Unlock()
+	return volume, nil
+}
+
+// Candles реализует engine.CandleSource: загружает свечи Bitget spot страницами по 200 от текущего момента назад.
+func (m *engineMarket) Candles(symbol, timeframe string, limit int) ([]levels.Candle, error) {
+	granularity, err := levels.ParseTimeframe(timeframe)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []levels.Candle
+	end := time.Now()
+	for len(out) < limit {
+		page, err := m.candle.GetCandlesUntil(symbol, granularity, limit-len(out), end)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s candles for %s: %w", timeframe, symbol, err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		sort.Slice(page, func(i, j int) bool { return page[i].Timestamp < page[j].Timestamp })
+		out = append(page, out...)
+		if len(page) < 200 {
+			break
+		}
+		end = time.UnixMilli(page[0].Timestamp - 1)
+	}
+	return out, nil
+}
+
+// TakerFeePercent реализует engine.FeeSource: комиссия тейкера из таблицы бирж.
+func (m *engineMarket) TakerFeePercent(exchange, market string) float64 {
+	return prices.TakerFeePercent(exchange, market)
+}
+
+// FundingRate реализует engine.FeeSource: ставка финансирования с биржи колла.
+func (m *engineMarket) FundingRate(symbol, exchange, market string) (float64, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fundingRequestTimeout)
+	defer cancel()
+
+	rate, interval, err := m.b.exchanges.FundingRate(ctx, symbol, exchange, market)
+	if errors.Is(err, prices.ErrNotSupported) {
+		return 0, 0, engine.ErrNoFunding
+	}
+	return rate, interval, err
+}
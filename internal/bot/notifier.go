@@ -0,0 +1,200 @@
+package bot
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"example.com/alert-bot/internal/alerts"
+	"example.com/alert-bot/internal/engine"
+	"example.com/alert-bot/internal/prices"
+
+	"github.com/sirupsen/logrus"
+)
+
+// enginePrices дает движку доступ к историческим ценам и предпочтительным биржам бота.
+type enginePrices struct {
+	b *TelegramBot
+}
+
+func (p enginePrices) HistoricalPrice(symbol string, at time.Time, exchange, market string) (float64, error) {
+	return p.b.fetchHistoricalPrice(symbol, at, exchange, market)
+}
+
+func (p enginePrices) PreferredSource(symbol string) (string, string) {
+	return p.b.getPreferredExchangeMarketForSymbol(symbol)
+}
+
+// Notify реализует engine.Notifier: форматирует событие и отправляет его в Telegram.
+func (b *TelegramBot) Notify(ev engine.Event) {
+	switch ev := ev.(type) {
+	case engine.AlertTriggered:
+		msg := formatAlertTriggered(ev)
+		if ev.Alert.Recurring() {
+			msg += fmt.Sprintf("\n🔁 Алерт `%s` остается активным (срабатываний: %d)", ev.Alert.ID, ev.Alert.TriggerCount)
+		}
+		b.reply(ev.Alert.ChatID, msg)
+
+	case engine.SharpMove:
+		direction := "вырос"
+		if ev.ChangePercent < 0 {
+			direction = "упал"
+		}
+		msg := fmt.Sprintf("%s %s на %.2f%% за %dм (от %s до %s)",
+			ev.Sym, direction, math.Abs(ev.ChangePercent), int(ev.Interval.Minutes()),
+			prices.FormatPrice(ev.OldPrice), prices.FormatPrice(ev.Price))
+		for _, r := range ev.Recipients {
+			b.reply(r.ChatID, msg)
+		}
+
+	case engine.StopLossHit:
+		directionRus := "Long"
+		if ev.Call.Direction == "short" {
+			directionRus = "Short"
+		}
+		stopName := "стоп-лоссу"
+		if ev.Call.Trailing() {
+			stopName = "трейлинг-стопу"
+		}
+		b.reply(ev.Call.ChatID, fmt.Sprintf("🛑 СТОП-ЛОСС! Колл `%s` (%s %s) закрыт по %s: цена %s достигла/пробила %s",
+			ev.Call.ID, ev.Call.Symbol, directionRus, stopName, prices.FormatPrice(ev.Price), prices.FormatPrice(ev.Call.StopLossPrice)))
+
+	case engine.StopMoved:
+		b.reply(ev.Call.ChatID, formatStopMoved(ev))
+
+	case engine.TimeStopHit:
+		pnlSign := "+"
+		if ev.Call.PnlPercent < 0 {
+			pnlSign = ""
+		}
+		b.reply(ev.Call.ChatID, fmt.Sprintf("⏰ Колл `%s` (%s) закрыт по времени по цене %s\nPnL: %s%.2f%%",
+			ev.Call.ID, ev.Call.Symbol, prices.FormatPrice(ev.Price), pnlSign, ev.Call.PnlPercent))
+
+	case engine.Liquidated:
+		b.reply(ev.Call.ChatID, fmt.Sprintf("💀 ЛИКВИДАЦИЯ! Колл `%s` (%s x%.0f) закрыт по цене ликвидации %s\nPnL: %.2f%%",
+			ev.Call.ID, ev.Call.Symbol, ev.Call.EffectiveLeverage(), prices.FormatPrice(ev.Price), ev.Call.PnlPercent))
+
+	case engine.TrailingStopActivated:
+		b.reply(ev.Call.ChatID, fmt.Sprintf("📈 Трейлинг-стоп колла `%s` (%s) активирован при цене %s, стоп-лосс: %s",
+			ev.Call.ID, ev.Call.Symbol, prices.FormatPrice(ev.Price), prices.FormatPrice(ev.Call.StopLossPrice)))
+
+	case engine.TakeProfitHit:
+		b.reply(ev.Call.ChatID, formatTakeProfitHit(ev))
+
+	case engine.LimitFilled:
+		b.reply(ev.Order.ChatID, formatLimitFilled(ev))
+
+	case engine.OcoCancelled:
+		reason := "исполнен"
+		if ev.Order.OrderType == alerts.OrderTypeStopLimit && ev.Order.StopTriggered {
+			reason = "сработал по стоп-цене"
+		}
+		b.reply(ev.Order.ChatID, fmt.Sprintf("🔗 OCO: ордер `%s` (%s) %s, отменены связанные ордера `%s`",
+			ev.Order.ID, ev.Order.Symbol, reason, strings.Join(ev.CancelledIDs, "`, `")))
+
+	case engine.LimitExpired:
+		b.reply(ev.Order.ChatID, fmt.Sprintf("⌛ Срок действия ордера `%s` (%s, %s) истек, ордер отменен",
+			ev.Order.ID, ev.Order.Symbol, formatOrderPrices(ev.Order)))
+
+	case engine.LimitRejected:
+		b.reply(ev.Order.ChatID, fmt.Sprintf("⛔ Лимитный ордер `%s` (%s) отменен риск-правилами: %s",
+			ev.Order.ID, ev.Order.Symbol, ev.Reason.Error()))
+
+	case engine.LimitFailed:
+		b.reply(ev.Order.ChatID, fmt.Sprintf("⚠️ Ошибка исполнения лимитного ордера `%s`: %s", ev.Order.ID, ev.Err.Error()))
+
+	default:
+		logrus.WithField("symbol", ev.Symbol()).Warnf("unknown engine event %T", ev)
+	}
+}
+
+// formatAlertTriggered текст уведомления о сработавшем алерте
+func formatAlertTriggered(ev engine.AlertTriggered) string {
+	symbol := ev.Alert.Symbol
+	if ev.Kind == "indicator" {
+		return fmt.Sprintf("🚨АЛЕРТ! %s: %s\n%s", symbol, ev.Alert.Expression, ev.Detail)
+	}
+	if ev.Kind == "expression" {
+		return fmt.Sprintf("🚨АЛЕРТ! Условие выполнено: %s", ev.Alert.Expression)
+	}
+	if ev.Kind == "percent" {
+		direction := "вырос"
+		if ev.Alert.TargetPercent < 0 {
+			direction = "упал"
+		}
+		return fmt.Sprintf("АЛЕРТ! %s %s на %.2f%% (от %s до %s)",
+			symbol, direction, math.Abs(ev.ChangePercent), prices.FormatPrice(ev.BasePrice), prices.FormatPrice(ev.Price))
+	}
+	return fmt.Sprintf("🚨АЛЕРТ! %s достиг %s (текущая: %s)", symbol, prices.FormatPrice(ev.Alert.TargetPrice), prices.FormatPrice(ev.Price))
+}
+
+// formatLimitFilled текст уведомления об исполненном лимитном ордере
+func formatLimitFilled(ev engine.LimitFilled) string {
+	order := ev.Order
+
+	if order.RelatedCallID == "" {
+		callID := ""
+		if ev.Call != nil {
+			callID = ev.Call.ID
+		}
+		directionRus := map[string]string{"long": "Long", "short": "Short"}[order.Direction]
+		return fmt.Sprintf("✅ %s `%s` исполнен!\nОткрыт колл `%s`\nСимвол: %s\nНаправление: %s\nЦена входа: %s\nРазмер: %.0f%%",
+			orderTypeName(order), order.ID, callID, order.Symbol, directionRus,
+			prices.FormatPrice(ev.Price), order.DepositPercent)
+	}
+
+	pnlSign := "+"
+	var pnl float64
+	if ev.Call != nil {
+		pnl = ev.Call.PnlPercent
+		if pnl < 0 {
+			pnlSign = ""
+		}
+	}
+
+	if ev.Call != nil && ev.Call.Status == "closed" {
+		return fmt.Sprintf("✅ %s `%s` исполнен!\nКолл `%s` (%s) полностью закрыт по цене %s\nPnL: %s%.2f%%",
+			orderTypeName(order), order.ID, order.RelatedCallID, order.Symbol,
+			prices.FormatPrice(ev.Price), pnlSign, pnl)
+	}
+	return fmt.Sprintf("✅ %s `%s` исполнен!\nЗакрыто %.0f%% колла `%s` (%s) по цене %s\nРеализованный PnL: %s%.2f%%",
+		orderTypeName(order), order.ID, ev.ClosedPercent,
+		order.RelatedCallID, order.Symbol, prices.FormatPrice(ev.Price), pnlSign, pnl)
+}
+
+// formatStopMoved текст уведомления о переносе стопа правилом колла
+func formatStopMoved(ev engine.StopMoved) string {
+	reason := "PnL достиг порога безубытка"
+	if ev.Reason == engine.StopReasonSwing {
+		if ev.Call.Direction == "short" {
+			reason = fmt.Sprintf("последний swing high на %s", ev.Call.SwingTimeframe)
+		} else {
+			reason = fmt.Sprintf("последний swing low на %s", ev.Call.SwingTimeframe)
+		}
+	}
+
+	msg := fmt.Sprintf("🛡 Стоп-лосс колла `%s` (%s) перенесен на %s (%s, цена %s)",
+		ev.Call.ID, ev.Call.Symbol, prices.FormatPrice(ev.Call.StopLossPrice), reason, prices.FormatPrice(ev.Price))
+	if ev.OldStop > 0 {
+		msg += fmt.Sprintf("\nПредыдущий стоп: %s", prices.FormatPrice(ev.OldStop))
+	}
+	return msg
+}
+
+// formatTakeProfitHit текст уведомления об исполненной ноге тейк-профита
+func formatTakeProfitHit(ev engine.TakeProfitHit) string {
+	call := ev.Call
+	pnlSign := "+"
+	if call.PnlPercent < 0 {
+		pnlSign = ""
+	}
+
+	if call.Status == "closed" {
+		return fmt.Sprintf("🎯 ТЕЙК-ПРОФИТ! Колл `%s` (%s) полностью закрыт по цене %s (цель %s)\nPnL: %s%.2f%%",
+			call.ID, call.Symbol, prices.FormatPrice(ev.Price), prices.FormatPrice(ev.Leg.Price), pnlSign, call.PnlPercent)
+	}
+	return fmt.Sprintf("🎯 ТЕЙК-ПРОФИТ! Закрыто %.0f%% колла `%s` (%s) по цене %s (цель %s)\nОсталось: %.0f%%\nРеализованный PnL: %s%.2f%%",
+		ev.ClosedPercent, call.ID, call.Symbol, prices.FormatPrice(ev.Price), prices.FormatPrice(ev.Leg.Price),
+		call.Size, pnlSign, call.PnlPercent)
+}
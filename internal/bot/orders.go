@@ -0,0 +1,234 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/alert-bot/internal/alerts"
+	"example.com/alert-bot/internal/prices"
+)
+
+const modLimitUsage = "Использование: /modlimit ORDERID price|stop|size|gtd VALUE\n" +
+	"price - лимитная цена (у стоп-ордера — стоп-цена), stop - стоп-цена стоп-лимит ордера,\n" +
+	"size - процент депозита (у ордера на закрытие — процент позиции), gtd - срок: 24h, 3d, 2024-12-31 или gtc\n" +
+	"Пример: /modlimit abc123de price 119500\n" +
+	"/modlimit ORDERID - журнал изменений ордера"
+
+// parseOrderExpiry разбирает срок действия ордера: длительность (30m, 12h, 7d), дату ГГГГ-ММ-ДД
+// (ордер действует до конца дня) или gtc — до отмены (nil).
+func parseOrderExpiry(value string, now time.Time) (*time.Time, error) {
+	if strings.ToLower(value) == "gtc" {
+		return nil, nil
+	}
+	var at time.Time
+	if day, err := time.ParseInLocation(statsDateFormat, value, now.Location()); err == nil {
+		at = day.AddDate(0, 0, 1)
+	} else {
+		dur, err := parseDuration(value)
+		if err != nil || dur <= 0 {
+			return nil, fmt.Errorf("укажите длительность (30m, 12h, 7d), дату ГГГГ-ММ-ДД или gtc")
+		}
+		at = now.Add(dur)
+	}
+	if !at.After(now) {
+		return nil, fmt.Errorf("срок действия уже прошел")
+	}
+	return &at, nil
+}
+
+// formatOrderExpiry срок действия ордера
+func formatOrderExpiry(order alerts.LimitOrder) string {
+	if order.ExpiresAt == nil {
+		return "до отмены (GTC)"
+	}
+	return "до " + order.ExpiresAt.Local().Format("02.01.2006 15:04")
+}
+
+// cmdModifyLimitOrder обрабатывает команду /modlimit ORDERID price|stop|size|gtd VALUE: меняет активный ордер
+// без смены ID и записывает изменение в журнал. Без поля показывает журнал изменений ордера.
+func (b *TelegramBot) cmdModifyLimitOrder(chatID, userID int64, text string) {
+	parts := strings.Fields(text)
+	if len(parts) != 2 && len(parts) != 4 {
+		b.reply(chatID, modLimitUsage)
+		return
+	}
+
+	order, err := b.st.GetLimitOrderByID(parts[1], userID)
+	if err != nil {
+		b.reply(chatID, "Ордер не найден или не принадлежит вам")
+		return
+	}
+	if len(parts) == 2 {
+		b.reply(chatID, formatOrderChanges(*order, b.st.GetLimitOrderChanges(order.ID)))
+		return
+	}
+	if order.Status != "active" {
+		b.reply(chatID, "Изменить можно только активный ордер")
+		return
+	}
+
+	field, value := strings.ToLower(parts[2]), parts[3]
+	updated := *order
+	var change alerts.LimitOrderChange
+
+	switch field {
+	case "gtd":
+		updated.ExpiresAt, err = parseOrderExpiry(value, time.Now())
+		if err != nil {
+			b.reply(chatID, "Ошибка срока действия: "+err.Error())
+			return
+		}
+		change = alerts.LimitOrderChange{Field: "expiry", OldValue: formatOrderExpiry(*order), NewValue: formatOrderExpiry(updated)}
+
+	case "price", "stop", "size":
+		v, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || v <= 0 {
+			b.reply(chatID, "Неверное значение "+value)
+			return
+		}
+		if err := b.applyOrderChange(&updated, field, v); err != nil {
+			b.reply(chatID, "Ошибка: "+err.Error())
+			return
+		}
+		change = orderValueChange(*order, updated, field)
+
+	default:
+		b.reply(chatID, modLimitUsage)
+		return
+	}
+
+	if change.OldValue == change.NewValue {
+		b.reply(chatID, "Значение не изменилось")
+		return
+	}
+
+	// Ордер на открытие заново проверяется по риск-правилам
+	if updated.RelatedCallID == "" && field != "gtd" {
+		entry := updated.LimitPrice
+		if updated.OrderType == alerts.OrderTypeStop {
+			entry = updated.StopPrice
+		}
+		exchange, market := b.getPreferredExchangeMarketForSymbol(updated.Symbol)
+		candidate := alerts.Call{
+			Symbol:         updated.Symbol,
+			Direction:      updated.Direction,
+			EntryPrice:     entry,
+			DepositPercent: updated.DepositPercent,
+			Market:         market,
+			Exchange:       exchange,
+			StopLossPrice:  updated.StopLossPrice,
+			Leverage:       updated.Leverage,
+		}
+		if err := b.checkRisk(chatID, userID, candidate); err != nil {
+			b.reply(chatID, "⛔ Изменение отклонено риск-правилами: "+err.Error())
+			return
+		}
+	}
+
+	if err := b.st.ModifyLimitOrder(updated, []alerts.LimitOrderChange{change}); err != nil {
+		b.reply(chatID, "Ошибка изменения ордера: "+err.Error())
+		return
+	}
+
+	b.reply(chatID, fmt.Sprintf("✏️ %s `%s` (%s) изменен: %s %s → %s\n%s\nДействует: %s",
+		orderTypeName(updated), updated.ID, updated.Symbol, orderFieldName(change.Field),
+		change.OldValue, change.NewValue, formatOrderPrices(updated), formatOrderExpiry(updated)))
+}
+
+// applyOrderChange меняет цену или размер ордера с проверками, как при создании.
+func (b *TelegramBot) applyOrderChange(order *alerts.LimitOrder, field string, v float64) error {
+	switch field {
+	case "price":
+		if order.OrderType != alerts.OrderTypeStop {
+			order.LimitPrice = v
+			return nil
+		}
+		// У стоп-ордера нет лимитной цены — меняется стоп-цена
+		fallthrough
+
+	case "stop":
+		if order.OrderType == alerts.OrderTypeLimit || order.OrderType == "" {
+			return fmt.Errorf("у лимитного ордера нет стоп-цены, используйте price")
+		}
+		if !order.WaitingForStop() {
+			return fmt.Errorf("стоп-цена уже сработала")
+		}
+		exchange, market := b.getPreferredExchangeMarketForSymbol(order.Symbol)
+		priceInfo, err := b.quotes.CurrentPrice(b.exchanges, order.Symbol, exchange, market)
+		if err == nil {
+			if order.Direction == "long" && v <= priceInfo.CurrentPrice {
+				return fmt.Errorf("стоп-цена покупки должна быть выше текущей цены %s", prices.FormatPrice(priceInfo.CurrentPrice))
+			}
+			if order.Direction == "short" && v >= priceInfo.CurrentPrice {
+				return fmt.Errorf("стоп-цена продажи должна быть ниже текущей цены %s", prices.FormatPrice(priceInfo.CurrentPrice))
+			}
+		}
+		order.StopPrice = v
+
+	case "size":
+		if order.RelatedCallID == "" {
+			order.DepositPercent = v
+			return nil
+		}
+		if v > 100 {
+			return fmt.Errorf("процент для закрытия не может быть больше 100")
+		}
+		call, err := b.st.GetCallByID(order.RelatedCallID, order.UserID)
+		if err != nil || call.Status != "open" {
+			return fmt.Errorf("колл `%s` не найден или уже закрыт", order.RelatedCallID)
+		}
+		order.DepositPercent = v
+		order.SizeToClose = call.Size * (v / 100)
+	}
+	return nil
+}
+
+// orderValueChange запись журнала для изменения цены или размера
+func orderValueChange(before, after alerts.LimitOrder, field string) alerts.LimitOrderChange {
+	switch {
+	case field == "size":
+		return alerts.LimitOrderChange{Field: "size",
+			OldValue: strconv.FormatFloat(before.DepositPercent, 'f', -1, 64) + "%",
+			NewValue: strconv.FormatFloat(after.DepositPercent, 'f', -1, 64) + "%"}
+	case before.StopPrice != after.StopPrice:
+		return alerts.LimitOrderChange{Field: "stop",
+			OldValue: prices.FormatPrice(before.StopPrice), NewValue: prices.FormatPrice(after.StopPrice)}
+	default:
+		return alerts.LimitOrderChange{Field: "price",
+			OldValue: prices.FormatPrice(before.LimitPrice), NewValue: prices.FormatPrice(after.LimitPrice)}
+	}
+}
+
+// orderFieldName название поля ордера в журнале
+func orderFieldName(field string) string {
+	switch field {
+	case "price":
+		return "цена"
+	case "stop":
+		return "стоп-цена"
+	case "size":
+		return "размер"
+	case "expiry":
+		return "срок"
+	}
+	return field
+}
+
+// formatOrderChanges журнал изменений ордера
+func formatOrderChanges(order alerts.LimitOrder, changes []alerts.LimitOrderChange) string {
+	var msg strings.Builder
+	msg.WriteString(fmt.Sprintf("📝 *Журнал ордера* `%s` (%s, %s)\n", order.ID, order.Symbol, order.Status))
+	msg.WriteString(fmt.Sprintf("Создан: %s\nСейчас: %s, %s\n\n",
+		order.CreatedAt.Local().Format("02.01 15:04"), formatOrderPrices(order), formatOrderExpiry(order)))
+	if len(changes) == 0 {
+		msg.WriteString("Изменений не было")
+		return msg.String()
+	}
+	for _, c := range changes {
+		msg.WriteString(fmt.Sprintf("%s — %s: %s → %s\n",
+			c.ChangedAt.Local().Format("02.01 15:04"), orderFieldName(c.Field), c.OldValue, c.NewValue))
+	}
+	return msg.String()
+}
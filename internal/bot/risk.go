@@ -0,0 +1,170 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
+
+	"example.com/alert-bot/internal/alerts"
+)
+
+const riskUsage = "Использование: /risk [chat] set KEY VALUE | /risk [chat] off KEY|all\n" +
+	"Ключи: exposure % (суммарная экспозиция), symbol % (на один символ), calls N (открытых коллов), " +
+	"sl on|off (обязательный стоп-лосс), maxloss % (убыток по стопу от депозита)\n" +
+	"Пример: /risk set exposure 300\n" +
+	"Пример: /risk chat set maxloss 2 (правило для всех участников чата, только для админов)\n" +
+	"/risk - показать действующие правила и текущую экспозицию"
+
+// checkRisk предварительно проверяет колл по риск-правилам пользователя и чата при выставлении
+// или изменении ордера. Окончательная проверка выполняется хранилищем в транзакции открытия колла.
+func (b *TelegramBot) checkRisk(chatID, userID int64, call alerts.Call) error {
+	rules := b.st.EffectiveRiskRules(userID, chatID)
+	return rules.Check(b.st.GetUserCalls(userID, true), call)
+}
+
+// cmdRisk обрабатывает команду /risk [chat] set KEY VALUE | /risk [chat] off KEY|all
+func (b *TelegramBot) cmdRisk(chatID int64, userID int64, text string) {
+	parts := strings.Fields(text)[1:]
+	if len(parts) == 0 {
+		b.reply(chatID, b.formatRisk(chatID, userID))
+		return
+	}
+
+	scope, ownerID := alerts.RiskScopeUser, userID
+	if strings.ToLower(parts[0]) == "chat" {
+		if !b.isChatAdmin(chatID, userID) {
+			b.reply(chatID, "Правила чата могут менять только администраторы")
+			return
+		}
+		scope, ownerID = alerts.RiskScopeChat, chatID
+		parts = parts[1:]
+	}
+
+	rules, err := b.st.GetRiskRules(scope, ownerID)
+	if err != nil {
+		b.reply(chatID, "Ошибка получения риск-правил: "+err.Error())
+		return
+	}
+
+	switch {
+	case len(parts) == 3 && strings.ToLower(parts[0]) == "set":
+		if err := setRiskRule(&rules, strings.ToLower(parts[1]), parts[2]); err != nil {
+			b.reply(chatID, "Ошибка: "+err.Error()+"\n\n"+riskUsage)
+			return
+		}
+	case len(parts) == 2 && strings.ToLower(parts[0]) == "off":
+		key := strings.ToLower(parts[1])
+		if key == "all" {
+			rules = alerts.RiskRules{}
+		} else if err := setRiskRule(&rules, key, "0"); err != nil {
+			b.reply(chatID, "Ошибка: "+err.Error()+"\n\n"+riskUsage)
+			return
+		}
+	default:
+		b.reply(chatID, riskUsage)
+		return
+	}
+
+	if err := b.st.SetRiskRules(scope, ownerID, rules); err != nil {
+		b.reply(chatID, "Ошибка сохранения риск-правил: "+err.Error())
+		return
+	}
+	b.reply(chatID, "Риск-правила обновлены\n\n"+b.formatRisk(chatID, userID))
+}
+
+// setRiskRule меняет одно ограничение; значение 0 (или off для sl) снимает его.
+func setRiskRule(rules *alerts.RiskRules, key, value string) error {
+	if key == "sl" {
+		switch strings.ToLower(value) {
+		case "on":
+			rules.RequireStopLoss = true
+		case "off", "0":
+			rules.RequireStopLoss = false
+		default:
+			return fmt.Errorf("значение sl должно быть on или off")
+		}
+		return nil
+	}
+
+	v, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || v < 0 {
+		return fmt.Errorf("неверное значение %q", value)
+	}
+	switch key {
+	case "exposure":
+		rules.MaxExposure = v
+	case "symbol":
+		rules.MaxSymbolExposure = v
+	case "calls":
+		rules.MaxOpenCalls = int(v)
+	case "maxloss":
+		rules.MaxLossAtStop = v
+	default:
+		return fmt.Errorf("неизвестный ключ %q", key)
+	}
+	return nil
+}
+
+// formatRisk текст с правилами пользователя, чата, действующими ограничениями и текущей экспозицией.
+func (b *TelegramBot) formatRisk(chatID, userID int64) string {
+	user, _ := b.st.GetRiskRules(alerts.RiskScopeUser, userID)
+	chat, _ := b.st.GetRiskRules(alerts.RiskScopeChat, chatID)
+	effective := user.Merge(chat)
+
+	var total float64
+	calls := b.st.GetUserCalls(userID, true)
+	for _, call := range calls {
+		total += call.Exposure()
+	}
+
+	var msg strings.Builder
+	msg.WriteString("🛡 *Риск-правила*\n")
+	msg.WriteString("Ваши: " + formatRiskRules(user) + "\n")
+	msg.WriteString("Чата: " + formatRiskRules(chat) + "\n")
+	msg.WriteString("Действуют: " + formatRiskRules(effective) + "\n\n")
+	msg.WriteString(fmt.Sprintf("Открытых коллов: %d, экспозиция: %.0f%% депозита", len(calls), total))
+	return msg.String()
+}
+
+// formatRiskRules краткое описание правил
+func formatRiskRules(rules alerts.RiskRules) string {
+	if rules.Empty() {
+		return "нет"
+	}
+	var parts []string
+	if rules.MaxExposure > 0 {
+		parts = append(parts, fmt.Sprintf("экспозиция ≤ %g%%", rules.MaxExposure))
+	}
+	if rules.MaxSymbolExposure > 0 {
+		parts = append(parts, fmt.Sprintf("на символ ≤ %g%%", rules.MaxSymbolExposure))
+	}
+	if rules.MaxOpenCalls > 0 {
+		parts = append(parts, fmt.Sprintf("коллов ≤ %d", rules.MaxOpenCalls))
+	}
+	if rules.RequireStopLoss {
+		parts = append(parts, "стоп-лосс обязателен")
+	}
+	if rules.MaxLossAtStop > 0 {
+		parts = append(parts, fmt.Sprintf("убыток по стопу ≤ %g%%", rules.MaxLossAtStop))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// isChatAdmin сообщает, может ли пользователь менять настройки чата: в личном чате — всегда,
+// в группе — только создатель и администраторы.
+func (b *TelegramBot) isChatAdmin(chatID, userID int64) bool {
+	if chatID == userID {
+		return true
+	}
+	member, err := b.api.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID},
+	})
+	if err != nil {
+		logrus.WithError(err).WithField("chat_id", chatID).Warn("failed to get chat member")
+		return false
+	}
+	return member.IsCreator() || member.IsAdministrator()
+}
@@ -0,0 +1,213 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
+
+	"example.com/alert-bot/internal/alerts"
+	"example.com/alert-bot/internal/prices"
+)
+
+// sizeRMultiples цели в R (расстояниях до стопа), которые показывает /size
+var sizeRMultiples = []float64{1, 2, 3}
+
+// openCallCallbackPrefix префикс данных кнопки, открывающей колл с параметрами /size
+const openCallCallbackPrefix = "ocall "
+
+// cmdPositionSize обрабатывает команду /size TICKER long|short RISK% sl PRICE [entry PRICE] [xLEVERAGE]
+func (b *TelegramBot) cmdPositionSize(ctx context.Context, chatID int64, userID int64, text string) {
+	const usage = "Использование: /size TICKER long|short RISK% sl PRICE [entry PRICE] [xLEVERAGE]\n" +
+		"Пример: /size BTC long 1% sl 58000 (рискнуть 1% депозита при входе по текущей цене)\n" +
+		"Пример: /size ETH short 0.5 sl 3500 entry 3300 x3"
+	parts := strings.Fields(text)
+	if len(parts) < 6 {
+		b.reply(chatID, usage)
+		return
+	}
+
+	symbol := formatSymbol(parts[1])
+	direction := strings.ToLower(parts[2])
+	if direction != "long" && direction != "short" {
+		b.reply(chatID, usage)
+		return
+	}
+
+	riskPercent, err := strconv.ParseFloat(strings.TrimSuffix(parts[3], "%"), 64)
+	if err != nil || riskPercent <= 0 || riskPercent > 100 {
+		b.reply(chatID, "Неверный риск. Укажите процент депозита от 0 до 100, например 1%.")
+		return
+	}
+
+	if strings.ToLower(parts[4]) != "sl" {
+		b.reply(chatID, usage)
+		return
+	}
+	stopLoss, err := strconv.ParseFloat(parts[5], 64)
+	if err != nil || stopLoss <= 0 {
+		b.reply(chatID, "Неверная цена стоп-лосса.")
+		return
+	}
+
+	var entry float64
+	leverage := 1.0
+	for i := 6; i < len(parts); i++ {
+		arg := strings.ToLower(parts[i])
+		switch {
+		case arg == "entry" && i+1 < len(parts):
+			entry, err = strconv.ParseFloat(parts[i+1], 64)
+			if err != nil || entry <= 0 {
+				b.reply(chatID, "Неверная цена входа.")
+				return
+			}
+			i++
+		case strings.HasPrefix(arg, "x"):
+			leverage, err = strconv.ParseFloat(arg[1:], 64)
+			if err != nil {
+				b.reply(chatID, "Неверное значение плеча. Используйте x и число, например x5.")
+				return
+			}
+		default:
+			b.reply(chatID, usage)
+			return
+		}
+	}
+
+	preferredExchange, preferredMarket := b.getPreferredExchangeMarketForSymbol(symbol)
+	priceInfo, err := b.quotes.CurrentPrice(b.exchanges, symbol, preferredExchange, preferredMarket)
+	if err != nil {
+		b.reply(chatID, "Ошибка получения цены для "+symbol+": "+err.Error())
+		return
+	}
+	if err := alerts.ValidateLeverage(leverage, priceInfo.Market); err != nil {
+		b.reply(chatID, "Ошибка плеча: "+err.Error())
+		return
+	}
+
+	atMarket := entry == 0
+	if atMarket {
+		entry = priceInfo.CurrentPrice
+	}
+	if (direction == "long" && stopLoss >= entry) || (direction == "short" && stopLoss <= entry) {
+		b.reply(chatID, fmt.Sprintf("Стоп-лосс %s должен быть %s цены входа %s",
+			prices.FormatPrice(stopLoss), map[string]string{"long": "ниже", "short": "выше"}[direction], prices.FormatPrice(entry)))
+		return
+	}
+
+	_, currentDeposit, err := b.st.GetUserDeposit(userID)
+	if err != nil {
+		b.reply(chatID, "Ошибка получения депозита: "+err.Error())
+		return
+	}
+
+	// Убыток по стопу от объема: расстояние до стопа плюс комиссии тейкера за вход и выход
+	fee := prices.TakerFeePercent(priceInfo.Exchange, priceInfo.Market)
+	stopDistance := math.Abs(entry-stopLoss) / entry * 100
+	lossPerNotional := stopDistance + fee*(1+stopLoss/entry)
+
+	exposure := riskPercent / lossPerNotional * 100 // Объем позиции в % депозита
+	depositPercent := math.Round(exposure/leverage*100) / 100
+	notional := exposure / 100 * currentDeposit
+
+	directionRus := "Long"
+	if direction == "short" {
+		directionRus = "Short"
+	}
+
+	var msg strings.Builder
+	msg.WriteString(fmt.Sprintf("📐 *Размер позиции* %s %s\n\n", symbol, directionRus))
+	msg.WriteString(fmt.Sprintf("Вход: %s", prices.FormatPrice(entry)))
+	if atMarket {
+		msg.WriteString(" (текущая цена)")
+	}
+	msg.WriteString(fmt.Sprintf("\nСтоп-лосс: %s (%.2f%%)\n", prices.FormatPrice(stopLoss), stopDistance))
+	msg.WriteString(fmt.Sprintf("Риск: %g%% депозита (%.2f из %.2f)\n\n", riskPercent, riskPercent/100*currentDeposit, currentDeposit))
+	msg.WriteString(fmt.Sprintf("*Процент депозита: %.2f%%*", depositPercent))
+	if leverage > 1 {
+		msg.WriteString(fmt.Sprintf(" с плечом x%g", leverage))
+	}
+	msg.WriteString(fmt.Sprintf("\nОбъем: %.2f (%s %s)\n", notional, formatQuantity(notional/entry), strings.TrimSuffix(symbol, "USDT")))
+	msg.WriteString(fmt.Sprintf("Комиссия тейкера учтена: %.3g%% за сторону\n\n", fee))
+
+	msg.WriteString("Цели:\n")
+	risk := math.Abs(entry - stopLoss)
+	for _, r := range sizeRMultiples {
+		target := entry + r*risk
+		if direction == "short" {
+			target = entry - r*risk
+		}
+		if target <= 0 {
+			break
+		}
+		msg.WriteString(fmt.Sprintf("   %gR: %s (+%.2f%% депозита)\n", r, prices.FormatPrice(target), r*riskPercent))
+	}
+
+	if exposure > 100 && leverage == 1 {
+		msg.WriteString(fmt.Sprintf("\n⚠️ Объем больше депозита: на фьючерсах укажите плечо, например x%.0f\n", math.Ceil(exposure/100)))
+	}
+
+	ocall := fmt.Sprintf("%s %s %s", symbol, direction, strconv.FormatFloat(depositPercent, 'f', -1, 64))
+	if leverage > 1 {
+		ocall += " x" + strconv.FormatFloat(leverage, 'f', -1, 64)
+	}
+	ocall += " sl " + strconv.FormatFloat(stopLoss, 'f', -1, 64)
+
+	m := tgbotapi.NewMessage(chatID, "")
+	if atMarket {
+		msg.WriteString(fmt.Sprintf("\nОткрыть: `/ocall %s`", ocall))
+		if data := openCallCallbackPrefix + ocall; len(data) <= 64 {
+			m.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("Открыть колл", data)))
+		}
+	} else {
+		// Ордер открывает колл с тем же стоп-лоссом и плечом
+		limit := fmt.Sprintf("%s %s %s %s sl %s", symbol, map[string]string{"long": "b", "short": "s"}[direction],
+			strconv.FormatFloat(entry, 'f', -1, 64), strconv.FormatFloat(depositPercent, 'f', -1, 64),
+			strconv.FormatFloat(stopLoss, 'f', -1, 64))
+		if leverage > 1 {
+			limit += " x" + strconv.FormatFloat(leverage, 'f', -1, 64)
+		}
+		msg.WriteString(fmt.Sprintf("\nЛимитный ордер: `/limit %s`", limit))
+	}
+	msg.WriteString("\n" + formatAsOf(priceInfo.AsOf))
+
+	m.Text = msg.String()
+	m.ParseMode = "Markdown"
+	m.AllowSendingWithoutReply = true
+	if _, err := b.api.Send(m); err != nil {
+		logrus.WithError(err).Warn("send position size message failed")
+	}
+}
+
+// formatQuantity количество монет с точностью, зависящей от величины
+func formatQuantity(q float64) string {
+	switch {
+	case q >= 100:
+		return strconv.FormatFloat(q, 'f', 2, 64)
+	case q >= 1:
+		return strconv.FormatFloat(q, 'f', 4, 64)
+	default:
+		return strconv.FormatFloat(q, 'g', 4, 64)
+	}
+}
+
+// handleCallback обрабатывает нажатия inline-кнопок. Кнопка /size открывает колл от имени нажавшего.
+func (b *TelegramBot) handleCallback(ctx context.Context, cq *tgbotapi.CallbackQuery) {
+	if _, err := b.api.Request(tgbotapi.NewCallback(cq.ID, "")); err != nil {
+		logrus.WithError(err).Debug("answer callback failed")
+	}
+	if cq.Message == nil || !strings.HasPrefix(cq.Data, openCallCallbackPrefix) {
+		return
+	}
+
+	username := cq.From.UserName
+	if username == "" {
+		username = cq.From.FirstName
+	}
+	b.cmdOpenCall(ctx, cq.Message.Chat.ID, cq.From.ID, username, "/ocall "+strings.TrimPrefix(cq.Data, openCallCallbackPrefix))
+}
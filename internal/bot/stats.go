@@ -0,0 +1,120 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/alert-bot/internal/alerts"
+)
+
+// statsDateFormat формат дат в диапазоне окна статистики
+const statsDateFormat = "2006-01-02"
+
+// statsUsage подсказка по аргументам /callstats, /mycallstats и /mytrades
+const statsUsage = "Окно: 7d, 30d, 90d (по умолчанию), ytd, all или даты 2024-01-01..2024-03-31; " +
+	"можно добавить тикер, например: /mycallstats 30d BTC"
+
+// parseStatsFilter разбирает аргументы команд статистики: окно и тикер в любом порядке.
+func parseStatsFilter(args []string, now time.Time) (alerts.StatsFilter, error) {
+	f := alerts.DefaultStatsFilter(now)
+	windowSet := false
+	for _, arg := range args {
+		lower := strings.ToLower(arg)
+		window, ok, err := parseStatsWindow(lower, now)
+		if err != nil {
+			return f, err
+		}
+		if !ok {
+			if f.Symbol != "" {
+				return f, fmt.Errorf("лишний аргумент %q", arg)
+			}
+			f.Symbol = formatSymbol(arg)
+			continue
+		}
+		if windowSet {
+			return f, fmt.Errorf("окно указано дважды")
+		}
+		window.Symbol = f.Symbol
+		f, windowSet = window, true
+	}
+	if f.Symbol != "" {
+		f.Label += " по " + f.Symbol
+	}
+	return f, nil
+}
+
+// parseStatsWindow разбирает окно статистики; ok=false — аргумент не похож на окно.
+func parseStatsWindow(arg string, now time.Time) (f alerts.StatsFilter, ok bool, err error) {
+	switch {
+	case arg == "all":
+		return alerts.StatsFilter{Label: "за все время"}, true, nil
+	case arg == "ytd":
+		from := time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, now.Location())
+		return alerts.StatsFilter{From: from, Label: fmt.Sprintf("с начала %d года", now.Year())}, true, nil
+	case strings.HasSuffix(arg, "d") && len(arg) > 1:
+		days, err := strconv.Atoi(arg[:len(arg)-1])
+		if err != nil {
+			return f, false, nil
+		}
+		if days <= 0 {
+			return f, true, fmt.Errorf("число дней должно быть больше 0")
+		}
+		return alerts.StatsFilter{From: now.AddDate(0, 0, -days), Label: fmt.Sprintf("за последние %d дн.", days)}, true, nil
+	case len(arg) >= len(statsDateFormat) && arg[4] == '-':
+		fromStr, toStr, isRange := strings.Cut(arg, "..")
+		from, err := time.ParseInLocation(statsDateFormat, fromStr, now.Location())
+		if err != nil {
+			return f, true, fmt.Errorf("неверная дата %q, формат ГГГГ-ММ-ДД", fromStr)
+		}
+		f = alerts.StatsFilter{From: from, Label: "с " + from.Format("02.01.2006")}
+		if isRange {
+			to, err := time.ParseInLocation(statsDateFormat, toStr, now.Location())
+			if err != nil {
+				return f, true, fmt.Errorf("неверная дата %q, формат ГГГГ-ММ-ДД", toStr)
+			}
+			if to.Before(from) {
+				return f, true, fmt.Errorf("конец диапазона раньше начала")
+			}
+			// Конечная дата включается целиком
+			f.To = to.AddDate(0, 0, 1)
+			f.Label = fmt.Sprintf("с %s по %s", from.Format("02.01.2006"), to.Format("02.01.2006"))
+		}
+		return f, true, nil
+	}
+	return f, false, nil
+}
+
+// formatTradeMetrics строки с profit factor, ожиданием, временем удержания, средним R и сериями
+func formatTradeMetrics(m alerts.TradeMetrics, indent string) string {
+	var msg strings.Builder
+	pf := "—"
+	switch {
+	case m.GrossLoss > 0:
+		pf = fmt.Sprintf("%.2f", m.ProfitFactor)
+	case m.GrossProfit > 0:
+		pf = "∞"
+	}
+	msg.WriteString(fmt.Sprintf("%sProfit factor: %s | Ожидание: %+.2f%% депозита на сделку\n", indent, pf, m.Expectancy))
+	if m.AvgHold > 0 {
+		msg.WriteString(fmt.Sprintf("%sСреднее удержание: %s\n", indent, formatHoldDuration(m.AvgHold)))
+	}
+	if m.RCalls > 0 {
+		msg.WriteString(fmt.Sprintf("%sСредний R: %+.2fR (по %d коллам со стопом)\n", indent, m.AvgR, m.RCalls))
+	}
+	msg.WriteString(fmt.Sprintf("%sСерии: %d прибыльных / %d убыточных подряд\n", indent, m.MaxWinStreak, m.MaxLossStreak))
+	return msg.String()
+}
+
+// formatHoldDuration длительность в днях, часах или минутах
+func formatHoldDuration(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%.1f дн.", d.Hours()/24)
+	case d >= time.Hour:
+		return fmt.Sprintf("%.1f ч", d.Hours())
+	default:
+		return fmt.Sprintf("%.0f мин", d.Minutes())
+	}
+}
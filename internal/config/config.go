@@ -11,11 +11,14 @@ import (
 type Config struct {
 	BotToken               string
 	LogLevel               string
-	SharpChangePercent     float64 // Процент для алертов о резких изменениях
-	SharpChangeIntervalMin int     // Интервал в минутах для проверки резких изменений
-	DatabasePath           string  // Путь к файлу базы данных SQLite
-	BybitAPIKey            string  // API ключ Bybit
-	BybitSecret            string  // Секретный ключ Bybit
+	SharpChangePercent     float64  // Процент для алертов о резких изменениях
+	SharpChangeIntervalMin int      // Интервал в минутах для проверки резких изменений
+	DatabasePath           string   // Путь к файлу базы данных SQLite
+	BybitAPIKey            string   // API ключ Bybit
+	BybitSecret            string   // Секретный ключ Bybit
+	ExchangePriority       []string // Порядок опроса бирж, например "Variational futures"
+	PriceStream            bool     // Получать цены через WebSocket (REST остается резервом)
+	PriceCacheTTLSec       int      // Сколько секунд котировка в кеше считается актуальной
 }
 
 // Load загружает конфигурацию из переменных окружения.
@@ -60,6 +63,33 @@ func Load() (Config, error) {
 	bybitAPIKey := os.Getenv("BYBIT_API_KEY")
 	bybitSecret := os.Getenv("BYBIT_SECRET")
 
+	// EXCHANGE_PRIORITY: порядок опроса бирж через запятую (по умолчанию Variational → Bitget → Bybit)
+	// Пример: "Bitget futures,Bybit futures,Variational futures"
+	var exchangePriority []string
+	if v := os.Getenv("EXCHANGE_PRIORITY"); v != "" {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				exchangePriority = append(exchangePriority, item)
+			}
+		}
+	}
+
+	// PRICE_STREAM: потоковые цены через WebSocket Bitget/Bybit (по умолчанию включено)
+	priceStream := true
+	if v := os.Getenv("PRICE_STREAM"); v != "" {
+		if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+			priceStream = b
+		}
+	}
+
+	// PRICE_CACHE_TTL_SEC: время жизни котировки в общем кеше (по умолчанию 30 секунд)
+	priceCacheTTLSec := 30
+	if v := os.Getenv("PRICE_CACHE_TTL_SEC"); v != "" {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n > 0 {
+			priceCacheTTLSec = n
+		}
+	}
+
 	return Config{
 		BotToken:               token,
 		LogLevel:               logLevel,
@@ -68,5 +98,8 @@ func Load() (Config, error) {
 		DatabasePath:           databasePath,
 		BybitAPIKey:            bybitAPIKey,
 		BybitSecret:            bybitSecret,
+		ExchangePriority:       exchangePriority,
+		PriceStream:            priceStream,
+		PriceCacheTTLSec:       priceCacheTTLSec,
 	}, nil
 }
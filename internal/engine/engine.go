@@ -0,0 +1,935 @@
+package engine
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"example.com/alert-bot/internal/alerts"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Store часть хранилища, которая нужна движку. Реализуется alerts.DatabaseStorage.
+type Store interface {
+	GetBySymbol(symbol string) []alerts.Alert
+	GetByKind(kind string) []alerts.Alert
+	Update(alert alerts.Alert) error
+	DeleteByID(chatID int64, id string) (bool, error)
+	LogAlertTrigger(alertID, symbol string, triggerPrice float64, chatID int64, userID int64, username string, triggerType string) error
+
+	GetAllOpenCalls() []alerts.Call
+	GetCallByID(callID string, userID int64) (*alerts.Call, error)
+	OpenCall(call alerts.Call, source string) (alerts.Call, error)
+	CloseCall(callID string, userID int64, exitPrice float64, sizeToClose float64, source string) error
+
+	GetLimitOrdersBySymbol(symbol string) []alerts.LimitOrder
+	ActivateStopLimit(order alerts.LimitOrder) ([]string, error)
+	CloseCallByOrder(order alerts.LimitOrder, exitPrice float64) ([]string, error)
+	OpenCallByOrder(order alerts.LimitOrder, candidate alerts.Call) (alerts.Call, []string, error)
+	GetExpiredLimitOrders(now time.Time) []alerts.LimitOrder
+	ExpireLimitOrder(orderID string) (bool, error)
+	CancelLimitOrder(orderID string, userID int64) error
+	CancelLimitOrdersByCallID(callID string) error
+
+	UpdateTrailingStop(callID string, bestPrice, stopLossPrice float64) (bool, error)
+	UpdateCallRules(call alerts.Call) error
+	TightenStopLoss(callID string, stopLossPrice float64) (bool, error)
+
+	GetActiveTakeProfits(callID string) []alerts.TakeProfit
+	CloseCallByTakeProfit(leg alerts.TakeProfit, userID int64, exitPrice, sizeToClose float64) error
+
+	AccrueFunding(callID string, percent float64, at time.Time) error
+}
+
+// PriceSource источник исторических цен и предпочтительных бирж.
+type PriceSource interface {
+	// HistoricalPrice возвращает цену символа на момент at
+	HistoricalPrice(symbol string, at time.Time, exchange, market string) (float64, error)
+	// PreferredSource возвращает биржу и рынок, с которых отслеживается символ
+	PreferredSource(symbol string) (exchange, market string)
+}
+
+// Clock источник текущего времени (в тестах подменяется фиксированным).
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock возвращает системное время.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// Tick новая цена символа.
+type Tick struct {
+	Symbol   string
+	Price    float64
+	Prev     float64 // Цена предыдущего тика (0, если неизвестна)
+	High     float64 // Максимум с предыдущего тика (0 — считать по Prev и Price)
+	Low      float64 // Минимум с предыдущего тика
+	Exchange string  // Источник цены (может быть пустым)
+	Market   string
+}
+
+// Range возвращает минимум и максимум цены с предыдущего тика, включая Prev и Price.
+func (t Tick) Range() (low, high float64) {
+	low, high = t.Price, t.Price
+	if t.Prev > 0 {
+		low, high = math.Min(low, t.Prev), math.Max(high, t.Prev)
+	}
+	if t.High > 0 {
+		high = math.Max(high, t.High)
+	}
+	if t.Low > 0 {
+		low = math.Min(low, t.Low)
+	}
+	return low, high
+}
+
+// SideFor возвращает сторону ценового алерта относительно текущей цены.
+func SideFor(target, currentPrice float64) string {
+	if target >= currentPrice {
+		return alerts.SideAbove
+	}
+	return alerts.SideBelow
+}
+
+// priceAlertHit проверяет, дошла ли цена до цели алерта. Алерт со стороной срабатывает,
+// когда цена пересекла цель с этой стороны (в том числе внутри тика по High/Low).
+// Старые алерты без стороны срабатывают при пересечении между тиками или в пределах допуска.
+func priceAlertHit(alert alerts.Alert, tick Tick, tolerance float64) bool {
+	low, high := tick.Range()
+	target := alert.TargetPrice
+
+	switch alert.Side {
+	case alerts.SideAbove:
+		return high >= target
+	case alerts.SideBelow:
+		return low <= target
+	}
+
+	if tick.Prev > 0 && low <= target && target <= high {
+		return true
+	}
+	return math.Abs(tick.Price-target) <= target*tolerance
+}
+
+type sharpState struct {
+	at    time.Time
+	price float64
+}
+
+// sharpRefRetry через сколько повторять запрос опорной цены резкого движения после ошибки
+const sharpRefRetry = time.Minute
+
+// Engine проверяет алерты, стоп-лоссы, лимитные ордера и резкие движения по входящим тикам.
+// Движок меняет состояние в Store и возвращает события; доставкой занимается Notifier.
+type Engine struct {
+	Store    Store
+	Prices   PriceSource
+	Notifier Notifier // Может быть nil: тогда события только возвращаются
+	Clock    Clock
+	Market   MarketData   // Данные для составных алертов; без него они не проверяются
+	Candles  CandleSource // Свечи для индикаторных алертов; без него они не проверяются
+	Fees     FeeSource    // Комиссии и ставки финансирования; без него коллы считаются без них
+
+	PriceTolerance      float64       // Допуск для старых алертов без стороны как доля цены (0.005 = 0.5%)
+	SharpChangePercent  float64       // Порог резкого движения, %
+	SharpChangeInterval time.Duration // Окно резкого движения
+	SharpChangeCooldown time.Duration // Не чаще одного уведомления о резком движении на символ
+
+	mu        sync.Mutex
+	lastSharp map[string]sharpState
+	sharpRefs map[string]sharpState // symbol → опорная цена SharpChangeInterval назад и время запроса
+	exprs     map[string]parsedExpr // alert ID → разобранное условие
+}
+
+type parsedExpr struct {
+	text string
+	expr Expr
+}
+
+// New создает движок с системными часами и стандартными допусками.
+func New(store Store, priceSource PriceSource, notifier Notifier, sharpChangePercent float64, sharpChangeInterval time.Duration) *Engine {
+	return &Engine{
+		Store:               store,
+		Prices:              priceSource,
+		Notifier:            notifier,
+		Clock:               SystemClock{},
+		PriceTolerance:      0.005,
+		SharpChangePercent:  sharpChangePercent,
+		SharpChangeInterval: sharpChangeInterval,
+		SharpChangeCooldown: 5 * time.Minute,
+		lastSharp:           make(map[string]sharpState),
+		sharpRefs:           make(map[string]sharpState),
+		exprs:               make(map[string]parsedExpr),
+	}
+}
+
+// OnTick обрабатывает новую цену символа и возвращает события в порядке возникновения.
+// Каждое событие также передается в Notifier, если он задан.
+func (e *Engine) OnTick(tick Tick) []Event {
+	symbolAlerts := e.Store.GetBySymbol(tick.Symbol)
+
+	var symbolCalls []alerts.Call
+	for _, call := range e.Store.GetAllOpenCalls() {
+		if call.Symbol == tick.Symbol {
+			symbolCalls = append(symbolCalls, call)
+		}
+	}
+
+	// Ордер на открытие может быть единственным, что отслеживается по символу
+	symbolOrders := e.Store.GetLimitOrdersBySymbol(tick.Symbol)
+
+	if len(symbolAlerts) == 0 && len(symbolCalls) == 0 && len(symbolOrders) == 0 {
+		logrus.WithField("symbol", tick.Symbol).Debug("no alerts, calls or orders for symbol, skipping check")
+		return nil
+	}
+
+	var events []Event
+	events = append(events, e.checkAlerts(tick, symbolAlerts)...)
+	events = append(events, e.checkSharpChange(tick)...)
+	events = append(events, e.checkLimitOrders(tick, symbolOrders)...)
+	timeEvents, symbolCalls := e.checkTimeStops(tick, symbolCalls)
+	events = append(events, timeEvents...)
+	liqEvents, symbolCalls := e.checkLiquidations(tick, symbolCalls)
+	events = append(events, liqEvents...)
+	events = append(events, e.checkBreakEven(tick, symbolCalls)...)
+	events = append(events, e.updateTrailingStops(tick, symbolCalls)...)
+	events = append(events, e.checkStopLosses(tick, symbolCalls)...)
+	events = append(events, e.checkTakeProfits(tick, symbolCalls)...)
+
+	if e.Notifier != nil {
+		for _, ev := range events {
+			e.Notifier.Notify(ev)
+		}
+	}
+	return events
+}
+
+// checkAlerts проверяет ценовые (с допуском), процентные и составные алерты; сработавшие удаляются.
+func (e *Engine) checkAlerts(tick Tick, symbolAlerts []alerts.Alert) []Event {
+	currentPrice := tick.Price
+	logrus.WithFields(logrus.Fields{
+		"symbol": tick.Symbol,
+		"price":  currentPrice,
+		"count":  len(symbolAlerts),
+	}).Debug("checking alerts for symbol")
+
+	var events []Event
+	for _, alert := range symbolAlerts {
+		if alert.Kind == alerts.AlertKindExpression {
+			if ev, ok := e.checkExpressionAlert(tick, alert); ok {
+				events = append(events, ev)
+			}
+			continue
+		}
+		if alert.Kind == alerts.AlertKindIndicator {
+			continue // Проверяются на закрытии свечи в CheckIndicators
+		}
+
+		// Сработавший повторяющийся алерт ждет повторного взвода и сам не срабатывает
+		if alert.Recurring() && !alert.Armed {
+			e.maybeRearm(alert, tick)
+			continue
+		}
+
+		triggered := false
+		ev := AlertTriggered{Alert: alert, Price: currentPrice, BasePrice: alert.BasePrice, At: e.Clock.Now()}
+
+		// Проверка алерта по целевой цене: пересечение с исходной стороны
+		if alert.TargetPrice > 0 {
+			if priceAlertHit(alert, tick, e.PriceTolerance) {
+				triggered = true
+				ev.Kind = "price"
+				logrus.WithField("alert_id", alert.ID).Info("price alert triggered")
+			}
+		}
+
+		// Проверка алерта по проценту (с учетом направления)
+		if !triggered && alert.TargetPercent != 0 && alert.BasePrice > 0 {
+			changePct := ((currentPrice - alert.BasePrice) / alert.BasePrice) * 100
+			if (alert.TargetPercent > 0 && changePct >= alert.TargetPercent) ||
+				(alert.TargetPercent < 0 && changePct <= alert.TargetPercent) {
+				triggered = true
+				ev.Kind = "percent"
+				ev.ChangePercent = changePct
+				logrus.WithFields(logrus.Fields{
+					"alert_id":   alert.ID,
+					"change_pct": changePct,
+					"target_pct": alert.TargetPercent,
+				}).Info("percent alert triggered")
+			}
+		}
+
+		if !triggered {
+			continue
+		}
+
+		if err := e.Store.LogAlertTrigger(alert.ID, tick.Symbol, currentPrice, alert.ChatID, alert.UserID, alert.Username, ev.Kind); err != nil {
+			logrus.WithError(err).WithField("alert_id", alert.ID).Warn("failed to log alert trigger")
+		}
+
+		if alert.Recurring() {
+			// Повторяющийся алерт остается, но снимается со взвода до отхода цены или конца паузы
+			now := e.Clock.Now()
+			alert.Armed = false
+			alert.TriggerCount++
+			alert.LastTriggeredAt = &now
+			if ev.Kind == "percent" {
+				// Следующее изменение считается от цены срабатывания
+				alert.BasePrice = currentPrice
+			}
+			if err := e.Store.Update(alert); err != nil {
+				logrus.WithError(err).WithField("alert_id", alert.ID).Warn("failed to disarm recurring alert")
+			}
+			ev.Alert = alert
+			events = append(events, ev)
+			continue
+		}
+
+		// Удаляем сработавший алерт
+		if _, err := e.Store.DeleteByID(alert.ChatID, alert.ID); err != nil {
+			logrus.WithError(err).WithField("alert_id", alert.ID).Warn("failed to delete triggered alert")
+		} else {
+			logrus.WithFields(logrus.Fields{
+				"alert_id": alert.ID,
+				"symbol":   tick.Symbol,
+				"price":    currentPrice,
+			}).Info("alert triggered and deleted")
+		}
+
+		events = append(events, ev)
+	}
+	return events
+}
+
+// checkExpressionAlert вычисляет условие составного алерта. Цена символа тика берется из тика,
+// остальные величины — из Market. Повторяющийся алерт взводится снова, когда условие перестает
+// выполняться (и прошла пауза, если она задана).
+func (e *Engine) checkExpressionAlert(tick Tick, alert alerts.Alert) (AlertTriggered, bool) {
+	if e.Market == nil {
+		return AlertTriggered{}, false
+	}
+
+	expr, err := e.expression(alert)
+	if err != nil {
+		logrus.WithError(err).WithField("alert_id", alert.ID).Warn("invalid alert expression")
+		return AlertTriggered{}, false
+	}
+
+	holds, err := expr.Eval(tickMarket{MarketData: e.Market, tick: tick})
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"alert_id":   alert.ID,
+			"expression": alert.Expression,
+		}).Debug("failed to evaluate alert expression")
+		return AlertTriggered{}, false
+	}
+
+	if alert.Recurring() && !alert.Armed {
+		cooled := alert.CooldownSec <= 0 || alert.LastTriggeredAt == nil ||
+			e.Clock.Now().Sub(*alert.LastTriggeredAt) >= time.Duration(alert.CooldownSec)*time.Second
+		if !holds && cooled {
+			alert.Armed = true
+			if err := e.Store.Update(alert); err != nil {
+				logrus.WithError(err).WithField("alert_id", alert.ID).Warn("failed to rearm recurring alert")
+			} else {
+				logrus.WithField("alert_id", alert.ID).Info("recurring expression alert rearmed")
+			}
+		}
+		return AlertTriggered{}, false
+	}
+
+	if !holds {
+		return AlertTriggered{}, false
+	}
+
+	ev := AlertTriggered{Alert: alert, Price: tick.Price, Kind: "expression", At: e.Clock.Now()}
+	logrus.WithFields(logrus.Fields{
+		"alert_id":   alert.ID,
+		"expression": alert.Expression,
+		"tick":       tick.Symbol,
+	}).Info("expression alert triggered")
+
+	if err := e.Store.LogAlertTrigger(alert.ID, tick.Symbol, tick.Price, alert.ChatID, alert.UserID, alert.Username, ev.Kind); err != nil {
+		logrus.WithError(err).WithField("alert_id", alert.ID).Warn("failed to log alert trigger")
+	}
+
+	if alert.Recurring() {
+		now := e.Clock.Now()
+		alert.Armed = false
+		alert.TriggerCount++
+		alert.LastTriggeredAt = &now
+		if err := e.Store.Update(alert); err != nil {
+			logrus.WithError(err).WithField("alert_id", alert.ID).Warn("failed to disarm recurring alert")
+		}
+		ev.Alert = alert
+		return ev, true
+	}
+
+	if _, err := e.Store.DeleteByID(alert.ChatID, alert.ID); err != nil {
+		logrus.WithError(err).WithField("alert_id", alert.ID).Warn("failed to delete triggered alert")
+	}
+	e.mu.Lock()
+	delete(e.exprs, alert.ID)
+	e.mu.Unlock()
+	return ev, true
+}
+
+// expression возвращает разобранное условие алерта, разбирая его один раз.
+func (e *Engine) expression(alert alerts.Alert) (Expr, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if cached, ok := e.exprs[alert.ID]; ok && cached.text == alert.Expression {
+		return cached.expr, nil
+	}
+	expr, err := ParseExpr(alert.Expression, nil)
+	if err != nil {
+		return nil, err
+	}
+	e.exprs[alert.ID] = parsedExpr{text: alert.Expression, expr: expr}
+	return expr, nil
+}
+
+// tickMarket подставляет цену из тика для его символа.
+type tickMarket struct {
+	MarketData
+	tick Tick
+}
+
+func (m tickMarket) Price(symbol string) (float64, error) {
+	if symbol == m.tick.Symbol && m.tick.Price > 0 {
+		return m.tick.Price, nil
+	}
+	return m.MarketData.Price(symbol)
+}
+
+// DefaultRearmPercent расстояние от цели для повторного взвода, если не задано ни расстояние, ни пауза.
+const DefaultRearmPercent = 1.0
+
+// maybeRearm взводит повторяющийся алерт, если прошла пауза после срабатывания или цена
+// отошла от цели на RearmPercent. Процентные алерты без паузы взводятся сразу от новой базы.
+func (e *Engine) maybeRearm(alert alerts.Alert, tick Tick) {
+	now := e.Clock.Now()
+
+	rearm := false
+	if alert.CooldownSec > 0 && alert.LastTriggeredAt != nil &&
+		now.Sub(*alert.LastTriggeredAt) >= time.Duration(alert.CooldownSec)*time.Second {
+		rearm = true
+	}
+
+	if alert.TargetPrice > 0 {
+		distance := alert.RearmPercent
+		if distance <= 0 && alert.CooldownSec <= 0 {
+			distance = DefaultRearmPercent
+		}
+		if distance > 0 && math.Abs(tick.Price-alert.TargetPrice)/alert.TargetPrice*100 >= distance {
+			rearm = true
+		}
+	} else if alert.TargetPercent != 0 && alert.CooldownSec <= 0 {
+		rearm = true
+	}
+
+	if !rearm {
+		return
+	}
+
+	alert.Armed = true
+	if alert.TargetPrice > 0 {
+		// Новая сторона: следующее срабатывание — при возврате цены к уровню
+		alert.Side = SideFor(alert.TargetPrice, tick.Price)
+	}
+	if err := e.Store.Update(alert); err != nil {
+		logrus.WithError(err).WithField("alert_id", alert.ID).Warn("failed to rearm recurring alert")
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"alert_id": alert.ID,
+		"symbol":   alert.Symbol,
+		"price":    tick.Price,
+		"side":     alert.Side,
+	}).Info("recurring alert rearmed")
+}
+
+// checkSharpChange сравнивает цену с ценой SharpChangeInterval назад (или с ценой последнего
+// уведомления, если оно было внутри окна) и сообщает всем владельцам алертов и коллов на символ.
+func (e *Engine) checkSharpChange(tick Tick) []Event {
+	if e.SharpChangePercent <= 0 || e.SharpChangeInterval <= 0 {
+		return nil
+	}
+
+	symbol := tick.Symbol
+	currentPrice := tick.Price
+	now := e.Clock.Now()
+
+	e.mu.Lock()
+	last, exists := e.lastSharp[symbol]
+	e.mu.Unlock()
+
+	// Последующие уведомления считаются от цены последнего срабатывания
+	var oldPrice float64
+	if exists && now.Sub(last.at) < e.SharpChangeInterval {
+		oldPrice = last.price
+	} else {
+		oldPrice = e.sharpReference(symbol, now)
+	}
+	if oldPrice <= 0 {
+		return nil
+	}
+
+	changePct := ((currentPrice - oldPrice) / oldPrice) * 100
+	logrus.WithFields(logrus.Fields{
+		"symbol":        symbol,
+		"current_price": currentPrice,
+		"old_price":     oldPrice,
+		"change_pct":    changePct,
+		"threshold":     e.SharpChangePercent,
+		"interval":      e.SharpChangeInterval.String(),
+	}).Debug("checking sharp change")
+
+	if math.Abs(changePct) < e.SharpChangePercent {
+		return nil
+	}
+
+	e.mu.Lock()
+	last, exists = e.lastSharp[symbol]
+	if exists && now.Sub(last.at) < e.SharpChangeCooldown {
+		e.mu.Unlock()
+		logrus.WithFields(logrus.Fields{
+			"symbol":              symbol,
+			"change_pct":          changePct,
+			"last_alert_time_ago": now.Sub(last.at).String(),
+		}).Debug("sharp change detected but alert suppressed due to recent notification")
+		return nil
+	}
+	e.lastSharp[symbol] = sharpState{at: now, price: currentPrice}
+	e.mu.Unlock()
+
+	// Уникальные получатели: владельцы алертов и открытых коллов на символ
+	recipients := make(map[int64]Recipient)
+	for _, alert := range e.Store.GetBySymbol(symbol) {
+		recipients[alert.ChatID] = Recipient{ChatID: alert.ChatID, UserID: alert.UserID, Username: alert.Username}
+	}
+	for _, call := range e.Store.GetAllOpenCalls() {
+		if call.Symbol == symbol {
+			recipients[call.ChatID] = Recipient{ChatID: call.ChatID, UserID: call.UserID, Username: call.Username}
+		}
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	ev := SharpMove{
+		Sym:           symbol,
+		OldPrice:      oldPrice,
+		Price:         currentPrice,
+		ChangePercent: changePct,
+		Interval:      e.SharpChangeInterval,
+		At:            now,
+	}
+	for _, r := range recipients {
+		ev.Recipients = append(ev.Recipients, r)
+		if err := e.Store.LogAlertTrigger("", symbol, currentPrice, r.ChatID, r.UserID, r.Username, "sharp_change"); err != nil {
+			logrus.WithError(err).WithField("symbol", symbol).Warn("failed to log sharp change")
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"symbol":         symbol,
+		"change_pct":     changePct,
+		"interval":       e.SharpChangeInterval.String(),
+		"notified_chats": len(recipients),
+	}).Info("sharp change alert sent")
+
+	return []Event{ev}
+}
+
+// sharpReference возвращает цену символа SharpChangeInterval назад. Тики из потока приходят чаще,
+// чем раз в секунду, поэтому цена запрашивается через REST не чаще раза в SharpChangeInterval
+// (после ошибки — не чаще раза в sharpRefRetry), а между запросами берется из кеша.
+// Вызывается под alertMu монитора, поэтому блокирующий запрос на каждом тике недопустим.
+func (e *Engine) sharpReference(symbol string, now time.Time) float64 {
+	e.mu.Lock()
+	ref, cached := e.sharpRefs[symbol]
+	e.mu.Unlock()
+
+	ttl := e.SharpChangeInterval
+	if cached && ref.price <= 0 {
+		ttl = min(ttl, sharpRefRetry)
+	}
+	if cached && now.Sub(ref.at) < ttl {
+		return ref.price
+	}
+
+	exchange, market := e.Prices.PreferredSource(symbol)
+	price, err := e.Prices.HistoricalPrice(symbol, now.Add(-e.SharpChangeInterval), exchange, market)
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"symbol":   symbol,
+			"interval": e.SharpChangeInterval.String(),
+		}).Debug("failed to get historical price for sharp change check")
+		price = 0
+	}
+
+	e.mu.Lock()
+	e.sharpRefs[symbol] = sharpState{at: now, price: price}
+	e.mu.Unlock()
+	return price
+}
+
+// checkLimitOrders исполняет ордера символа по их типу: limit — long, когда цена опустилась до/ниже лимита,
+// short — поднялась до/выше; stop — long на пробое вверх до/выше стоп-цены, short — вниз; stop_limit после
+// стоп-цены становится лимитным. Исполнение ордера (или срабатывание стопа stop_limit) отменяет остальные
+// ордера его OCO-группы.
+func (e *Engine) checkLimitOrders(tick Tick, orders []alerts.LimitOrder) []Event {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	currentPrice := tick.Price
+	logrus.WithFields(logrus.Fields{
+		"symbol": tick.Symbol,
+		"price":  currentPrice,
+		"count":  len(orders),
+	}).Debug("checking limit orders for symbol")
+
+	var events []Event
+	now := e.Clock.Now()
+	cancelled := make(map[string]bool)
+	for _, order := range orders {
+		// Истекший ордер не исполняется, даже если CheckOrderExpiry еще не успел его отменить
+		if cancelled[order.ID] || order.Expired(now) {
+			continue
+		}
+
+		if order.WaitingForStop() {
+			if !order.StopReached(currentPrice) {
+				continue
+			}
+			if order.OrderType == alerts.OrderTypeStopLimit {
+				siblings, err := e.Store.ActivateStopLimit(order)
+				if errors.Is(err, alerts.ErrOrderNotActive) {
+					continue
+				}
+				if err != nil {
+					logrus.WithError(err).WithField("order_id", order.ID).Error("failed to activate stop-limit order")
+					continue
+				}
+				order.StopTriggered = true
+				events = append(events, e.ocoCancelled(order, siblings, currentPrice, cancelled)...)
+				if !order.LimitReached(currentPrice) {
+					continue
+				}
+			}
+		} else if !order.LimitReached(currentPrice) {
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"order_id":        order.ID,
+			"symbol":          order.Symbol,
+			"direction":       order.Direction,
+			"order_type":      order.OrderType,
+			"limit_price":     order.LimitPrice,
+			"stop_price":      order.StopPrice,
+			"current_price":   currentPrice,
+			"related_call_id": order.RelatedCallID,
+		}).Info("limit order triggered")
+
+		ev, siblings := e.fillLimitOrder(tick, order)
+		if ev != nil {
+			events = append(events, ev)
+		}
+		events = append(events, e.ocoCancelled(order, siblings, currentPrice, cancelled)...)
+	}
+	return events
+}
+
+// ocoCancelled отмечает ордера OCO-группы order, отмененные вместе с его исполнением или срабатыванием
+// стопа, в cancelled, чтобы они не исполнились на этом же тике, и возвращает событие об отмене.
+func (e *Engine) ocoCancelled(order alerts.LimitOrder, ids []string, price float64, cancelled map[string]bool) []Event {
+	if len(ids) == 0 {
+		return nil
+	}
+	for _, id := range ids {
+		cancelled[id] = true
+	}
+	return []Event{OcoCancelled{Order: order, CancelledIDs: ids, Price: price, At: e.Clock.Now()}}
+}
+
+// fillLimitOrder исполняет один ордер и возвращает событие (nil, если ордер отменен или уже не активен,
+// без уведомления) и ID ордеров OCO-группы, отмененных в той же транзакции, что и исполнение.
+func (e *Engine) fillLimitOrder(tick Tick, order alerts.LimitOrder) (Event, []string) {
+	currentPrice := tick.Price
+	now := e.Clock.Now()
+
+	// Ордер на закрытие колла
+	if order.RelatedCallID != "" {
+		call, err := e.Store.GetCallByID(order.RelatedCallID, order.UserID)
+		if err != nil {
+			logrus.WithError(err).WithField("order_id", order.ID).Error("failed to get call for limit order")
+			e.Store.CancelLimitOrder(order.ID, order.UserID)
+			return nil, nil
+		}
+		if call.Status != "open" {
+			logrus.WithField("order_id", order.ID).Warn("call already closed, cancelling limit order")
+			e.Store.CancelLimitOrder(order.ID, order.UserID)
+			return nil, nil
+		}
+
+		siblings, err := e.Store.CloseCallByOrder(order, currentPrice)
+		if errors.Is(err, alerts.ErrOrderNotActive) {
+			logrus.WithField("order_id", order.ID).Info("limit order no longer active, fill skipped")
+			return nil, nil
+		}
+		if err != nil {
+			logrus.WithError(err).WithField("order_id", order.ID).Error("failed to close call by limit order")
+			return LimitFailed{Order: order, Price: currentPrice, Err: err, At: now}, nil
+		}
+
+		var closedPercent float64
+		if call.Size > 0 {
+			closedPercent = order.SizeToClose / call.Size * 100
+		}
+		updatedCall, _ := e.Store.GetCallByID(order.RelatedCallID, order.UserID)
+
+		return LimitFilled{Order: order, Price: currentPrice, Call: updatedCall, ClosedPercent: closedPercent, At: now}, siblings
+	}
+
+	// Ордер на открытие позиции
+	exchange, market := tick.Exchange, tick.Market
+	if exchange == "" || market == "" {
+		exchange, market = e.Prices.PreferredSource(tick.Symbol)
+	}
+
+	candidate := alerts.Call{
+		UserID:         order.UserID,
+		Username:       order.Username,
+		ChatID:         order.ChatID,
+		Symbol:         tick.Symbol,
+		Direction:      order.Direction,
+		EntryPrice:     currentPrice,
+		Market:         market,
+		DepositPercent: order.DepositPercent,
+		Exchange:       exchange,
+		TakerFee:       e.takerFee(exchange, market),
+		StopLossPrice:  order.StopLossPrice,
+		Leverage:       order.Leverage,
+	}
+
+	// Риск-правила пользователя и чата проверяются в транзакции исполнения
+	call, siblings, err := e.Store.OpenCallByOrder(order, candidate)
+	if errors.Is(err, alerts.ErrOrderNotActive) {
+		logrus.WithField("order_id", order.ID).Info("limit order no longer active, fill skipped")
+		return nil, nil
+	}
+	var violation *alerts.RiskViolation
+	if errors.As(err, &violation) {
+		logrus.WithError(err).WithField("order_id", order.ID).Info("limit order rejected by risk rules")
+		if err := e.Store.CancelLimitOrder(order.ID, order.UserID); err != nil {
+			logrus.WithError(err).WithField("order_id", order.ID).Warn("failed to cancel rejected limit order")
+		}
+		return LimitRejected{Order: order, Price: currentPrice, Reason: err, At: now}, nil
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("order_id", order.ID).Error("failed to open call by limit order")
+		return LimitFailed{Order: order, Price: currentPrice, Err: err, At: now}, nil
+	}
+
+	return LimitFilled{Order: order, Price: currentPrice, Call: &call, At: now}, siblings
+}
+
+// checkTakeProfits исполняет ноги тейк-профита: long — цена поднялась до/выше цели,
+// short — опустилась до/ниже. Последняя активная нога закрывает весь остаток позиции.
+// Вызывается после стоп-лоссов: у закрытого по стопу колла ноги уже отменены.
+func (e *Engine) checkTakeProfits(tick Tick, symbolCalls []alerts.Call) []Event {
+	currentPrice := tick.Price
+
+	var events []Event
+	for _, call := range symbolCalls {
+		legs := e.Store.GetActiveTakeProfits(call.ID)
+		size := call.Size
+		for i, leg := range legs {
+			if size < 0.001 {
+				break
+			}
+			if !(call.Direction == "long" && currentPrice >= leg.Price) &&
+				!(call.Direction == "short" && currentPrice <= leg.Price) {
+				continue
+			}
+
+			// Последняя нога закрывает остаток, прочитанный в транзакции закрытия
+			sizeToClose := math.Min(leg.Percent, size)
+			closeSize := sizeToClose
+			if i == len(legs)-1 {
+				sizeToClose, closeSize = size, alerts.CloseRemaining
+			}
+
+			logrus.WithFields(logrus.Fields{
+				"call_id":       call.ID,
+				"symbol":        call.Symbol,
+				"direction":     call.Direction,
+				"tp_price":      leg.Price,
+				"current_price": currentPrice,
+				"size_to_close": sizeToClose,
+			}).Info("take-profit triggered")
+
+			// Нога отмечается исполненной в транзакции закрытия: полное закрытие колла отменяет оставшиеся ноги
+			err := e.Store.CloseCallByTakeProfit(leg, call.UserID, currentPrice, closeSize)
+			if errors.Is(err, alerts.ErrTakeProfitNotActive) {
+				logrus.WithField("take_profit_id", leg.ID).Info("take-profit no longer active, fill skipped")
+				continue
+			}
+			if err != nil {
+				logrus.WithError(err).WithField("call_id", call.ID).Error("failed to close call by take-profit")
+				break
+			}
+			size -= sizeToClose
+
+			updated := call
+			if c, err := e.Store.GetCallByID(call.ID, call.UserID); err == nil {
+				updated = *c
+			} else {
+				updated.Size = size
+			}
+			events = append(events, TakeProfitHit{
+				Leg:           leg,
+				Price:         currentPrice,
+				Call:          updated,
+				ClosedPercent: sizeToClose,
+				At:            e.Clock.Now(),
+			})
+		}
+	}
+	return events
+}
+
+// updateTrailingStops подтягивает стоп-лоссы коллов с трейлинг-стопом за лучшей ценой с момента активации.
+// Стоп только улучшается; обновленные значения записываются в symbolCalls, чтобы проверка стоп-лоссов
+// на этом же тике видела новый стоп.
+func (e *Engine) updateTrailingStops(tick Tick, symbolCalls []alerts.Call) []Event {
+	currentPrice := tick.Price
+
+	var events []Event
+	for i := range symbolCalls {
+		call := &symbolCalls[i]
+		if !call.Trailing() || call.EntryPrice <= 0 {
+			continue
+		}
+
+		best := call.TrailBestPrice
+		activated := false
+		if best == 0 && call.TrailActivation > 0 {
+			var profit float64
+			if call.Direction == "short" {
+				profit = (call.EntryPrice - currentPrice) / call.EntryPrice * 100
+			} else {
+				profit = (currentPrice - call.EntryPrice) / call.EntryPrice * 100
+			}
+			if profit < call.TrailActivation {
+				continue
+			}
+			activated = true
+		}
+		if best == 0 {
+			best = currentPrice
+		}
+
+		var stop float64
+		if call.Direction == "short" {
+			best = math.Min(best, currentPrice)
+			stop = best + call.TrailDistance
+			if call.TrailPercent > 0 {
+				stop = best * (1 + call.TrailPercent/100)
+			}
+			if call.StopLossPrice > 0 && stop > call.StopLossPrice {
+				stop = call.StopLossPrice
+			}
+		} else {
+			best = math.Max(best, currentPrice)
+			stop = best - call.TrailDistance
+			if call.TrailPercent > 0 {
+				stop = best * (1 - call.TrailPercent/100)
+			}
+			if stop < call.StopLossPrice {
+				stop = call.StopLossPrice
+			}
+		}
+
+		if best == call.TrailBestPrice && stop == call.StopLossPrice {
+			continue
+		}
+		moved, err := e.Store.UpdateTrailingStop(call.ID, best, stop)
+		if err != nil {
+			logrus.WithError(err).WithField("call_id", call.ID).Error("failed to update trailing stop")
+			continue
+		}
+
+		call.TrailBestPrice = best
+		// Стоп не сдвигается, если в базе уже стоит более тугой, например подтянутый через /sl
+		if moved {
+			logrus.WithFields(logrus.Fields{
+				"call_id":    call.ID,
+				"symbol":     call.Symbol,
+				"best_price": best,
+				"old_stop":   call.StopLossPrice,
+				"new_stop":   stop,
+			}).Info("trailing stop moved")
+			call.StopLossPrice = stop
+		}
+
+		if activated {
+			events = append(events, TrailingStopActivated{Call: *call, Price: currentPrice, At: e.Clock.Now()})
+		}
+	}
+	return events
+}
+
+// checkStopLosses закрывает коллы, цена которых достигла стоп-лосса (в том числе внутри тика
+// по минимуму для long и максимуму для short), и отменяет их лимитные ордера.
+// Если цена вернулась за стоп к концу тика, колл закрывается по цене стопа, иначе — по текущей.
+func (e *Engine) checkStopLosses(tick Tick, symbolCalls []alerts.Call) []Event {
+	currentPrice := tick.Price
+	low, high := tick.Range()
+
+	var events []Event
+	for _, call := range symbolCalls {
+		if call.StopLossPrice <= 0 {
+			continue
+		}
+		exitPrice := currentPrice
+		switch {
+		case call.Direction == "long" && low <= call.StopLossPrice:
+			exitPrice = math.Min(currentPrice, call.StopLossPrice)
+		case call.Direction == "short" && high >= call.StopLossPrice:
+			exitPrice = math.Max(currentPrice, call.StopLossPrice)
+		default:
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"call_id":         call.ID,
+			"symbol":          call.Symbol,
+			"current_price":   currentPrice,
+			"exit_price":      exitPrice,
+			"stop_loss_price": call.StopLossPrice,
+			"direction":       call.Direction,
+		}).Info("stop-loss triggered")
+
+		// Закрываем колл полностью оставшимся размером
+		if err := e.Store.CloseCall(call.ID, call.UserID, exitPrice, alerts.CloseRemaining, alerts.FillSourceStopLoss); err != nil {
+			logrus.WithError(err).WithField("call_id", call.ID).Error("failed to close call by stop-loss")
+			continue
+		}
+		if err := e.Store.CancelLimitOrdersByCallID(call.ID); err != nil {
+			logrus.WithError(err).Warn("failed to cancel limit orders after stop-loss")
+		}
+
+		events = append(events, StopLossHit{Call: call, Price: exitPrice, At: e.Clock.Now()})
+	}
+	return events
+}
@@ -0,0 +1,531 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"example.com/alert-bot/internal/alerts"
+
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	logrus.SetOutput(io.Discard)
+}
+
+// fakeStore хранилище в памяти с поведением alerts.DatabaseStorage, достаточным для OnTick.
+// Закрытия коллов записываются в closes в виде "callID size@price source".
+type fakeStore struct {
+	alerts map[string]alerts.Alert
+	calls  map[string]*alerts.Call
+	orders map[string]*alerts.LimitOrder
+	legs   map[string][]alerts.TakeProfit
+	rules  alerts.RiskRules
+	closes []string
+	nextID int
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		alerts: make(map[string]alerts.Alert),
+		calls:  make(map[string]*alerts.Call),
+		orders: make(map[string]*alerts.LimitOrder),
+		legs:   make(map[string][]alerts.TakeProfit),
+	}
+}
+
+func (s *fakeStore) addCall(call alerts.Call) *fakeStore {
+	call.Status = "open"
+	if call.Size == 0 {
+		call.Size = 100
+	}
+	s.calls[call.ID] = &call
+	return s
+}
+
+func (s *fakeStore) addOrder(order alerts.LimitOrder) *fakeStore {
+	order.Status = "active"
+	if order.OrderType == "" {
+		order.OrderType = alerts.OrderTypeLimit
+	}
+	s.orders[order.ID] = &order
+	return s
+}
+
+func (s *fakeStore) addLegs(callID string, legs ...alerts.TakeProfit) *fakeStore {
+	for _, leg := range legs {
+		s.nextID++
+		leg.ID = int64(s.nextID)
+		leg.CallID = callID
+		leg.Status = alerts.TakeProfitActive
+		s.legs[callID] = append(s.legs[callID], leg)
+	}
+	return s
+}
+
+func (s *fakeStore) addAlerts(list ...alerts.Alert) *fakeStore {
+	for _, a := range list {
+		s.alerts[a.ID] = a
+	}
+	return s
+}
+
+func (s *fakeStore) withRules(rules alerts.RiskRules) *fakeStore {
+	s.rules = rules
+	return s
+}
+
+func (s *fakeStore) GetBySymbol(symbol string) []alerts.Alert {
+	var result []alerts.Alert
+	for _, a := range s.alerts {
+		if a.Symbol == symbol {
+			result = append(result, a)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+func (s *fakeStore) GetByKind(kind string) []alerts.Alert {
+	var result []alerts.Alert
+	for _, a := range s.alerts {
+		if a.Kind == kind {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+func (s *fakeStore) Update(alert alerts.Alert) error {
+	s.alerts[alert.ID] = alert
+	return nil
+}
+
+func (s *fakeStore) DeleteByID(chatID int64, id string) (bool, error) {
+	_, ok := s.alerts[id]
+	delete(s.alerts, id)
+	return ok, nil
+}
+
+func (s *fakeStore) LogAlertTrigger(string, string, float64, int64, int64, string, string) error {
+	return nil
+}
+
+func (s *fakeStore) GetAllOpenCalls() []alerts.Call {
+	return s.GetUserCalls(0, true)
+}
+
+// GetUserCalls возвращает коллы пользователя по ID; userID 0 — коллы всех пользователей.
+func (s *fakeStore) GetUserCalls(userID int64, onlyOpen bool) []alerts.Call {
+	var result []alerts.Call
+	for _, c := range s.calls {
+		if (userID == 0 || c.UserID == userID) && (!onlyOpen || c.Status == "open") {
+			result = append(result, *c)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+func (s *fakeStore) GetCallByID(callID string, userID int64) (*alerts.Call, error) {
+	c, ok := s.calls[callID]
+	if !ok {
+		return nil, errors.New("call not found")
+	}
+	call := *c
+	return &call, nil
+}
+
+func (s *fakeStore) OpenCall(call alerts.Call, source string) (alerts.Call, error) {
+	s.nextID++
+	call.ID = fmt.Sprintf("c%d", s.nextID)
+	s.addCall(call)
+	return *s.calls[call.ID], nil
+}
+
+func (s *fakeStore) CloseCall(callID string, userID int64, exitPrice float64, sizeToClose float64, source string) error {
+	c, ok := s.calls[callID]
+	if !ok || c.Status != "open" {
+		return errors.New("call is not open")
+	}
+	if sizeToClose == alerts.CloseRemaining {
+		sizeToClose = c.Size
+	}
+	if sizeToClose <= 0 || sizeToClose > c.Size {
+		return fmt.Errorf("size %g is out of range, call size %g", sizeToClose, c.Size)
+	}
+	s.closes = append(s.closes, fmt.Sprintf("%s %g@%g %s", callID, sizeToClose, exitPrice, source))
+	c.Size -= sizeToClose
+	if c.Size < 0.001 {
+		// Полное закрытие отменяет оставшиеся ноги тейк-профита
+		c.Status = "closed"
+		for i := range s.legs[callID] {
+			if s.legs[callID][i].Status == alerts.TakeProfitActive {
+				s.legs[callID][i].Status = alerts.TakeProfitCancelled
+			}
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) GetLimitOrdersBySymbol(symbol string) []alerts.LimitOrder {
+	var result []alerts.LimitOrder
+	for _, o := range s.orders {
+		if o.Symbol == symbol && o.Status == "active" {
+			result = append(result, *o)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// fill помечает ордер исполненным и отменяет остальные активные ордера его OCO-группы.
+func (s *fakeStore) fill(order alerts.LimitOrder) ([]string, error) {
+	o, ok := s.orders[order.ID]
+	if !ok || o.Status != "active" {
+		return nil, alerts.ErrOrderNotActive
+	}
+	o.Status = "triggered"
+	return s.cancelSiblings(*o), nil
+}
+
+func (s *fakeStore) cancelSiblings(order alerts.LimitOrder) []string {
+	if order.OcoGroup == "" {
+		return nil
+	}
+	var ids []string
+	for _, o := range s.orders {
+		if o.OcoGroup == order.OcoGroup && o.ID != order.ID && o.Status == "active" {
+			o.Status = "cancelled"
+			ids = append(ids, o.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (s *fakeStore) ActivateStopLimit(order alerts.LimitOrder) ([]string, error) {
+	o, ok := s.orders[order.ID]
+	if !ok || o.Status != "active" || o.StopTriggered {
+		return nil, alerts.ErrOrderNotActive
+	}
+	o.StopTriggered = true
+	return s.cancelSiblings(*o), nil
+}
+
+func (s *fakeStore) CloseCallByOrder(order alerts.LimitOrder, exitPrice float64) ([]string, error) {
+	siblings, err := s.fill(order)
+	if err != nil {
+		return nil, err
+	}
+	return siblings, s.CloseCall(order.RelatedCallID, order.UserID, exitPrice, order.SizeToClose, alerts.FillSourceLimit)
+}
+
+// OpenCallByOrder проверяет риск-правила после проверки ордера и до его исполнения, как транзакция хранилища.
+func (s *fakeStore) OpenCallByOrder(order alerts.LimitOrder, candidate alerts.Call) (alerts.Call, []string, error) {
+	if o, ok := s.orders[order.ID]; !ok || o.Status != "active" {
+		return alerts.Call{}, nil, alerts.ErrOrderNotActive
+	}
+	if err := s.rules.Check(s.GetUserCalls(candidate.UserID, true), candidate); err != nil {
+		return alerts.Call{}, nil, err
+	}
+	siblings, err := s.fill(order)
+	if err != nil {
+		return alerts.Call{}, nil, err
+	}
+	call, err := s.OpenCall(candidate, alerts.FillSourceLimit)
+	return call, siblings, err
+}
+
+func (s *fakeStore) GetExpiredLimitOrders(time.Time) []alerts.LimitOrder { return nil }
+
+func (s *fakeStore) ExpireLimitOrder(string) (bool, error) { return false, nil }
+
+func (s *fakeStore) CancelLimitOrder(orderID string, userID int64) error {
+	if o, ok := s.orders[orderID]; ok && o.Status == "active" {
+		o.Status = "cancelled"
+	}
+	return nil
+}
+
+func (s *fakeStore) CancelLimitOrdersByCallID(callID string) error {
+	for _, o := range s.orders {
+		if o.RelatedCallID == callID && o.Status == "active" {
+			o.Status = "cancelled"
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) UpdateTrailingStop(callID string, bestPrice, stopLossPrice float64) (bool, error) {
+	c := s.calls[callID]
+	c.TrailBestPrice, c.StopLossPrice = bestPrice, stopLossPrice
+	return true, nil
+}
+
+func (s *fakeStore) UpdateCallRules(call alerts.Call) error {
+	*s.calls[call.ID] = call
+	return nil
+}
+
+func (s *fakeStore) TightenStopLoss(callID string, stopLossPrice float64) (bool, error) {
+	c := s.calls[callID]
+	tighter := stopLossPrice > c.StopLossPrice
+	if c.Direction == "short" {
+		tighter = stopLossPrice < c.StopLossPrice
+	}
+	if c.StopLossPrice <= 0 || tighter {
+		c.StopLossPrice = stopLossPrice
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *fakeStore) GetActiveTakeProfits(callID string) []alerts.TakeProfit {
+	var result []alerts.TakeProfit
+	for _, leg := range s.legs[callID] {
+		if leg.Status == alerts.TakeProfitActive {
+			result = append(result, leg)
+		}
+	}
+	return result
+}
+
+func (s *fakeStore) CloseCallByTakeProfit(leg alerts.TakeProfit, userID int64, exitPrice, sizeToClose float64) error {
+	legs := s.legs[leg.CallID]
+	for i := range legs {
+		if legs[i].ID == leg.ID && legs[i].Status == alerts.TakeProfitActive {
+			// Нога отмечается до закрытия и возвращается в активные, если закрытие не удалось
+			legs[i].Status = alerts.TakeProfitFilled
+			if err := s.CloseCall(leg.CallID, userID, exitPrice, sizeToClose, alerts.FillSourceTakeProfit); err != nil {
+				legs[i].Status = alerts.TakeProfitActive
+				return err
+			}
+			return nil
+		}
+	}
+	return alerts.ErrTakeProfitNotActive
+}
+
+func (s *fakeStore) AccrueFunding(string, float64, time.Time) error { return nil }
+
+// fakeClock фиксированное время.
+type fakeClock struct{ now time.Time }
+
+func (c fakeClock) Now() time.Time { return c.now }
+
+// fakeNotifier запоминает доставленные события.
+type fakeNotifier struct{ events []Event }
+
+func (n *fakeNotifier) Notify(ev Event) { n.events = append(n.events, ev) }
+
+// noPrices источник без истории цен: резкие движения в этих тестах не проверяются.
+type noPrices struct{}
+
+func (noPrices) HistoricalPrice(string, time.Time, string, string) (float64, error) {
+	return 0, errors.New("no history")
+}
+
+func (noPrices) PreferredSource(string) (string, string) { return "Bitget", "futures" }
+
+// describe краткое описание события для сравнения в тестах.
+func describe(ev Event) string {
+	switch ev := ev.(type) {
+	case AlertTriggered:
+		return fmt.Sprintf("alert %s %s", ev.Alert.ID, ev.Kind)
+	case StopLossHit:
+		return fmt.Sprintf("sl %s @%g", ev.Call.ID, ev.Price)
+	case TakeProfitHit:
+		return fmt.Sprintf("tp %s %g%% @%g", ev.Call.ID, ev.ClosedPercent, ev.Price)
+	case LimitFilled:
+		return fmt.Sprintf("fill %s @%g", ev.Order.ID, ev.Price)
+	case OcoCancelled:
+		return fmt.Sprintf("oco %s %v", ev.Order.ID, ev.CancelledIDs)
+	case LimitRejected:
+		return fmt.Sprintf("rejected %s", ev.Order.ID)
+	}
+	return fmt.Sprintf("%T", ev)
+}
+
+func TestOnTick(t *testing.T) {
+	long := alerts.Call{ID: "c-long", UserID: 1, ChatID: 1, Symbol: "BTCUSDT", Direction: "long", EntryPrice: 100}
+	short := alerts.Call{ID: "c-short", UserID: 1, ChatID: 1, Symbol: "BTCUSDT", Direction: "short", EntryPrice: 100}
+	withStop := func(call alerts.Call, stop float64) alerts.Call {
+		call.StopLossPrice = stop
+		return call
+	}
+
+	tests := []struct {
+		name   string
+		store  *fakeStore
+		ticks  []Tick
+		events []string
+		closes []string
+		check  func(t *testing.T, s *fakeStore)
+	}{
+		{
+			name: "алерт выше цели срабатывает по максимуму тика",
+			store: newFakeStore().addAlerts(
+				alerts.Alert{ID: "up", Symbol: "BTCUSDT", TargetPrice: 105, Side: alerts.SideAbove},
+				alerts.Alert{ID: "down", Symbol: "BTCUSDT", TargetPrice: 95, Side: alerts.SideBelow},
+			),
+			ticks:  []Tick{{Symbol: "BTCUSDT", Prev: 100, Price: 103, High: 106}},
+			events: []string{"alert up price"},
+			check: func(t *testing.T, s *fakeStore) {
+				if _, ok := s.alerts["up"]; ok {
+					t.Error("сработавший алерт не удален")
+				}
+				if _, ok := s.alerts["down"]; !ok {
+					t.Error("несработавший алерт удален")
+				}
+			},
+		},
+		{
+			name: "алерт ниже цели не срабатывает, пока цена выше",
+			store: newFakeStore().addAlerts(
+				alerts.Alert{ID: "down", Symbol: "BTCUSDT", TargetPrice: 95, Side: alerts.SideBelow},
+			),
+			ticks:  []Tick{{Symbol: "BTCUSDT", Prev: 100, Price: 97, Low: 96}, {Symbol: "BTCUSDT", Prev: 97, Price: 94}},
+			events: []string{"alert down price"},
+		},
+		{
+			name: "процентный алерт",
+			store: newFakeStore().addAlerts(
+				alerts.Alert{ID: "pct", Symbol: "BTCUSDT", BasePrice: 100, TargetPercent: -5},
+			),
+			ticks:  []Tick{{Symbol: "BTCUSDT", Price: 96}, {Symbol: "BTCUSDT", Price: 95}},
+			events: []string{"alert pct percent"},
+		},
+		{
+			name:   "стоп-лосс long по минимуму тика закрывается по цене стопа",
+			store:  newFakeStore().addCall(withStop(long, 95)),
+			ticks:  []Tick{{Symbol: "BTCUSDT", Prev: 98, Price: 97, Low: 94}},
+			events: []string{"sl c-long @95"},
+			closes: []string{"c-long 100@95 sl"},
+		},
+		{
+			name:   "стоп-лосс short при гэпе закрывается по текущей цене",
+			store:  newFakeStore().addCall(withStop(short, 105)),
+			ticks:  []Tick{{Symbol: "BTCUSDT", Prev: 101, Price: 107}},
+			events: []string{"sl c-short @107"},
+			closes: []string{"c-short 100@107 sl"},
+		},
+		{
+			name:   "стоп-лосс отменяет ордера колла",
+			store:  newFakeStore().addCall(withStop(long, 95)).addOrder(alerts.LimitOrder{ID: "o1", Symbol: "BTCUSDT", Direction: "short", LimitPrice: 120, RelatedCallID: "c-long", SizeToClose: 50}),
+			ticks:  []Tick{{Symbol: "BTCUSDT", Price: 94}},
+			events: []string{"sl c-long @94"},
+			closes: []string{"c-long 100@94 sl"},
+			check: func(t *testing.T, s *fakeStore) {
+				if s.orders["o1"].Status != "cancelled" {
+					t.Errorf("ордер колла в статусе %s, ожидалось cancelled", s.orders["o1"].Status)
+				}
+			},
+		},
+		{
+			name: "лесенка тейк-профита по тикам",
+			store: newFakeStore().addCall(long).addLegs("c-long",
+				alerts.TakeProfit{Price: 110, Percent: 30}, alerts.TakeProfit{Price: 120, Percent: 30}, alerts.TakeProfit{Price: 130, Percent: 40}),
+			ticks:  []Tick{{Symbol: "BTCUSDT", Price: 111}, {Symbol: "BTCUSDT", Price: 115}, {Symbol: "BTCUSDT", Price: 131}},
+			events: []string{"tp c-long 30% @111", "tp c-long 30% @131", "tp c-long 40% @131"},
+			closes: []string{"c-long 30@111 tp", "c-long 30@131 tp", "c-long 40@131 tp"},
+			check: func(t *testing.T, s *fakeStore) {
+				if s.calls["c-long"].Status != "closed" {
+					t.Error("последняя нога должна закрыть колл")
+				}
+			},
+		},
+		{
+			name: "последняя нога short закрывает весь остаток",
+			store: newFakeStore().addCall(alerts.Call{ID: "c-short", UserID: 1, Symbol: "BTCUSDT", Direction: "short", EntryPrice: 100, Size: 70}).
+				addLegs("c-short", alerts.TakeProfit{Price: 90, Percent: 50}),
+			ticks:  []Tick{{Symbol: "BTCUSDT", Price: 89}},
+			events: []string{"tp c-short 70% @89"},
+			closes: []string{"c-short 70@89 tp"},
+		},
+		{
+			name: "исполнение ордера OCO отменяет второй ордер группы",
+			store: newFakeStore().addCall(long).
+				addOrder(alerts.LimitOrder{ID: "take", Symbol: "BTCUSDT", Direction: "short", LimitPrice: 110, RelatedCallID: "c-long", SizeToClose: 100, OcoGroup: "g"}).
+				addOrder(alerts.LimitOrder{ID: "stop", Symbol: "BTCUSDT", Direction: "short", OrderType: alerts.OrderTypeStop, StopPrice: 95, RelatedCallID: "c-long", SizeToClose: 100, OcoGroup: "g"}),
+			ticks:  []Tick{{Symbol: "BTCUSDT", Price: 111}, {Symbol: "BTCUSDT", Price: 94}},
+			events: []string{"fill take @111", "oco take [stop]"},
+			closes: []string{"c-long 100@111 limit"},
+		},
+		{
+			name: "ордера OCO на вход, достигнутые одним тиком, исполняются один раз",
+			store: newFakeStore().
+				addOrder(alerts.LimitOrder{ID: "a", UserID: 1, Symbol: "BTCUSDT", Direction: "long", LimitPrice: 100, DepositPercent: 10, OcoGroup: "g"}).
+				addOrder(alerts.LimitOrder{ID: "b", UserID: 1, Symbol: "BTCUSDT", Direction: "long", LimitPrice: 99, DepositPercent: 10, OcoGroup: "g"}),
+			ticks:  []Tick{{Symbol: "BTCUSDT", Price: 98}},
+			events: []string{"fill a @98", "oco a [b]"},
+			check: func(t *testing.T, s *fakeStore) {
+				open := s.GetAllOpenCalls()
+				if len(open) != 1 || open[0].EntryPrice != 98 || open[0].DepositPercent != 10 {
+					t.Errorf("открыты коллы %+v, ожидался один по 98 на 10%%", open)
+				}
+			},
+		},
+		{
+			name:   "ордер на вход без стопа отклоняется риск-правилом",
+			store:  newFakeStore().withRules(alerts.RiskRules{RequireStopLoss: true}).addOrder(alerts.LimitOrder{ID: "a", UserID: 1, Symbol: "BTCUSDT", Direction: "long", LimitPrice: 100, DepositPercent: 10}),
+			ticks:  []Tick{{Symbol: "BTCUSDT", Price: 99}},
+			events: []string{"rejected a"},
+			check: func(t *testing.T, s *fakeStore) {
+				if len(s.GetAllOpenCalls()) != 0 || s.orders["a"].Status != "cancelled" {
+					t.Errorf("колл открыт или ордер не отменен (статус %s)", s.orders["a"].Status)
+				}
+			},
+		},
+		{
+			name: "ордер на вход переносит стоп-лосс и плечо в колл",
+			store: newFakeStore().withRules(alerts.RiskRules{RequireStopLoss: true}).
+				addOrder(alerts.LimitOrder{ID: "a", UserID: 1, Symbol: "BTCUSDT", Direction: "long", LimitPrice: 100, DepositPercent: 10, StopLossPrice: 95, Leverage: 3}),
+			ticks:  []Tick{{Symbol: "BTCUSDT", Price: 99}},
+			events: []string{"fill a @99"},
+			check: func(t *testing.T, s *fakeStore) {
+				open := s.GetAllOpenCalls()
+				if len(open) != 1 || open[0].StopLossPrice != 95 || open[0].Leverage != 3 {
+					t.Errorf("открыты коллы %+v, ожидался один со стопом 95 и плечом x3", open)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifier := &fakeNotifier{}
+			e := New(tt.store, noPrices{}, notifier, 0, 0)
+			e.Clock = fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+
+			var returned []string
+			for _, tick := range tt.ticks {
+				for _, ev := range e.OnTick(tick) {
+					returned = append(returned, describe(ev))
+				}
+			}
+			var notified []string
+			for _, ev := range notifier.events {
+				notified = append(notified, describe(ev))
+			}
+
+			if !reflect.DeepEqual(returned, tt.events) {
+				t.Errorf("события %q, ожидалось %q", returned, tt.events)
+			}
+			if !reflect.DeepEqual(notified, returned) {
+				t.Errorf("в Notifier переданы %q, возвращены %q", notified, returned)
+			}
+			if !reflect.DeepEqual(tt.store.closes, tt.closes) {
+				t.Errorf("закрытия %q, ожидалось %q", tt.store.closes, tt.closes)
+			}
+			if tt.check != nil {
+				tt.check(t, tt.store)
+			}
+		})
+	}
+}
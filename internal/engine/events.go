@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"time"
+
+	"example.com/alert-bot/internal/alerts"
+)
+
+// Event результат обработки тика, о котором нужно уведомить пользователей.
+type Event interface {
+	// Symbol возвращает символ, к которому относится событие
+	Symbol() string
+}
+
+// Notifier доставляет события пользователям (в Telegram это реализует бот).
+type Notifier interface {
+	Notify(ev Event)
+}
+
+// AlertTriggered ценовой или процентный алерт сработал и удален.
+type AlertTriggered struct {
+	Alert         alerts.Alert
+	Price         float64
+	Kind          string  // "price", "percent", "expression" или "indicator"
+	ChangePercent float64 // Изменение от базовой цены (для процентных алертов)
+	BasePrice     float64 // Базовая цена, от которой считалось изменение
+	Detail        string  // Значение индикатора (для индикаторных алертов)
+	At            time.Time
+}
+
+func (e AlertTriggered) Symbol() string { return e.Alert.Symbol }
+
+// StopLossHit колл закрыт по стоп-лоссу.
+type StopLossHit struct {
+	Call  alerts.Call
+	Price float64
+	At    time.Time
+}
+
+func (e StopLossHit) Symbol() string { return e.Call.Symbol }
+
+// Причины автоматического переноса стопа
+const (
+	StopReasonBreakEven = "breakeven" // PnL достиг порога правила безубытка
+	StopReasonSwing     = "swing"     // Подтянут к последнему swing low/high
+)
+
+// StopMoved стоп-лосс колла перенесен правилом автоматизации.
+type StopMoved struct {
+	Call    alerts.Call // Колл с новым стоп-лоссом
+	OldStop float64
+	Reason  string // StopReasonBreakEven или StopReasonSwing
+	Price   float64
+	At      time.Time
+}
+
+func (e StopMoved) Symbol() string { return e.Call.Symbol }
+
+// TimeStopHit колл закрыт правилом времени: он оставался открытым дольше заданного.
+type TimeStopHit struct {
+	Call  alerts.Call // Колл после закрытия
+	Price float64
+	At    time.Time
+}
+
+func (e TimeStopHit) Symbol() string { return e.Call.Symbol }
+
+// Liquidated колл с плечом принудительно закрыт по цене ликвидации.
+type Liquidated struct {
+	Call  alerts.Call // Колл после закрытия
+	Price float64     // Цена ликвидации
+	At    time.Time
+}
+
+func (e Liquidated) Symbol() string { return e.Call.Symbol }
+
+// TrailingStopActivated трейлинг-стоп колла с порогом активации начал подтягиваться: достигнут порог прибыли.
+type TrailingStopActivated struct {
+	Call  alerts.Call // Колл с уже подтянутым стоп-лоссом
+	Price float64
+	At    time.Time
+}
+
+func (e TrailingStopActivated) Symbol() string { return e.Call.Symbol }
+
+// TakeProfitHit исполнена нога тейк-профита колла.
+type TakeProfitHit struct {
+	Leg   alerts.TakeProfit
+	Price float64
+	// Call колл после частичного или полного закрытия ногой
+	Call alerts.Call
+	// ClosedPercent доля позиции в процентах, закрытая ногой
+	ClosedPercent float64
+	At            time.Time
+}
+
+func (e TakeProfitHit) Symbol() string { return e.Call.Symbol }
+
+// LimitFilled лимитный ордер исполнен: открыт новый колл или закрыта часть существующего.
+type LimitFilled struct {
+	Order alerts.LimitOrder
+	Price float64
+	// Call открытый колл (ордер на открытие) или колл после частичного/полного закрытия.
+	// Может быть nil, если обновленный колл не удалось прочитать.
+	Call *alerts.Call
+	// ClosedPercent доля колла в процентах, закрытая ордером (для ордеров на закрытие)
+	ClosedPercent float64
+	At            time.Time
+}
+
+func (e LimitFilled) Symbol() string { return e.Order.Symbol }
+
+// OcoCancelled ордер OCO-группы исполнился (или сработал стоп stop_limit), остальные ордера группы отменены.
+type OcoCancelled struct {
+	Order        alerts.LimitOrder
+	CancelledIDs []string
+	Price        float64
+	At           time.Time
+}
+
+func (e OcoCancelled) Symbol() string { return e.Order.Symbol }
+
+// LimitExpired срок действия ордера (good-till-date) истек, ордер отменен.
+type LimitExpired struct {
+	Order alerts.LimitOrder
+	At    time.Time
+}
+
+func (e LimitExpired) Symbol() string { return e.Order.Symbol }
+
+// LimitFailed лимитный ордер сработал по цене, но исполнить его не удалось.
+type LimitFailed struct {
+	Order alerts.LimitOrder
+	Price float64
+	Err   error
+	At    time.Time
+}
+
+func (e LimitFailed) Symbol() string { return e.Order.Symbol }
+
+// LimitRejected лимитный ордер на открытие сработал, но нарушил риск-правила и отменен.
+type LimitRejected struct {
+	Order  alerts.LimitOrder
+	Price  float64
+	Reason error // *alerts.RiskViolation
+	At     time.Time
+}
+
+func (e LimitRejected) Symbol() string { return e.Order.Symbol }
+
+// Recipient пользователь, которому отправляется уведомление о резком движении.
+type Recipient struct {
+	ChatID   int64
+	UserID   int64
+	Username string
+}
+
+// SharpMove цена символа изменилась больше порога за интервал.
+type SharpMove struct {
+	Sym           string
+	OldPrice      float64
+	Price         float64
+	ChangePercent float64
+	Interval      time.Duration
+	Recipients    []Recipient
+	At            time.Time
+}
+
+func (e SharpMove) Symbol() string { return e.Sym }
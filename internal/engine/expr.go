@@ -0,0 +1,442 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MarketData рыночные данные для вычисления составных условий.
+type MarketData interface {
+	// Price возвращает текущую цену символа
+	Price(symbol string) (float64, error)
+	// Change возвращает изменение цены символа в процентах за интервал (15m, 1h, 4h, 24h)
+	Change(symbol, interval string) (float64, error)
+	// Volume24h возвращает объем торгов символа за 24 часа в котируемой валюте
+	Volume24h(symbol string) (float64, error)
+}
+
+// Expr составное условие алерта, например "BTCUSDT < 60000 AND ETHUSDT/BTCUSDT > 0.05".
+type Expr interface {
+	// Eval вычисляет условие; AND и OR вычисляются лениво слева направо
+	Eval(md MarketData) (bool, error)
+	// Symbols возвращает символы условия в порядке первого упоминания
+	Symbols() []string
+	// String возвращает условие в каноническом виде (его можно снова разобрать ParseExpr)
+	String() string
+}
+
+// ChangeIntervals интервалы, доступные в change(SYMBOL, INTERVAL).
+var ChangeIntervals = []string{"15m", "1h", "4h", "24h"}
+
+// ParseExpr разбирает условие составного алерта.
+//
+// Грамматика:
+//
+//	expr    := and ("OR" and)*
+//	and     := term ("AND" term)*
+//	term    := "(" expr ")" | operand op NUMBER
+//	operand := SYMBOL | SYMBOL "/" SYMBOL | change(SYMBOL, INTERVAL) | volume(SYMBOL)
+//	op      := "<" | "<=" | ">" | ">="
+//
+// Вместо AND/OR можно писать && и ||. Числа допускают суффиксы K, M, B и знак %.
+// normalize приводит символы к виду, в котором они отслеживаются (может быть nil).
+func ParseExpr(input string, normalize func(string) string) (Expr, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("пустое условие")
+	}
+	if normalize == nil {
+		normalize = strings.ToUpper
+	}
+
+	p := &exprParser{tokens: tokens, normalize: normalize}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("лишний токен %q", p.tokens[p.pos])
+	}
+	return expr, nil
+}
+
+// tokenize делит строку на слова, скобки, запятые, "/" и операторы сравнения.
+func tokenize(input string) ([]string, error) {
+	var tokens []string
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == ',' || r == '/':
+			tokens = append(tokens, string(r))
+			i++
+		case r == '<' || r == '>':
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				tokens = append(tokens, string(runes[i:i+2]))
+				i += 2
+			} else {
+				tokens = append(tokens, string(r))
+				i++
+			}
+		case r == '&' || r == '|':
+			if i+1 >= len(runes) || runes[i+1] != r {
+				return nil, fmt.Errorf("неизвестный оператор %q", string(r))
+			}
+			if r == '&' {
+				tokens = append(tokens, "AND")
+			} else {
+				tokens = append(tokens, "OR")
+			}
+			i += 2
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '_' || r == '%':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) ||
+				runes[i] == '.' || runes[i] == '_' || runes[i] == '%') {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		default:
+			return nil, fmt.Errorf("неожиданный символ %q", string(r))
+		}
+	}
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens    []string
+	pos       int
+	normalize func(string) string
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	tok := p.peek()
+	if tok != "" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *exprParser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		if got == "" {
+			return fmt.Errorf("ожидалось %q в конце условия", tok)
+		}
+		return fmt.Errorf("ожидалось %q, получено %q", tok, got)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalExpr{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (Expr, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "AND") {
+		p.next()
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalExpr{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseTerm() (Expr, error) {
+	if p.peek() == "(" {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.next()
+	switch op {
+	case "<", "<=", ">", ">=":
+	case "":
+		return nil, fmt.Errorf("после %s ожидался оператор сравнения", left)
+	default:
+		return nil, fmt.Errorf("неизвестный оператор сравнения %q", op)
+	}
+
+	valueTok := p.next()
+	value, err := parseExprNumber(valueTok)
+	if err != nil {
+		return nil, err
+	}
+	return comparison{left: left, op: op, value: value}, nil
+}
+
+func (p *exprParser) parseOperand() (operand, error) {
+	tok := p.next()
+	if tok == "" {
+		return nil, fmt.Errorf("неожиданный конец условия")
+	}
+	if !isWord(tok) || strings.EqualFold(tok, "AND") || strings.EqualFold(tok, "OR") {
+		return nil, fmt.Errorf("ожидался символ, получено %q", tok)
+	}
+
+	if p.peek() == "(" {
+		switch strings.ToLower(tok) {
+		case "change", "chg":
+			p.next()
+			sym, err := p.parseSymbol()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+			interval, err := normalizeChangeInterval(p.next())
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return changeOperand{symbol: sym, interval: interval}, nil
+		case "volume", "vol":
+			p.next()
+			sym, err := p.parseSymbol()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return volumeOperand{symbol: sym}, nil
+		default:
+			return nil, fmt.Errorf("неизвестная функция %q (доступны change и volume)", tok)
+		}
+	}
+
+	sym := p.normalize(tok)
+	if p.peek() == "/" {
+		p.next()
+		den, err := p.parseSymbol()
+		if err != nil {
+			return nil, err
+		}
+		return ratioOperand{num: sym, den: den}, nil
+	}
+	return priceOperand{symbol: sym}, nil
+}
+
+func (p *exprParser) parseSymbol() (string, error) {
+	tok := p.next()
+	if !isWord(tok) {
+		return "", fmt.Errorf("ожидался символ, получено %q", tok)
+	}
+	return p.normalize(tok), nil
+}
+
+func isWord(tok string) bool {
+	if tok == "" {
+		return false
+	}
+	switch tok {
+	case "(", ")", ",", "/", "<", "<=", ">", ">=":
+		return false
+	}
+	return true
+}
+
+// normalizeChangeInterval приводит интервал к одному из ChangeIntervals (1d — то же, что 24h).
+func normalizeChangeInterval(tok string) (string, error) {
+	interval := strings.ToLower(tok)
+	if interval == "1d" {
+		interval = "24h"
+	}
+	for _, allowed := range ChangeIntervals {
+		if interval == allowed {
+			return interval, nil
+		}
+	}
+	return "", fmt.Errorf("неизвестный интервал %q (доступны %s)", tok, strings.Join(ChangeIntervals, ", "))
+}
+
+// parseExprNumber разбирает число с необязательным суффиксом K/M/B или %.
+func parseExprNumber(tok string) (float64, error) {
+	s := strings.TrimSuffix(tok, "%")
+	if s == "" {
+		return 0, fmt.Errorf("ожидалось число в конце условия")
+	}
+	multiplier := 1.0
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		multiplier, s = 1e3, s[:len(s)-1]
+	case "M":
+		multiplier, s = 1e6, s[:len(s)-1]
+	case "B":
+		multiplier, s = 1e9, s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("неверное число %q", tok)
+	}
+	return v * multiplier, nil
+}
+
+// operand числовая величина в левой части сравнения.
+type operand interface {
+	value(md MarketData) (float64, error)
+	symbols() []string
+	String() string
+}
+
+type priceOperand struct{ symbol string }
+
+func (o priceOperand) value(md MarketData) (float64, error) { return md.Price(o.symbol) }
+func (o priceOperand) symbols() []string                    { return []string{o.symbol} }
+func (o priceOperand) String() string                       { return o.symbol }
+
+type ratioOperand struct{ num, den string }
+
+func (o ratioOperand) value(md MarketData) (float64, error) {
+	num, err := md.Price(o.num)
+	if err != nil {
+		return 0, err
+	}
+	den, err := md.Price(o.den)
+	if err != nil {
+		return 0, err
+	}
+	if den == 0 {
+		return 0, fmt.Errorf("zero price for %s", o.den)
+	}
+	return num / den, nil
+}
+func (o ratioOperand) symbols() []string { return []string{o.num, o.den} }
+func (o ratioOperand) String() string    { return o.num + "/" + o.den }
+
+type changeOperand struct{ symbol, interval string }
+
+func (o changeOperand) value(md MarketData) (float64, error) { return md.Change(o.symbol, o.interval) }
+func (o changeOperand) symbols() []string                    { return []string{o.symbol} }
+func (o changeOperand) String() string {
+	return fmt.Sprintf("change(%s, %s)", o.symbol, o.interval)
+}
+
+type volumeOperand struct{ symbol string }
+
+func (o volumeOperand) value(md MarketData) (float64, error) { return md.Volume24h(o.symbol) }
+func (o volumeOperand) symbols() []string                    { return []string{o.symbol} }
+func (o volumeOperand) String() string                       { return fmt.Sprintf("volume(%s)", o.symbol) }
+
+type comparison struct {
+	left  operand
+	op    string
+	value float64
+}
+
+func (c comparison) Eval(md MarketData) (bool, error) {
+	v, err := c.left.value(md)
+	if err != nil {
+		return false, err
+	}
+	return c.compare(v)
+}
+
+// compare сравнивает значение с порогом сравнения.
+func (c comparison) compare(v float64) (bool, error) {
+	switch c.op {
+	case "<":
+		return v < c.value, nil
+	case "<=":
+		return v <= c.value, nil
+	case ">":
+		return v > c.value, nil
+	case ">=":
+		return v >= c.value, nil
+	}
+	return false, fmt.Errorf("unknown operator %q", c.op)
+}
+
+func (c comparison) Symbols() []string { return c.left.symbols() }
+
+func (c comparison) String() string {
+	return fmt.Sprintf("%s %s %s", c.left, c.op, strconv.FormatFloat(c.value, 'f', -1, 64))
+}
+
+type logicalExpr struct {
+	op          string // "AND" или "OR"
+	left, right Expr
+}
+
+func (l logicalExpr) Eval(md MarketData) (bool, error) {
+	left, err := l.left.Eval(md)
+	if err != nil {
+		return false, err
+	}
+	if l.op == "AND" && !left {
+		return false, nil
+	}
+	if l.op == "OR" && left {
+		return true, nil
+	}
+	return l.right.Eval(md)
+}
+
+func (l logicalExpr) Symbols() []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, sym := range append(l.left.Symbols(), l.right.Symbols()...) {
+		if !seen[sym] {
+			seen[sym] = true
+			out = append(out, sym)
+		}
+	}
+	return out
+}
+
+func (l logicalExpr) String() string {
+	return l.wrap(l.left) + " " + l.op + " " + l.wrap(l.right)
+}
+
+// wrap берет в скобки OR внутри AND, чтобы String сохранял приоритет операций.
+func (l logicalExpr) wrap(e Expr) string {
+	if inner, ok := e.(logicalExpr); ok && l.op == "AND" && inner.op == "OR" {
+		return "(" + inner.String() + ")"
+	}
+	return e.String()
+}
@@ -0,0 +1,21 @@
+package engine
+
+import "testing"
+
+func TestParseExprRejectsBareNumberSuffix(t *testing.T) {
+	for _, input := range []string{"BTC > %", "BTC > K", "BTC > %%", "change(BTC, 1h) > %"} {
+		if _, err := ParseExpr(input, nil); err == nil {
+			t.Errorf("ParseExpr(%q): ожидалась ошибка", input)
+		}
+	}
+}
+
+func TestParseExprNumberSuffixes(t *testing.T) {
+	cases := map[string]float64{"5": 5, "5%": 5, "1.5K": 1500, "2M": 2e6, "3b": 3e9}
+	for tok, want := range cases {
+		got, err := parseExprNumber(tok)
+		if err != nil || got != want {
+			t.Errorf("parseExprNumber(%q) = %v, %v; ожидалось %v", tok, got, err, want)
+		}
+	}
+}
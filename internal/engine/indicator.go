@@ -0,0 +1,419 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/alert-bot/internal/alerts"
+	"example.com/alert-bot/internal/indicators"
+	"example.com/alert-bot/internal/levels"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CandleSource источник свечей для индикаторных алертов.
+type CandleSource interface {
+	// Candles возвращает не меньше limit последних свечей (если история есть) в порядке возрастания времени
+	Candles(symbol, timeframe string, limit int) ([]levels.Candle, error)
+}
+
+// IndicatorSpec условие индикаторного алерта, например "rsi:14 1h < 30" или "ema 4h 50x200".
+//
+// Поддерживаются:
+//   - rsi[:N] TF OP VALUE — RSI на закрытии свечи (по умолчанию N=14)
+//   - atr[:N] TF OP VALUE — ATR на закрытии свечи (по умолчанию N=14)
+//   - ema|sma TF FASTxSLOW — пересечение быстрой и медленной средней
+//   - ema|sma TF N — пересечение цены закрытия и средней
+//   - macd[:FAST:SLOW:SIGNAL] TF — пересечение MACD и сигнальной линии (по умолчанию 12:26:9)
+//   - bb[:N:K] TF — закрытие за полосами Боллинджера (по умолчанию 20:2)
+type IndicatorSpec struct {
+	Name      string    // rsi, atr, ema, sma, macd, bb
+	Params    []float64 // Периоды (и множитель для bb)
+	Timeframe string    // 1m ... 1w
+	Op        string    // Оператор сравнения для rsi/atr
+	Value     float64   // Порог для rsi/atr
+}
+
+// IndicatorNames индикаторы, доступные в алертах.
+var IndicatorNames = []string{"rsi", "ema", "sma", "macd", "bb", "atr"}
+
+// IsIndicator сообщает, что слово начинает условие индикаторного алерта ("rsi", "rsi:21", ...).
+func IsIndicator(word string) bool {
+	name := strings.ToLower(strings.SplitN(word, ":", 2)[0])
+	for _, n := range IndicatorNames {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseIndicatorSpec разбирает условие индикаторного алерта из слов команды после символа.
+func ParseIndicatorSpec(fields []string) (IndicatorSpec, error) {
+	if len(fields) < 2 {
+		return IndicatorSpec{}, fmt.Errorf("нужны индикатор и таймфрейм")
+	}
+
+	head := strings.Split(strings.ToLower(fields[0]), ":")
+	spec := IndicatorSpec{Name: head[0], Timeframe: strings.ToLower(fields[1])}
+	if !IsIndicator(spec.Name) {
+		return IndicatorSpec{}, fmt.Errorf("неизвестный индикатор %q (доступны %s)", fields[0], strings.Join(IndicatorNames, ", "))
+	}
+	if _, err := levels.TimeframeDuration(spec.Timeframe); err != nil {
+		return IndicatorSpec{}, fmt.Errorf("неизвестный таймфрейм %q (доступны 1m, 5m, 15m, 30m, 1h, 4h, 6h, 12h, 1d, 1w)", fields[1])
+	}
+	for _, p := range head[1:] {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v <= 0 {
+			return IndicatorSpec{}, fmt.Errorf("неверный параметр %q", p)
+		}
+		spec.Params = append(spec.Params, v)
+	}
+	rest := fields[2:]
+
+	switch spec.Name {
+	case "rsi", "atr":
+		spec.Params = withDefaults(spec.Params, 14)
+		if len(rest) != 2 {
+			return IndicatorSpec{}, fmt.Errorf("ожидалось условие вида %s %s < 30", spec.Name, spec.Timeframe)
+		}
+		switch rest[0] {
+		case "<", "<=", ">", ">=":
+			spec.Op = rest[0]
+		default:
+			return IndicatorSpec{}, fmt.Errorf("неизвестный оператор сравнения %q", rest[0])
+		}
+		v, err := parseExprNumber(rest[1])
+		if err != nil {
+			return IndicatorSpec{}, err
+		}
+		spec.Value = v
+
+	case "ema", "sma":
+		if len(rest) != 1 {
+			return IndicatorSpec{}, fmt.Errorf("ожидались периоды вида 50x200 или 200")
+		}
+		for _, p := range strings.Split(strings.ToLower(rest[0]), "x") {
+			v, err := strconv.Atoi(p)
+			if err != nil || v <= 0 {
+				return IndicatorSpec{}, fmt.Errorf("неверный период %q", p)
+			}
+			spec.Params = append(spec.Params, float64(v))
+		}
+		if len(spec.Params) > 2 || (len(spec.Params) == 2 && spec.Params[0] >= spec.Params[1]) {
+			return IndicatorSpec{}, fmt.Errorf("ожидались периоды вида FASTxSLOW, где FAST < SLOW")
+		}
+
+	case "macd":
+		spec.Params = withDefaults(spec.Params, 12, 26, 9)
+		if len(rest) != 0 {
+			return IndicatorSpec{}, fmt.Errorf("лишние параметры: %s", strings.Join(rest, " "))
+		}
+
+	case "bb":
+		spec.Params = withDefaults(spec.Params, 20, 2)
+		if len(rest) != 0 {
+			return IndicatorSpec{}, fmt.Errorf("лишние параметры: %s", strings.Join(rest, " "))
+		}
+	}
+
+	if spec.MaxPeriod() > MaxIndicatorPeriod {
+		return IndicatorSpec{}, fmt.Errorf("период не больше %d", MaxIndicatorPeriod)
+	}
+	return spec, nil
+}
+
+// MaxIndicatorPeriod наибольший допустимый период индикатора.
+const MaxIndicatorPeriod = 300
+
+func withDefaults(params []float64, defaults ...float64) []float64 {
+	out := append([]float64(nil), params...)
+	for len(out) < len(defaults) {
+		out = append(out, defaults[len(out)])
+	}
+	return out
+}
+
+func (s IndicatorSpec) period(i int) int { return int(s.Params[i]) }
+
+// MaxPeriod возвращает наибольший период индикатора.
+func (s IndicatorSpec) MaxPeriod() int {
+	longest := 0.0
+	for i, p := range s.Params {
+		switch {
+		case s.Name == "bb" && i == 1:
+			continue // множитель отклонения, не период
+		case s.Name == "macd" && i == 2:
+			p += s.Params[1] // сигнальная линия считается от MACD, которому нужна медленная EMA
+		}
+		longest = math.Max(longest, p)
+	}
+	return int(longest)
+}
+
+// CandlesNeeded количество закрытых свечей для устойчивого значения индикатора.
+func (s IndicatorSpec) CandlesNeeded() int {
+	n := 3 * s.MaxPeriod()
+	if n < 100 {
+		n = 100
+	}
+	return n
+}
+
+// String возвращает условие в каноническом виде (его можно снова разобрать ParseIndicatorSpec).
+func (s IndicatorSpec) String() string {
+	params := make([]string, len(s.Params))
+	for i, p := range s.Params {
+		params[i] = strconv.FormatFloat(p, 'f', -1, 64)
+	}
+
+	switch s.Name {
+	case "ema", "sma":
+		return fmt.Sprintf("%s %s %s", s.Name, s.Timeframe, strings.Join(params, "x"))
+	case "rsi", "atr":
+		return fmt.Sprintf("%s:%s %s %s %s", s.Name, params[0], s.Timeframe, s.Op, strconv.FormatFloat(s.Value, 'f', -1, 64))
+	default:
+		return fmt.Sprintf("%s:%s %s", s.Name, strings.Join(params, ":"), s.Timeframe)
+	}
+}
+
+// IndicatorSignal результат проверки индикатора на последней закрытой свече.
+type IndicatorSignal struct {
+	Hit    bool
+	Detail string // Значение индикатора для уведомления, например "RSI(14) 1h = 28.41"
+}
+
+// Evaluate проверяет условие на последней из переданных (закрытых) свечей.
+func (s IndicatorSpec) Evaluate(candles []levels.Candle) (IndicatorSignal, error) {
+	closes := indicators.Closes(candles)
+	tf := s.Timeframe
+
+	switch s.Name {
+	case "rsi", "atr":
+		series, label := indicators.RSI(candles, s.period(0)), "RSI"
+		if s.Name == "atr" {
+			series, label = indicators.ATR(candles, s.period(0)), "ATR"
+		}
+		v, ok := indicators.Last(series)
+		if !ok {
+			return IndicatorSignal{}, fmt.Errorf("not enough candles for %s", s)
+		}
+		hit, _ := comparison{op: s.Op, value: s.Value}.compare(v)
+		return IndicatorSignal{Hit: hit, Detail: fmt.Sprintf("%s(%d) %s = %.2f", label, s.period(0), tf, v)}, nil
+
+	case "ema", "sma":
+		ma := indicators.EMA
+		label := "EMA"
+		if s.Name == "sma" {
+			ma, label = indicators.SMA, "SMA"
+		}
+		if len(s.Params) == 1 {
+			line := ma(closes, s.period(0))
+			v, ok := indicators.Last(line)
+			if !ok {
+				return IndicatorSignal{}, fmt.Errorf("not enough candles for %s", s)
+			}
+			price := closes[len(closes)-1]
+			switch {
+			case indicators.CrossedAbove(closes, line):
+				return IndicatorSignal{Hit: true, Detail: fmt.Sprintf("Цена %.6g пересекла %s(%d) %s = %.6g снизу вверх", price, label, s.period(0), tf, v)}, nil
+			case indicators.CrossedBelow(closes, line):
+				return IndicatorSignal{Hit: true, Detail: fmt.Sprintf("Цена %.6g пересекла %s(%d) %s = %.6g сверху вниз", price, label, s.period(0), tf, v)}, nil
+			}
+			return IndicatorSignal{Detail: fmt.Sprintf("%s(%d) %s = %.6g, цена %.6g", label, s.period(0), tf, v, price)}, nil
+		}
+
+		fast, slow := ma(closes, s.period(0)), ma(closes, s.period(1))
+		f, okF := indicators.Last(fast)
+		sl, okS := indicators.Last(slow)
+		if !okF || !okS {
+			return IndicatorSignal{}, fmt.Errorf("not enough candles for %s", s)
+		}
+		values := fmt.Sprintf("%s(%d) %s = %.6g, %s(%d) = %.6g", label, s.period(0), tf, f, label, s.period(1), sl)
+		switch {
+		case indicators.CrossedAbove(fast, slow):
+			return IndicatorSignal{Hit: true, Detail: "Золотой крест: " + values}, nil
+		case indicators.CrossedBelow(fast, slow):
+			return IndicatorSignal{Hit: true, Detail: "Крест смерти: " + values}, nil
+		}
+		return IndicatorSignal{Detail: values}, nil
+
+	case "macd":
+		macd, signal, _ := indicators.MACD(closes, s.period(0), s.period(1), s.period(2))
+		m, okM := indicators.Last(macd)
+		sg, okS := indicators.Last(signal)
+		if !okM || !okS {
+			return IndicatorSignal{}, fmt.Errorf("not enough candles for %s", s)
+		}
+		values := fmt.Sprintf("MACD %s = %.6g, сигнальная = %.6g", tf, m, sg)
+		switch {
+		case indicators.CrossedAbove(macd, signal):
+			return IndicatorSignal{Hit: true, Detail: "MACD пересек сигнальную снизу вверх: " + values}, nil
+		case indicators.CrossedBelow(macd, signal):
+			return IndicatorSignal{Hit: true, Detail: "MACD пересек сигнальную сверху вниз: " + values}, nil
+		}
+		return IndicatorSignal{Detail: values}, nil
+
+	case "bb":
+		_, upper, lower := indicators.Bollinger(closes, s.period(0), s.Params[1])
+		u, okU := indicators.Last(upper)
+		l, okL := indicators.Last(lower)
+		if !okU || !okL {
+			return IndicatorSignal{}, fmt.Errorf("not enough candles for %s", s)
+		}
+		price := closes[len(closes)-1]
+		bands := fmt.Sprintf("полосы Боллинджера %s: %.6g – %.6g", tf, l, u)
+		switch {
+		case price > u:
+			return IndicatorSignal{Hit: true, Detail: fmt.Sprintf("Закрытие %.6g выше верхней полосы, %s", price, bands)}, nil
+		case price < l:
+			return IndicatorSignal{Hit: true, Detail: fmt.Sprintf("Закрытие %.6g ниже нижней полосы, %s", price, bands)}, nil
+		}
+		return IndicatorSignal{Detail: fmt.Sprintf("Закрытие %.6g, %s", price, bands)}, nil
+	}
+
+	return IndicatorSignal{}, fmt.Errorf("unknown indicator %q", s.Name)
+}
+
+// ClosedCandles отбрасывает незакрытую свечу и возвращает закрытые в порядке возрастания времени.
+func ClosedCandles(candles []levels.Candle, timeframe string, now time.Time) []levels.Candle {
+	tf, err := levels.TimeframeDuration(timeframe)
+	if err != nil {
+		return nil
+	}
+	sorted := append([]levels.Candle(nil), candles...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Timestamp < sorted[j].Timestamp })
+
+	closed := sorted[:0]
+	for _, c := range sorted {
+		if !time.UnixMilli(c.Timestamp).Add(tf).After(now) {
+			closed = append(closed, c)
+		}
+	}
+	return closed
+}
+
+// LastCandleClose возвращает момент закрытия последней закрытой свечи таймфрейма.
+func LastCandleClose(timeframe string, now time.Time) (time.Time, error) {
+	tf, err := levels.TimeframeDuration(timeframe)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return now.UTC().Truncate(tf), nil
+}
+
+// CheckIndicators проверяет индикаторные алерты, у которых с прошлой проверки закрылась свеча.
+// Свечи загружаются один раз на символ и таймфрейм. Вызывается периодически, не на каждом тике.
+func (e *Engine) CheckIndicators() []Event {
+	if e.Candles == nil {
+		return nil
+	}
+	now := e.Clock.Now()
+
+	type candleKey struct{ symbol, timeframe string }
+	loaded := make(map[candleKey][]levels.Candle)
+
+	var events []Event
+	for _, alert := range e.Store.GetByKind(alerts.AlertKindIndicator) {
+		spec, err := ParseIndicatorSpec(strings.Fields(alert.Expression))
+		if err != nil {
+			logrus.WithError(err).WithField("alert_id", alert.ID).Warn("invalid indicator alert")
+			continue
+		}
+
+		closeAt, _ := LastCandleClose(spec.Timeframe, now)
+		if alert.LastCandleAt != nil && !closeAt.After(*alert.LastCandleAt) {
+			continue // Новая свеча еще не закрылась
+		}
+
+		key := candleKey{alert.Symbol, spec.Timeframe}
+		candles, ok := loaded[key]
+		if !ok {
+			raw, err := e.Candles.Candles(alert.Symbol, spec.Timeframe, spec.CandlesNeeded()+1)
+			if err != nil {
+				logrus.WithError(err).WithFields(logrus.Fields{
+					"symbol":    alert.Symbol,
+					"timeframe": spec.Timeframe,
+				}).Warn("failed to load candles for indicator alerts")
+				continue
+			}
+			candles = ClosedCandles(raw, spec.Timeframe, now)
+			loaded[key] = candles
+		}
+
+		signal, err := spec.Evaluate(candles)
+		if err != nil {
+			logrus.WithError(err).WithField("alert_id", alert.ID).Debug("failed to evaluate indicator alert")
+			continue
+		}
+
+		alert.LastCandleAt = &closeAt
+		if ev, ok := e.applyIndicatorSignal(alert, signal, candles); ok {
+			events = append(events, ev)
+		}
+	}
+
+	if e.Notifier != nil {
+		for _, ev := range events {
+			e.Notifier.Notify(ev)
+		}
+	}
+	return events
+}
+
+// applyIndicatorSignal сохраняет состояние алерта после проверки свечи и возвращает событие,
+// если алерт сработал. Повторяющийся алерт взводится снова на свече без сигнала.
+func (e *Engine) applyIndicatorSignal(alert alerts.Alert, signal IndicatorSignal, candles []levels.Candle) (AlertTriggered, bool) {
+	if alert.Recurring() && !alert.Armed {
+		cooled := alert.CooldownSec <= 0 || alert.LastTriggeredAt == nil ||
+			e.Clock.Now().Sub(*alert.LastTriggeredAt) >= time.Duration(alert.CooldownSec)*time.Second
+		if !signal.Hit && cooled {
+			alert.Armed = true
+		}
+		if err := e.Store.Update(alert); err != nil {
+			logrus.WithError(err).WithField("alert_id", alert.ID).Warn("failed to update indicator alert")
+		}
+		return AlertTriggered{}, false
+	}
+
+	if !signal.Hit {
+		if err := e.Store.Update(alert); err != nil {
+			logrus.WithError(err).WithField("alert_id", alert.ID).Warn("failed to update indicator alert")
+		}
+		return AlertTriggered{}, false
+	}
+
+	price := candles[len(candles)-1].Close
+	ev := AlertTriggered{Alert: alert, Price: price, Kind: "indicator", Detail: signal.Detail, At: e.Clock.Now()}
+	logrus.WithFields(logrus.Fields{
+		"alert_id":  alert.ID,
+		"symbol":    alert.Symbol,
+		"condition": alert.Expression,
+		"detail":    signal.Detail,
+	}).Info("indicator alert triggered")
+
+	if err := e.Store.LogAlertTrigger(alert.ID, alert.Symbol, price, alert.ChatID, alert.UserID, alert.Username, ev.Kind); err != nil {
+		logrus.WithError(err).WithField("alert_id", alert.ID).Warn("failed to log alert trigger")
+	}
+
+	if alert.Recurring() {
+		now := e.Clock.Now()
+		alert.Armed = false
+		alert.TriggerCount++
+		alert.LastTriggeredAt = &now
+		if err := e.Store.Update(alert); err != nil {
+			logrus.WithError(err).WithField("alert_id", alert.ID).Warn("failed to disarm recurring alert")
+		}
+		ev.Alert = alert
+		return ev, true
+	}
+
+	if _, err := e.Store.DeleteByID(alert.ChatID, alert.ID); err != nil {
+		logrus.WithError(err).WithField("alert_id", alert.ID).Warn("failed to delete triggered alert")
+	}
+	return ev, true
+}
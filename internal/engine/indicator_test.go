@@ -0,0 +1,24 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseIndicatorSpecRejectsBareNumberSuffix(t *testing.T) {
+	for _, input := range []string{"rsi 1h < %", "atr 4h > K", "rsi:21 1d >= %"} {
+		if _, err := ParseIndicatorSpec(strings.Fields(input)); err == nil {
+			t.Errorf("ParseIndicatorSpec(%q): ожидалась ошибка", input)
+		}
+	}
+}
+
+func TestParseIndicatorSpecThreshold(t *testing.T) {
+	spec, err := ParseIndicatorSpec(strings.Fields("rsi 1h < 30%"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Op != "<" || spec.Value != 30 {
+		t.Errorf("получено %s %v, ожидалось < 30", spec.Op, spec.Value)
+	}
+}
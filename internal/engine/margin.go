@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"errors"
+	"time"
+
+	"example.com/alert-bot/internal/alerts"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fundingAccrualStep как часто начисляется финансирование открытых фьючерсных коллов
+const fundingAccrualStep = 15 * time.Minute
+
+// ErrNoFunding возвращается FeeSource, если у биржи или рынка нет ставки финансирования.
+var ErrNoFunding = errors.New("funding rate is not available")
+
+// FeeSource комиссии и ставки финансирования бирж.
+type FeeSource interface {
+	// TakerFeePercent возвращает комиссию тейкера в % от объема за одну сторону сделки
+	TakerFeePercent(exchange, market string) float64
+	// FundingRate возвращает ставку за интервал (доля: 0.0001 = 0.01%) и интервал выплат;
+	// ErrNoFunding — если у рынка нет финансирования
+	FundingRate(symbol, exchange, market string) (rate float64, interval time.Duration, err error)
+}
+
+// takerFee комиссия тейкера для нового колла; без Fees — 0.
+func (e *Engine) takerFee(exchange, market string) float64 {
+	if e.Fees == nil {
+		return 0
+	}
+	return e.Fees.TakerFeePercent(exchange, market)
+}
+
+// checkLiquidations принудительно закрывает коллы с плечом, цена которых дошла до цены ликвидации,
+// и возвращает события вместе с коллами, которые остались открытыми. Если стоп-лосс стоит ближе
+// к входу, чем ликвидация, и тоже сработал, колл закрывается стоп-лоссом.
+func (e *Engine) checkLiquidations(tick Tick, symbolCalls []alerts.Call) ([]Event, []alerts.Call) {
+	var events []Event
+	open := symbolCalls[:0:0]
+	for _, call := range symbolCalls {
+		if !call.Liquidated(tick.Price) || stopBeforeLiquidation(call, tick.Price) {
+			open = append(open, call)
+			continue
+		}
+
+		liq := call.LiquidationPrice()
+		logrus.WithFields(logrus.Fields{
+			"call_id":   call.ID,
+			"symbol":    call.Symbol,
+			"leverage":  call.Leverage,
+			"liq_price": liq,
+			"price":     tick.Price,
+		}).Info("call liquidated")
+
+		if err := e.Store.CloseCall(call.ID, call.UserID, liq, alerts.CloseRemaining, alerts.FillSourceLiquidate); err != nil {
+			logrus.WithError(err).WithField("call_id", call.ID).Error("failed to close call by liquidation")
+			open = append(open, call)
+			continue
+		}
+
+		closed := call
+		if c, err := e.Store.GetCallByID(call.ID, call.UserID); err == nil {
+			closed = *c
+		}
+		events = append(events, Liquidated{Call: closed, Price: liq, At: e.Clock.Now()})
+	}
+	return events, open
+}
+
+// stopBeforeLiquidation сообщает, что стоп-лосс колла сработал по цене price и стоит не дальше от входа,
+// чем цена ликвидации.
+func stopBeforeLiquidation(call alerts.Call, price float64) bool {
+	stop, liq := call.StopLossPrice, call.LiquidationPrice()
+	if stop <= 0 {
+		return false
+	}
+	if call.Direction == "short" {
+		return price >= stop && stop <= liq
+	}
+	return price <= stop && stop >= liq
+}
+
+// CheckFunding начисляет финансирование открытым фьючерсным коллам пропорционально времени
+// с прошлого начисления: лонг платит положительную ставку, шорт ее получает. Ставка загружается
+// один раз на символ и биржу. Вызывается периодически, как CheckIndicators.
+func (e *Engine) CheckFunding() {
+	if e.Fees == nil {
+		return
+	}
+	now := e.Clock.Now()
+
+	type rateKey struct{ symbol, exchange, market string }
+	type fundingRate struct {
+		rate     float64
+		interval time.Duration
+		err      error
+	}
+	loaded := make(map[rateKey]fundingRate)
+
+	for _, call := range e.Store.GetAllOpenCalls() {
+		if call.Market != "futures" {
+			continue
+		}
+		since := call.OpenedAt
+		if call.FundingAt != nil {
+			since = *call.FundingAt
+		}
+		elapsed := now.Sub(since)
+		if elapsed < fundingAccrualStep {
+			continue
+		}
+
+		key := rateKey{call.Symbol, call.Exchange, call.Market}
+		fr, ok := loaded[key]
+		if !ok {
+			fr.rate, fr.interval, fr.err = e.Fees.FundingRate(call.Symbol, call.Exchange, call.Market)
+			loaded[key] = fr
+			if fr.err != nil && !errors.Is(fr.err, ErrNoFunding) {
+				logrus.WithError(fr.err).WithFields(logrus.Fields{
+					"symbol":   call.Symbol,
+					"exchange": call.Exchange,
+				}).Warn("failed to get funding rate")
+			}
+		}
+		if fr.err != nil || fr.interval <= 0 {
+			continue
+		}
+
+		// Ставка в процентах от объема за прошедшую долю интервала
+		percent := fr.rate * 100 * float64(elapsed) / float64(fr.interval)
+		if call.Direction == "short" {
+			percent = -percent
+		}
+		if err := e.Store.AccrueFunding(call.ID, percent, now); err != nil {
+			logrus.WithError(err).WithField("call_id", call.ID).Warn("failed to accrue funding")
+		}
+	}
+}
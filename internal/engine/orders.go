@@ -0,0 +1,32 @@
+package engine
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// CheckOrderExpiry отменяет ордера с истекшим сроком действия (good-till-date) и уведомляет о них.
+// Вызывается периодически, как CheckIndicators.
+func (e *Engine) CheckOrderExpiry() []Event {
+	now := e.Clock.Now()
+
+	var events []Event
+	for _, order := range e.Store.GetExpiredLimitOrders(now) {
+		expired, err := e.Store.ExpireLimitOrder(order.ID)
+		if err != nil {
+			logrus.WithError(err).WithField("order_id", order.ID).Error("failed to expire limit order")
+			continue
+		}
+		if !expired {
+			continue // Ордер успели исполнить или отменить
+		}
+		order.Status = "expired"
+		events = append(events, LimitExpired{Order: order, At: now})
+	}
+
+	if e.Notifier != nil {
+		for _, ev := range events {
+			e.Notifier.Notify(ev)
+		}
+	}
+	return events
+}
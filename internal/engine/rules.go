@@ -0,0 +1,217 @@
+package engine
+
+import (
+	"example.com/alert-bot/internal/alerts"
+	"example.com/alert-bot/internal/levels"
+
+	"github.com/sirupsen/logrus"
+)
+
+// swingStrength сколько свечей с каждой стороны должны быть выше (ниже) swing low (high)
+const swingStrength = 2
+
+// swingCandles сколько закрытых свечей просматривается в поиске последнего swing
+const swingCandles = 50
+
+// checkTimeStops закрывает коллы, время жизни которых по правилу истекло, и возвращает события
+// вместе с коллами, которые остались открытыми.
+func (e *Engine) checkTimeStops(tick Tick, symbolCalls []alerts.Call) ([]Event, []alerts.Call) {
+	now := e.Clock.Now()
+
+	var events []Event
+	open := symbolCalls[:0:0]
+	for _, call := range symbolCalls {
+		if call.TimeStopAt == nil || now.Before(*call.TimeStopAt) {
+			open = append(open, call)
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"call_id":      call.ID,
+			"symbol":       call.Symbol,
+			"time_stop_at": call.TimeStopAt,
+			"price":        tick.Price,
+		}).Info("time stop triggered")
+
+		if err := e.Store.CloseCall(call.ID, call.UserID, tick.Price, alerts.CloseRemaining, alerts.FillSourceTimeStop); err != nil {
+			logrus.WithError(err).WithField("call_id", call.ID).Error("failed to close call by time stop")
+			open = append(open, call)
+			continue
+		}
+
+		closed := call
+		if c, err := e.Store.GetCallByID(call.ID, call.UserID); err == nil {
+			closed = *c
+		}
+		events = append(events, TimeStopHit{Call: closed, Price: tick.Price, At: now})
+	}
+	return events, open
+}
+
+// checkBreakEven переносит стоп в цену входа, когда PnL колла достиг порога правила безубытка.
+// Правило срабатывает один раз; перенесенный стоп записывается в symbolCalls.
+func (e *Engine) checkBreakEven(tick Tick, symbolCalls []alerts.Call) []Event {
+	var events []Event
+	for i := range symbolCalls {
+		call := &symbolCalls[i]
+		if call.BreakEvenPercent <= 0 || call.BreakEvenDone || call.EntryPrice <= 0 {
+			continue
+		}
+
+		var pnl float64
+		if call.Direction == "short" {
+			pnl = (call.EntryPrice - tick.Price) / call.EntryPrice * 100
+		} else {
+			pnl = (tick.Price - call.EntryPrice) / call.EntryPrice * 100
+		}
+		if pnl < call.BreakEvenPercent {
+			continue
+		}
+
+		moved, err := e.Store.TightenStopLoss(call.ID, call.EntryPrice)
+		if err != nil {
+			logrus.WithError(err).WithField("call_id", call.ID).Error("failed to move stop to break-even")
+			continue
+		}
+		call.BreakEvenDone = true
+		if err := e.Store.UpdateCallRules(*call); err != nil {
+			logrus.WithError(err).WithField("call_id", call.ID).Warn("failed to save break-even rule state")
+		}
+		if !moved {
+			continue // Стоп уже лучше цены входа
+		}
+
+		oldStop := call.StopLossPrice
+		call.StopLossPrice = call.EntryPrice
+		events = append(events, StopMoved{
+			Call:    *call,
+			OldStop: oldStop,
+			Reason:  StopReasonBreakEven,
+			Price:   tick.Price,
+			At:      e.Clock.Now(),
+		})
+	}
+	return events
+}
+
+// CheckCallRules подтягивает стопы коллов с правилом swing к последнему swing low (long) или
+// swing high (short) закрытых свечей таймфрейма. Каждый колл проверяется один раз на закрытую свечу;
+// свечи загружаются один раз на символ и таймфрейм. Вызывается периодически, как CheckIndicators.
+func (e *Engine) CheckCallRules() []Event {
+	if e.Candles == nil {
+		return nil
+	}
+	now := e.Clock.Now()
+
+	type candleKey struct{ symbol, timeframe string }
+	loaded := make(map[candleKey][]levels.Candle)
+
+	var events []Event
+	for _, call := range e.Store.GetAllOpenCalls() {
+		if call.SwingTimeframe == "" {
+			continue
+		}
+
+		closeAt, err := LastCandleClose(call.SwingTimeframe, now)
+		if err != nil {
+			logrus.WithError(err).WithField("call_id", call.ID).Warn("invalid swing timeframe")
+			continue
+		}
+		if call.SwingCheckedAt != nil && !closeAt.After(*call.SwingCheckedAt) {
+			continue // Новая свеча еще не закрылась
+		}
+
+		key := candleKey{call.Symbol, call.SwingTimeframe}
+		candles, ok := loaded[key]
+		if !ok {
+			raw, err := e.Candles.Candles(call.Symbol, call.SwingTimeframe, swingCandles+1)
+			if err != nil {
+				logrus.WithError(err).WithFields(logrus.Fields{
+					"symbol":    call.Symbol,
+					"timeframe": call.SwingTimeframe,
+				}).Warn("failed to load candles for swing stops")
+				continue
+			}
+			candles = ClosedCandles(raw, call.SwingTimeframe, now)
+			loaded[key] = candles
+		}
+		if len(candles) == 0 {
+			continue
+		}
+
+		call.SwingCheckedAt = &closeAt
+		if err := e.Store.UpdateCallRules(call); err != nil {
+			logrus.WithError(err).WithField("call_id", call.ID).Warn("failed to save swing rule state")
+		}
+
+		lastClose := candles[len(candles)-1].Close
+		swing, found := lastSwingLow(candles, swingStrength)
+		if call.Direction == "short" {
+			swing, found = lastSwingHigh(candles, swingStrength)
+		}
+		// Стоп по другую сторону от текущей цены сразу закрыл бы колл — такой swing пропускаем
+		if !found || (call.Direction == "short" && swing <= lastClose) || (call.Direction != "short" && swing >= lastClose) {
+			continue
+		}
+
+		moved, err := e.Store.TightenStopLoss(call.ID, swing)
+		if err != nil {
+			logrus.WithError(err).WithField("call_id", call.ID).Error("failed to tighten stop to swing")
+			continue
+		}
+		if !moved {
+			continue
+		}
+
+		oldStop := call.StopLossPrice
+		call.StopLossPrice = swing
+		events = append(events, StopMoved{
+			Call:    call,
+			OldStop: oldStop,
+			Reason:  StopReasonSwing,
+			Price:   lastClose,
+			At:      now,
+		})
+	}
+
+	if e.Notifier != nil {
+		for _, ev := range events {
+			e.Notifier.Notify(ev)
+		}
+	}
+	return events
+}
+
+// lastSwingLow возвращает минимум последней свечи, у которой strength свечей слева и справа
+// имеют более высокие минимумы.
+func lastSwingLow(candles []levels.Candle, strength int) (float64, bool) {
+	for i := len(candles) - 1 - strength; i >= strength; i-- {
+		pivot := true
+		for j := i - strength; j <= i+strength && pivot; j++ {
+			if j != i && candles[j].Low <= candles[i].Low {
+				pivot = false
+			}
+		}
+		if pivot {
+			return candles[i].Low, true
+		}
+	}
+	return 0, false
+}
+
+// lastSwingHigh возвращает максимум последней свечи, у которой strength свечей слева и справа
+// имеют более низкие максимумы.
+func lastSwingHigh(candles []levels.Candle, strength int) (float64, bool) {
+	for i := len(candles) - 1 - strength; i >= strength; i-- {
+		pivot := true
+		for j := i - strength; j <= i+strength && pivot; j++ {
+			if j != i && candles[j].High >= candles[i].High {
+				pivot = false
+			}
+		}
+		if pivot {
+			return candles[i].High, true
+		}
+	}
+	return 0, false
+}
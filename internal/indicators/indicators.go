@@ -0,0 +1,236 @@
+// Package indicators рассчитывает технические индикаторы по свечам.
+//
+// Все функции принимают свечи или значения в порядке возрастания времени и возвращают ряд
+// той же длины; значения, для которых еще не хватает истории, равны NaN.
+package indicators
+
+import (
+	"math"
+
+	"example.com/alert-bot/internal/levels"
+)
+
+// Closes возвращает цены закрытия свечей.
+func Closes(candles []levels.Candle) []float64 {
+	out := make([]float64, len(candles))
+	for i, c := range candles {
+		out[i] = c.Close
+	}
+	return out
+}
+
+func nanSeries(n int) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = math.NaN()
+	}
+	return out
+}
+
+// SMA простая скользящая средняя.
+func SMA(values []float64, period int) []float64 {
+	out := nanSeries(len(values))
+	if period <= 0 || len(values) < period {
+		return out
+	}
+
+	var sum float64
+	for i, v := range values {
+		sum += v
+		if i >= period {
+			sum -= values[i-period]
+		}
+		if i >= period-1 {
+			out[i] = sum / float64(period)
+		}
+	}
+	return out
+}
+
+// EMA экспоненциальная скользящая средняя; первое значение — SMA за period.
+// NaN во входном ряду (например, в начале другого индикатора) пропускаются.
+func EMA(values []float64, period int) []float64 {
+	out := nanSeries(len(values))
+	if period <= 0 {
+		return out
+	}
+
+	k := 2.0 / float64(period+1)
+	var sum float64
+	count := 0
+	prev := math.NaN()
+	for i, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		if count < period {
+			sum += v
+			count++
+			if count == period {
+				prev = sum / float64(period)
+				out[i] = prev
+			}
+			continue
+		}
+		prev = v*k + prev*(1-k)
+		out[i] = prev
+	}
+	return out
+}
+
+// RSI индекс относительной силы по Уайлдеру.
+func RSI(candles []levels.Candle, period int) []float64 {
+	closes := Closes(candles)
+	out := nanSeries(len(closes))
+	if period <= 0 || len(closes) <= period {
+		return out
+	}
+
+	var gain, loss float64
+	for i := 1; i <= period; i++ {
+		change := closes[i] - closes[i-1]
+		if change > 0 {
+			gain += change
+		} else {
+			loss -= change
+		}
+	}
+	avgGain := gain / float64(period)
+	avgLoss := loss / float64(period)
+	out[period] = rsiValue(avgGain, avgLoss)
+
+	for i := period + 1; i < len(closes); i++ {
+		change := closes[i] - closes[i-1]
+		g, l := 0.0, 0.0
+		if change > 0 {
+			g = change
+		} else {
+			l = -change
+		}
+		avgGain = (avgGain*float64(period-1) + g) / float64(period)
+		avgLoss = (avgLoss*float64(period-1) + l) / float64(period)
+		out[i] = rsiValue(avgGain, avgLoss)
+	}
+	return out
+}
+
+func rsiValue(avgGain, avgLoss float64) float64 {
+	if avgLoss == 0 {
+		if avgGain == 0 {
+			return 50
+		}
+		return 100
+	}
+	rs := avgGain / avgLoss
+	return 100 - 100/(1+rs)
+}
+
+// MACD возвращает линию MACD (EMA fast − EMA slow), сигнальную линию (EMA signal от MACD)
+// и гистограмму (MACD − сигнальная).
+func MACD(values []float64, fast, slow, signal int) (macd, signalLine, histogram []float64) {
+	fastEMA := EMA(values, fast)
+	slowEMA := EMA(values, slow)
+
+	macd = nanSeries(len(values))
+	for i := range values {
+		if !math.IsNaN(fastEMA[i]) && !math.IsNaN(slowEMA[i]) {
+			macd[i] = fastEMA[i] - slowEMA[i]
+		}
+	}
+
+	signalLine = EMA(macd, signal)
+	histogram = nanSeries(len(values))
+	for i := range values {
+		if !math.IsNaN(macd[i]) && !math.IsNaN(signalLine[i]) {
+			histogram[i] = macd[i] - signalLine[i]
+		}
+	}
+	return macd, signalLine, histogram
+}
+
+// Bollinger возвращает среднюю линию (SMA) и полосы на k стандартных отклонений от нее.
+func Bollinger(values []float64, period int, k float64) (middle, upper, lower []float64) {
+	middle = SMA(values, period)
+	upper = nanSeries(len(values))
+	lower = nanSeries(len(values))
+
+	for i := range values {
+		if math.IsNaN(middle[i]) {
+			continue
+		}
+		var variance float64
+		for _, v := range values[i-period+1 : i+1] {
+			variance += (v - middle[i]) * (v - middle[i])
+		}
+		std := math.Sqrt(variance / float64(period))
+		upper[i] = middle[i] + k*std
+		lower[i] = middle[i] - k*std
+	}
+	return middle, upper, lower
+}
+
+// ATR средний истинный диапазон по Уайлдеру.
+func ATR(candles []levels.Candle, period int) []float64 {
+	out := nanSeries(len(candles))
+	if period <= 0 || len(candles) <= period {
+		return out
+	}
+
+	trueRange := func(i int) float64 {
+		c := candles[i]
+		tr := c.High - c.Low
+		if i > 0 {
+			prevClose := candles[i-1].Close
+			tr = math.Max(tr, math.Max(math.Abs(c.High-prevClose), math.Abs(c.Low-prevClose)))
+		}
+		return tr
+	}
+
+	var sum float64
+	for i := 1; i <= period; i++ {
+		sum += trueRange(i)
+	}
+	atr := sum / float64(period)
+	out[period] = atr
+
+	for i := period + 1; i < len(candles); i++ {
+		atr = (atr*float64(period-1) + trueRange(i)) / float64(period)
+		out[i] = atr
+	}
+	return out
+}
+
+// Last возвращает последнее значение ряда; false, если его еще нет.
+func Last(series []float64) (float64, bool) {
+	if len(series) == 0 || math.IsNaN(series[len(series)-1]) {
+		return 0, false
+	}
+	return series[len(series)-1], true
+}
+
+// CrossedAbove сообщает, что на последнем значении ряд a пересек ряд b снизу вверх.
+func CrossedAbove(a, b []float64) bool {
+	n := len(a)
+	if n < 2 || len(b) != n || anyNaN(a[n-2], a[n-1], b[n-2], b[n-1]) {
+		return false
+	}
+	return a[n-2] <= b[n-2] && a[n-1] > b[n-1]
+}
+
+// CrossedBelow сообщает, что на последнем значении ряд a пересек ряд b сверху вниз.
+func CrossedBelow(a, b []float64) bool {
+	n := len(a)
+	if n < 2 || len(b) != n || anyNaN(a[n-2], a[n-1], b[n-2], b[n-1]) {
+		return false
+	}
+	return a[n-2] >= b[n-2] && a[n-1] < b[n-1]
+}
+
+func anyNaN(values ...float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package indicators
+
+import (
+	"math"
+	"testing"
+
+	"example.com/alert-bot/internal/levels"
+)
+
+var nan = math.NaN()
+
+// assertSeries сравнивает ряд с ожидаемым; NaN в ожидаемом ряду означает «значения еще нет».
+func assertSeries(t *testing.T, name string, got, want []float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: длина %d, ожидалось %d", name, len(got), len(want))
+	}
+	for i := range want {
+		if math.IsNaN(want[i]) != math.IsNaN(got[i]) || (!math.IsNaN(want[i]) && math.Abs(got[i]-want[i]) > 1e-9) {
+			t.Errorf("%s[%d] = %v, ожидалось %v", name, i, got[i], want[i])
+		}
+	}
+}
+
+// closesOnly свечи, у которых задана только цена закрытия.
+func closesOnly(closes ...float64) []levels.Candle {
+	candles := make([]levels.Candle, len(closes))
+	for i, c := range closes {
+		candles[i] = levels.Candle{Open: c, High: c, Low: c, Close: c}
+	}
+	return candles
+}
+
+func TestSMA(t *testing.T) {
+	assertSeries(t, "SMA", SMA([]float64{1, 2, 3, 4, 5}, 3), []float64{nan, nan, 2, 3, 4})
+}
+
+func TestEMA(t *testing.T) {
+	// k = 2/(3+1) = 0.5, первое значение — SMA(2, 4, 6) = 4
+	assertSeries(t, "EMA", EMA([]float64{2, 4, 6, 8, 12, 14}, 3), []float64{nan, nan, 4, 6, 9, 11.5})
+	// NaN в начале ряда пропускаются, отсчет периода начинается с первого значения
+	assertSeries(t, "EMA с NaN", EMA([]float64{nan, 2, 4, 6, 8}, 3), []float64{nan, nan, nan, 4, 6})
+}
+
+func TestRSIWilder(t *testing.T) {
+	// Изменения +1, -1, +2, -1. Первое значение: средние 0.5 и 0.5 → 50.
+	// Затем сглаживание Уайлдера: (0.5+2)/2 = 1.25 и (0.5+0)/2 = 0.25 → RS 5 → 83.33;
+	// (1.25+0)/2 и (0.25+1)/2 = 0.625 → 50 (простое среднее последних изменений дало бы 66.67)
+	rsi := RSI(closesOnly(10, 11, 10, 12, 11), 2)
+	assertSeries(t, "RSI", rsi, []float64{nan, nan, 50, 100 - 100.0/6, 50})
+
+	assertSeries(t, "RSI без убытков", RSI(closesOnly(1, 2, 3, 4), 2), []float64{nan, nan, 100, 100})
+	assertSeries(t, "RSI без изменений", RSI(closesOnly(5, 5, 5), 2), []float64{nan, nan, 50})
+}
+
+func TestMACD(t *testing.T) {
+	// EMA(2): 3, 5, 7, 31/3, 115/9; EMA(3): 4, 6, 9, 11.5
+	macd, signal, hist := MACD([]float64{2, 4, 6, 8, 12, 14}, 2, 3, 2)
+	assertSeries(t, "MACD", macd, []float64{nan, nan, 1, 1, 4.0 / 3, 23.0 / 18})
+	assertSeries(t, "MACD signal", signal, []float64{nan, nan, nan, 1, 11.0 / 9, 34.0 / 27})
+	assertSeries(t, "MACD histogram", hist, []float64{nan, nan, nan, 0, 1.0 / 9, 1.0 / 54})
+}
+
+func TestBollinger(t *testing.T) {
+	// Стандартное отклонение генеральной совокупности: sqrt(((2-4)² + 0 + (6-4)²)/3) = sqrt(8/3)
+	std := math.Sqrt(8.0 / 3)
+	middle, upper, lower := Bollinger([]float64{2, 4, 6, 6}, 3, 2)
+	assertSeries(t, "Bollinger middle", middle, []float64{nan, nan, 4, 16.0 / 3})
+	assertSeries(t, "Bollinger upper", upper, []float64{nan, nan, 4 + 2*std, 16.0/3 + 2*math.Sqrt(8.0/9)})
+	assertSeries(t, "Bollinger lower", lower, []float64{nan, nan, 4 - 2*std, 16.0/3 - 2*math.Sqrt(8.0/9)})
+}
+
+func TestATRWilder(t *testing.T) {
+	candles := []levels.Candle{
+		{High: 10, Low: 8, Close: 9},
+		{High: 11, Low: 9, Close: 10},  // TR = 2
+		{High: 15, Low: 13, Close: 14}, // Гэп: TR = 15 - 10 = 5
+		{High: 14, Low: 12, Close: 13}, // TR = max(2, 0, 2) = 2
+	}
+	// Первое значение — среднее TR за период: (2+5)/2; затем (3.5*1 + 2)/2
+	assertSeries(t, "ATR", ATR(candles, 2), []float64{nan, nan, 3.5, 2.75})
+}
+
+func TestLastWithoutEnoughData(t *testing.T) {
+	cases := []struct {
+		name   string
+		series []float64
+	}{
+		{"пустой ряд", nil},
+		{"SMA короче периода", SMA([]float64{1, 2}, 3)},
+		{"RSI без изменения после периода", RSI(closesOnly(1, 2, 3), 3)},
+		{"ATR короче периода", ATR(closesOnly(1, 2), 2)},
+		{"MACD без сигнальной линии", func() []float64 { _, s, _ := MACD([]float64{1, 2, 3}, 2, 3, 2); return s }()},
+	}
+	for _, c := range cases {
+		if v, ok := Last(c.series); ok {
+			t.Errorf("%s: Last = %v, ожидалось отсутствие значения", c.name, v)
+		}
+	}
+
+	if v, ok := Last(SMA([]float64{1, 2, 3}, 3)); !ok || v != 2 {
+		t.Errorf("Last = %v, %v; ожидалось 2, true", v, ok)
+	}
+}
@@ -33,6 +33,11 @@ func NewBitgetClient(baseURL string) *BitgetClient {
 }
 
 func (c *BitgetClient) GetCandles(symbol, granularity string, limit int) ([]Candle, error) {
+	return c.GetCandlesUntil(symbol, granularity, limit, time.Now())
+}
+
+// GetCandlesUntil возвращает до 200 свечей, открытых до момента end (для загрузки истории страницами).
+func (c *BitgetClient) GetCandlesUntil(symbol, granularity string, limit int, end time.Time) ([]Candle, error) {
 	endpoint := c.baseURL + "/api/v2/spot/market/history-candles"
 
 	params := url.Values{}
@@ -45,7 +50,7 @@ func (c *BitgetClient) GetCandles(symbol, granularity string, limit int) ([]Cand
 		capped = 200
 	}
 	params.Add("limit", strconv.Itoa(capped))
-	params.Add("endTime", strconv.FormatInt(time.Now().UnixMilli(), 10))
+	params.Add("endTime", strconv.FormatInt(end.UnixMilli(), 10))
 
 	fullURL := fmt.Sprintf("%s?%s", endpoint, params.Encode())
 
@@ -126,3 +131,31 @@ func ParseTimeframe(tf string) (string, error) {
 		return "1day", fmt.Errorf("unsupported timeframe: %s, using default 1D", tf)
 	}
 }
+
+// TimeframeDuration возвращает длительность свечи таймфрейма (1m, 5m, 15m, 30m, 1h, 4h, 6h, 12h, 1d, 1w).
+func TimeframeDuration(tf string) (time.Duration, error) {
+	switch strings.ToLower(tf) {
+	case "1m":
+		return time.Minute, nil
+	case "5m":
+		return 5 * time.Minute, nil
+	case "15m":
+		return 15 * time.Minute, nil
+	case "30m":
+		return 30 * time.Minute, nil
+	case "1h":
+		return time.Hour, nil
+	case "4h":
+		return 4 * time.Hour, nil
+	case "6h":
+		return 6 * time.Hour, nil
+	case "12h":
+		return 12 * time.Hour, nil
+	case "1d":
+		return 24 * time.Hour, nil
+	case "1w":
+		return 7 * 24 * time.Hour, nil
+	default:
+		return 0, fmt.Errorf("unsupported timeframe: %s", tf)
+	}
+}
@@ -0,0 +1,93 @@
+package levels
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/wcharczuk/go-chart/v2"
+	"github.com/wcharczuk/go-chart/v2/drawing"
+)
+
+// GenerateEquityChart строит PNG кривой капитала: значения values в моменты times
+// и пунктир исходного уровня (первой точки).
+func (cg *BasicChartGenerator) GenerateEquityChart(title string, times []time.Time, values []float64) ([]byte, error) {
+	if len(times) < 2 || len(times) != len(values) {
+		return nil, fmt.Errorf("not enough equity points")
+	}
+
+	minValue, maxValue := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		minValue = math.Min(minValue, v)
+		maxValue = math.Max(maxValue, v)
+	}
+	pad := (maxValue - minValue) * 0.05
+	if pad == 0 {
+		pad = math.Max(math.Abs(maxValue)*0.01, 1)
+	}
+
+	series := chart.TimeSeries{
+		Name:    "Equity",
+		XValues: times,
+		YValues: values,
+		Style: chart.Style{
+			StrokeColor: drawing.ColorBlue,
+			StrokeWidth: 2,
+		},
+	}
+
+	// Исходный уровень депозита
+	baseline := chart.TimeSeries{
+		Name:    "Start",
+		XValues: []time.Time{times[0], times[len(times)-1]},
+		YValues: []float64{values[0], values[0]},
+		Style: chart.Style{
+			StrokeColor:     drawing.ColorBlack,
+			StrokeWidth:     1,
+			StrokeDashArray: []float64{5, 5},
+		},
+	}
+
+	timeFormat := "02.01"
+	if times[len(times)-1].Sub(times[0]) < 48*time.Hour {
+		timeFormat = "02.01 15:04"
+	}
+
+	graph := chart.Chart{
+		Title:  title,
+		Width:  cg.width,
+		Height: cg.height,
+		Background: chart.Style{
+			Padding: chart.Box{
+				Top:    20,
+				Left:   20,
+				Right:  60,
+				Bottom: 20,
+			},
+		},
+		XAxis: chart.XAxis{
+			ValueFormatter: chart.TimeValueFormatterWithFormat(timeFormat),
+		},
+		YAxis: chart.YAxis{
+			Name: "Equity",
+			ValueFormatter: func(v interface{}) string {
+				if vf, isFloat := v.(float64); isFloat {
+					return fmt.Sprintf("%.2f", vf)
+				}
+				return ""
+			},
+			Range: &chart.ContinuousRange{
+				Min: minValue - pad,
+				Max: maxValue + pad,
+			},
+		},
+		Series: []chart.Series{baseline, series},
+	}
+
+	buffer := bytes.NewBuffer([]byte{})
+	if err := graph.Render(chart.PNG, buffer); err != nil {
+		return nil, fmt.Errorf("failed to render equity chart: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,335 @@
+package prices
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/alert-bot/internal/config"
+	"example.com/alert-bot/internal/levels"
+)
+
+// NewDefaultRegistry создает реестр со встроенными адаптерами Variational, Bitget и Bybit
+// и применяет порядок опроса из конфигурации.
+func NewDefaultRegistry(cfg config.Config) *Registry {
+	variationalClient := &http.Client{Timeout: 10 * time.Second}
+	bitgetClient := &http.Client{Timeout: 10 * time.Second}
+	bybitClient := &http.Client{Timeout: 10 * time.Second}
+
+	registry := NewRegistry()
+	registry.Register(NewVariationalExchange(variationalClient))
+	registry.Register(NewBitgetExchange(bitgetClient, "spot"))
+	registry.Register(NewBitgetExchange(bitgetClient, "futures"))
+	registry.Register(NewBybitExchange(bybitClient, "spot"))
+	registry.Register(NewBybitExchange(bybitClient, "futures"))
+
+	// Консервативные лимиты ниже публичных ограничений бирж по IP
+	registry.SetRateLimit("Variational", 5, 5)
+	registry.SetRateLimit("Bitget", 10, 10)
+	registry.SetRateLimit("Bybit", 10, 10)
+
+	if len(cfg.ExchangePriority) > 0 {
+		registry.SetPriority(cfg.ExchangePriority)
+	} else {
+		registry.SetPriority(DefaultPriority)
+	}
+
+	return registry
+}
+
+// --- Variational ---
+
+// VariationalExchange адаптер фьючерсного рынка Variational.
+type VariationalExchange struct {
+	client *http.Client
+}
+
+// NewVariationalExchange создает адаптер Variational.
+func NewVariationalExchange(client *http.Client) *VariationalExchange {
+	return &VariationalExchange{client: client}
+}
+
+func (e *VariationalExchange) Name() string   { return "Variational" }
+func (e *VariationalExchange) Market() string { return "futures" }
+
+func (e *VariationalExchange) CurrentPrice(ctx context.Context, symbol string) (float64, error) {
+	return fetchVariationalPrice(ctx, e.client, symbol)
+}
+
+// HistoricalClose не поддерживается: у Variational нет исторического API.
+func (e *VariationalExchange) HistoricalClose(ctx context.Context, symbol string, at time.Time) (float64, error) {
+	return 0, ErrNotSupported
+}
+
+// Candles не поддерживается: у Variational нет свечей.
+func (e *VariationalExchange) Candles(ctx context.Context, symbol string, interval time.Duration, limit int) ([]levels.Candle, error) {
+	return nil, ErrNotSupported
+}
+
+// Symbols возвращает тикеры Variational в формате бота (BTC → BTCUSDT).
+func (e *VariationalExchange) Symbols(ctx context.Context) ([]string, error) {
+	listings, err := fetchVariationalListings(ctx, e.client)
+	if err != nil {
+		return nil, err
+	}
+
+	symbols := make([]string, 0, len(listings))
+	for _, listing := range listings {
+		symbols = append(symbols, strings.ToUpper(listing.Ticker)+"USDT")
+	}
+	return symbols, nil
+}
+
+// Tickers возвращает mark price всех листингов Variational одним запросом.
+// Символ доступен по тикеру и по парам с USDT/USDC/PERP, как и в CurrentPrice.
+func (e *VariationalExchange) Tickers(ctx context.Context) (map[string]float64, error) {
+	listings, err := fetchVariationalListings(ctx, e.client)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]float64, len(listings)*4)
+	for _, listing := range listings {
+		price, err := parseFloat(listing.MarkPrice)
+		if err != nil || price <= 0 {
+			continue
+		}
+		ticker := strings.ToUpper(listing.Ticker)
+		result[ticker] = price
+		for _, suffix := range []string{"USDT", "USDC", "PERP"} {
+			result[ticker+suffix] = price
+		}
+	}
+	return result, nil
+}
+
+// --- Bitget ---
+
+// bitgetSpotGranularity и bitgetFuturesGranularity сопоставляют интервал свечей с параметром API Bitget.
+var bitgetSpotGranularity = map[time.Duration]string{
+	time.Minute:        "1min",
+	5 * time.Minute:    "5min",
+	15 * time.Minute:   "15min",
+	30 * time.Minute:   "30min",
+	time.Hour:          "1h",
+	4 * time.Hour:      "4h",
+	6 * time.Hour:      "6h",
+	12 * time.Hour:     "12h",
+	24 * time.Hour:     "1day",
+	7 * 24 * time.Hour: "1week",
+}
+
+var bitgetFuturesGranularity = map[time.Duration]string{
+	time.Minute:        "1m",
+	5 * time.Minute:    "5m",
+	15 * time.Minute:   "15m",
+	30 * time.Minute:   "30m",
+	time.Hour:          "1H",
+	4 * time.Hour:      "4H",
+	6 * time.Hour:      "6H",
+	12 * time.Hour:     "12H",
+	24 * time.Hour:     "1D",
+	7 * 24 * time.Hour: "1W",
+}
+
+// BitgetExchange адаптер спотового или фьючерсного (USDT-FUTURES) рынка Bitget.
+type BitgetExchange struct {
+	client *http.Client
+	market string
+}
+
+// NewBitgetExchange создает адаптер Bitget для рынка "spot" или "futures".
+func NewBitgetExchange(client *http.Client, market string) *BitgetExchange {
+	return &BitgetExchange{client: client, market: market}
+}
+
+func (e *BitgetExchange) Name() string   { return "Bitget" }
+func (e *BitgetExchange) Market() string { return e.market }
+
+func (e *BitgetExchange) source() string { return "Bitget " + e.market }
+
+func (e *BitgetExchange) CurrentPrice(ctx context.Context, symbol string) (float64, error) {
+	if e.market == "futures" {
+		return fetchBitgetFuturesPrice(ctx, e.client, symbol)
+	}
+	return fetchBitgetSpotPriceOnly(ctx, e.client, symbol)
+}
+
+func (e *BitgetExchange) HistoricalClose(ctx context.Context, symbol string, at time.Time) (float64, error) {
+	if e.market == "futures" {
+		return fetchHistoricalPriceBitgetFutures(ctx, e.client, symbol, at)
+	}
+	return fetchHistoricalPriceBitgetSpot(ctx, e.client, symbol, at)
+}
+
+func (e *BitgetExchange) Candles(ctx context.Context, symbol string, interval time.Duration, limit int) ([]levels.Candle, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 200
+	}
+
+	var url string
+	if e.market == "futures" {
+		granularity, ok := bitgetFuturesGranularity[interval]
+		if !ok {
+			return nil, fmt.Errorf("unsupported bitget interval %s", interval)
+		}
+		url = fmt.Sprintf("https://api.bitget.com/api/v2/mix/market/candles?symbol=%s&granularity=%s&limit=%d&productType=USDT-FUTURES",
+			symbol, granularity, limit)
+	} else {
+		granularity, ok := bitgetSpotGranularity[interval]
+		if !ok {
+			return nil, fmt.Errorf("unsupported bitget interval %s", interval)
+		}
+		url = fmt.Sprintf("https://api.bitget.com/api/v2/spot/market/candles?symbol=%s&granularity=%s&limit=%d",
+			symbol, granularity, limit)
+	}
+
+	rows, err := fetchBitgetCandles(ctx, e.client, url, symbol, e.source())
+	if err != nil {
+		return nil, err
+	}
+	return parseCandleRows(rows), nil
+}
+
+// tickersURL адрес списка всех тикеров рынка
+func (e *BitgetExchange) tickersURL() string {
+	if e.market == "futures" {
+		return "https://api.bitget.com/api/v2/mix/market/tickers?productType=USDT-FUTURES"
+	}
+	return "https://api.bitget.com/api/v2/spot/market/tickers"
+}
+
+// Tickers возвращает цены всех символов рынка одним запросом.
+func (e *BitgetExchange) Tickers(ctx context.Context) (map[string]float64, error) {
+	tickers, err := fetchBitgetTickers(ctx, e.client, e.tickersURL(), e.source())
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]float64, len(tickers))
+	for _, ticker := range tickers {
+		if price, err := bitgetTickerPrice(ticker, e.source()); err == nil && price > 0 {
+			result[strings.ToUpper(ticker.Symbol)] = price
+		}
+	}
+	return result, nil
+}
+
+func (e *BitgetExchange) Symbols(ctx context.Context) ([]string, error) {
+	tickers, err := fetchBitgetTickers(ctx, e.client, e.tickersURL(), e.source())
+	if err != nil {
+		return nil, err
+	}
+
+	symbols := make([]string, 0, len(tickers))
+	for _, ticker := range tickers {
+		symbols = append(symbols, strings.ToUpper(ticker.Symbol))
+	}
+	return symbols, nil
+}
+
+// --- Bybit ---
+
+// bybitInterval сопоставляет интервал свечей с параметром API Bybit.
+var bybitInterval = map[time.Duration]string{
+	time.Minute:        "1",
+	5 * time.Minute:    "5",
+	15 * time.Minute:   "15",
+	30 * time.Minute:   "30",
+	time.Hour:          "60",
+	4 * time.Hour:      "240",
+	6 * time.Hour:      "360",
+	12 * time.Hour:     "720",
+	24 * time.Hour:     "D",
+	7 * 24 * time.Hour: "W",
+}
+
+// BybitExchange адаптер спотового или линейного (USDT perpetual) рынка Bybit.
+type BybitExchange struct {
+	client *http.Client
+	market string
+}
+
+// NewBybitExchange создает адаптер Bybit для рынка "spot" или "futures".
+func NewBybitExchange(client *http.Client, market string) *BybitExchange {
+	return &BybitExchange{client: client, market: market}
+}
+
+func (e *BybitExchange) Name() string   { return "Bybit" }
+func (e *BybitExchange) Market() string { return e.market }
+
+func (e *BybitExchange) source() string { return "Bybit " + e.market }
+
+// category возвращает категорию Bybit API для рынка
+func (e *BybitExchange) category() string {
+	if e.market == "futures" {
+		return "linear"
+	}
+	return "spot"
+}
+
+func (e *BybitExchange) CurrentPrice(ctx context.Context, symbol string) (float64, error) {
+	if e.market == "futures" {
+		return FetchBybitFuturesPrice(ctx, e.client, symbol)
+	}
+	return FetchBybitSpotPrice(ctx, e.client, symbol)
+}
+
+func (e *BybitExchange) HistoricalClose(ctx context.Context, symbol string, at time.Time) (float64, error) {
+	return FetchBybitHistoricalPrice(ctx, e.client, symbol, at, e.category())
+}
+
+func (e *BybitExchange) Candles(ctx context.Context, symbol string, interval time.Duration, limit int) ([]levels.Candle, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 200
+	}
+
+	bybitIntervalStr, ok := bybitInterval[interval]
+	if !ok {
+		return nil, fmt.Errorf("unsupported bybit interval %s", interval)
+	}
+
+	url := fmt.Sprintf("https://api.bybit.com/v5/market/kline?category=%s&symbol=%s&interval=%s&limit=%d",
+		e.category(), symbol, bybitIntervalStr, limit)
+
+	rows, err := fetchBybitCandles(ctx, e.client, url, symbol, e.source())
+	if err != nil {
+		return nil, err
+	}
+	return parseCandleRows(rows), nil
+}
+
+// Tickers возвращает цены всех символов рынка одним запросом.
+func (e *BybitExchange) Tickers(ctx context.Context) (map[string]float64, error) {
+	url := fmt.Sprintf("https://api.bybit.com/v5/market/tickers?category=%s", e.category())
+
+	tickers, err := fetchBybitTickers(ctx, e.client, url, e.source())
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]float64, len(tickers))
+	for _, ticker := range tickers {
+		if price, err := bybitTickerPrice(ticker, e.source()); err == nil && price > 0 {
+			result[strings.ToUpper(ticker.Symbol)] = price
+		}
+	}
+	return result, nil
+}
+
+func (e *BybitExchange) Symbols(ctx context.Context) ([]string, error) {
+	url := fmt.Sprintf("https://api.bybit.com/v5/market/tickers?category=%s", e.category())
+
+	tickers, err := fetchBybitTickers(ctx, e.client, url, e.source())
+	if err != nil {
+		return nil, err
+	}
+
+	symbols := make([]string, 0, len(tickers))
+	for _, ticker := range tickers {
+		symbols = append(symbols, strings.ToUpper(ticker.Symbol))
+	}
+	return symbols, nil
+}
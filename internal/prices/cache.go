@@ -0,0 +1,157 @@
+package prices
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Quote последняя известная цена символа на конкретной бирже и рынке.
+type Quote struct {
+	Symbol   string
+	Exchange string
+	Market   string
+	Price    float64
+	AsOf     time.Time // Когда цена получена с биржи
+}
+
+type quoteKey struct {
+	symbol string
+	source string // sourceKey биржи и рынка
+}
+
+// QuoteCache общий кеш котировок: монитор заполняет его, команды бота читают.
+// Котировки старше TTL считаются устаревшими и запрашиваются заново.
+type QuoteCache struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	quotes  map[quoteKey]Quote
+	latest  map[string]quoteKey        // symbol → источник последней котировки
+	changes map[quoteKey]cachedChanges // изменения за 15м/1ч/4ч/24ч (только для /p и /allp), живут TTL
+}
+
+type cachedChanges struct {
+	info PriceInfo
+	at   time.Time
+}
+
+// NewQuoteCache создает кеш с заданным временем жизни котировки.
+func NewQuoteCache(ttl time.Duration) *QuoteCache {
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	return &QuoteCache{
+		ttl:     ttl,
+		quotes:  make(map[quoteKey]Quote),
+		latest:  make(map[string]quoteKey),
+		changes: make(map[quoteKey]cachedChanges),
+	}
+}
+
+// TTL возвращает время жизни котировки.
+func (c *QuoteCache) TTL() time.Duration { return c.ttl }
+
+// Put сохраняет котировку, если она не старее уже сохраненной.
+func (c *QuoteCache) Put(q Quote) {
+	if q.Price <= 0 {
+		return
+	}
+	if q.AsOf.IsZero() {
+		q.AsOf = time.Now()
+	}
+	q.Symbol = strings.ToUpper(q.Symbol)
+	key := quoteKey{symbol: q.Symbol, source: sourceKey(q.Exchange, q.Market)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.quotes[key]; ok && existing.AsOf.After(q.AsOf) {
+		return
+	}
+	c.quotes[key] = q
+	c.latest[q.Symbol] = key
+}
+
+// Get возвращает свежую котировку символа. Если биржа или рынок не указаны,
+// возвращается последняя котировка символа с любого источника.
+func (c *QuoteCache) Get(symbol, exchange, market string) (Quote, bool) {
+	symbol = strings.ToUpper(symbol)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	key := quoteKey{symbol: symbol, source: sourceKey(exchange, market)}
+	if exchange == "" || market == "" {
+		latest, ok := c.latest[symbol]
+		if !ok {
+			return Quote{}, false
+		}
+		key = latest
+	}
+
+	q, ok := c.quotes[key]
+	if !ok || time.Since(q.AsOf) > c.ttl {
+		return Quote{}, false
+	}
+	return q, true
+}
+
+// CurrentPrice возвращает цену из кеша, а при отсутствии свежей котировки запрашивает ее у бирж и кеширует.
+func (c *QuoteCache) CurrentPrice(registry *Registry, symbol, preferredExchange, preferredMarket string) (*FetchPriceInfoResult, error) {
+	if q, ok := c.Get(symbol, preferredExchange, preferredMarket); ok {
+		return &FetchPriceInfoResult{
+			PriceInfo: PriceInfo{CurrentPrice: q.Price, Source: q.Exchange + " " + q.Market},
+			Exchange:  q.Exchange,
+			Market:    q.Market,
+			AsOf:      q.AsOf,
+		}, nil
+	}
+
+	result, err := FetchCurrentPrice(registry, symbol, preferredExchange, preferredMarket)
+	if err != nil {
+		return nil, err
+	}
+	c.Put(Quote{Symbol: symbol, Exchange: result.Exchange, Market: result.Market, Price: result.CurrentPrice, AsOf: result.AsOf})
+	return result, nil
+}
+
+// PriceInfo возвращает цену с изменениями за 15м/1ч/4ч/24ч. Текущая цена берется из кеша,
+// изменения пересчитываются не чаще одного раза за TTL.
+func (c *QuoteCache) PriceInfo(registry *Registry, symbol, preferredExchange, preferredMarket string) (*FetchPriceInfoResult, error) {
+	result, err := c.CurrentPrice(registry, symbol, preferredExchange, preferredMarket)
+	if err != nil {
+		return nil, err
+	}
+
+	key := quoteKey{symbol: strings.ToUpper(symbol), source: sourceKey(result.Exchange, result.Market)}
+	c.mu.RLock()
+	cached, ok := c.changes[key]
+	c.mu.RUnlock()
+
+	if ok && time.Since(cached.at) <= c.ttl {
+		result.Change15m = cached.info.Change15m
+		result.Change1h = cached.info.Change1h
+		result.Change4h = cached.info.Change4h
+		result.Change24h = cached.info.Change24h
+		return result, nil
+	}
+
+	fillPriceChanges(registry, symbol, result)
+
+	now := time.Now()
+	c.mu.Lock()
+	c.pruneChanges(now)
+	c.changes[key] = cachedChanges{info: result.PriceInfo, at: now}
+	c.mu.Unlock()
+
+	return result, nil
+}
+
+// pruneChanges удаляет изменения старше TTL, чтобы кеш не рос с каждым запрошенным символом.
+// Вызывается под c.mu при каждой записи, то есть не чаще одного раза за TTL на символ.
+func (c *QuoteCache) pruneChanges(now time.Time) {
+	for key, cached := range c.changes {
+		if now.Sub(cached.at) > c.ttl {
+			delete(c.changes, key)
+		}
+	}
+}
@@ -1,38 +1,21 @@
 package prices
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
-	"example.com/alert-bot/internal/config"
+	"example.com/alert-bot/internal/levels"
 )
 
-// ExchangeClients содержит HTTP клиенты для различных бирж.
-type ExchangeClients struct {
-	VariationalClient *http.Client
-	BitgetClient      *http.Client
-	BybitClient       *http.Client
-}
-
-// NewExchangeClients создает и инициализирует клиенты для бирж.
-func NewExchangeClients(cfg config.Config) *ExchangeClients {
-	variationalClient := &http.Client{Timeout: 10 * time.Second}
-	bitgetClient := &http.Client{Timeout: 10 * time.Second}
-	bybitClient := &http.Client{Timeout: 10 * time.Second}
-
-	return &ExchangeClients{
-		VariationalClient: variationalClient,
-		BitgetClient:      bitgetClient,
-		BybitClient:       bybitClient,
-	}
-}
-
 // --- Variational API types ---
 
 // VariationalStatsResponse описывает ответ Variational API /metadata/stats
@@ -57,48 +40,62 @@ type VariationalListing struct {
 
 const variationalBaseURL = "https://omni-client-api.prod.ap-northeast-1.variational.io"
 
-// fetchVariationalPrice получает цену с Variational по тикеру.
-// Variational хранит тикеры без суффикса (BTC, ETH), поэтому обрезаем USDT/USDC если есть.
-func fetchVariationalPrice(client *http.Client, symbol string) (float64, error) {
-	// Нормализуем символ: убираем USDT/USDC суффикс, если есть
+// variationalTicker нормализует символ для Variational: убирает USDT/USDC/PERP суффикс, если есть.
+func variationalTicker(symbol string) string {
 	ticker := strings.ToUpper(symbol)
 	for _, suffix := range []string{"USDT", "USDC", "PERP"} {
 		ticker = strings.TrimSuffix(ticker, suffix)
 	}
+	return ticker
+}
 
+// fetchVariationalListings получает все листинги Variational.
+func fetchVariationalListings(ctx context.Context, client *http.Client) ([]VariationalListing, error) {
 	url := fmt.Sprintf("%s/metadata/stats", variationalBaseURL)
 
 	logrus.WithFields(logrus.Fields{
 		"url":    url,
-		"ticker": ticker,
 		"source": "Variational futures",
 	}).Debug("variational request")
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return 0, fmt.Errorf("variational http status %d", resp.StatusCode)
+		return nil, fmt.Errorf("variational http status %d", resp.StatusCode)
 	}
 
 	var response VariationalStatsResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return 0, fmt.Errorf("failed to decode variational response: %w", err)
+		return nil, fmt.Errorf("failed to decode variational response: %w", err)
 	}
 
 	if len(response.Listings) == 0 {
-		return 0, fmt.Errorf("no listings in variational response")
+		return nil, fmt.Errorf("no listings in variational response")
 	}
 
-	for _, listing := range response.Listings {
+	return response.Listings, nil
+}
+
+// fetchVariationalPrice получает цену с Variational по тикеру.
+// Variational хранит тикеры без суффикса (BTC, ETH), поэтому обрезаем USDT/USDC если есть.
+func fetchVariationalPrice(ctx context.Context, client *http.Client, symbol string) (float64, error) {
+	ticker := variationalTicker(symbol)
+
+	listings, err := fetchVariationalListings(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, listing := range listings {
 		if strings.ToUpper(listing.Ticker) == ticker {
 			price, err := parseFloat(listing.MarkPrice)
 			if err != nil {
@@ -183,7 +180,8 @@ type BybitTicker struct {
 	Price24hPcnt string `json:"price24hPcnt"`
 	HighPrice24h string `json:"highPrice24h"`
 	LowPrice24h  string `json:"lowPrice24h"`
-	MarkPrice    string `json:"markPrice,omitempty"` // Поле для фьючерсов
+	MarkPrice    string `json:"markPrice,omitempty"`   // Поле для фьючерсов
+	FundingRate  string `json:"fundingRate,omitempty"` // Ставка финансирования (фьючерсы)
 }
 
 // BybitCandleResponse описывает ответ Bybit API для исторических данных (свечей)
@@ -213,64 +211,81 @@ type PriceInfo struct {
 // FetchPriceInfoResult содержит информацию о цене и источнике
 type FetchPriceInfoResult struct {
 	PriceInfo
-	Exchange string // "Variational", "Bitget" или "Bybit"
-	Market   string // "spot" или "futures"
+	Exchange string    // "Variational", "Bitget" или "Bybit"
+	Market   string    // "spot" или "futures"
+	AsOf     time.Time // Когда цена получена с биржи
 }
 
 // --- Bitget helpers ---
 
-// fetchWithURL общая функция для получения данных с Bitget
-func fetchWithURL(client *http.Client, url, symbol, source string) (float64, error) {
+// fetchBitgetTickers получает список тикеров Bitget по URL
+func fetchBitgetTickers(ctx context.Context, client *http.Client, url, source string) ([]BitgetTicker, error) {
 	logrus.WithFields(logrus.Fields{
 		"url":    url,
 		"source": source,
 	}).Debug("bitget request")
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return 0, fmt.Errorf("bitget http status %d", resp.StatusCode)
+		return nil, fmt.Errorf("bitget http status %d", resp.StatusCode)
 	}
 
 	var response BitgetTickerResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return 0, fmt.Errorf("failed to decode response: %w", err)
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
 	// Проверяем код ответа API
 	if response.Code != "00000" {
-		return 0, fmt.Errorf("bitget api error code=%s msg=%s", response.Code, response.Msg)
+		return nil, fmt.Errorf("bitget api error code=%s msg=%s", response.Code, response.Msg)
+	}
+
+	return response.Data, nil
+}
+
+// bitgetTickerPrice возвращает цену тикера; для фьючерсов приоритет markPrice, если есть
+func bitgetTickerPrice(ticker BitgetTicker, source string) (float64, error) {
+	priceStr := ticker.LastPr
+	if strings.Contains(source, "futures") && ticker.MarkPrice != "" && ticker.MarkPrice != "0" {
+		priceStr = ticker.MarkPrice
+	}
+
+	price, err := parseFloat(priceStr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse price '%s': %w", priceStr, err)
+	}
+	return price, nil
+}
+
+// fetchWithURL общая функция для получения данных с Bitget
+func fetchWithURL(ctx context.Context, client *http.Client, url, symbol, source string) (float64, error) {
+	tickers, err := fetchBitgetTickers(ctx, client, url, source)
+	if err != nil {
+		return 0, err
 	}
 
 	// Проверяем, что данные есть
-	if len(response.Data) == 0 {
+	if len(tickers) == 0 {
 		return 0, fmt.Errorf("no ticker data found for symbol %s on %s", symbol, source)
 	}
 
 	// Ищем точное совпадение символа
 	wanted := strings.ToUpper(symbol)
-	for _, ticker := range response.Data {
+	for _, ticker := range tickers {
 		if strings.ToUpper(ticker.Symbol) == wanted {
-			// Для фьючерсов приоритет markPrice, если есть
-			var priceStr string
-			if strings.Contains(source, "futures") && ticker.MarkPrice != "" && ticker.MarkPrice != "0" {
-				priceStr = ticker.MarkPrice
-			} else {
-				priceStr = ticker.LastPr
-			}
-
-			price, err := parseFloat(priceStr)
+			price, err := bitgetTickerPrice(ticker, source)
 			if err != nil {
-				return 0, fmt.Errorf("failed to parse price '%s': %w", priceStr, err)
+				return 0, err
 			}
 
 			logrus.WithFields(logrus.Fields{
@@ -285,8 +300,8 @@ func fetchWithURL(client *http.Client, url, symbol, source string) (float64, err
 		}
 	}
 
-	available := make([]string, len(response.Data))
-	for i, ticker := range response.Data {
+	available := make([]string, len(tickers))
+	for i, ticker := range tickers {
 		available[i] = ticker.Symbol
 	}
 
@@ -299,46 +314,56 @@ func fetchWithURL(client *http.Client, url, symbol, source string) (float64, err
 	return 0, fmt.Errorf("symbol %s not found in %s response", symbol, source)
 }
 
-// fetchHistoricalWithURL общая функция для получения исторических данных с Bitget
-func fetchHistoricalWithURL(client *http.Client, url, symbol, source string) (float64, error) {
-	logrus.WithFields(logrus.Fields{
-		"url":    url,
-		"source": source,
-	}).Debug("bitget historical request")
-
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// fetchBitgetCandles получает сырые свечи Bitget по URL
+func fetchBitgetCandles(ctx context.Context, client *http.Client, url, symbol, source string) ([][]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return 0, fmt.Errorf("bitget historical http status %d", resp.StatusCode)
+		return nil, fmt.Errorf("bitget historical http status %d", resp.StatusCode)
 	}
 
 	var response BitgetCandleResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return 0, fmt.Errorf("failed to decode historical response: %w", err)
+		return nil, fmt.Errorf("failed to decode historical response: %w", err)
 	}
 
 	// Проверяем код ответа API
 	if response.Code != "00000" {
-		return 0, fmt.Errorf("bitget api error code=%s msg=%s", response.Code, response.Msg)
+		return nil, fmt.Errorf("bitget api error code=%s msg=%s", response.Code, response.Msg)
 	}
 
 	// Проверяем, что данные есть
 	if len(response.Data) == 0 {
-		return 0, fmt.Errorf("no historical data found for symbol %s on %s", symbol, source)
+		return nil, fmt.Errorf("no historical data found for symbol %s on %s", symbol, source)
+	}
+
+	return response.Data, nil
+}
+
+// fetchHistoricalWithURL общая функция для получения исторических данных с Bitget
+func fetchHistoricalWithURL(ctx context.Context, client *http.Client, url, symbol, source string) (float64, error) {
+	logrus.WithFields(logrus.Fields{
+		"url":    url,
+		"source": source,
+	}).Debug("bitget historical request")
+
+	data, err := fetchBitgetCandles(ctx, client, url, symbol, source)
+	if err != nil {
+		return 0, err
 	}
 
 	// Данные свечи: [timestamp, open, high, low, close, volume, quoteVolume, usdtVolume]
 	// Берем цену закрытия последней свечи
-	lastCandle := response.Data[len(response.Data)-1]
+	lastCandle := data[len(data)-1]
 	if len(lastCandle) < 5 {
 		return 0, fmt.Errorf("invalid bitget candle data format")
 	}
@@ -358,6 +383,38 @@ func fetchHistoricalWithURL(client *http.Client, url, symbol, source string) (fl
 	return closePrice, nil
 }
 
+// parseCandleRows преобразует строки свечей [timestamp, open, high, low, close, volume, ...]
+// в levels.Candle и сортирует их по времени от старых к новым.
+func parseCandleRows(rows [][]string) []levels.Candle {
+	candles := make([]levels.Candle, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 6 {
+			continue
+		}
+
+		timestamp, _ := strconv.ParseInt(row[0], 10, 64)
+		open, _ := strconv.ParseFloat(row[1], 64)
+		high, _ := strconv.ParseFloat(row[2], 64)
+		low, _ := strconv.ParseFloat(row[3], 64)
+		closePrice, _ := strconv.ParseFloat(row[4], 64)
+		volume, _ := strconv.ParseFloat(row[5], 64)
+
+		candles = append(candles, levels.Candle{
+			Timestamp: timestamp,
+			Open:      open,
+			High:      high,
+			Low:       low,
+			Close:     closePrice,
+			Volume:    volume,
+		})
+	}
+
+	sort.Slice(candles, func(i, j int) bool {
+		return candles[i].Timestamp < candles[j].Timestamp
+	})
+	return candles
+}
+
 // calculateChangePercent вычисляет процентное изменение
 func calculateChangePercent(oldPrice, newPrice float64) float64 {
 	if oldPrice == 0 {
@@ -442,10 +499,10 @@ func min(a, b int) int {
 // --- Bitget price fetchers ---
 
 // fetchBitgetSpotPriceOnly получает цену только со спота Bitget
-func fetchBitgetSpotPriceOnly(client *http.Client, symbol string) (float64, error) {
+func fetchBitgetSpotPriceOnly(ctx context.Context, client *http.Client, symbol string) (float64, error) {
 	// Пробуем сначала API v2 для одного символа
 	url := fmt.Sprintf("https://api.bitget.com/api/v2/spot/market/tickers?symbol=%s", symbol)
-	price, err := fetchWithURL(client, url, symbol, "Bitget spot")
+	price, err := fetchWithURL(ctx, client, url, symbol, "Bitget spot")
 	if err == nil {
 		return price, nil
 	}
@@ -454,14 +511,14 @@ func fetchBitgetSpotPriceOnly(client *http.Client, symbol string) (float64, erro
 
 	// Если не получилось, пробуем получить все тикеры и найти нужный
 	url = "https://api.bitget.com/api/v2/spot/market/tickers"
-	return fetchWithURL(client, url, symbol, "Bitget spot")
+	return fetchWithURL(ctx, client, url, symbol, "Bitget spot")
 }
 
 // fetchBitgetFuturesPrice получает цену с фьючерсного рынка Bitget
-func fetchBitgetFuturesPrice(client *http.Client, symbol string) (float64, error) {
+func fetchBitgetFuturesPrice(ctx context.Context, client *http.Client, symbol string) (float64, error) {
 	// Пробуем получить конкретный символ на фьючерсах
 	url := fmt.Sprintf("https://api.bitget.com/api/v2/mix/market/ticker?productType=USDT-FUTURES&symbol=%s", symbol)
-	price, err := fetchWithURL(client, url, symbol, "Bitget futures")
+	price, err := fetchWithURL(ctx, client, url, symbol, "Bitget futures")
 	if err == nil {
 		return price, nil
 	}
@@ -470,84 +527,101 @@ func fetchBitgetFuturesPrice(client *http.Client, symbol string) (float64, error
 
 	// Если не получилось, получаем все фьючерсные тикеры
 	url = "https://api.bitget.com/api/v2/mix/market/tickers?productType=USDT-FUTURES"
-	return fetchWithURL(client, url, symbol, "Bitget futures")
+	return fetchWithURL(ctx, client, url, symbol, "Bitget futures")
 }
 
 // --- Bybit price fetchers ---
 
 // FetchBybitSpotPrice получает цену только со спота Bybit
-func FetchBybitSpotPrice(client *http.Client, symbol string) (float64, error) {
+func FetchBybitSpotPrice(ctx context.Context, client *http.Client, symbol string) (float64, error) {
 	url := fmt.Sprintf("https://api.bybit.com/v5/market/tickers?category=spot&symbol=%s", symbol)
-	price, err := fetchBybitWithURL(client, url, symbol, "Bybit spot")
+	price, err := fetchBybitWithURL(ctx, client, url, symbol, "Bybit spot")
 	if err == nil {
 		return price, nil
 	}
 	logrus.WithError(err).WithField("symbol", symbol).Debug("failed to fetch Bybit spot with symbol param, trying all tickers")
 
 	url = "https://api.bybit.com/v5/market/tickers?category=spot"
-	return fetchBybitWithURL(client, url, symbol, "Bybit spot")
+	return fetchBybitWithURL(ctx, client, url, symbol, "Bybit spot")
 }
 
 // FetchBybitFuturesPrice получает цену с фьючерсного рынка Bybit
-func FetchBybitFuturesPrice(client *http.Client, symbol string) (float64, error) {
+func FetchBybitFuturesPrice(ctx context.Context, client *http.Client, symbol string) (float64, error) {
 	url := fmt.Sprintf("https://api.bybit.com/v5/market/tickers?category=linear&symbol=%s", symbol)
-	price, err := fetchBybitWithURL(client, url, symbol, "Bybit futures")
+	price, err := fetchBybitWithURL(ctx, client, url, symbol, "Bybit futures")
 	if err == nil {
 		return price, nil
 	}
 	logrus.WithError(err).WithField("symbol", symbol).Debug("failed to fetch Bybit futures with symbol param, trying all tickers")
 
 	url = "https://api.bybit.com/v5/market/tickers?category=linear"
-	return fetchBybitWithURL(client, url, symbol, "Bybit futures")
+	return fetchBybitWithURL(ctx, client, url, symbol, "Bybit futures")
 }
 
-func fetchBybitWithURL(client *http.Client, url, symbol, source string) (float64, error) {
+// fetchBybitTickers получает список тикеров Bybit по URL
+func fetchBybitTickers(ctx context.Context, client *http.Client, url, source string) ([]BybitTicker, error) {
 	logrus.WithFields(logrus.Fields{
 		"url":    url,
 		"source": source,
 	}).Debug("bybit request")
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return 0, fmt.Errorf("bybit http status %d", resp.StatusCode)
+		return nil, fmt.Errorf("bybit http status %d", resp.StatusCode)
 	}
 
 	var response BybitTickerResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return 0, fmt.Errorf("failed to decode bybit response: %w", err)
+		return nil, fmt.Errorf("failed to decode bybit response: %w", err)
 	}
 
 	if response.RetCode != 0 {
-		return 0, fmt.Errorf("bybit api error code=%d msg=%s", response.RetCode, response.RetMsg)
+		return nil, fmt.Errorf("bybit api error code=%d msg=%s", response.RetCode, response.RetMsg)
 	}
 
-	if len(response.Result.List) == 0 {
+	return response.Result.List, nil
+}
+
+// bybitTickerPrice возвращает цену тикера; для фьючерсов приоритет markPrice, если есть
+func bybitTickerPrice(ticker BybitTicker, source string) (float64, error) {
+	priceStr := ticker.LastPrice
+	if strings.Contains(source, "futures") && ticker.MarkPrice != "" && ticker.MarkPrice != "0" {
+		priceStr = ticker.MarkPrice
+	}
+
+	price, err := parseFloat(priceStr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse price '%s': %w", priceStr, err)
+	}
+	return price, nil
+}
+
+func fetchBybitWithURL(ctx context.Context, client *http.Client, url, symbol, source string) (float64, error) {
+	tickers, err := fetchBybitTickers(ctx, client, url, source)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(tickers) == 0 {
 		return 0, fmt.Errorf("no ticker data found for symbol %s on %s", symbol, source)
 	}
 
 	wanted := strings.ToUpper(symbol)
-	for _, ticker := range response.Result.List {
+	for _, ticker := range tickers {
 		if strings.ToUpper(ticker.Symbol) == wanted {
-			var priceStr string
-			if strings.Contains(source, "futures") && ticker.MarkPrice != "" && ticker.MarkPrice != "0" {
-				priceStr = ticker.MarkPrice
-			} else {
-				priceStr = ticker.LastPrice
-			}
-
-			price, err := parseFloat(priceStr)
+			price, err := bybitTickerPrice(ticker, source)
 			if err != nil {
-				return 0, fmt.Errorf("failed to parse price '%s': %w", priceStr, err)
+				return 0, err
 			}
 
 			logrus.WithFields(logrus.Fields{
@@ -561,8 +635,8 @@ func fetchBybitWithURL(client *http.Client, url, symbol, source string) (float64
 		}
 	}
 
-	available := make([]string, min(10, len(response.Result.List)))
-	for i, ticker := range response.Result.List {
+	available := make([]string, min(10, len(tickers)))
+	for i, ticker := range tickers {
 		if i >= 10 {
 			break
 		}
@@ -580,42 +654,52 @@ func fetchBybitWithURL(client *http.Client, url, symbol, source string) (float64
 
 // --- Bybit historical helpers ---
 
-// fetchBybitHistoricalWithURL общая функция для получения исторических данных с Bybit
-func fetchBybitHistoricalWithURL(client *http.Client, url, symbol, source string) (float64, error) {
-	logrus.WithFields(logrus.Fields{
-		"url":    url,
-		"source": source,
-	}).Debug("bybit historical request")
-
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// fetchBybitCandles получает сырые свечи Bybit по URL (Bybit отдает их от новых к старым)
+func fetchBybitCandles(ctx context.Context, client *http.Client, url, symbol, source string) ([][]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return 0, fmt.Errorf("bybit historical http status %d", resp.StatusCode)
+		return nil, fmt.Errorf("bybit historical http status %d", resp.StatusCode)
 	}
 
 	var response BybitCandleResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return 0, fmt.Errorf("failed to decode bybit historical response: %w", err)
+		return nil, fmt.Errorf("failed to decode bybit historical response: %w", err)
 	}
 
 	if response.RetCode != 0 {
-		return 0, fmt.Errorf("bybit api error code=%d msg=%s", response.RetCode, response.RetMsg)
+		return nil, fmt.Errorf("bybit api error code=%d msg=%s", response.RetCode, response.RetMsg)
 	}
 
 	if len(response.Result.List) == 0 {
-		return 0, fmt.Errorf("no historical data found for symbol %s on %s", symbol, source)
+		return nil, fmt.Errorf("no historical data found for symbol %s on %s", symbol, source)
+	}
+
+	return response.Result.List, nil
+}
+
+// fetchBybitHistoricalWithURL общая функция для получения исторических данных с Bybit
+func fetchBybitHistoricalWithURL(ctx context.Context, client *http.Client, url, symbol, source string) (float64, error) {
+	logrus.WithFields(logrus.Fields{
+		"url":    url,
+		"source": source,
+	}).Debug("bybit historical request")
+
+	list, err := fetchBybitCandles(ctx, client, url, symbol, source)
+	if err != nil {
+		return 0, err
 	}
 
-	lastCandle := response.Result.List[len(response.Result.List)-1]
+	lastCandle := list[len(list)-1]
 	if len(lastCandle) < 5 {
 		return 0, fmt.Errorf("invalid bybit candle data format")
 	}
@@ -638,7 +722,7 @@ func fetchBybitHistoricalWithURL(client *http.Client, url, symbol, source string
 // --- Bitget historical fetchers ---
 
 // fetchHistoricalPriceBitgetSpot получает историческую цену со спота Bitget
-func fetchHistoricalPriceBitgetSpot(client *http.Client, symbol string, timestamp time.Time) (float64, error) {
+func fetchHistoricalPriceBitgetSpot(ctx context.Context, client *http.Client, symbol string, timestamp time.Time) (float64, error) {
 	endTime := timestamp.UnixMilli()
 	startTime := timestamp.Add(-2 * time.Minute).UnixMilli()
 
@@ -652,11 +736,11 @@ func fetchHistoricalPriceBitgetSpot(client *http.Client, symbol string, timestam
 		"source":    "Bitget spot",
 	}).Debug("fetching historical price from Bitget spot")
 
-	return fetchHistoricalWithURL(client, url, symbol, "Bitget spot")
+	return fetchHistoricalWithURL(ctx, client, url, symbol, "Bitget spot")
 }
 
 // fetchHistoricalPriceBitgetFutures получает историческую цену с фьючерсов Bitget
-func fetchHistoricalPriceBitgetFutures(client *http.Client, symbol string, timestamp time.Time) (float64, error) {
+func fetchHistoricalPriceBitgetFutures(ctx context.Context, client *http.Client, symbol string, timestamp time.Time) (float64, error) {
 	endTime := timestamp.UnixMilli()
 	startTime := timestamp.Add(-2 * time.Minute).UnixMilli()
 
@@ -670,11 +754,11 @@ func fetchHistoricalPriceBitgetFutures(client *http.Client, symbol string, times
 		"source":    "Bitget futures",
 	}).Debug("fetching historical price from Bitget futures")
 
-	return fetchHistoricalWithURL(client, url, symbol, "Bitget futures")
+	return fetchHistoricalWithURL(ctx, client, url, symbol, "Bitget futures")
 }
 
 // FetchBybitHistoricalPrice получает историческую цену с Bybit
-func FetchBybitHistoricalPrice(client *http.Client, symbol string, timestamp time.Time, category string) (float64, error) {
+func FetchBybitHistoricalPrice(ctx context.Context, client *http.Client, symbol string, timestamp time.Time, category string) (float64, error) {
 	endTime := timestamp.UnixMilli()
 	startTime := timestamp.Add(-2 * time.Minute).UnixMilli()
 
@@ -688,366 +772,137 @@ func FetchBybitHistoricalPrice(client *http.Client, symbol string, timestamp tim
 		"source":    "Bybit " + category,
 	}).Debug("fetching historical price from Bybit")
 
-	return fetchBybitHistoricalWithURL(client, url, symbol, "Bybit "+category)
+	return fetchBybitHistoricalWithURL(ctx, client, url, symbol, "Bybit "+category)
 }
 
 // --- Main public functions ---
 
 // FetchPriceInfo получает подробную информацию о цене с изменениями за разные периоды,
-// проверяя биржи в порядке приоритета: Variational → Bitget → Bybit.
-func FetchPriceInfo(clients *ExchangeClients, symbol string, preferredExchange, preferredMarket string) (*FetchPriceInfoResult, error) {
-	var currentPrice float64
-	var sourceExchange, sourceMarket string
-	var err error
-
-	// Если указана предпочтительная биржа/рынок, пробуем сначала получить цену оттуда
-	if preferredExchange != "" && preferredMarket != "" {
-		logrus.WithFields(logrus.Fields{
-			"symbol":    symbol,
-			"preferred": fmt.Sprintf("%s %s", preferredExchange, preferredMarket),
-		}).Debug("attempting to fetch price from preferred source")
-
-		switch preferredExchange {
-		case "Variational":
-			if preferredMarket == "futures" {
-				currentPrice, err = fetchVariationalPrice(clients.VariationalClient, symbol)
-				if err == nil {
-					sourceExchange = "Variational"
-					sourceMarket = "futures"
-				} else {
-					logrus.WithError(err).WithField("symbol", symbol).Warn("Variational price fetch failed, trying Bitget spot")
-				}
-			}
-		case "Bitget":
-			if preferredMarket == "spot" {
-				currentPrice, err = fetchBitgetSpotPriceOnly(clients.BitgetClient, symbol)
-				if err == nil {
-					sourceExchange = "Bitget"
-					sourceMarket = "spot"
-				}
-			} else if preferredMarket == "futures" {
-				currentPrice, err = fetchBitgetFuturesPrice(clients.BitgetClient, symbol)
-				if err == nil {
-					sourceExchange = "Bitget"
-					sourceMarket = "futures"
-				}
-			}
-		case "Bybit":
-			if preferredMarket == "spot" {
-				currentPrice, err = FetchBybitSpotPrice(clients.BybitClient, symbol)
-				if err == nil {
-					sourceExchange = "Bybit"
-					sourceMarket = "spot"
-				}
-			} else if preferredMarket == "futures" {
-				currentPrice, err = FetchBybitFuturesPrice(clients.BybitClient, symbol)
-				if err == nil {
-					sourceExchange = "Bybit"
-					sourceMarket = "futures"
-				}
-			}
-		}
-
-		if err == nil { // Если успешно получили цену из предпочтительного источника
-			priceInfo := &PriceInfo{
-				CurrentPrice: currentPrice,
-				Source:       fmt.Sprintf("%s %s", sourceExchange, sourceMarket),
-			}
-			now := time.Now()
-			if price15m, err := FetchHistoricalPrice(clients, symbol, now.Add(-15*time.Minute), sourceExchange, sourceMarket); err == nil {
-				priceInfo.Change15m = calculateChangePercent(price15m, currentPrice)
-			}
-			if price1h, err := FetchHistoricalPrice(clients, symbol, now.Add(-1*time.Hour), sourceExchange, sourceMarket); err == nil {
-				priceInfo.Change1h = calculateChangePercent(price1h, currentPrice)
-			}
-			if price4h, err := FetchHistoricalPrice(clients, symbol, now.Add(-4*time.Hour), sourceExchange, sourceMarket); err == nil {
-				priceInfo.Change4h = calculateChangePercent(price4h, currentPrice)
-			}
-			if price24h, err := FetchHistoricalPrice(clients, symbol, now.Add(-24*time.Hour), sourceExchange, sourceMarket); err == nil {
-				priceInfo.Change24h = calculateChangePercent(price24h, currentPrice)
-			}
-			return &FetchPriceInfoResult{PriceInfo: *priceInfo, Exchange: sourceExchange, Market: sourceMarket}, nil
-		}
-		logrus.WithError(err).WithFields(logrus.Fields{
-			"symbol":    symbol,
-			"preferred": fmt.Sprintf("%s %s", preferredExchange, preferredMarket),
-		}).Debug("failed to fetch price from preferred source, trying all sources")
-	}
-
-	// Fallback: пробуем все источники по порядку приоритета:
-	// 1. Variational futures
-	currentPrice, err = fetchVariationalPrice(clients.VariationalClient, symbol)
-	if err == nil {
-		sourceExchange = "Variational"
-		sourceMarket = "futures"
-	} else {
-		logrus.WithError(err).WithField("symbol", symbol).Debug("Variational price fetch failed, trying Bitget spot")
-
-		// 2. Bitget spot
-		currentPrice, err = fetchBitgetSpotPriceOnly(clients.BitgetClient, symbol)
-		if err == nil {
-			sourceExchange = "Bitget"
-			sourceMarket = "spot"
-		} else {
-			logrus.WithError(err).WithField("symbol", symbol).Debug("Bitget spot price fetch failed, trying Bitget futures")
-
-			// 3. Bitget futures
-			currentPrice, err = fetchBitgetFuturesPrice(clients.BitgetClient, symbol)
-			if err == nil {
-				sourceExchange = "Bitget"
-				sourceMarket = "futures"
-			} else {
-				logrus.WithError(err).WithField("symbol", symbol).Debug("Bitget futures price fetch failed, trying Bybit spot")
-
-				// 4. Bybit spot
-				currentPrice, err = FetchBybitSpotPrice(clients.BybitClient, symbol)
-				if err == nil {
-					sourceExchange = "Bybit"
-					sourceMarket = "spot"
-				} else {
-					logrus.WithError(err).WithField("symbol", symbol).Debug("Bybit spot price fetch failed, trying Bybit futures")
-
-					// 5. Bybit futures
-					currentPrice, err = FetchBybitFuturesPrice(clients.BybitClient, symbol)
-					if err == nil {
-						sourceExchange = "Bybit"
-						sourceMarket = "futures"
-					} else {
-						return nil, fmt.Errorf("failed to get current price for %s from any source: %w", symbol, err)
-					}
-				}
-			}
-		}
+// проверяя биржи реестра в порядке приоритета (по умолчанию Variational → Bitget → Bybit).
+func FetchPriceInfo(registry *Registry, symbol string, preferredExchange, preferredMarket string) (*FetchPriceInfoResult, error) {
+	result, err := FetchCurrentPrice(registry, symbol, preferredExchange, preferredMarket)
+	if err != nil {
+		return nil, err
 	}
 
-	priceInfo := &PriceInfo{
-		CurrentPrice: currentPrice,
-		Source:       fmt.Sprintf("%s %s", sourceExchange, sourceMarket),
-	}
+	fillPriceChanges(registry, symbol, result)
+	return result, nil
+}
 
+// fillPriceChanges заполняет изменения цены за 15м/1ч/4ч/24ч по историческим ценам источника result.
+func fillPriceChanges(registry *Registry, symbol string, result *FetchPriceInfoResult) {
+	currentPrice := result.CurrentPrice
 	now := time.Now()
 
-	if price15m, err := FetchHistoricalPrice(clients, symbol, now.Add(-15*time.Minute), sourceExchange, sourceMarket); err == nil {
-		priceInfo.Change15m = calculateChangePercent(price15m, currentPrice)
+	if price15m, err := FetchHistoricalPrice(registry, symbol, now.Add(-15*time.Minute), result.Exchange, result.Market); err == nil {
+		result.Change15m = calculateChangePercent(price15m, currentPrice)
 	}
-	if price1h, err := FetchHistoricalPrice(clients, symbol, now.Add(-1*time.Hour), sourceExchange, sourceMarket); err == nil {
-		priceInfo.Change1h = calculateChangePercent(price1h, currentPrice)
+	if price1h, err := FetchHistoricalPrice(registry, symbol, now.Add(-1*time.Hour), result.Exchange, result.Market); err == nil {
+		result.Change1h = calculateChangePercent(price1h, currentPrice)
 	}
-	if price4h, err := FetchHistoricalPrice(clients, symbol, now.Add(-4*time.Hour), sourceExchange, sourceMarket); err == nil {
-		priceInfo.Change4h = calculateChangePercent(price4h, currentPrice)
+	if price4h, err := FetchHistoricalPrice(registry, symbol, now.Add(-4*time.Hour), result.Exchange, result.Market); err == nil {
+		result.Change4h = calculateChangePercent(price4h, currentPrice)
 	}
-	if price24h, err := FetchHistoricalPrice(clients, symbol, now.Add(-24*time.Hour), sourceExchange, sourceMarket); err == nil {
-		priceInfo.Change24h = calculateChangePercent(price24h, currentPrice)
+	if price24h, err := FetchHistoricalPrice(registry, symbol, now.Add(-24*time.Hour), result.Exchange, result.Market); err == nil {
+		result.Change24h = calculateChangePercent(price24h, currentPrice)
 	}
-
-	return &FetchPriceInfoResult{PriceInfo: *priceInfo, Exchange: sourceExchange, Market: sourceMarket}, nil
 }
 
 // FetchHistoricalPrice получает цену на определенный момент времени, проверяя биржи в порядке приоритета.
-// Важно: Variational не предоставляет исторических данных (свечей), поэтому для исторических цен
-// используются Bitget и Bybit.
-func FetchHistoricalPrice(clients *ExchangeClients, symbol string, timestamp time.Time, preferredExchange, preferredMarket string) (float64, error) {
-	var price float64
-	var err error
-
-	// Если есть предпочтительная биржа/рынок, сначала пробуем их.
-	// Variational не имеет исторического API, поэтому для него откатываемся на Bitget futures.
-	if preferredExchange == "Variational" {
-		price, err = fetchHistoricalPriceBitgetFutures(clients.BitgetClient, symbol, timestamp)
-		if err == nil {
-			return price, nil
+// Важно: Variational не предоставляет исторических данных (свечей), поэтому для него
+// в первую очередь используются фьючерсы других бирж.
+func FetchHistoricalPrice(registry *Registry, symbol string, timestamp time.Time, preferredExchange, preferredMarket string) (float64, error) {
+	ctx := context.Background()
+	err := ErrNotSupported
+
+	for _, ex := range registry.historyCandidates(preferredExchange, preferredMarket) {
+		if waitErr := registry.wait(ctx, ex); waitErr != nil {
+			return 0, waitErr
 		}
-		logrus.WithError(err).WithField("symbol", symbol).Debug("Bitget futures historical (fallback for Variational) failed, trying Bybit linear")
-		price, err = FetchBybitHistoricalPrice(clients.BybitClient, symbol, timestamp, "linear")
-		if err == nil {
+		price, fetchErr := ex.HistoricalClose(ctx, symbol, timestamp)
+		if fetchErr == nil {
 			return price, nil
 		}
-		logrus.WithError(err).WithField("symbol", symbol).Debug("Bybit linear historical (fallback for Variational) failed")
-		// Продолжаем в общий fallback ниже
-	}
-	if preferredExchange == "Bitget" && preferredMarket == "spot" {
-		price, err = fetchHistoricalPriceBitgetSpot(clients.BitgetClient, symbol, timestamp)
-		if err == nil {
-			return price, nil
-		}
-		logrus.WithError(err).WithField("symbol", symbol).Debug("Bitget spot historical price failed, trying preferred futures")
-	}
-	if preferredExchange == "Bitget" && preferredMarket == "futures" {
-		price, err = fetchHistoricalPriceBitgetFutures(clients.BitgetClient, symbol, timestamp)
-		if err == nil {
-			return price, nil
-		}
-		logrus.WithError(err).WithField("symbol", symbol).Debug("Bitget futures historical price failed, trying Bybit spot")
-	}
-	if preferredExchange == "Bybit" && preferredMarket == "spot" {
-		price, err = FetchBybitHistoricalPrice(clients.BybitClient, symbol, timestamp, "spot")
-		if err == nil {
-			return price, nil
-		}
-		logrus.WithError(err).WithField("symbol", symbol).Debug("Bybit spot historical price failed, trying Bybit futures")
-	}
-	if preferredExchange == "Bybit" && preferredMarket == "futures" {
-		price, err = FetchBybitHistoricalPrice(clients.BybitClient, symbol, timestamp, "linear")
-		if err == nil {
-			return price, nil
+		if errors.Is(fetchErr, ErrNotSupported) {
+			continue
 		}
-		logrus.WithError(err).WithField("symbol", symbol).Debug("Bybit futures historical price failed, trying Bitget spot")
-	}
-
-	// Общий fallback по порядку:
-
-	// 1. Bitget spot
-	price, err = fetchHistoricalPriceBitgetSpot(clients.BitgetClient, symbol, timestamp)
-	if err == nil {
-		return price, nil
-	}
-	logrus.WithError(err).WithField("symbol", symbol).Debug("Bitget spot historical price failed, trying Bitget futures")
-
-	// 2. Bitget futures
-	price, err = fetchHistoricalPriceBitgetFutures(clients.BitgetClient, symbol, timestamp)
-	if err == nil {
-		return price, nil
-	}
-	logrus.WithError(err).WithField("symbol", symbol).Debug("Bitget futures historical price failed, trying Bybit spot")
-
-	// 3. Bybit spot
-	price, err = FetchBybitHistoricalPrice(clients.BybitClient, symbol, timestamp, "spot")
-	if err == nil {
-		return price, nil
-	}
-	logrus.WithError(err).WithField("symbol", symbol).Debug("Bybit spot historical price failed, trying Bybit futures")
-
-	// 4. Bybit futures
-	price, err = FetchBybitHistoricalPrice(clients.BybitClient, symbol, timestamp, "linear")
-	if err == nil {
-		return price, nil
+		err = fetchErr
+		logrus.WithError(fetchErr).WithFields(logrus.Fields{
+			"symbol": symbol,
+			"source": fmt.Sprintf("%s %s", ex.Name(), ex.Market()),
+		}).Debug("historical price fetch failed, trying next source")
 	}
-	logrus.WithError(err).WithField("symbol", symbol).Debug("Bybit futures historical price failed")
 
 	return 0, fmt.Errorf("failed to get historical price for %s from any source: %w", symbol, err)
 }
 
 // FetchCurrentPrice получает только текущую цену без исторических изменений (для мониторинга).
-// Порядок приоритета: Variational → Bitget → Bybit.
-func FetchCurrentPrice(clients *ExchangeClients, symbol string, preferredExchange, preferredMarket string) (*FetchPriceInfoResult, error) {
-	var currentPrice float64
-	var sourceExchange, sourceMarket string
-	var err error
+// Сначала пробуется предпочтительная биржа/рынок, затем все биржи реестра в порядке приоритета.
+func FetchCurrentPrice(registry *Registry, symbol string, preferredExchange, preferredMarket string) (*FetchPriceInfoResult, error) {
+	return FetchCurrentPriceContext(context.Background(), registry, symbol, preferredExchange, preferredMarket)
+}
+
+// FetchCurrentPriceContext то же, что FetchCurrentPrice, но с контекстом для отмены и дедлайна.
+// Перед каждым запросом соблюдается ограничение частоты биржи.
+func FetchCurrentPriceContext(ctx context.Context, registry *Registry, symbol string, preferredExchange, preferredMarket string) (*FetchPriceInfoResult, error) {
+	err := errors.New("no exchanges registered")
 
-	// Если указана предпочтительная биржа/рынок, пробуем сначала получить цену оттуда
 	if preferredExchange != "" && preferredMarket != "" {
 		logrus.WithFields(logrus.Fields{
 			"symbol":    symbol,
 			"preferred": fmt.Sprintf("%s %s", preferredExchange, preferredMarket),
 		}).Debug("attempting to fetch price from preferred source")
+	}
 
-		switch preferredExchange {
-		case "Variational":
-			if preferredMarket == "futures" {
-				currentPrice, err = fetchVariationalPrice(clients.VariationalClient, symbol)
-				if err == nil {
-					sourceExchange = "Variational"
-					sourceMarket = "futures"
-				}
-			}
-		case "Bitget":
-			if preferredMarket == "spot" {
-				currentPrice, err = fetchBitgetSpotPriceOnly(clients.BitgetClient, symbol)
-				if err == nil {
-					sourceExchange = "Bitget"
-					sourceMarket = "spot"
-				}
-			} else if preferredMarket == "futures" {
-				currentPrice, err = fetchBitgetFuturesPrice(clients.BitgetClient, symbol)
-				if err == nil {
-					sourceExchange = "Bitget"
-					sourceMarket = "futures"
-				}
-			}
-		case "Bybit":
-			if preferredMarket == "spot" {
-				currentPrice, err = FetchBybitSpotPrice(clients.BybitClient, symbol)
-				if err == nil {
-					sourceExchange = "Bybit"
-					sourceMarket = "spot"
-				}
-			} else if preferredMarket == "futures" {
-				currentPrice, err = FetchBybitFuturesPrice(clients.BybitClient, symbol)
-				if err == nil {
-					sourceExchange = "Bybit"
-					sourceMarket = "futures"
-				}
-			}
+	for _, ex := range registry.candidates(preferredExchange, preferredMarket) {
+		if waitErr := registry.wait(ctx, ex); waitErr != nil {
+			return nil, fmt.Errorf("failed to get current price for %s: %w", symbol, waitErr)
 		}
-
-		if err == nil {
+		price, fetchErr := ex.CurrentPrice(ctx, symbol)
+		if fetchErr == nil {
 			return &FetchPriceInfoResult{
 				PriceInfo: PriceInfo{
-					CurrentPrice: currentPrice,
-					Source:       fmt.Sprintf("%s %s", sourceExchange, sourceMarket),
+					CurrentPrice: price,
+					Source:       fmt.Sprintf("%s %s", ex.Name(), ex.Market()),
 				},
-				Exchange: sourceExchange,
-				Market:   sourceMarket,
+				Exchange: ex.Name(),
+				Market:   ex.Market(),
+				AsOf:     time.Now(),
 			}, nil
 		}
-		logrus.WithError(err).WithFields(logrus.Fields{
-			"symbol":    symbol,
-			"preferred": fmt.Sprintf("%s %s", preferredExchange, preferredMarket),
-		}).Debug("failed to fetch price from preferred source, trying all sources")
+		err = fetchErr
+		logrus.WithError(fetchErr).WithFields(logrus.Fields{
+			"symbol": symbol,
+			"source": fmt.Sprintf("%s %s", ex.Name(), ex.Market()),
+		}).Debug("price fetch failed, trying next source")
 	}
 
-	// Fallback: пробуем все источники по порядку приоритета:
-	// 1. Variational futures
-	currentPrice, err = fetchVariationalPrice(clients.VariationalClient, symbol)
-	if err == nil {
-		sourceExchange = "Variational"
-		sourceMarket = "futures"
-	} else {
-		logrus.WithError(err).WithField("symbol", symbol).Debug("Variational price fetch failed, trying Bitget spot")
-
-		// 2. Bitget spot
-		currentPrice, err = fetchBitgetSpotPriceOnly(clients.BitgetClient, symbol)
-		if err == nil {
-			sourceExchange = "Bitget"
-			sourceMarket = "spot"
-		} else {
-			logrus.WithError(err).WithField("symbol", symbol).Debug("Bitget spot price fetch failed, trying Bitget futures")
-
-			// 3. Bitget futures
-			currentPrice, err = fetchBitgetFuturesPrice(clients.BitgetClient, symbol)
-			if err == nil {
-				sourceExchange = "Bitget"
-				sourceMarket = "futures"
-			} else {
-				logrus.WithError(err).WithField("symbol", symbol).Debug("Bitget futures price fetch failed, trying Bybit spot")
-
-				// 4. Bybit spot
-				currentPrice, err = FetchBybitSpotPrice(clients.BybitClient, symbol)
-				if err == nil {
-					sourceExchange = "Bybit"
-					sourceMarket = "spot"
-				} else {
-					logrus.WithError(err).WithField("symbol", symbol).Debug("Bybit spot price fetch failed, trying Bybit futures")
-
-					// 5. Bybit futures
-					currentPrice, err = FetchBybitFuturesPrice(clients.BybitClient, symbol)
-					if err == nil {
-						sourceExchange = "Bybit"
-						sourceMarket = "futures"
-					} else {
-						return nil, fmt.Errorf("failed to get current price for %s from any source: %w", symbol, err)
-					}
-				}
-			}
+	return nil, fmt.Errorf("failed to get current price for %s from any source: %w", symbol, err)
+}
+
+// FetchCandles получает свечи символа с первой биржи, которая их отдает.
+// Порядок такой же, как для исторических цен.
+func FetchCandles(registry *Registry, symbol string, interval time.Duration, limit int, preferredExchange, preferredMarket string) ([]levels.Candle, error) {
+	ctx := context.Background()
+	err := ErrNotSupported
+
+	for _, ex := range registry.historyCandidates(preferredExchange, preferredMarket) {
+		if waitErr := registry.wait(ctx, ex); waitErr != nil {
+			return nil, waitErr
+		}
+		candles, fetchErr := ex.Candles(ctx, symbol, interval, limit)
+		if fetchErr == nil && len(candles) > 0 {
+			return candles, nil
+		}
+		if fetchErr == nil || errors.Is(fetchErr, ErrNotSupported) {
+			continue
 		}
+		err = fetchErr
+		logrus.WithError(fetchErr).WithFields(logrus.Fields{
+			"symbol": symbol,
+			"source": fmt.Sprintf("%s %s", ex.Name(), ex.Market()),
+		}).Debug("candles fetch failed, trying next source")
 	}
 
-	return &FetchPriceInfoResult{
-		PriceInfo: PriceInfo{
-			CurrentPrice: currentPrice,
-			Source:       fmt.Sprintf("%s %s", sourceExchange, sourceMarket),
-		},
-		Exchange: sourceExchange,
-		Market:   sourceMarket,
-	}, nil
+	return nil, fmt.Errorf("failed to get candles for %s from any source: %w", symbol, err)
 }
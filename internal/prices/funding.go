@@ -0,0 +1,127 @@
+package prices
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultFundingInterval интервал выплат финансирования, если биржа его не сообщает
+const defaultFundingInterval = 8 * time.Hour
+
+// FundingRater реализуется фьючерсными адаптерами, которые отдают ставку финансирования.
+type FundingRater interface {
+	// FundingRate возвращает текущую ставку за один интервал (доля: 0.0001 = 0.01%) и интервал выплат.
+	// Положительная ставка означает, что лонги платят шортам.
+	FundingRate(ctx context.Context, symbol string) (rate float64, interval time.Duration, err error)
+}
+
+// FundingRate возвращает ставку финансирования символа на бирже и рынке.
+// Для спота и бирж без ставки возвращает ErrNotSupported.
+func (r *Registry) FundingRate(ctx context.Context, symbol, exchange, market string) (float64, time.Duration, error) {
+	ex, ok := r.Get(exchange, market)
+	if !ok {
+		return 0, 0, fmt.Errorf("exchange %s %s is not registered", exchange, market)
+	}
+	rater, ok := ex.(FundingRater)
+	if !ok {
+		return 0, 0, ErrNotSupported
+	}
+	if err := r.wait(ctx, ex); err != nil {
+		return 0, 0, err
+	}
+	return rater.FundingRate(ctx, symbol)
+}
+
+// takerFees комиссия тейкера в процентах от объема за одну сторону сделки по "бирже рынку".
+var takerFees = map[string]float64{
+	"variational futures": 0,
+	"bitget spot":         0.1,
+	"bitget futures":      0.06,
+	"bybit spot":          0.1,
+	"bybit futures":       0.055,
+}
+
+// defaultTakerFee комиссия для бирж, которых нет в таблице
+const defaultTakerFee = 0.1
+
+// TakerFeePercent возвращает комиссию тейкера в процентах от объема за одну сторону сделки.
+func TakerFeePercent(exchange, market string) float64 {
+	if fee, ok := takerFees[sourceKey(exchange, market)]; ok {
+		return fee
+	}
+	return defaultTakerFee
+}
+
+// FundingRate ставка финансирования листинга Variational и ее интервал.
+func (e *VariationalExchange) FundingRate(ctx context.Context, symbol string) (float64, time.Duration, error) {
+	listings, err := fetchVariationalListings(ctx, e.client)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ticker := variationalTicker(symbol)
+	for _, listing := range listings {
+		if !strings.EqualFold(listing.Ticker, ticker) {
+			continue
+		}
+		rate, err := parseFloat(listing.FundingRate)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to parse variational funding rate '%s': %w", listing.FundingRate, err)
+		}
+		interval := time.Duration(listing.FundingInterval) * time.Second
+		if interval <= 0 {
+			interval = defaultFundingInterval
+		}
+		return rate, interval, nil
+	}
+	return 0, 0, fmt.Errorf("symbol %s not found on Variational", symbol)
+}
+
+// FundingRate ставка финансирования USDT-FUTURES Bitget; для спота не поддерживается.
+func (e *BitgetExchange) FundingRate(ctx context.Context, symbol string) (float64, time.Duration, error) {
+	if e.market != "futures" {
+		return 0, 0, ErrNotSupported
+	}
+
+	tickers, err := fetchBitgetTickers(ctx, e.client, e.tickersURL(), e.source())
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, ticker := range tickers {
+		if !strings.EqualFold(ticker.Symbol, symbol) {
+			continue
+		}
+		rate, err := parseFloat(ticker.FundingRate)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to parse bitget funding rate '%s': %w", ticker.FundingRate, err)
+		}
+		return rate, defaultFundingInterval, nil
+	}
+	return 0, 0, fmt.Errorf("symbol %s not found on %s", symbol, e.source())
+}
+
+// FundingRate ставка финансирования линейных контрактов Bybit; для спота не поддерживается.
+func (e *BybitExchange) FundingRate(ctx context.Context, symbol string) (float64, time.Duration, error) {
+	if e.market != "futures" {
+		return 0, 0, ErrNotSupported
+	}
+
+	url := fmt.Sprintf("https://api.bybit.com/v5/market/tickers?category=%s&symbol=%s", e.category(), strings.ToUpper(symbol))
+	tickers, err := fetchBybitTickers(ctx, e.client, url, e.source())
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, ticker := range tickers {
+		if !strings.EqualFold(ticker.Symbol, symbol) {
+			continue
+		}
+		rate, err := parseFloat(ticker.FundingRate)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to parse bybit funding rate '%s': %w", ticker.FundingRate, err)
+		}
+		return rate, defaultFundingInterval, nil
+	}
+	return 0, 0, fmt.Errorf("symbol %s not found on %s", symbol, e.source())
+}
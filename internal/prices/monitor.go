@@ -2,6 +2,7 @@ package prices
 
 import (
 	"context"
+	"math"
 	"sync"
 	"time"
 
@@ -17,15 +18,52 @@ type SymbolProvider interface {
 // PriceMonitor периодически опрашивает цены и сообщает об изменениях через callback.
 type PriceMonitor struct {
 	//Client           *http.Client // Удаляем, так как ExchangeClients уже содержит клиенты
-	ExchangeClients   *ExchangeClients // Добавляем клиентов бирж
-	SymbolProvider    SymbolProvider   // Провайдер для получения актуального списка символов
+	Exchanges         *Registry      // Реестр адаптеров бирж
+	SymbolProvider    SymbolProvider // Провайдер для получения актуального списка символов
 	PreferredExchange string
 	PreferredMarket   string
 	ThresholdPercent  float64
 	Interval          time.Duration
+	Stream            *StreamFeed   // Потоковый источник цен (опционально), REST используется как резерв
+	StreamMinInterval time.Duration // Минимальный интервал между проверками символа по тикам потока
+	Workers           int           // Количество параллельных запросов при REST опросе
+	CycleTimeout      time.Duration // Дедлайн одного цикла опроса (по умолчанию Interval)
+	Quotes            *QuoteCache   // Общий кеш котировок, который монитор заполняет (опционально)
 
-	mu          sync.Mutex
-	lastPriceBy map[string]float64
+	mu             sync.Mutex
+	lastPriceBy    map[string]float64
+	lastStreamEval map[string]time.Time
+	lastCycle      CycleStats
+	rangeBy        map[string]priceRange // максимум/минимум тиков потока с последней проверки
+	alertMu        sync.Mutex            // onUpdate вызывается последовательно из потока и из REST опроса
+}
+
+// PriceUpdate новая цена символа, передаваемая в callback монитора.
+type PriceUpdate struct {
+	Symbol       string
+	Exchange     string // Источник цены (может быть пустым)
+	Market       string
+	Prev         float64 // Цена на предыдущей проверке
+	Price        float64
+	High         float64 // Максимум между предыдущей и текущей проверкой (включая обе цены)
+	Low          float64 // Минимум между предыдущей и текущей проверкой
+	DeltaPercent float64 // Изменение от Prev в процентах
+}
+
+type priceRange struct {
+	high, low float64
+}
+
+// CycleStats метрики одного цикла REST опроса.
+type CycleStats struct {
+	StartedAt time.Time
+	Duration  time.Duration
+	Symbols   int  // Символов в цикле
+	Streamed  int  // Пропущено: цена уже приходит из потока
+	Fetched   int  // Успешно получено через REST
+	Failed    int  // Ошибки и таймауты
+	Snapshots int  // Загружено снимков тикеров (запросов к рынкам бирж)
+	TimedOut  bool // Цикл прерван по дедлайну
 }
 
 // NewPriceMonitor конструктор.
@@ -35,46 +73,68 @@ func NewPriceMonitor(symbols []string, thresholdPercent float64, intervalSec int
 	}
 	return &PriceMonitor{
 		//Client:           &http.Client{Timeout: 10 * time.Second}, // Удаляем
-		ThresholdPercent: thresholdPercent,
-		Interval:         time.Duration(intervalSec) * time.Second,
-		lastPriceBy:      make(map[string]float64),
+		ThresholdPercent:  thresholdPercent,
+		Interval:          time.Duration(intervalSec) * time.Second,
+		StreamMinInterval: time.Second,
+		Workers:           8,
+		lastPriceBy:       make(map[string]float64),
+		lastStreamEval:    make(map[string]time.Time),
+		rangeBy:           make(map[string]priceRange),
 	}
 }
 
 // NewPriceMonitorWithProvider создает монитор с провайдером символов, запрашивает цены каждые 60 секунд
-func NewPriceMonitorWithProvider(provider SymbolProvider, clients *ExchangeClients, thresholdPercent float64, intervalSec int) *PriceMonitor {
+func NewPriceMonitorWithProvider(provider SymbolProvider, exchanges *Registry, thresholdPercent float64, intervalSec int) *PriceMonitor {
 	if intervalSec <= 0 {
 		intervalSec = 60
 	}
 	return &PriceMonitor{
 		//Client:           &http.Client{Timeout: 10 * time.Second}, // Удаляем
-		ExchangeClients:  clients, // Передаем клиентов
-		SymbolProvider:   provider,
-		ThresholdPercent: thresholdPercent,
-		Interval:         time.Duration(intervalSec) * time.Second,
-		lastPriceBy:      make(map[string]float64),
+		Exchanges:         exchanges,
+		SymbolProvider:    provider,
+		ThresholdPercent:  thresholdPercent,
+		Interval:          time.Duration(intervalSec) * time.Second,
+		StreamMinInterval: time.Second,
+		Workers:           8,
+		lastPriceBy:       make(map[string]float64),
+		lastStreamEval:    make(map[string]time.Time),
+		rangeBy:           make(map[string]priceRange),
 	}
 }
 
-// Run запускает мониторинг до завершения контекста. На значимое изменение вызывает onAlert(symbol, old, new, deltaPercent).
-func (m *PriceMonitor) Run(ctx context.Context, onAlert func(string, float64, float64, float64)) error {
+// Run запускает мониторинг до завершения контекста. На изменение не меньше порога вызывает onUpdate.
+func (m *PriceMonitor) Run(ctx context.Context, onUpdate func(PriceUpdate)) error {
 	ticker := time.NewTicker(m.Interval)
 	defer ticker.Stop()
 
-	// Первый проход сразу
-	m.poll(onAlert)
+	if m.Stream != nil {
+		go m.Stream.Run(ctx, func(tick Tick) {
+			m.onStreamTick(tick, onUpdate)
+		})
+	}
+
+	// Первый проход сразу. Циклы выполняются последовательно и ограничены дедлайном,
+	// поэтому не накладываются друг на друга
+	m.poll(ctx, onUpdate)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
 		case <-ticker.C:
-			m.poll(onAlert)
+			m.poll(ctx, onUpdate)
 		}
 	}
 }
 
-func (m *PriceMonitor) poll(onAlert func(string, float64, float64, float64)) {
+// LastCycle возвращает метрики последнего завершенного цикла опроса.
+func (m *PriceMonitor) LastCycle() CycleStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastCycle
+}
+
+func (m *PriceMonitor) poll(ctx context.Context, onUpdate func(PriceUpdate)) {
 	// Получаем актуальный список символов
 	var symbols []string
 	if m.SymbolProvider != nil {
@@ -90,39 +150,175 @@ func (m *PriceMonitor) poll(onAlert func(string, float64, float64, float64)) {
 		m.cleanupOldPrices(symbols)
 	}
 
+	timeout := m.CycleTimeout
+	if timeout <= 0 {
+		timeout = m.Interval
+	}
+	cycleCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stats := CycleStats{StartedAt: time.Now(), Symbols: len(symbols)}
+	// Один снимок тикеров на цикл: запросов O(бирж), а не O(символов)
+	snapshot := NewSnapshot(m.Exchanges)
+	var statsMu sync.Mutex
+
+	workers := m.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > len(symbols) {
+		workers = len(symbols)
+	}
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sym := range jobs {
+				streamed, err := m.pollSymbol(cycleCtx, snapshot, sym, onUpdate)
+
+				statsMu.Lock()
+				switch {
+				case streamed:
+					stats.Streamed++
+				case err != nil:
+					stats.Failed++
+				default:
+					stats.Fetched++
+				}
+				statsMu.Unlock()
+			}
+		}()
+	}
+
+dispatch:
 	for _, sym := range symbols {
-		// Получаем предпочтительную биржу/рынок для каждого символа из БД
-		preferredExchange := ""
-		preferredMarket := ""
-		if m.SymbolProvider != nil {
-			preferredExchange, preferredMarket = m.SymbolProvider.GetPreferredExchangeMarketForSymbol(sym)
+		select {
+		case jobs <- sym:
+		case <-cycleCtx.Done():
+			break dispatch
 		}
+	}
+	close(jobs)
+	wg.Wait()
 
-		priceInfo, err := FetchCurrentPrice(m.ExchangeClients, sym, preferredExchange, preferredMarket)
-		if err != nil {
-			logrus.WithError(err).WithField("symbol", sym).Warn("fetch price failed")
-			continue
-		}
-		price := priceInfo.CurrentPrice
+	stats.Duration = time.Since(stats.StartedAt)
+	stats.Snapshots = snapshot.Requests()
+	stats.TimedOut = cycleCtx.Err() == context.DeadlineExceeded
+	// Символы, не отправленные воркерам до дедлайна, считаем неуспешными
+	stats.Failed += stats.Symbols - stats.Streamed - stats.Fetched - stats.Failed
+
+	m.mu.Lock()
+	m.lastCycle = stats
+	m.mu.Unlock()
+
+	entry := logrus.WithFields(logrus.Fields{
+		"duration_ms": stats.Duration.Milliseconds(),
+		"symbols":     stats.Symbols,
+		"streamed":    stats.Streamed,
+		"fetched":     stats.Fetched,
+		"failed":      stats.Failed,
+		"snapshots":   stats.Snapshots,
+		"workers":     workers,
+	})
+	if stats.TimedOut {
+		entry.Warn("price poll cycle hit deadline")
+	} else {
+		entry.Debug("price poll cycle finished")
+	}
+}
+
+// pollSymbol получает цену одного символа из снимка тикеров цикла. streamed=true, если символ обслуживается потоком.
+func (m *PriceMonitor) pollSymbol(ctx context.Context, snapshot *Snapshot, sym string, onUpdate func(PriceUpdate)) (streamed bool, err error) {
+	// Символы со свежими тиками из потока не опрашиваем через REST
+	if m.Stream != nil && m.Stream.IsLive(sym, m.Interval) {
+		return true, nil
+	}
+
+	// Получаем предпочтительную биржу/рынок для каждого символа из БД
+	preferredExchange := ""
+	preferredMarket := ""
+	if m.SymbolProvider != nil {
+		preferredExchange, preferredMarket = m.SymbolProvider.GetPreferredExchangeMarketForSymbol(sym)
+	}
 
-		m.mu.Lock()
-		prev, had := m.lastPriceBy[sym]
-		m.lastPriceBy[sym] = price
+	priceInfo, err := snapshot.CurrentPrice(ctx, sym, preferredExchange, preferredMarket)
+	if err != nil {
+		logrus.WithError(err).WithField("symbol", sym).Warn("fetch price failed")
+		return false, err
+	}
+	if m.Quotes != nil {
+		m.Quotes.Put(Quote{Symbol: sym, Exchange: priceInfo.Exchange, Market: priceInfo.Market, Price: priceInfo.CurrentPrice, AsOf: priceInfo.AsOf})
+	}
+	m.handlePrice(sym, priceInfo.CurrentPrice, priceInfo.Exchange, priceInfo.Market, onUpdate)
+	return false, nil
+}
+
+// onStreamTick обрабатывает тик из потока, ограничивая частоту проверок по символу.
+func (m *PriceMonitor) onStreamTick(tick Tick, onUpdate func(PriceUpdate)) {
+	if m.Quotes != nil {
+		m.Quotes.Put(Quote{Symbol: tick.Symbol, Exchange: tick.Exchange, Market: tick.Market, Price: tick.Price, AsOf: tick.Time})
+	}
+
+	m.mu.Lock()
+	// Пропущенные из-за ограничения частоты тики учитываются в максимуме/минимуме
+	r, ok := m.rangeBy[tick.Symbol]
+	if !ok {
+		r = priceRange{high: tick.Price, low: tick.Price}
+	}
+	r.high = math.Max(r.high, tick.Price)
+	r.low = math.Min(r.low, tick.Price)
+	m.rangeBy[tick.Symbol] = r
+
+	last := m.lastStreamEval[tick.Symbol]
+	if time.Since(last) < m.StreamMinInterval {
 		m.mu.Unlock()
+		return
+	}
+	m.lastStreamEval[tick.Symbol] = time.Now()
+	m.mu.Unlock()
 
-		if !had || prev == 0 {
-			logrus.WithFields(logrus.Fields{
-				"symbol": sym,
-				"price":  price,
-			}).Debug("initial price recorded")
-			continue
-		}
+	m.handlePrice(tick.Symbol, tick.Price, tick.Exchange, tick.Market, onUpdate)
+}
 
-		delta := price - prev
-		deltaPct := (delta / prev) * 100.0
-		if deltaPct >= m.ThresholdPercent || deltaPct <= -m.ThresholdPercent {
-			onAlert(sym, prev, price, deltaPct)
-		}
+// handlePrice запоминает новую цену и вызывает onUpdate при изменении не меньше порога.
+func (m *PriceMonitor) handlePrice(sym string, price float64, exchange, market string, onUpdate func(PriceUpdate)) {
+	m.mu.Lock()
+	prev, had := m.lastPriceBy[sym]
+	m.lastPriceBy[sym] = price
+	r, hasRange := m.rangeBy[sym]
+	delete(m.rangeBy, sym)
+	m.mu.Unlock()
+
+	if !had || prev == 0 {
+		logrus.WithFields(logrus.Fields{
+			"symbol": sym,
+			"price":  price,
+		}).Debug("initial price recorded")
+		return
+	}
+
+	update := PriceUpdate{
+		Symbol:       sym,
+		Exchange:     exchange,
+		Market:       market,
+		Prev:         prev,
+		Price:        price,
+		High:         math.Max(prev, price),
+		Low:          math.Min(prev, price),
+		DeltaPercent: (price - prev) / prev * 100.0,
+	}
+	if hasRange {
+		update.High = math.Max(update.High, r.high)
+		update.Low = math.Min(update.Low, r.low)
+	}
+
+	if update.DeltaPercent >= m.ThresholdPercent || update.DeltaPercent <= -m.ThresholdPercent {
+		m.alertMu.Lock()
+		onUpdate(update)
+		m.alertMu.Unlock()
 	}
 }
 
@@ -141,6 +337,8 @@ func (m *PriceMonitor) cleanupOldPrices(currentSymbols []string) {
 	for sym := range m.lastPriceBy {
 		if _, exists := symbolSet[sym]; !exists {
 			delete(m.lastPriceBy, sym)
+			delete(m.lastStreamEval, sym)
+			delete(m.rangeBy, sym)
 			logrus.WithField("symbol", sym).Debug("removed unused symbol from price cache")
 		}
 	}
@@ -0,0 +1,205 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/alert-bot/internal/levels"
+)
+
+// ErrNotSupported возвращается адаптером, если биржа не поддерживает операцию
+// (например, Variational не отдает свечи и исторические цены).
+var ErrNotSupported = errors.New("operation not supported by exchange")
+
+// Exchange описывает адаптер одного рынка биржи (например, Bitget spot или Bybit futures).
+type Exchange interface {
+	// Name возвращает название биржи: "Variational", "Bitget", "Bybit"
+	Name() string
+	// Market возвращает рынок: "spot" или "futures"
+	Market() string
+	// CurrentPrice возвращает текущую цену символа
+	CurrentPrice(ctx context.Context, symbol string) (float64, error)
+	// HistoricalClose возвращает цену закрытия минутной свечи на момент at
+	HistoricalClose(ctx context.Context, symbol string, at time.Time) (float64, error)
+	// Candles возвращает последние limit свечей с интервалом interval, от старых к новым
+	Candles(ctx context.Context, symbol string, interval time.Duration, limit int) ([]levels.Candle, error)
+	// Symbols возвращает список символов, торгующихся на рынке
+	Symbols(ctx context.Context) ([]string, error)
+}
+
+// TickerSnapshotter реализуется адаптерами, которые отдают цены всех символов рынка одним запросом.
+type TickerSnapshotter interface {
+	// Tickers возвращает цены всех символов рынка, ключи в формате бота (BTCUSDT)
+	Tickers(ctx context.Context) (map[string]float64, error)
+}
+
+// DefaultPriority порядок опроса бирж по умолчанию.
+var DefaultPriority = []string{
+	"Variational futures",
+	"Bitget spot",
+	"Bitget futures",
+	"Bybit spot",
+	"Bybit futures",
+}
+
+// Registry хранит зарегистрированные адаптеры бирж и порядок их опроса.
+type Registry struct {
+	mu        sync.RWMutex
+	exchanges map[string]Exchange
+	order     []string // порядок регистрации
+	priority  []string
+	limiters  map[string]*TokenBucket // название биржи → ограничитель (общий для spot и futures)
+}
+
+// NewRegistry создает пустой реестр бирж.
+func NewRegistry() *Registry {
+	return &Registry{
+		exchanges: make(map[string]Exchange),
+		limiters:  make(map[string]*TokenBucket),
+	}
+}
+
+// SetRateLimit ограничивает частоту запросов к бирже (для всех ее рынков).
+// perSecond <= 0 снимает ограничение.
+func (r *Registry) SetRateLimit(exchange string, perSecond float64, burst int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := strings.ToLower(strings.TrimSpace(exchange))
+	if perSecond <= 0 {
+		delete(r.limiters, key)
+		return
+	}
+	r.limiters[key] = NewTokenBucket(perSecond, burst)
+}
+
+// wait ожидает разрешения ограничителя биржи перед запросом.
+func (r *Registry) wait(ctx context.Context, ex Exchange) error {
+	r.mu.RLock()
+	limiter := r.limiters[strings.ToLower(ex.Name())]
+	r.mu.RUnlock()
+
+	if limiter == nil {
+		return ctx.Err()
+	}
+	return limiter.Wait(ctx)
+}
+
+// sourceKey формирует ключ источника вида "bitget spot".
+func sourceKey(exchange, market string) string {
+	return strings.ToLower(strings.TrimSpace(exchange)) + " " + strings.ToLower(strings.TrimSpace(market))
+}
+
+// Register добавляет адаптер в реестр. Повторная регистрация заменяет адаптер.
+func (r *Registry) Register(ex Exchange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := sourceKey(ex.Name(), ex.Market())
+	if _, exists := r.exchanges[key]; !exists {
+		r.order = append(r.order, key)
+	}
+	r.exchanges[key] = ex
+}
+
+// SetPriority задает порядок опроса. Элементы имеют вид "Bitget spot" или "Bitget:spot".
+// Незарегистрированные источники игнорируются при опросе, незаданные опрашиваются последними.
+func (r *Registry) SetPriority(sources []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.priority = r.priority[:0]
+	for _, src := range sources {
+		src = strings.ReplaceAll(src, ":", " ")
+		fields := strings.Fields(src)
+		if len(fields) != 2 {
+			continue
+		}
+		r.priority = append(r.priority, sourceKey(fields[0], fields[1]))
+	}
+}
+
+// Get возвращает адаптер для биржи и рынка.
+func (r *Registry) Get(exchange, market string) (Exchange, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ex, ok := r.exchanges[sourceKey(exchange, market)]
+	return ex, ok
+}
+
+// Ordered возвращает адаптеры в порядке приоритета.
+func (r *Registry) Ordered() []Exchange {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seen := make(map[string]struct{}, len(r.exchanges))
+	result := make([]Exchange, 0, len(r.exchanges))
+	for _, key := range append(append([]string{}, r.priority...), r.order...) {
+		if _, dup := seen[key]; dup {
+			continue
+		}
+		if ex, ok := r.exchanges[key]; ok {
+			seen[key] = struct{}{}
+			result = append(result, ex)
+		}
+	}
+	return result
+}
+
+// candidates возвращает адаптеры для получения текущей цены:
+// сначала предпочтительный источник, затем все остальные по приоритету.
+func (r *Registry) candidates(preferredExchange, preferredMarket string) []Exchange {
+	ordered := r.Ordered()
+	if preferredExchange == "" || preferredMarket == "" {
+		return ordered
+	}
+
+	preferred, ok := r.Get(preferredExchange, preferredMarket)
+	if !ok {
+		return ordered
+	}
+
+	result := make([]Exchange, 0, len(ordered))
+	result = append(result, preferred)
+	for _, ex := range ordered {
+		if ex != preferred {
+			result = append(result, ex)
+		}
+	}
+	return result
+}
+
+// historyCandidates возвращает адаптеры для исторических данных: предпочтительный источник,
+// затем остальные биржи того же рынка, затем все прочие по приоритету.
+// Так для Variational (без истории) первыми пробуются фьючерсы Bitget и Bybit.
+func (r *Registry) historyCandidates(preferredExchange, preferredMarket string) []Exchange {
+	ordered := r.Ordered()
+	if preferredMarket == "" {
+		return ordered
+	}
+
+	result := make([]Exchange, 0, len(ordered))
+	added := make(map[Exchange]struct{}, len(ordered))
+	add := func(ex Exchange) {
+		if _, dup := added[ex]; !dup {
+			added[ex] = struct{}{}
+			result = append(result, ex)
+		}
+	}
+
+	if preferred, ok := r.Get(preferredExchange, preferredMarket); ok {
+		add(preferred)
+	}
+	for _, ex := range ordered {
+		if strings.EqualFold(ex.Market(), preferredMarket) {
+			add(ex)
+		}
+	}
+	for _, ex := range ordered {
+		add(ex)
+	}
+	return result
+}
@@ -0,0 +1,120 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Snapshot кеширует полные списки тикеров бирж на время одного цикла опроса:
+// каждый рынок запрашивается не более одного раза, все символы берутся из его снимка.
+// Адаптеры без TickerSnapshotter опрашиваются по одному символу, как в FetchCurrentPrice.
+type Snapshot struct {
+	registry *Registry
+
+	mu      sync.Mutex
+	entries map[string]*snapshotEntry // sourceKey → снимок рынка
+}
+
+type snapshotEntry struct {
+	once      sync.Once
+	prices    map[string]float64
+	fetchedAt time.Time
+	err       error
+}
+
+// NewSnapshot создает пустой снимок; тикеры загружаются при первом обращении к рынку.
+func NewSnapshot(registry *Registry) *Snapshot {
+	return &Snapshot{
+		registry: registry,
+		entries:  make(map[string]*snapshotEntry),
+	}
+}
+
+// tickers возвращает снимок рынка, загружая его при первом обращении.
+func (s *Snapshot) tickers(ctx context.Context, ex Exchange, snapshotter TickerSnapshotter) (map[string]float64, time.Time, error) {
+	key := sourceKey(ex.Name(), ex.Market())
+
+	s.mu.Lock()
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &snapshotEntry{}
+		s.entries[key] = entry
+	}
+	s.mu.Unlock()
+
+	entry.once.Do(func() {
+		if entry.err = s.registry.wait(ctx, ex); entry.err != nil {
+			return
+		}
+		entry.prices, entry.err = snapshotter.Tickers(ctx)
+		entry.fetchedAt = time.Now()
+
+		logrus.WithFields(logrus.Fields{
+			"source":  fmt.Sprintf("%s %s", ex.Name(), ex.Market()),
+			"tickers": len(entry.prices),
+		}).Debug("ticker snapshot loaded")
+	})
+
+	return entry.prices, entry.fetchedAt, entry.err
+}
+
+// Requests возвращает количество рынков, чьи снимки были загружены.
+func (s *Snapshot) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// CurrentPrice возвращает цену символа из снимков в том же порядке источников, что и FetchCurrentPrice.
+func (s *Snapshot) CurrentPrice(ctx context.Context, symbol string, preferredExchange, preferredMarket string) (*FetchPriceInfoResult, error) {
+	symbol = strings.ToUpper(symbol)
+	err := errors.New("no exchanges registered")
+
+	for _, ex := range s.registry.candidates(preferredExchange, preferredMarket) {
+		var price float64
+		var fetchErr error
+		asOf := time.Now()
+
+		if snapshotter, ok := ex.(TickerSnapshotter); ok {
+			var tickers map[string]float64
+			tickers, asOf, fetchErr = s.tickers(ctx, ex, snapshotter)
+			if fetchErr == nil {
+				var listed bool
+				if price, listed = tickers[symbol]; !listed {
+					fetchErr = fmt.Errorf("symbol %s not listed", symbol)
+				}
+			}
+		} else {
+			if waitErr := s.registry.wait(ctx, ex); waitErr != nil {
+				return nil, fmt.Errorf("failed to get current price for %s: %w", symbol, waitErr)
+			}
+			price, fetchErr = ex.CurrentPrice(ctx, symbol)
+		}
+
+		if fetchErr == nil {
+			return &FetchPriceInfoResult{
+				PriceInfo: PriceInfo{
+					CurrentPrice: price,
+					Source:       fmt.Sprintf("%s %s", ex.Name(), ex.Market()),
+				},
+				Exchange: ex.Name(),
+				Market:   ex.Market(),
+				AsOf:     asOf,
+			}, nil
+		}
+
+		err = fetchErr
+		logrus.WithError(fetchErr).WithFields(logrus.Fields{
+			"symbol": symbol,
+			"source": fmt.Sprintf("%s %s", ex.Name(), ex.Market()),
+		}).Debug("snapshot price lookup failed, trying next source")
+	}
+
+	return nil, fmt.Errorf("failed to get current price for %s from any source: %w", symbol, err)
+}
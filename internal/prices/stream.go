@@ -0,0 +1,541 @@
+package prices
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	bitgetPublicWSURL      = "wss://ws.bitget.com/v2/ws/public"
+	bybitSpotPublicWSURL   = "wss://stream.bybit.com/v5/public/spot"
+	bybitLinearPublicWSURL = "wss://stream.bybit.com/v5/public/linear"
+)
+
+// Tick обновление цены, полученное из потока биржи.
+type Tick struct {
+	Symbol   string
+	Exchange string
+	Market   string
+	Price    float64
+	Time     time.Time
+}
+
+// StreamVenue описывает публичный WebSocket канал тикеров одного рынка биржи.
+type StreamVenue interface {
+	// Exchange и Market совпадают с соответствующим адаптером Exchange
+	Exchange() string
+	Market() string
+	// URL адрес WebSocket сервера
+	URL() string
+	// SubscribeMessages и UnsubscribeMessages формируют запросы (с разбиением на пачки)
+	SubscribeMessages(symbols []string) [][]byte
+	UnsubscribeMessages(symbols []string) [][]byte
+	// PingMessage сообщение keep-alive на уровне протокола биржи
+	PingMessage() []byte
+	// Parse разбирает входящее сообщение; служебные сообщения возвращают пустой список
+	Parse(msg []byte) ([]Tick, error)
+}
+
+// streamVenueKey возвращает ключ канала для биржи и рынка символа.
+// Для Variational (нет WebSocket) и неизвестных источников используются фьючерсы Bitget.
+func streamVenueKey(exchange, market string) string {
+	switch sourceKey(exchange, market) {
+	case "bitget spot", "bitget futures", "bybit spot", "bybit futures":
+		return sourceKey(exchange, market)
+	}
+	return sourceKey("Bitget", "futures")
+}
+
+// batchSymbols разбивает символы на пачки не длиннее size.
+func batchSymbols(symbols []string, size int) [][]string {
+	var batches [][]string
+	for len(symbols) > 0 {
+		n := min(size, len(symbols))
+		batches = append(batches, symbols[:n])
+		symbols = symbols[n:]
+	}
+	return batches
+}
+
+// --- Bitget stream ---
+
+// BitgetStream канал тикеров Bitget (spot или USDT-FUTURES).
+type BitgetStream struct {
+	url    string
+	market string
+}
+
+// NewBitgetStream создает канал Bitget. Пустой url означает публичный сервер Bitget.
+func NewBitgetStream(market, url string) *BitgetStream {
+	if url == "" {
+		url = bitgetPublicWSURL
+	}
+	return &BitgetStream{url: url, market: market}
+}
+
+func (s *BitgetStream) Exchange() string { return "Bitget" }
+func (s *BitgetStream) Market() string   { return s.market }
+func (s *BitgetStream) URL() string      { return s.url }
+
+func (s *BitgetStream) instType() string {
+	if s.market == "futures" {
+		return "USDT-FUTURES"
+	}
+	return "SPOT"
+}
+
+func (s *BitgetStream) requests(op string, symbols []string) [][]byte {
+	type arg struct {
+		InstType string `json:"instType"`
+		Channel  string `json:"channel"`
+		InstID   string `json:"instId"`
+	}
+
+	var messages [][]byte
+	for _, batch := range batchSymbols(symbols, 20) {
+		args := make([]arg, 0, len(batch))
+		for _, sym := range batch {
+			args = append(args, arg{InstType: s.instType(), Channel: "ticker", InstID: sym})
+		}
+		msg, _ := json.Marshal(map[string]interface{}{"op": op, "args": args})
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func (s *BitgetStream) SubscribeMessages(symbols []string) [][]byte {
+	return s.requests("subscribe", symbols)
+}
+
+func (s *BitgetStream) UnsubscribeMessages(symbols []string) [][]byte {
+	return s.requests("unsubscribe", symbols)
+}
+
+func (s *BitgetStream) PingMessage() []byte { return []byte("ping") }
+
+func (s *BitgetStream) Parse(msg []byte) ([]Tick, error) {
+	if string(msg) == "pong" {
+		return nil, nil
+	}
+
+	var push struct {
+		Event string `json:"event"`
+		Code  any    `json:"code"`
+		Msg   string `json:"msg"`
+		Arg   struct {
+			Channel string `json:"channel"`
+		} `json:"arg"`
+		Data []struct {
+			InstID    string `json:"instId"`
+			LastPr    string `json:"lastPr"`
+			MarkPrice string `json:"markPrice"`
+			Ts        string `json:"ts"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(msg, &push); err != nil {
+		return nil, fmt.Errorf("failed to decode bitget stream message: %w", err)
+	}
+
+	if push.Event == "error" {
+		return nil, fmt.Errorf("bitget stream error code=%v msg=%s", push.Code, push.Msg)
+	}
+	if push.Event != "" || push.Arg.Channel != "ticker" {
+		return nil, nil
+	}
+
+	ticks := make([]Tick, 0, len(push.Data))
+	for _, item := range push.Data {
+		priceStr := item.LastPr
+		if s.market == "futures" && item.MarkPrice != "" && item.MarkPrice != "0" {
+			priceStr = item.MarkPrice
+		}
+		price, err := parseFloat(priceStr)
+		if err != nil || price <= 0 {
+			continue
+		}
+		ticks = append(ticks, Tick{
+			Symbol:   strings.ToUpper(item.InstID),
+			Exchange: s.Exchange(),
+			Market:   s.market,
+			Price:    price,
+			Time:     time.Now(),
+		})
+	}
+	return ticks, nil
+}
+
+// --- Bybit stream ---
+
+// BybitStream канал тикеров Bybit (spot или linear).
+type BybitStream struct {
+	url    string
+	market string
+}
+
+// NewBybitStream создает канал Bybit. Пустой url означает публичный сервер Bybit для рынка.
+func NewBybitStream(market, url string) *BybitStream {
+	if url == "" {
+		url = bybitSpotPublicWSURL
+		if market == "futures" {
+			url = bybitLinearPublicWSURL
+		}
+	}
+	return &BybitStream{url: url, market: market}
+}
+
+func (s *BybitStream) Exchange() string { return "Bybit" }
+func (s *BybitStream) Market() string   { return s.market }
+func (s *BybitStream) URL() string      { return s.url }
+
+func (s *BybitStream) requests(op string, symbols []string) [][]byte {
+	var messages [][]byte
+	// Bybit spot принимает не более 10 топиков в одном запросе
+	for _, batch := range batchSymbols(symbols, 10) {
+		topics := make([]string, 0, len(batch))
+		for _, sym := range batch {
+			topics = append(topics, "tickers."+sym)
+		}
+		msg, _ := json.Marshal(map[string]interface{}{"op": op, "args": topics})
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func (s *BybitStream) SubscribeMessages(symbols []string) [][]byte {
+	return s.requests("subscribe", symbols)
+}
+
+func (s *BybitStream) UnsubscribeMessages(symbols []string) [][]byte {
+	return s.requests("unsubscribe", symbols)
+}
+
+func (s *BybitStream) PingMessage() []byte { return []byte(`{"op":"ping"}`) }
+
+func (s *BybitStream) Parse(msg []byte) ([]Tick, error) {
+	var push struct {
+		Op      string `json:"op"`
+		Success *bool  `json:"success"`
+		RetMsg  string `json:"ret_msg"`
+		Topic   string `json:"topic"`
+		Data    struct {
+			Symbol    string `json:"symbol"`
+			LastPrice string `json:"lastPrice"`
+			MarkPrice string `json:"markPrice"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(msg, &push); err != nil {
+		return nil, fmt.Errorf("failed to decode bybit stream message: %w", err)
+	}
+
+	if push.Success != nil && !*push.Success {
+		return nil, fmt.Errorf("bybit stream %s failed: %s", push.Op, push.RetMsg)
+	}
+	if !strings.HasPrefix(push.Topic, "tickers.") {
+		return nil, nil
+	}
+
+	// В дельта-обновлениях linear поля могут отсутствовать
+	priceStr := push.Data.LastPrice
+	if s.market == "futures" && push.Data.MarkPrice != "" && push.Data.MarkPrice != "0" {
+		priceStr = push.Data.MarkPrice
+	}
+	if priceStr == "" {
+		return nil, nil
+	}
+	price, err := parseFloat(priceStr)
+	if err != nil || price <= 0 {
+		return nil, nil
+	}
+
+	symbol := push.Data.Symbol
+	if symbol == "" {
+		symbol = strings.TrimPrefix(push.Topic, "tickers.")
+	}
+
+	return []Tick{{
+		Symbol:   strings.ToUpper(symbol),
+		Exchange: s.Exchange(),
+		Market:   s.market,
+		Price:    price,
+		Time:     time.Now(),
+	}}, nil
+}
+
+// DefaultStreamVenues возвращает публичные каналы Bitget и Bybit для спота и фьючерсов.
+func DefaultStreamVenues() []StreamVenue {
+	return []StreamVenue{
+		NewBitgetStream("spot", ""),
+		NewBitgetStream("futures", ""),
+		NewBybitStream("spot", ""),
+		NewBybitStream("futures", ""),
+	}
+}
+
+// --- Stream feed ---
+
+// StreamFeed поддерживает WebSocket подключения к биржам и подписки на тикеры
+// всех символов из SymbolProvider, переподключаясь с экспоненциальной задержкой.
+type StreamFeed struct {
+	Provider        SymbolProvider
+	Venues          []StreamVenue
+	Dialer          *websocket.Dialer
+	RefreshInterval time.Duration // Как часто сверять список символов с провайдером
+	PingInterval    time.Duration
+	MinBackoff      time.Duration
+	MaxBackoff      time.Duration
+
+	mu        sync.Mutex
+	connected map[string]bool      // venue key → есть активное подключение
+	lastTick  map[string]time.Time // symbol → время последнего тика
+}
+
+// NewStreamFeed создает потоковый источник цен.
+func NewStreamFeed(provider SymbolProvider, venues ...StreamVenue) *StreamFeed {
+	return &StreamFeed{
+		Provider:        provider,
+		Venues:          venues,
+		Dialer:          websocket.DefaultDialer,
+		RefreshInterval: 15 * time.Second,
+		PingInterval:    20 * time.Second,
+		MinBackoff:      time.Second,
+		MaxBackoff:      time.Minute,
+		connected:       make(map[string]bool),
+		lastTick:        make(map[string]time.Time),
+	}
+}
+
+// Run подключается ко всем каналам и передает тики в onTick до завершения контекста.
+// onTick может вызываться конкурентно из разных каналов.
+func (f *StreamFeed) Run(ctx context.Context, onTick func(Tick)) {
+	var wg sync.WaitGroup
+	for _, venue := range f.Venues {
+		wg.Add(1)
+		go func(venue StreamVenue) {
+			defer wg.Done()
+			f.runVenue(ctx, venue, onTick)
+		}(venue)
+	}
+	wg.Wait()
+}
+
+// IsLive сообщает, приходят ли по символу свежие тики (не старше maxAge) через активное подключение.
+func (f *StreamFeed) IsLive(symbol string, maxAge time.Duration) bool {
+	venue := sourceKey("Bitget", "futures")
+	if f.Provider != nil {
+		venue = streamVenueKey(f.Provider.GetPreferredExchangeMarketForSymbol(symbol))
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	last, ok := f.lastTick[symbol]
+	return f.connected[venue] && ok && time.Since(last) <= maxAge
+}
+
+// Connected сообщает, есть ли хотя бы одно активное подключение.
+func (f *StreamFeed) Connected() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, ok := range f.connected {
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *StreamFeed) setConnected(venue string, connected bool) {
+	f.mu.Lock()
+	f.connected[venue] = connected
+	f.mu.Unlock()
+}
+
+// symbolsFor возвращает отсортированные символы, которые должны идти через канал venue.
+func (f *StreamFeed) symbolsFor(venue StreamVenue) []string {
+	if f.Provider == nil {
+		return nil
+	}
+
+	key := sourceKey(venue.Exchange(), venue.Market())
+	var symbols []string
+	for _, sym := range f.Provider.GetAllSymbols() {
+		if streamVenueKey(f.Provider.GetPreferredExchangeMarketForSymbol(sym)) == key {
+			symbols = append(symbols, sym)
+		}
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// runVenue держит подключение к одному каналу, переподключаясь при обрыве.
+func (f *StreamFeed) runVenue(ctx context.Context, venue StreamVenue, onTick func(Tick)) {
+	key := sourceKey(venue.Exchange(), venue.Market())
+	backoff := f.MinBackoff
+
+	for {
+		// Не держим подключение, пока нет символов для канала
+		if len(f.symbolsFor(venue)) > 0 {
+			started := time.Now()
+			err := f.session(ctx, venue, onTick)
+			f.setConnected(key, false)
+			if ctx.Err() != nil {
+				return
+			}
+
+			// Сбрасываем задержку, если подключение продержалось дольше максимальной задержки
+			if time.Since(started) > f.MaxBackoff {
+				backoff = f.MinBackoff
+			}
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"venue":   key,
+				"backoff": backoff.String(),
+			}).Warn("price stream disconnected, falling back to REST until reconnect")
+		} else {
+			backoff = f.RefreshInterval
+		}
+
+		// Задержка с небольшим случайным разбросом
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)/4+1))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if len(f.symbolsFor(venue)) > 0 {
+			backoff *= 2
+			if backoff > f.MaxBackoff {
+				backoff = f.MaxBackoff
+			}
+		} else {
+			backoff = f.MinBackoff
+		}
+	}
+}
+
+// session обслуживает одно подключение: подписка, keep-alive, пересогласование подписок и чтение тиков.
+func (f *StreamFeed) session(ctx context.Context, venue StreamVenue, onTick func(Tick)) error {
+	key := sourceKey(venue.Exchange(), venue.Market())
+
+	conn, _, err := f.Dialer.DialContext(ctx, venue.URL(), nil)
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", venue.URL(), err)
+	}
+	defer conn.Close()
+
+	// Биржи отвечают на каждый ping, поэтому тишина дольше двух интервалов означает полуоткрытое
+	// подключение: чтение завершается по таймауту, и канал переподключается с задержкой
+	readTimeout := 2 * f.PingInterval
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(readTimeout))
+	})
+
+	// Читаем сообщения в отдельной горутине; запись выполняется только из этой функции
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			if err := conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+				readErr <- err
+				return
+			}
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			ticks, err := venue.Parse(msg)
+			if err != nil {
+				logrus.WithError(err).WithField("venue", key).Debug("price stream message rejected")
+				continue
+			}
+			for _, tick := range ticks {
+				f.mu.Lock()
+				f.lastTick[tick.Symbol] = tick.Time
+				f.mu.Unlock()
+				onTick(tick)
+			}
+		}
+	}()
+
+	subscribed := make(map[string]struct{})
+	resync := func() error {
+		desired := f.symbolsFor(venue)
+		desiredSet := make(map[string]struct{}, len(desired))
+		var toSubscribe, toUnsubscribe []string
+		for _, sym := range desired {
+			desiredSet[sym] = struct{}{}
+			if _, ok := subscribed[sym]; !ok {
+				toSubscribe = append(toSubscribe, sym)
+			}
+		}
+		for sym := range subscribed {
+			if _, ok := desiredSet[sym]; !ok {
+				toUnsubscribe = append(toUnsubscribe, sym)
+			}
+		}
+		sort.Strings(toUnsubscribe)
+
+		for _, msg := range venue.UnsubscribeMessages(toUnsubscribe) {
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return err
+			}
+		}
+		for _, msg := range venue.SubscribeMessages(toSubscribe) {
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return err
+			}
+		}
+		for _, sym := range toUnsubscribe {
+			delete(subscribed, sym)
+		}
+		for _, sym := range toSubscribe {
+			subscribed[sym] = struct{}{}
+		}
+
+		if len(toSubscribe) > 0 || len(toUnsubscribe) > 0 {
+			logrus.WithFields(logrus.Fields{
+				"venue":        key,
+				"subscribed":   toSubscribe,
+				"unsubscribed": toUnsubscribe,
+			}).Info("price stream subscriptions updated")
+		}
+		return nil
+	}
+
+	if err := resync(); err != nil {
+		return fmt.Errorf("subscribe: %w", err)
+	}
+	f.setConnected(key, true)
+	logrus.WithFields(logrus.Fields{"venue": key, "url": venue.URL()}).Info("price stream connected")
+
+	refresh := time.NewTicker(f.RefreshInterval)
+	defer refresh.Stop()
+	ping := time.NewTicker(f.PingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return ctx.Err()
+		case err := <-readErr:
+			return fmt.Errorf("read: %w", err)
+		case <-ping.C:
+			if err := conn.WriteMessage(websocket.TextMessage, venue.PingMessage()); err != nil {
+				return fmt.Errorf("ping: %w", err)
+			}
+		case <-refresh.C:
+			if err := resync(); err != nil {
+				return fmt.Errorf("resubscribe: %w", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package prices
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/alert-bot/internal/levels"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	logrus.SetOutput(io.Discard)
+}
+
+// bitgetTicker push сообщение канала ticker Bitget для USDT-FUTURES
+const bitgetTicker = `{"action":"snapshot","arg":{"instType":"USDT-FUTURES","channel":"ticker","instId":"BTCUSDT"},` +
+	`"data":[{"instId":"BTCUSDT","lastPr":"100.5","markPrice":"100.4","ts":"1700000000000"}]}`
+
+// staticSymbols провайдер символов, которые всегда идут через фьючерсы Bitget.
+type staticSymbols []string
+
+func (s staticSymbols) GetAllSymbols() []string { return s }
+
+func (s staticSymbols) GetPreferredExchangeMarketForSymbol(string) (string, string) {
+	return "Bitget", "futures"
+}
+
+// fakeExchange REST адаптер с фиксированной ценой, считающий запросы.
+type fakeExchange struct {
+	price float64
+	calls atomic.Int32
+}
+
+func (e *fakeExchange) Name() string   { return "Bitget" }
+func (e *fakeExchange) Market() string { return "futures" }
+
+func (e *fakeExchange) CurrentPrice(context.Context, string) (float64, error) {
+	e.calls.Add(1)
+	return e.price, nil
+}
+
+func (e *fakeExchange) HistoricalClose(context.Context, string, time.Time) (float64, error) {
+	return 0, ErrNotSupported
+}
+
+func (e *fakeExchange) Candles(context.Context, string, time.Duration, int) ([]levels.Candle, error) {
+	return nil, ErrNotSupported
+}
+
+func (e *fakeExchange) Symbols(context.Context) ([]string, error) { return nil, ErrNotSupported }
+
+// streamServer WebSocket сервер биржи: каждое подключение обслуживает handle,
+// первое сообщение клиента (подписка) отправляется в subscribes.
+type streamServer struct {
+	*httptest.Server
+	conns      atomic.Int32
+	subscribes chan string
+}
+
+func newStreamServer(t *testing.T, handle func(n int32, conn *websocket.Conn)) *streamServer {
+	t.Helper()
+	s := &streamServer{subscribes: make(chan string, 16)}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		select {
+		case s.subscribes <- string(msg):
+		default:
+		}
+		handle(s.conns.Add(1), conn)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *streamServer) wsURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// drain читает сообщения клиента (ping и т.п.), пока подключение не закроется.
+func drain(conn *websocket.Conn) {
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// testFeed создает поток с короткими интервалами и запускает его до конца теста.
+func testFeed(t *testing.T, url string, onTick func(Tick)) *StreamFeed {
+	t.Helper()
+	feed := NewStreamFeed(staticSymbols{"BTCUSDT"}, NewBitgetStream("futures", url))
+	feed.PingInterval = 50 * time.Millisecond
+	feed.RefreshInterval = time.Hour
+	feed.MinBackoff = 10 * time.Millisecond
+	feed.MaxBackoff = 20 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		feed.Run(ctx, onTick)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return feed
+}
+
+// waitFor ждет выполнения условия не дольше пяти секунд.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("не дождались: %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStreamFeedSubscribesAndParsesTicks(t *testing.T) {
+	srv := newStreamServer(t, func(_ int32, conn *websocket.Conn) {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(bitgetTicker)); err != nil {
+			return
+		}
+		drain(conn)
+	})
+
+	ticks := make(chan Tick, 16)
+	feed := testFeed(t, srv.wsURL(), func(tick Tick) { ticks <- tick })
+
+	select {
+	case msg := <-srv.subscribes:
+		var sub struct {
+			Op   string `json:"op"`
+			Args []struct {
+				InstType string `json:"instType"`
+				Channel  string `json:"channel"`
+				InstID   string `json:"instId"`
+			} `json:"args"`
+		}
+		if err := json.Unmarshal([]byte(msg), &sub); err != nil {
+			t.Fatalf("подписка %q: %v", msg, err)
+		}
+		if sub.Op != "subscribe" || len(sub.Args) != 1 || sub.Args[0].InstType != "USDT-FUTURES" ||
+			sub.Args[0].Channel != "ticker" || sub.Args[0].InstID != "BTCUSDT" {
+			t.Errorf("неожиданная подписка %s", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("подписка не отправлена")
+	}
+
+	select {
+	case tick := <-ticks:
+		// Для фьючерсов используется mark price
+		if tick.Symbol != "BTCUSDT" || tick.Exchange != "Bitget" || tick.Market != "futures" || tick.Price != 100.4 {
+			t.Errorf("неожиданный тик %+v", tick)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("тик не получен")
+	}
+
+	if !feed.Connected() || !feed.IsLive("BTCUSDT", time.Minute) {
+		t.Error("после тика символ должен обслуживаться потоком")
+	}
+}
+
+func TestStreamFeedReconnectsAfterReadDeadline(t *testing.T) {
+	// Сервер принимает подписку и молчит: клиент должен закрыть полуоткрытое подключение
+	// по таймауту чтения и переподписаться на новом
+	srv := newStreamServer(t, func(_ int32, conn *websocket.Conn) {
+		drain(conn)
+	})
+	feed := testFeed(t, srv.wsURL(), func(Tick) {})
+
+	waitFor(t, "повторное подключение", func() bool { return srv.conns.Load() >= 2 })
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-srv.subscribes:
+			if !strings.Contains(msg, `"op":"subscribe"`) || !strings.Contains(msg, "BTCUSDT") {
+				t.Errorf("подключение %d: неожиданная подписка %s", i+1, msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("подключение %d: подписка не отправлена", i+1)
+		}
+	}
+	if feed.IsLive("BTCUSDT", time.Minute) {
+		t.Error("без тиков символ не должен считаться живым")
+	}
+}
+
+func TestPriceMonitorFallsBackToRESTWhenStreamDrops(t *testing.T) {
+	var once sync.Once
+	drop := make(chan struct{})
+	srv := newStreamServer(t, func(n int32, conn *websocket.Conn) {
+		// Повторные подключения сразу обрываются, поток остается недоступным
+		if n > 1 {
+			return
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(bitgetTicker)); err != nil {
+			return
+		}
+		<-drop
+	})
+
+	ex := &fakeExchange{price: 101}
+	registry := NewRegistry()
+	registry.Register(ex)
+
+	monitor := NewPriceMonitorWithProvider(staticSymbols{"BTCUSDT"}, registry, 1, 60)
+	monitor.Stream = testFeed(t, srv.wsURL(), func(Tick) {})
+	t.Cleanup(func() { once.Do(func() { close(drop) }) })
+
+	onUpdate := func(PriceUpdate) {}
+
+	waitFor(t, "тик из потока", func() bool { return monitor.Stream.IsLive("BTCUSDT", monitor.Interval) })
+	streamed, err := monitor.pollSymbol(context.Background(), NewSnapshot(registry), "BTCUSDT", onUpdate)
+	if err != nil || !streamed || ex.calls.Load() != 0 {
+		t.Fatalf("при живом потоке REST не опрашивается: streamed=%v, err=%v, запросов %d", streamed, err, ex.calls.Load())
+	}
+
+	once.Do(func() { close(drop) })
+	waitFor(t, "обрыв потока", func() bool { return !monitor.Stream.IsLive("BTCUSDT", monitor.Interval) })
+
+	streamed, err = monitor.pollSymbol(context.Background(), NewSnapshot(registry), "BTCUSDT", onUpdate)
+	if err != nil || streamed || ex.calls.Load() != 1 {
+		t.Fatalf("после обрыва цена берется через REST: streamed=%v, err=%v, запросов %d", streamed, err, ex.calls.Load())
+	}
+	if price, ok := monitor.GetCachedPrice("BTCUSDT"); !ok || price != 101 {
+		t.Errorf("цена из REST %.2f (%v), ожидалось 101", price, ok)
+	}
+}